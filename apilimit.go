@@ -0,0 +1,250 @@
+package siasync
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/node/api"
+)
+
+// coalesceWindow is how long the result of a GET is handed to identical GETs
+// after it returned, unless siasync changed something on Sia meanwhile.
+var coalesceWindow = 250 * time.Millisecond
+
+// APICalls counts the siad API calls of the session, shared by all folders.
+type APICalls struct {
+	Made      int64 `json:"made"`      // Made is the number of calls made to siad
+	Coalesced int64 `json:"coalesced"` // Coalesced is the number of GETs answered by an identical GET instead of siad
+	Throttled int64 `json:"throttled"` // Throttled is the number of calls that waited for the rate limit
+}
+
+// LimitedClient is a SiaBackend that limits the rate of the API calls made to
+// siad, which may be busy serving streams, and answers identical GETs made
+// within coalesceWindow of each other with a single call. It is shared by
+// every SiaFolder and the node monitor, so the limit applies to siasync as a
+// whole. Any call that changes something on Sia drops the shared results.
+type LimitedClient struct {
+	SiaBackend
+
+	mu     sync.Mutex
+	rate   float64 // rate is the number of calls per second, 0 is unlimited
+	tokens float64
+	last   time.Time
+	gets   map[string]*coalescedGet
+	calls  APICalls
+}
+
+// coalescedGet is a GET that is in flight or returned within coalesceWindow.
+type coalescedGet struct {
+	done     chan struct{}
+	returned time.Time
+	value    interface{}
+	err      error
+}
+
+// NewLimitedClient returns a client making at most rate calls per second to
+// c, with bursts of up to a second's worth of calls. A rate of 0 only
+// coalesces GETs.
+func NewLimitedClient(c SiaBackend, rate float64) *LimitedClient {
+	return &LimitedClient{
+		SiaBackend: c,
+		rate:       rate,
+		tokens:     math.Max(rate, 1),
+		last:       time.Now(),
+		gets:       make(map[string]*coalescedGet),
+	}
+}
+
+// wait blocks until the rate limit allows another call and counts it.
+func (c *LimitedClient) wait() {
+	c.mu.Lock()
+	c.calls.Made++
+	if c.rate <= 0 {
+		c.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	c.tokens = math.Min(math.Max(c.rate, 1), c.tokens+now.Sub(c.last).Seconds()*c.rate)
+	c.last = now
+	c.tokens--
+	var delay time.Duration
+	if c.tokens < 0 {
+		delay = time.Duration(-c.tokens / c.rate * float64(time.Second))
+		c.calls.Throttled++
+	}
+	c.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// get returns the result of the GET identified by key, calling fetch unless
+// an identical GET is in flight or returned within coalesceWindow.
+func (c *LimitedClient) get(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	for k, g := range c.gets {
+		select {
+		case <-g.done:
+			if time.Since(g.returned) >= coalesceWindow {
+				delete(c.gets, k)
+			}
+		default:
+		}
+	}
+	if g, ok := c.gets[key]; ok {
+		c.calls.Coalesced++
+		c.mu.Unlock()
+		<-g.done
+		return g.value, g.err
+	}
+	g := &coalescedGet{done: make(chan struct{})}
+	c.gets[key] = g
+	c.mu.Unlock()
+
+	c.wait()
+	g.value, g.err = fetch()
+	c.mu.Lock()
+	g.returned = time.Now()
+	close(g.done)
+	c.mu.Unlock()
+	return g.value, g.err
+}
+
+// change makes a call that changes something on Sia and drops the shared
+// GETs, whose results may be outdated by it.
+func (c *LimitedClient) change(call func() error) error {
+	c.wait()
+	err := call()
+	c.mu.Lock()
+	c.gets = make(map[string]*coalescedGet)
+	c.mu.Unlock()
+	return err
+}
+
+// unwrap implements wrappedClient.
+func (c *LimitedClient) unwrap() SiaClient {
+	return c.SiaBackend
+}
+
+// Calls returns the API calls counted so far.
+func (c *LimitedClient) Calls() APICalls {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+// LogSummary logs the API calls of the session.
+func (c *LimitedClient) LogSummary() {
+	calls := c.Calls()
+	log.WithFields(logrus.Fields{
+		"made":      calls.Made,
+		"coalesced": calls.Coalesced,
+		"throttled": calls.Throttled,
+	}).Info("siad API calls this session")
+}
+
+// DaemonVersionGet implements SiaClient, coalescing identical GETs.
+func (c *LimitedClient) DaemonVersionGet() (api.DaemonVersionGet, error) {
+	v, err := c.get("daemon/version", func() (interface{}, error) {
+		return c.SiaBackend.DaemonVersionGet()
+	})
+	dvg, _ := v.(api.DaemonVersionGet)
+	return dvg, err
+}
+
+// ConsensusGet implements NodeClient, coalescing identical GETs.
+func (c *LimitedClient) ConsensusGet() (api.ConsensusGET, error) {
+	v, err := c.get("consensus", func() (interface{}, error) {
+		return c.SiaBackend.ConsensusGet()
+	})
+	cg, _ := v.(api.ConsensusGET)
+	return cg, err
+}
+
+// WalletGet implements NodeClient, coalescing identical GETs.
+func (c *LimitedClient) WalletGet() (api.WalletGET, error) {
+	v, err := c.get("wallet", func() (interface{}, error) {
+		return c.SiaBackend.WalletGet()
+	})
+	wg, _ := v.(api.WalletGET)
+	return wg, err
+}
+
+// RenterGet implements NodeClient, coalescing identical GETs.
+func (c *LimitedClient) RenterGet() (api.RenterGET, error) {
+	v, err := c.get("renter", func() (interface{}, error) {
+		return c.SiaBackend.RenterGet()
+	})
+	rg, _ := v.(api.RenterGET)
+	return rg, err
+}
+
+// RenterDisabledContractsGet implements NodeClient, coalescing identical
+// GETs.
+func (c *LimitedClient) RenterDisabledContractsGet() (api.RenterContracts, error) {
+	v, err := c.get("renter/contracts?disabled", func() (interface{}, error) {
+		return c.SiaBackend.RenterDisabledContractsGet()
+	})
+	rc, _ := v.(api.RenterContracts)
+	return rc, err
+}
+
+// RenterFileGet implements SiaClient, coalescing identical GETs.
+func (c *LimitedClient) RenterFileGet(siaPath modules.SiaPath) (api.RenterFile, error) {
+	v, err := c.get("renter/file/"+siaPath.String(), func() (interface{}, error) {
+		return c.SiaBackend.RenterFileGet(siaPath)
+	})
+	rf, _ := v.(api.RenterFile)
+	return rf, err
+}
+
+// RenterGetDir implements SiaClient, coalescing identical GETs.
+func (c *LimitedClient) RenterGetDir(siaPath modules.SiaPath) (api.RenterDirectory, error) {
+	v, err := c.get("renter/dir/"+siaPath.String(), func() (interface{}, error) {
+		return c.SiaBackend.RenterGetDir(siaPath)
+	})
+	rd, _ := v.(api.RenterDirectory)
+	return rd, err
+}
+
+// RenterDownloadFullGet implements SiaClient. Downloads are never coalesced.
+func (c *LimitedClient) RenterDownloadFullGet(siaPath modules.SiaPath, destination string, async bool) error {
+	c.wait()
+	return c.SiaBackend.RenterDownloadFullGet(siaPath, destination, async)
+}
+
+// RenterUploadPost implements SiaClient and drops the shared GETs.
+func (c *LimitedClient) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	return c.change(func() error {
+		return c.SiaBackend.RenterUploadPost(path, siaPath, dataPieces, parityPieces)
+	})
+}
+
+// RenterDeletePost implements SiaClient and drops the shared GETs.
+func (c *LimitedClient) RenterDeletePost(siaPath modules.SiaPath) error {
+	return c.change(func() error {
+		return c.SiaBackend.RenterDeletePost(siaPath)
+	})
+}
+
+// RenterRenamePost implements SiaClient and drops the shared GETs.
+func (c *LimitedClient) RenterRenamePost(siaPathOld, siaPathNew modules.SiaPath) error {
+	return c.change(func() error {
+		return c.SiaBackend.RenterRenamePost(siaPathOld, siaPathNew)
+	})
+}
+
+// RenterDirCreatePost implements SiaClient and drops the shared GETs.
+func (c *LimitedClient) RenterDirCreatePost(siaPath modules.SiaPath) error {
+	return c.change(func() error {
+		return c.SiaBackend.RenterDirCreatePost(siaPath)
+	})
+}
+
+// RenterDirDeletePost implements SiaClient and drops the shared GETs.
+func (c *LimitedClient) RenterDirDeletePost(siaPath modules.SiaPath) error {
+	return c.change(func() error {
+		return c.SiaBackend.RenterDirDeletePost(siaPath)
+	})
+}
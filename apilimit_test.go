@@ -0,0 +1,100 @@
+package siasync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/node/api"
+)
+
+// countingClient is a MockClient that counts the RenterFileGet calls that
+// reach it and makes them take a while.
+type countingClient struct {
+	*MockClient
+	fileGets int64
+}
+
+func (c *countingClient) RenterFileGet(siaPath modules.SiaPath) (api.RenterFile, error) {
+	atomic.AddInt64(&c.fileGets, 1)
+	time.Sleep(20 * time.Millisecond)
+	return c.MockClient.RenterFileGet(siaPath)
+}
+
+// TestLimitedClient verifies that identical GETs are answered by a single
+// call until something is changed on Sia, and that calls beyond the rate are
+// held back.
+func TestLimitedClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(path, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := &countingClient{MockClient: NewMockClient(0)}
+	client := NewLimitedClient(node, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.RenterFileGet(testSiaPath("file"))
+			if err == nil || !strings.Contains(err.Error(), "no file known") {
+				t.Errorf("expected no file to be known, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if gets := atomic.LoadInt64(&node.fileGets); gets != 1 {
+		t.Fatalf("identical GETs should make a single call, made %v", gets)
+	}
+	if calls := client.Calls(); calls.Made != 1 || calls.Coalesced != 4 {
+		t.Fatalf("expected 1 call made and 4 coalesced, got %+v", calls)
+	}
+
+	// an upload must not be hidden by the GET before it
+	err = client.RenterUploadPost(path, testSiaPath("file"), 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.RenterFileGet(testSiaPath("file"))
+	if err != nil {
+		t.Fatalf("the uploaded file should be found, got %v", err)
+	}
+	if gets := atomic.LoadInt64(&node.fileGets); gets != 2 {
+		t.Fatalf("a GET after an upload should make a new call, made %v", gets)
+	}
+
+	// after the window a GET makes a new call
+	time.Sleep(coalesceWindow)
+	_, err = client.RenterFileGet(testSiaPath("file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gets := atomic.LoadInt64(&node.fileGets); gets != 3 {
+		t.Fatalf("a GET after the window should make a new call, made %v", gets)
+	}
+
+	client = NewLimitedClient(NewMockClient(0), 10)
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		client.RenterDeletePost(testSiaPath("file"))
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("15 calls at 10 per second should take 0.5s, took %v", elapsed)
+	}
+	if calls := client.Calls(); calls.Made != 15 || calls.Throttled != 5 {
+		t.Fatalf("expected 15 calls made and 5 throttled, got %+v", calls)
+	}
+}
@@ -0,0 +1,82 @@
+package siasync
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AuditRecord is an entry of the audit log, a change siasync made or tried to
+// make to the Sia node. Error is set if siad rejected it, DryRun if it was
+// only planned.
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	Op       string    `json:"op"`
+	Path     string    `json:"path,omitempty"`
+	SiaPath  string    `json:"siapath"`
+	To       string    `json:"to,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Checksum string    `json:"checksum,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	DryRun   bool      `json:"dryRun,omitempty"`
+}
+
+// The operations recorded in the audit log.
+const (
+	auditUpload    = "upload"
+	auditDelete    = "delete"
+	auditRename    = "rename"
+	auditCreateDir = "createdir"
+	auditDeleteDir = "deletedir"
+)
+
+// AuditSink receives the audit log of a SiaFolder, one record right after
+// every upload, deletion and rename on Sia. Record is called from several
+// goroutines at once.
+type AuditSink interface {
+	Record(record AuditRecord) error
+}
+
+// JSONAuditLog is an AuditSink writing every record as a line of JSON.
+type JSONAuditLog struct {
+	w io.Writer
+}
+
+// NewJSONAuditLog returns an AuditSink writing to w, which must be safe for
+// concurrent use, like an *os.File. Every record is a single write.
+func NewJSONAuditLog(w io.Writer) *JSONAuditLog {
+	return &JSONAuditLog{w: w}
+}
+
+// Record implements AuditSink.
+func (l *JSONAuditLog) Record(record AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = l.w.Write(append(data, '\n'))
+	return err
+}
+
+// audit sends a change to Sia and its result to the audit sink, if there is
+// one.
+func (sf *SiaFolder) audit(record AuditRecord, err error) {
+	if sf.auditSink == nil {
+		return
+	}
+	record.Time = time.Now()
+	record.DryRun = sf.dryRun
+	if err != nil {
+		record.Error = err.Error()
+	}
+	err = sf.auditSink.Record(record)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"op":      record.Op,
+			"siapath": record.SiaPath,
+			"error":   err.Error(),
+		}).Error("Error writing audit log")
+	}
+}
@@ -0,0 +1,62 @@
+package siasync
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestJSONAuditLog verifies that every record is appended to the audit log as
+// one line of JSON.
+func TestJSONAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	l, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	sink := NewJSONAuditLog(l)
+	records := []AuditRecord{
+		{Time: time.Now(), Op: auditUpload, Path: "/tmp/file", SiaPath: "siasync/file", Size: 4, Checksum: "abcd"},
+		{Time: time.Now(), Op: auditRename, SiaPath: "siasync/file", To: "siasync/new", Error: "no file known with that path"},
+		{Time: time.Now(), Op: auditDelete, SiaPath: "siasync/new", DryRun: true},
+	}
+	for _, record := range records {
+		err = sink.Record(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != len(records) {
+		t.Fatalf("expected %v lines, got %q", len(records), data)
+	}
+	for i, line := range lines {
+		var record AuditRecord
+		err = json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Op != records[i].Op || record.SiaPath != records[i].SiaPath || record.To != records[i].To || record.Error != records[i].Error || record.DryRun != records[i].DryRun {
+			t.Errorf("line %v: expected %+v, got %+v", i, records[i], record)
+		}
+	}
+	if !strings.Contains(lines[2], `"dryRun":true`) || strings.Contains(lines[0], "dryRun") {
+		t.Fatalf("only dry run records should have the dryRun field, got %q", data)
+	}
+}
@@ -0,0 +1,64 @@
+package siasync
+
+import (
+	"regexp"
+	"strings"
+)
+
+// tvCategory is the folder on Sia that files matching the category pattern
+// are synced to with auto categorization, DefaultCategory the one the others
+// are synced to unless configured otherwise.
+const (
+	tvCategory      = "tv"
+	DefaultCategory = "movies"
+)
+
+// defaultCategoryPattern matches the relative paths of TV episodes: season and
+// episode numbers like S02E05, S02.E05 or 2x05, season folders and the dates
+// of daily shows. The numbers must stand on their own, so that titles like
+// Se7en or resolutions like 1920x1080 don't match.
+const defaultCategoryPattern = `(?i)(^|[^a-z0-9])(s\d{1,2}[ ._-]?e\d{1,3}|\d{1,2}x\d{2,3}|season[ ._-]?\d{1,2}|(19|20)\d{2}[ ._-](0[1-9]|1[0-2])[ ._-](0[1-9]|[12]\d|3[01]))([^a-z0-9]|$)`
+
+// category returns the folder on Sia a file is synced to with auto
+// categorization: tvCategory if its slash separated relative path matches the
+// category pattern, and the default category otherwise.
+func (sf *SiaFolder) category(relpath string) string {
+	if sf.categoryPattern.MatchString(relpath) {
+		return tvCategory
+	}
+	return sf.categoryDefault
+}
+
+// categorize returns the slash separated relative path of a file inside its
+// category folder, or the relative path unchanged without auto
+// categorization.
+func (sf *SiaFolder) categorize(relpath string) string {
+	relpath = slashPath(relpath, isWindows)
+	if sf.categoryPattern == nil || relpath == "" {
+		return relpath
+	}
+	return sf.category(relpath) + "/" + relpath
+}
+
+// uncategorize returns the relative path of the local file of a slash
+// separated path below the prefix on Sia, removing its category folder.
+func (sf *SiaFolder) uncategorize(relpath string) string {
+	if sf.categoryPattern == nil {
+		return relpath
+	}
+	for _, category := range []string{tvCategory, sf.categoryDefault} {
+		if strings.HasPrefix(relpath, category+"/") {
+			return strings.TrimPrefix(relpath, category+"/")
+		}
+	}
+	return relpath
+}
+
+// CompileCategoryPattern compiles the pattern of -category-pattern, falling
+// back to defaultCategoryPattern if it is empty.
+func CompileCategoryPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		pattern = defaultCategoryPattern
+	}
+	return regexp.Compile(pattern)
+}
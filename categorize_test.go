@@ -0,0 +1,77 @@
+package siasync
+
+import (
+	"testing"
+)
+
+// TestCategory verifies that the default category pattern recognizes TV
+// episodes without mistaking movies with similar looking titles for them.
+func TestCategory(t *testing.T) {
+	pattern, err := CompileCategoryPattern("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf := &SiaFolder{categoryPattern: pattern, categoryDefault: "movies"}
+	tests := []struct {
+		relpath  string
+		expected string
+	}{
+		{"Show.Name.S02E05.720p.mkv", "tv"},
+		{"show.name.s02e05.mkv", "tv"},
+		{"Show Name - S02.E05 - Title.mkv", "tv"},
+		{"Show.Name.2x05.mkv", "tv"},
+		{"Doctor.Who.S00E05.Special.mkv", "tv"},
+		{"The.Daily.Show.2020.03.15.mkv", "tv"},
+		{"Show Name/Season 2/Episode 5.mkv", "tv"},
+		{"Show Name/Season.02/05.mkv", "tv"},
+		{"Movie.Name.2019.1080p.mkv", "movies"},
+		{"Se7en.1995.mkv", "movies"},
+		{"S1m0ne.2002.mkv", "movies"},
+		{"2001.A.Space.Odyssey.1968.mkv", "movies"},
+		{"Blade.Runner.2049.2017.mkv", "movies"},
+		{"Movie.2019.1920x1080.mkv", "movies"},
+		{"Seasons.Of.Love.2014.mkv", "movies"},
+		{"Apollo.13.1995.mkv", "movies"},
+	}
+	for _, test := range tests {
+		if category := sf.category(test.relpath); category != test.expected {
+			t.Errorf("category(%q): expected %v, got %v", test.relpath, test.expected, category)
+		}
+	}
+}
+
+// TestCategorize verifies that paths are moved into their category folder and
+// back.
+func TestCategorize(t *testing.T) {
+	pattern, err := CompileCategoryPattern(`(?i)s\d+e\d+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf := &SiaFolder{categoryPattern: pattern, categoryDefault: "films"}
+	tests := []struct {
+		relpath     string
+		categorized string
+	}{
+		{"Show.S01E01.mkv", "tv/Show.S01E01.mkv"},
+		{"incoming/Movie.mkv", "films/incoming/Movie.mkv"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		categorized := sf.categorize(test.relpath)
+		if categorized != test.categorized {
+			t.Errorf("categorize(%q): expected %q, got %q", test.relpath, test.categorized, categorized)
+		}
+		if relpath := sf.uncategorize(categorized); relpath != test.relpath {
+			t.Errorf("uncategorize(%q): expected %q, got %q", categorized, test.relpath, relpath)
+		}
+	}
+
+	// without auto categorization paths stay the same
+	sf = &SiaFolder{}
+	if categorized := sf.categorize("Show.S01E01.mkv"); categorized != "Show.S01E01.mkv" {
+		t.Errorf("expected the path to stay the same, got %q", categorized)
+	}
+	if relpath := sf.uncategorize("tv/Show.S01E01.mkv"); relpath != "tv/Show.S01E01.mkv" {
+		t.Errorf("expected the path to stay the same, got %q", relpath)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// configSetting is a setting read from a config file. key is the name of the
+// flag it sets, values has one value for every item of a list.
+type configSetting struct {
+	key    string
+	values []string
+	line   int
+}
+
+// readConfigFile reads the settings in the config file at path.
+func readConfigFile(path string) ([]configSetting, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	settings, err := parseConfigFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return settings, nil
+}
+
+// parseConfigFile parses a config file written in a subset of YAML: one
+// "key: value" per line, where the key is the name of a flag. The value of a
+// flag that can be repeated can be a list, with one "- value" line per item
+// following the key. Values can be quoted, and everything after a # outside
+// of quotes is a comment.
+func parseConfigFile(r io.Reader) ([]configSetting, error) {
+	var settings []configSetting
+	seen := make(map[string]bool)
+
+	// list is set while the items of the last key are read
+	list := false
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), " \t")
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			if !list {
+				return nil, fmt.Errorf("line %d: list item without a key", line)
+			}
+			value, err := parseConfigValue(strings.TrimSpace(trimmed[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			last := &settings[len(settings)-1]
+			last.values = append(last.values, value)
+			continue
+		}
+		if trimmed != text {
+			return nil, fmt.Errorf("line %d: nested keys are not supported", line)
+		}
+
+		kv := strings.SplitN(text, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: expected key: value", line)
+		}
+		key := strings.TrimSpace(kv[0])
+		if seen[key] {
+			return nil, fmt.Errorf("line %d: %v is set twice", line, key)
+		}
+		seen[key] = true
+		setting := configSetting{key: key, line: line}
+		value, err := parseConfigValue(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		// a key without a value may be followed by a list
+		list = value == "" && !strings.ContainsAny(kv[1], "\"'")
+		if !list {
+			setting.values = []string{value}
+		}
+		settings = append(settings, setting)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// keys without a value or list are set to an empty value
+	for i := range settings {
+		if settings[i].values == nil {
+			settings[i].values = []string{""}
+		}
+	}
+	return settings, nil
+}
+
+// parseConfigValue returns the value of a config file line with its quotes
+// and comment removed.
+func parseConfigValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "\""):
+		end := strings.LastIndex(value, "\"")
+		if end == 0 || !isConfigComment(value[end+1:]) {
+			return "", fmt.Errorf("unterminated quote in %v", value)
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		// a single quote is escaped by doubling it
+		end := strings.LastIndex(value, "'")
+		if end == 0 || !isConfigComment(value[end+1:]) {
+			return "", fmt.Errorf("unterminated quote in %v", value)
+		}
+		return strings.Replace(value[1:end], "''", "'", -1), nil
+	case strings.HasPrefix(value, "#"):
+		return "", nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// isConfigComment reports whether the rest of a line after a quoted value is
+// empty or a comment.
+func isConfigComment(rest string) bool {
+	rest = strings.TrimSpace(rest)
+	return rest == "" || strings.HasPrefix(rest, "#")
+}
+
+// applyConfigFile sets the flags of fs to the settings of a config file.
+// Flags given on the command line take precedence over the config file.
+func applyConfigFile(fs *flag.FlagSet, settings []configSetting) error {
+	fromCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		fromCommandLine[f.Name] = true
+	})
+
+	for _, setting := range settings {
+		f := fs.Lookup(setting.key)
+		if f == nil || setting.key == "config" || setting.key == "check-config" {
+			return fmt.Errorf("line %d: unknown key %v", setting.line, setting.key)
+		}
+		if len(setting.values) > 1 && !isRepeatable(f) {
+			return fmt.Errorf("line %d: %v can't be a list", setting.line, setting.key)
+		}
+		if fromCommandLine[setting.key] {
+			continue
+		}
+		for _, value := range setting.values {
+			err := fs.Set(setting.key, value)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid value %q for %v: %v", setting.line, value, setting.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isRepeatable reports whether a flag can be given more than once.
+func isRepeatable(f *flag.Flag) bool {
+	switch f.Value.(type) {
+	case *stringSliceFlag, *mappingFlag:
+		return true
+	}
+	return false
+}
+
+// changedSettings returns the sorted keys whose values differ between two
+// readings of a config file, including keys that were added or removed.
+func changedSettings(previous, current []configSetting) []string {
+	values := func(settings []configSetting) map[string]string {
+		m := make(map[string]string)
+		for _, setting := range settings {
+			m[setting.key] = strings.Join(setting.values, "\n")
+		}
+		return m
+	}
+	oldValues, newValues := values(previous), values(current)
+	var changed []string
+	for key, value := range newValues {
+		if oldValue, ok := oldValues[key]; !ok || oldValue != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range oldValues {
+		if _, ok := newValues[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// reloadConfigFile reads the config file at path again and returns its
+// exclude patterns, which are applied on reload. It warns about every other
+// setting that changed since loaded was read, as they only take effect after
+// a restart. ok is false if the file could not be read.
+func reloadConfigFile(path string, loaded []configSetting) (excludePatterns []string, ok bool) {
+	settings, err := readConfigFile(path)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not read config file")
+		return nil, false
+	}
+	for _, key := range changedSettings(loaded, settings) {
+		if key == "exclude-pattern" {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"key": key,
+		}).Warn("Setting changed in config file, restart siasync to apply it")
+	}
+	for _, setting := range settings {
+		if setting.key == "exclude-pattern" {
+			excludePatterns = append(excludePatterns, setting.values...)
+		}
+	}
+	return excludePatterns, true
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestConfigFile verifies that a config file sets the flags that are not
+// given on the command line, including lists of repeatable flags.
+func TestConfigFile(t *testing.T) {
+	file := `# siasync config
+address: 127.0.0.1:4280
+password: "secret # not a comment"
+subfolder: 'it''s' # a comment
+archive: true
+settle-duration: 1m
+exclude-pattern:
+  - "*.tmp"
+  - sample/
+`
+	settings, err := parseConfigFile(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("siasync", flag.ContinueOnError)
+	address := fs.String("address", "", "")
+	password := fs.String("password", "", "")
+	subfolder := fs.String("subfolder", "", "")
+	archive := fs.Bool("archive", false, "")
+	settle := fs.Duration("settle-duration", 0, "")
+	var patterns stringSliceFlag
+	fs.Var(&patterns, "exclude-pattern", "")
+	err = fs.Parse([]string{"-address", "localhost:9980"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = applyConfigFile(fs, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *address != "localhost:9980" {
+		t.Errorf("the command line should take precedence, got %v", *address)
+	}
+	if *password != "secret # not a comment" || *subfolder != "it's" {
+		t.Errorf("quoted values were not parsed, got %q and %q", *password, *subfolder)
+	}
+	if !*archive || *settle != time.Minute {
+		t.Errorf("expected archive and a settle duration of 1m, got %v and %v", *archive, *settle)
+	}
+	if patterns.String() != "*.tmp,sample/" {
+		t.Errorf("expected both exclude patterns, got %v", patterns.String())
+	}
+}
+
+// TestConfigFileErrors verifies that invalid config files are rejected with
+// the offending line.
+func TestConfigFileErrors(t *testing.T) {
+	fs := flag.NewFlagSet("siasync", flag.ContinueOnError)
+	fs.String("address", "", "")
+	fs.Bool("archive", false, "")
+	fs.String("config", "", "")
+
+	tests := map[string]string{
+		"adress: localhost":       "line 1: unknown key adress",
+		"config: other.yaml":      "line 1: unknown key config",
+		"archive: maybe":          "line 1: invalid value",
+		"address:\n  - a\n  - b":  "line 1: address can't be a list",
+		"address: a\naddress: b":  "line 2: address is set twice",
+		"  - a":                   "line 1: list item without a key",
+		"address: a\n  nested: b": "line 2: nested keys are not supported",
+		"address":                 "line 1: expected key: value",
+		"address: \"unterminated": "line 1: unterminated quote",
+	}
+	for file, expected := range tests {
+		settings, err := parseConfigFile(strings.NewReader(file))
+		if err == nil {
+			err = applyConfigFile(fs, settings)
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("expected %q to fail with %q, got %v", file, expected, err)
+		}
+	}
+}
+
+// TestChangedSettings verifies that added, removed and changed keys of a
+// config file are reported.
+func TestChangedSettings(t *testing.T) {
+	previous, err := parseConfigFile(strings.NewReader("address: a\narchive: true\ndebug: true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := parseConfigFile(strings.NewReader("address: b\narchive: true\nsubfolder: c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := changedSettings(previous, current)
+	if strings.Join(changed, ",") != "address,debug,subfolder" {
+		t.Errorf("expected address, debug and subfolder to change, got %v", changed)
+	}
+}
+
+// TestReloadConfigFile verifies that reloading a config file returns its
+// exclude patterns.
+func TestReloadConfigFile(t *testing.T) {
+	initLogger("info", "text")
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "siasync.yml")
+	err = ioutil.WriteFile(path, []byte("exclude-pattern:\n  - \"*.tmp\"\n  - cache\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, []byte("exclude-pattern:\n  - \"*.log\"\narchive: true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns, ok := reloadConfigFile(path, loaded)
+	if !ok || strings.Join(patterns, ",") != "*.log" {
+		t.Fatalf("expected the new exclude patterns, got %v, %v", patterns, ok)
+	}
+
+	os.Remove(path)
+	if _, ok := reloadConfigFile(path, loaded); ok {
+		t.Fatal("a missing config file shouldn't be reloaded")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MSevey/siasync"
+)
+
+// dirLock is the lock on a synced directory held by this siasync.
+type dirLock struct {
+	file *os.File
+}
+
+// lockDir locks the directory for this siasync and writes its pid to the lock
+// file. It fails if another siasync holds the lock. The lock is held by the
+// open lock file, so the lock of a siasync that crashed is released with it.
+// A single synced file is locked by a lock file named after it next to it.
+func lockDir(dir string) (*dirLock, error) {
+	path := filepath.Join(dir, siasync.LockFileName)
+	if stat, err := os.Stat(dir); err == nil && stat.Mode().IsRegular() {
+		path = filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+siasync.LockFileName)
+	}
+	f, err := lockFile(path)
+	if err == errLocked {
+		pid, _ := ioutil.ReadFile(path)
+		return nil, fmt.Errorf("another siasync (pid %v) is already syncing %v, stop it or run with -no-lock", strings.TrimSpace(string(pid)), dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &dirLock{file: f}, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLockDir verifies that a directory can only be locked once at a time,
+// and can be locked again once it is unlocked. A single file is locked apart
+// from its directory.
+func TestLockDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock, err := lockDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lockDir(dir)
+	if err == nil || !strings.Contains(err.Error(), "already syncing") {
+		t.Fatalf("a locked directory should not be locked again, got %v", err)
+	}
+	err = lock.unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err = lockDir(dir)
+	if err != nil {
+		t.Fatalf("an unlocked directory should be locked again, got %v", err)
+	}
+	defer lock.unlock()
+
+	file := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(file, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileLock, err := lockDir(file)
+	if err != nil {
+		t.Fatalf("a file should be locked apart from its directory, got %v", err)
+	}
+	defer fileLock.unlock()
+	_, err = lockDir(file)
+	if err == nil || !strings.Contains(err.Error(), "already syncing") {
+		t.Fatalf("a locked file should not be locked again, got %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errLocked is returned by lockFile if another process holds the lock.
+var errLocked = errors.New("lock is held by another process")
+
+// lockFile creates the lock file at path. Windows doesn't allow removing a
+// file that another process has open, so a lock file that can be removed was
+// left behind by a siasync that is gone.
+func lockFile(path string) (*os.File, error) {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errLocked
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, errLocked
+	}
+	return f, err
+}
+
+// unlock releases the lock and removes the lock file.
+func (l *dirLock) unlock() error {
+	err := l.file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(l.file.Name())
+}
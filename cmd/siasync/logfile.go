@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// logFile is a log file that is rotated once it grows beyond maxSize bytes.
+// The rotated files are renamed to path.1, path.2 and so on, keeping at most
+// maxBackups of them. A maxSize of 0 never rotates the file.
+type logFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// openLogFile opens the log file at path, appending to it if it exists.
+func openLogFile(path string, maxSize int64, maxBackups int) (*logFile, error) {
+	l := &logFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	err := l.open()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the file at path for appending and records its size.
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = stat.Size()
+	return nil
+}
+
+// Write implements io.Writer, rotating the file first if p would make it
+// grow beyond maxSize. A single write is never split across files.
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		err := l.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file to path.1, shifting older backups up by
+// one and dropping the oldest, and opens a new file at path.
+func (l *logFile) rotate() error {
+	err := l.file.Close()
+	if err != nil {
+		return err
+	}
+	backup := func(i int) string {
+		return fmt.Sprintf("%v.%d", l.path, i)
+	}
+	os.Remove(backup(l.maxBackups))
+	for i := l.maxBackups - 1; i > 0; i-- {
+		err = os.Rename(backup(i), backup(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if l.maxBackups > 0 {
+		err = os.Rename(l.path, backup(1))
+	} else {
+		err = os.Remove(l.path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.open()
+}
+
+// Reopen closes the file and opens path again, so that logs go to a new file
+// after an external tool like logrotate moved the old one away. It can be
+// called again if opening the file failed.
+func (l *logFile) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// the file may already be closed by a failed reopen or rotation
+	l.file.Close()
+	return l.open()
+}
+
+// Close closes the file.
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
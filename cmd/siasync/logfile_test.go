@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLogFileRotation verifies that the log file is rotated once it reaches
+// its maximum size, keeping the configured number of backups.
+func TestLogFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "siasync.log")
+
+	l, err := openLogFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = l.Write([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for file, data := range expected {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(contents) != data {
+			t.Errorf("expected %v to contain %q, got %q", file, data, contents)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("only two backups should be kept")
+	}
+}
+
+// TestLogFileReopen verifies that logs go to a new file after the log file
+// was moved away and reopened.
+func TestLogFileReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "siasync.log")
+
+	l, err := openLogFile(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, err = l.Write([]byte("old\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(path, path+".old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Reopen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.Write([]byte("new\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contents, _ := ioutil.ReadFile(path); string(contents) != "new\n" {
+		t.Errorf("expected the new file to contain the new line, got %q", contents)
+	}
+	if contents, _ := ioutil.ReadFile(path + ".old"); string(contents) != "old\n" {
+		t.Errorf("expected the moved file to contain the old line, got %q", contents)
+	}
+}
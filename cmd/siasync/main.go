@@ -0,0 +1,967 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/MSevey/siasync"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/build"
+)
+
+var (
+	archive           bool
+	debug             bool
+	logLevel          string
+	logFormat         string
+	logFilePath       string
+	auditLogPath      string
+	auditMaxSize      int64
+	auditMaxBackups   int
+	logStderr         bool
+	logMaxSize        int64
+	logMaxBackups     int
+	password          string
+	prefix            string
+	siaPrefix         string
+	autoCategorize    bool
+	categoryPattern   string
+	categoryDefault   string
+	categoryConfig    string
+	include           string
+	exclude           string
+	excludePatterns   stringSliceFlag
+	mappings          mappingFlag
+	skipPreflight     bool
+	dataPieces        uint64
+	parityPieces      uint64
+	sizeOnly          bool
+	changeDetection   string
+	syncOnly          bool
+	dryRun            bool
+	dryRunOutput      string
+	stateFile         string
+	settleDuration    time.Duration
+	maxUploads        int
+	maxUploadsPerHour int
+	maxConcurrentSize int64
+	minFileSize       int64
+	uploadWindowList  string
+	uploadTimezone    string
+	maxUploadAttempts int
+	oneShot           bool
+	pruneOnly         bool
+	restoreOnly       bool
+	verifyOnly        bool
+	jsonOutput        bool
+	statusAddr        string
+	healthTimeout     time.Duration
+	onUpload          string
+	onDelete          string
+	onError           string
+	restoreWorkers    int
+	restoreAt         string
+	scanWorkers       int
+	assumeYes         bool
+	shutdownTimeout   time.Duration
+	apiTimeout        time.Duration
+	maxVersions       int
+	maxDeletes        int
+	maxDeletesPercent float64
+	deleteWindow      time.Duration
+	forceDeletes      bool
+	rescan            bool
+	removeSourceFiles bool
+	emptyDirGrace     time.Duration
+	doneDir           string
+	keepManifest      bool
+	keepMetadata      bool
+	dedupe            bool
+	uploadOrder       string
+	healthInterval    time.Duration
+	progressInterval  time.Duration
+	stallTimeout      time.Duration
+	stallAction       string
+	minRedundancy     float64
+	autoRepair        bool
+	rescanInterval    time.Duration
+	poll              bool
+	pollInterval      time.Duration
+	noCache           bool
+	configPath        string
+	checkConfig       bool
+	directory         string
+	noLock            bool
+	syncHidden        bool
+	sanitizeNames     bool
+	strict            bool
+	confirm           bool
+	lowAllowance      float64
+	pauseOnLow        bool
+	backend           string
+	mockRate          float64
+	apiRate           float64
+)
+
+// backends are the supported values of -backend. sia syncs to siad, mock to a
+// MockClient.
+var backends = []string{"sia", "mock"}
+
+// newMockBackend returns the Sia node of -backend mock. Tests replace it to
+// make the node misbehave.
+var newMockBackend = func(redundancyRate float64) siasync.SiaBackend {
+	return siasync.NewMockClient(redundancyRate)
+}
+
+// log is the logger for outputting info to the terminal
+var log *logrus.Logger
+
+// logLevels and logFormats are the supported values of -log-level and
+// -log-format.
+var (
+	logLevels  = []string{"debug", "info", "warn", "error"}
+	logFormats = []string{"text", "json"}
+)
+
+// initLogger initializes the logger with one of logLevels and logFormats
+func initLogger(level, format string) {
+	log = logrus.New()
+
+	// Define logger level
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		lvl = logrus.InfoLevel
+	}
+	log.SetLevel(lvl)
+
+	// json logs are one object per line with the fields of every log site.
+	// The caller is renamed so it doesn't clash with the file being synced.
+	if format == "json" {
+		log.SetFormatter(&logrus.JSONFormatter{
+			FieldMap: logrus.FieldMap{
+				logrus.FieldKeyTime: "timestamp",
+				logrus.FieldKeyFile: "caller",
+			},
+		})
+	}
+
+	// Print out file names and line numbers
+	log.SetReportCaller(true)
+}
+
+// Usage prints out an example usage command and the defaults for the flags
+func Usage() {
+	fmt.Printf(`usage: siasync <flags> <directory-or-file-to-sync>
+  for example: ./siasync -password abcd123 /tmp/sync/to/sia
+
+`)
+	flag.PrintDefaults()
+}
+
+// usageError prints an error about the command line and the usage, and exits.
+func usageError(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n\n", args...)
+	flag.Usage()
+	os.Exit(2)
+}
+
+// contains checks if a string exists in a []strings.
+func contains(a []string, x string) bool {
+	for _, n := range a {
+		if x == n {
+			return true
+		}
+	}
+	return false
+}
+
+// siaDirectory returns the Sia directory, which is SIA_DIR if it is set.
+func siaDirectory() string {
+	dir := os.Getenv("SIA_DIR")
+	if dir == "" {
+		dir = build.DefaultSiaDir()
+	}
+	return dir
+}
+
+// findAPIPassword looks for the API password via the -password flag, env
+// variable, or the apipassword file in the Sia directory. It returns the
+// password and where it was found.
+func findAPIPassword(flagPassword string) (string, string) {
+	// password from cli -password flag
+	if flagPassword != "" {
+		log.Info("Using API password submitted by user")
+		return flagPassword, "the -password flag"
+	}
+
+	// password from environment variable
+	envPassword := os.Getenv("SIA_API_PASSWORD")
+	if envPassword != "" {
+		log.Info("Using Environnement Variable API password")
+		return envPassword, "the SIA_API_PASSWORD environment variable"
+	}
+
+	// password from apipassword file
+	passwordFile := build.APIPasswordFile(siaDirectory())
+	APIPasswordFile, err := ioutil.ReadFile(passwordFile)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Could not read API password file")
+	}
+	return strings.TrimSpace(string(APIPasswordFile)), passwordFile
+}
+
+// apiPasswordError returns the error to report if siad rejected the API
+// password from passwordSource with err, or nil if err is something else.
+func apiPasswordError(err error, passwordSource string) error {
+	if err == nil || !(strings.Contains(err.Error(), "401") || strings.Contains(err.Error(), "API authentication failed")) {
+		return nil
+	}
+	return fmt.Errorf("wrong API password from %v, siasync looks for it in the -password flag, the SIA_API_PASSWORD environment variable and the apipassword file in SIA_DIR or the default Sia directory, in that order: %v", passwordSource, err)
+}
+
+// testConnection test the connection to the sia network, and that the renter
+// can upload with the erasure coding.
+func testConnection(sc siasync.SiaBackend, passwordSource string, dataPieces, parityPieces uint64) {
+	// Get siad Version
+	version, err := sc.DaemonVersionGet()
+	if passwordErr := apiPasswordError(err, passwordSource); passwordErr != nil {
+		log.WithFields(logrus.Fields{
+			"error": passwordErr.Error(),
+		}).Fatal("Sia rejected the API password")
+	}
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Panic("Could not get the Daemon version")
+	}
+	log.WithFields(logrus.Fields{
+		"version": version.Version,
+	}).Info("Connected to Sia")
+
+	if skipPreflight {
+		return
+	}
+	problems, err := siasync.Preflight(sc, dataPieces, parityPieces)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Could not check whether Sia can accept uploads")
+	}
+	for _, problem := range problems {
+		log.Error(problem)
+	}
+	if len(problems) > 0 {
+		log.Fatal("Sia can't accept uploads, fix the problems above or run with -skip-preflight")
+	}
+	log.Info("Sia is ready for uploads")
+}
+
+func main() {
+	flag.Usage = Usage
+	flag.StringVar(&configPath, "config", "", "Config file to read flags from, one \"flag: value\" per line. Flags on the command line take precedence")
+	flag.BoolVar(&checkConfig, "check-config", false, "Check the flags and the config file and exit without syncing")
+	flag.StringVar(&directory, "directory", "", "Directory to sync, instead of the last argument")
+	var addresses stringSliceFlag
+	flag.Var(&addresses, "address", "Sia's API address (default \""+siasync.DefaultAddress+"\"). Can be repeated or comma separated to fail over to standby siad nodes in that order")
+	flag.StringVar(&password, "password", "", "Sia's API password")
+	flag.Float64Var(&apiRate, "api-rate", 20, "Maximum number of siad API calls per second, shared by all synced directories, 0 is unlimited")
+	flag.DurationVar(&apiTimeout, "api-timeout", 5*time.Minute, "How long a siad API call of a synced directory may take before it is given up, 0 waits forever")
+	flag.StringVar(&backend, "backend", "sia", "Sia node to sync to: sia for siad, mock for an in-memory fake to try siasync without siad")
+	flag.Float64Var(&mockRate, "mock-redundancy-rate", 0.5, "Redundancy files uploaded to -backend mock gain per second, 0 makes them fully redundant right away")
+	agent := flag.String("agent", "Sia-Agent", "Sia agent")
+	flag.BoolVar(&archive, "archive", false, "Files will not be removed from Sia, even if they are deleted locally, and changed files are uploaded as new versions next to the old ones")
+	flag.IntVar(&maxVersions, "max-versions", 0, "Number of versions of a changed file kept on Sia with -archive, older versions are removed, 0 keeps them all")
+	flag.BoolVar(&debug, "debug", false, "Enable debug mode, same as -log-level debug. Warning: generates a lot of output.")
+	flag.StringVar(&logLevel, "log-level", "info", "Minimum level of logged messages: "+strings.Join(logLevels, ", "))
+	flag.StringVar(&logFilePath, "log-file", "", "File to write logs to instead of stderr")
+	flag.StringVar(&auditLogPath, "audit-log", "", "File to append a JSON line to for every upload, deletion and rename on Sia")
+	flag.Int64Var(&auditMaxSize, "audit-log-max-size", 100, "Size in MB at which -audit-log is rotated, 0 never rotates it")
+	flag.IntVar(&auditMaxBackups, "audit-log-max-backups", 5, "Number of rotated audit logs to keep")
+	flag.BoolVar(&logStderr, "log-stderr", false, "Also log to stderr when logging to -log-file")
+	flag.Int64Var(&logMaxSize, "log-max-size", 100, "Size in MB at which -log-file is rotated, 0 never rotates it")
+	flag.IntVar(&logMaxBackups, "log-max-backups", 5, "Number of rotated log files to keep")
+	flag.StringVar(&logFormat, "log-format", "text", "Format of logged messages: "+strings.Join(logFormats, ", ")+", json writes one object per line")
+	flag.StringVar(&prefix, "subfolder", "siasync", "Folder on Sia to sync files too")
+	flag.BoolVar(&autoCategorize, "auto-categorize", false, "Sync TV episodes into the tv folder inside the folder on Sia and everything else into the -category-default folder")
+	flag.StringVar(&categoryPattern, "category-pattern", "", "Regular expression matching the relative paths of TV episodes for -auto-categorize (default season and episode numbers like S02E05 or 2x05, season folders and dates)")
+	flag.StringVar(&categoryDefault, "category-default", siasync.DefaultCategory, "Folder for the files -auto-categorize doesn't recognize as TV episodes")
+	flag.StringVar(&categoryConfig, "category-config", "", "Erasure coding and minimum redundancy per top-level folder on Sia, like movies:10/20:1.0,home:10/40:2.0, the redundancy is optional")
+	flag.StringVar(&siaPrefix, "siapath-prefix", "", "Folder on Sia that -subfolder and the -mapping folders are in, {hostname} is replaced with the host name and {dir} with the name of the synced directory")
+	flag.StringVar(&include, "include", "", "Comma separated list of file extensions to copy, all other files will be ignored.")
+	flag.StringVar(&exclude, "exclude", "", "Comma separated list of file extensions to skip, all other files will be copied.")
+	flag.Var(&excludePatterns, "exclude-pattern", "Glob pattern of files or directories to skip, relative to the synced directory. ** matches any number of directories. Can be repeated, more patterns can be listed in "+siasync.IgnoreFile+".")
+	flag.Var(&mappings, "mapping", "Sync a directory to a folder on Sia, written as local=<directory>,sia=<folder>. Can be repeated to sync several directories instead of the one given as argument.")
+	flag.BoolVar(&skipPreflight, "skip-preflight", false, "Don't check that the wallet is unlocked, an allowance is set and there are enough contracts before syncing")
+	flag.Uint64Var(&dataPieces, "data-pieces", 10, "Number of data pieces in erasure code")
+	flag.Uint64Var(&parityPieces, "parity-pieces", 30, "Number of parity pieces in erasure code")
+	flag.BoolVar(&sizeOnly, "size-only", false, "Compare only based on file size and not on checksum, same as -change-detection size")
+	flag.StringVar(&changeDetection, "change-detection", "sha256", "How to detect changed files: sha256, size or mtime")
+	flag.BoolVar(&sanitizeNames, "sanitize-names", false, "Replace characters Sia doesn't accept in file names instead of skipping those files")
+	flag.BoolVar(&strict, "strict", false, "Fail at startup if a file or directory can't be read, instead of skipping it")
+	flag.BoolVar(&syncHidden, "sync-hidden", false, "Also sync hidden files and directories and the temporary files of editors and file managers, which are skipped by default")
+	flag.BoolVar(&syncOnly, "sync-only", false, "Sync, don't monitor directory for changes")
+	flag.BoolVar(&removeSourceFiles, "remove-source-files", false, "Remove the local copy of a file once it is on Sia with a redundancy of at least 1, implies -archive")
+	flag.StringVar(&doneDir, "done-dir", "", "Move files into this directory once they are on Sia with a redundancy of at least 1, keeping their path relative to the synced directory, implies -archive")
+	flag.DurationVar(&healthInterval, "health-interval", 0, "How often to check the redundancy of uploaded files while watching, 0 never")
+	flag.DurationVar(&progressInterval, "progress-interval", 0, "How often to log the upload progress and redundancy of files Sia is still uploading while watching, 0 never")
+	flag.DurationVar(&emptyDirGrace, "empty-dir-grace", 0, "Remove directories on Sia below the synced folder that have been empty for this long, except the category folders and the ones of local directories, 0 keeps them")
+	flag.DurationVar(&stallTimeout, "stall-timeout", 0, "How long the upload progress of a file may not increase while watching before -stall-action is taken, 0 never")
+	flag.StringVar(&stallAction, "stall-action", "alert", "What to do with a stalled upload: alert logs it and runs the -on-error script, reupload also uploads the file again")
+	flag.Float64Var(&minRedundancy, "min-redundancy", 1, "Redundancy below which -health-interval reports a file")
+	flag.Float64Var(&lowAllowance, "low-allowance", 10, "Percentage of the allowance below which the unspent allowance is reported as low")
+	flag.BoolVar(&pauseOnLow, "pause-on-low-allowance", false, "Pause new uploads while the unspent allowance is below -low-allowance, deletions and renames go on")
+	flag.BoolVar(&autoRepair, "auto-repair", false, "Upload files again that stay below -min-redundancy for "+strconv.Itoa(siasync.HealthChecksBeforeRepair)+" health checks in a row, if the local file is unchanged")
+	flag.BoolVar(&dedupe, "dedupe", false, "Don't upload files with the same content as an uploaded file, record them as its duplicates in the manifest instead, needs -change-detection sha256 and implies -manifest")
+	flag.BoolVar(&keepManifest, "manifest", false, "Keep a manifest of the uploaded files and their checksums in the folder on Sia, which -verify and -restore use to check file contents")
+	flag.BoolVar(&keepMetadata, "preserve-metadata", false, "Record the modification time, permissions and owner of uploaded files in the manifest, which -restore applies to the restored files, implies -manifest")
+	flag.BoolVar(&oneShot, "one-shot", false, "Sync once and exit, with a non-zero status if any file could not be uploaded")
+	flag.BoolVar(&pruneOnly, "prune", false, "Delete the files on Sia that no longer exist locally, even with -archive, and exit")
+	flag.BoolVar(&restoreOnly, "restore", false, "Download every file in the Sia folder into the directory and exit, skipping files that are already there")
+	flag.IntVar(&restoreWorkers, "restore-concurrency", 4, "Maximum number of files downloaded at the same time by -restore")
+	flag.StringVar(&restoreAt, "restore-at", "", "Time like 2006-01-02 or 2006-01-02T15:04:05Z07:00 whose versions of the files -restore downloads, leaving out files uploaded later (default the current versions)")
+	flag.BoolVar(&verifyOnly, "verify", false, "Compare the directory with the files on Sia without changing anything and exit, with a non-zero status if they differ")
+	flag.BoolVar(&jsonOutput, "json", false, "Print the -verify report as JSON")
+	flag.StringVar(&statusAddr, "status-addr", "", "Address to serve the sync status as JSON on /status, for example 127.0.0.1:9990")
+	flag.DurationVar(&healthTimeout, "health-timeout", 5*time.Minute, "How long the event watcher may not run and siad API calls may fail before /healthz reports siasync as unhealthy")
+	flag.StringVar(&onUpload, "on-upload", "", "Script to run after a file was uploaded to Sia")
+	flag.StringVar(&onDelete, "on-delete", "", "Script to run after a file was deleted from Sia")
+	flag.StringVar(&onError, "on-error", "", "Script to run when siasync gives up uploading a file, or an upload stalls")
+	flag.BoolVar(&confirm, "confirm", false, "Summarize the initial upload and ask for confirmation before it starts")
+	flag.BoolVar(&assumeYes, "yes", false, "Don't ask for confirmation before -prune deletes files or the -confirm upload starts")
+	flag.StringVar(&uploadOrder, "upload-order", "fifo", "Order in which queued files are uploaded: "+strings.Join(siasync.UploadOrders, ", "))
+	flag.IntVar(&maxUploads, "max-uploads", 4, "Maximum number of files handed to Sia for upload at the same time")
+	flag.IntVar(&maxUploadsPerHour, "max-uploads-per-hour", 0, "Maximum number of files handed to Sia for upload per hour, 0 is unlimited")
+	flag.Int64Var(&maxConcurrentSize, "max-concurrent-size", 0, "Size in MB of the files Sia may be uploading before the next file is handed to it, 0 is unlimited")
+	flag.StringVar(&uploadWindowList, "upload-window", "", "Comma separated times of day during which files are uploaded, like 22:00-06:00, files are queued outside of them (default always)")
+	flag.StringVar(&uploadTimezone, "upload-window-timezone", "Local", "Time zone of -upload-window, like UTC or Europe/Berlin")
+	flag.Int64Var(&minFileSize, "min-file-size", 0, "Size in bytes below which files are not uploaded until they grow, like the empty placeholders of download clients")
+	flag.IntVar(&maxUploadAttempts, "upload-attempts", 5, "How often a failed upload is retried with exponential backoff before it is given up")
+	flag.IntVar(&maxDeletes, "max-deletes", 500, "Hold back removals from Sia once more files than this were removed within -delete-window, until resumed with POST /resume-deletes, 0 is unlimited")
+	flag.Float64Var(&maxDeletesPercent, "max-deletes-percent", 25, "Hold back removals from Sia once more than this percentage of the tracked files were removed within -delete-window, 0 is unlimited")
+	flag.DurationVar(&deleteWindow, "delete-window", siasync.DefaultDeleteWindow, "Window in which removed files are counted against -max-deletes and -max-deletes-percent")
+	flag.BoolVar(&forceDeletes, "force-resume-deletes", false, "Remove the files deleted while siasync wasn't running from Sia, even beyond -max-deletes and -max-deletes-percent")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long to wait for uploads in progress when exiting, 0 waits forever")
+	flag.DurationVar(&settleDuration, "settle-duration", 10*time.Second, "How long a file must stop changing before it is uploaded")
+	flag.StringVar(&stateFile, "state-file", "", "File to keep the state of synced files in between runs (default \"<directory-to-sync>/"+siasync.DefaultStateFile+"\")")
+	flag.BoolVar(&rescan, "rescan", false, "Ignore the state file and checksum every file again")
+	flag.BoolVar(&poll, "poll", false, "Walk the directory for changes every -poll-interval instead of watching it, for filesystems like NFS that don't report changes")
+	flag.DurationVar(&pollInterval, "poll-interval", siasync.DefaultPollInterval, "How often to walk the directory for changes when polling, or the directories that could not be watched")
+	flag.DurationVar(&rescanInterval, "rescan-interval", 0, "How often to walk the watched directory again to catch up on missed changes, 0 never")
+	flag.BoolVar(&noLock, "no-lock", false, "Don't lock the synced directories against a second siasync syncing them")
+	flag.BoolVar(&noCache, "no-cache", false, "Don't read or write the state file, checksum every file on every start")
+	flag.IntVar(&scanWorkers, "scan-workers", 0, "Number of files checksummed at the same time when scanning the directory at startup and when files change, 0 uses one per CPU")
+	flag.BoolVar(&dryRun, "dry-run", false, "Show what would have been uploaded without changing files in Sia")
+	flag.StringVar(&dryRunOutput, "dry-run-output", "", "File to write the changes a dry run would have made to, as JSON")
+
+	flag.Parse()
+
+	// flags that are not on the command line are taken from the config
+	// file, which must be read before the logger is set up
+	var configSettings []configSetting
+	var configErr error
+	excludeOnCommandLine := false
+	flag.Visit(func(f *flag.Flag) {
+		excludeOnCommandLine = excludeOnCommandLine || f.Name == "exclude-pattern"
+	})
+	if configPath != "" {
+		configSettings, configErr = readConfigFile(configPath)
+		if configErr == nil {
+			configErr = applyConfigFile(flag.CommandLine, configSettings)
+			if configErr != nil {
+				configErr = fmt.Errorf("%v: %v", configPath, configErr)
+			}
+		}
+	}
+
+	// Init the logger
+	if debug {
+		logLevel = "debug"
+	}
+	badLogging := !contains(logLevels, logLevel) || !contains(logFormats, logFormat)
+	initLogger(logLevel, logFormat)
+	siasync.SetLogger(log)
+	if badLogging {
+		log.WithFields(logrus.Fields{
+			"log-level":  logLevel,
+			"log-format": logFormat,
+		}).Fatal("Unknown log level or format")
+	}
+	var logOutput *logFile
+	if logFilePath != "" {
+		var err error
+		logOutput, err = openLogFile(logFilePath, logMaxSize*1e6, logMaxBackups)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatal("Could not open log file")
+		}
+		defer logOutput.Close()
+		if logStderr {
+			log.SetOutput(io.MultiWriter(os.Stderr, logOutput))
+		} else {
+			log.SetOutput(logOutput)
+		}
+	}
+	if configErr != nil {
+		log.WithFields(logrus.Fields{
+			"error": configErr.Error(),
+		}).Fatal("Invalid config file")
+	}
+
+	if sizeOnly {
+		changeDetection = "size"
+	}
+	if oneShot || pruneOnly || verifyOnly {
+		syncOnly = true
+	}
+	if (removeSourceFiles || doneDir != "") && syncOnly {
+		log.Fatal("-remove-source-files and -done-dir need siasync to keep running until files are uploaded, they can't be used with -sync-only, -one-shot, -prune or -verify")
+	}
+	if scanWorkers < 1 {
+		scanWorkers = runtime.NumCPU()
+	}
+	if !contains(backends, backend) {
+		log.WithFields(logrus.Fields{
+			"backend": backend,
+		}).Fatal("Unknown backend")
+	}
+	if lowAllowance < 0 || lowAllowance > 100 {
+		log.Fatal("-low-allowance must be a percentage between 0 and 100")
+	}
+	if maxVersions < 0 {
+		log.Fatal("-max-versions can't be negative")
+	}
+	restoreTime, err := siasync.ParseRestoreTime(restoreAt)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Invalid -restore-at")
+	}
+	if maxDeletes < 0 {
+		log.Fatal("-max-deletes can't be negative")
+	}
+	if maxDeletesPercent < 0 || maxDeletesPercent > 100 {
+		log.Fatal("-max-deletes-percent must be a percentage between 0 and 100")
+	}
+	if deleteWindow <= 0 {
+		log.Fatal("-delete-window must be positive")
+	}
+	if apiRate < 0 {
+		log.Fatal("-api-rate can't be negative")
+	}
+	if apiTimeout < 0 {
+		log.Fatal("-api-timeout can't be negative")
+	}
+	if mockRate < 0 {
+		log.Fatal("-mock-redundancy-rate can't be negative")
+	}
+	if !contains(siasync.UploadOrders, uploadOrder) {
+		log.WithFields(logrus.Fields{
+			"upload-order": uploadOrder,
+		}).Fatal("Unknown upload order")
+	}
+	if !contains(siasync.StallActions, stallAction) {
+		log.WithFields(logrus.Fields{
+			"stall-action": stallAction,
+		}).Fatal("Unknown stall action")
+	}
+	if !contains(siasync.ChangeDetectionModes, changeDetection) {
+		log.WithFields(logrus.Fields{
+			"change-detection": changeDetection,
+		}).Fatal("Unknown change detection mode")
+	}
+	if _, err := siasync.CompileCategoryPattern(categoryPattern); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Invalid -category-pattern")
+	}
+	categories, err := siasync.ParseCategorySettings(categoryConfig)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Invalid -category-config")
+	}
+	preflightData, preflightParity := siasync.MostContracts(dataPieces, parityPieces, categories)
+	if dedupe && changeDetection != "sha256" {
+		log.Fatal("-dedupe needs the sha256 checksums of files, it can't be used with -size-only or another -change-detection mode")
+	}
+	uploadWindows, err := siasync.ParseUploadWindows(uploadWindowList)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Invalid -upload-window")
+	}
+	uploadLocation, err := time.LoadLocation(uploadTimezone)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Invalid -upload-window-timezone")
+	}
+
+	// sync the directory given as argument or with -directory to
+	// -subfolder, unless directories are mapped to folders on Sia with
+	// -mapping
+	args := flag.Args()
+	switch {
+	case len(args) > 1:
+		usageError("expected a single directory to sync after the flags, got %v", strings.Join(args, " "))
+	case len(args) == 1 && len(mappings) > 0:
+		usageError("the directory to sync can't be given together with -mapping")
+	}
+	if len(mappings) == 0 {
+		local := directory
+		if len(args) == 1 {
+			local = args[0]
+		}
+		if local == "" {
+			usageError("no directory to sync given")
+		}
+		mappings = append(mappings, folderMapping{local: local, sia: prefix})
+	}
+	for i, mapping := range mappings {
+		// restore creates the directory it restores into
+		if restoreOnly {
+			local, err := expandHome(mapping.local)
+			if err == nil {
+				err = os.MkdirAll(local, 0755)
+			}
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Fatal("Could not create directory to restore into")
+			}
+		}
+		local, err := checkDirectory(mapping.local)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatal("Invalid directory to sync")
+		}
+		mappings[i].local = local
+	}
+	if siaPrefix != "" {
+		hostname, _ := os.Hostname()
+		for i, mapping := range mappings {
+			folder, err := expandSiaPrefix(siaPrefix, mapping.local, hostname)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Fatal("Invalid -siapath-prefix")
+			}
+			mappings[i].sia = strings.Trim(folder+"/"+strings.Trim(mapping.sia, "/"), "/")
+		}
+	}
+	err = checkMappings(mappings)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatal("Invalid mapping")
+	}
+	if len(mappings) > 1 && (stateFile != "" || dryRunOutput != "" || doneDir != "") {
+		log.Fatal("-state-file, -dry-run-output and -done-dir can't be used with more than one mapping")
+	}
+	if checkConfig {
+		log.Info("Configuration is valid")
+		return
+	}
+
+	// keep a second siasync from syncing the same directories, the locks
+	// are released when siasync exits
+	if !noLock {
+		for _, mapping := range mappings {
+			lock, err := lockDir(mapping.local)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Fatal("Could not lock directory")
+			}
+			defer lock.unlock()
+		}
+	}
+
+	var sc siasync.SiaBackend
+	var passwordSource string
+	if backend == "mock" {
+		log.Warn("Syncing to an in-memory mock Sia node, nothing is uploaded to Sia")
+		sc = newMockBackend(mockRate)
+	} else {
+		var apiPassword string
+		apiPassword, passwordSource = findAPIPassword(password)
+		client := siasync.NewFailoverClient(siasync.ParseAddresses(addresses), apiPassword, *agent)
+		client.Connect()
+		sc = client
+	}
+	limiter := siasync.NewLimitedClient(sc, apiRate)
+	sc = limiter
+
+	// Verify that we can talk to Sia and have valid contracts.
+	testConnection(sc, passwordSource, preflightData, preflightParity)
+
+	var auditSink siasync.AuditSink
+	var auditOutput *logFile
+	if auditLogPath != "" {
+		var err error
+		auditOutput, err = openLogFile(auditLogPath, auditMaxSize*1e6, auditMaxBackups)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatal("Could not open audit log")
+		}
+		defer auditOutput.Close()
+		auditSink = siasync.NewJSONAuditLog(auditOutput)
+	}
+
+	spending := siasync.NewSpendingTracker(sc, lowAllowance, pauseOnLow)
+	config := siasync.Config{
+		Archive:              archive,
+		MaxVersions:          maxVersions,
+		RestoreAt:            restoreTime,
+		RemoveSourceFiles:    removeSourceFiles,
+		DoneDir:              doneDir,
+		Manifest:             keepManifest,
+		PreserveMetadata:     keepMetadata,
+		Dedupe:               dedupe,
+		AutoCategorize:       autoCategorize,
+		CategoryPattern:      categoryPattern,
+		CategoryDefault:      categoryDefault,
+		HealthInterval:       healthInterval,
+		ProgressInterval:     progressInterval,
+		StallTimeout:         stallTimeout,
+		StallAction:          stallAction,
+		MinRedundancy:        minRedundancy,
+		AutoRepair:           autoRepair,
+		DataPieces:           dataPieces,
+		ParityPieces:         parityPieces,
+		Categories:           categories,
+		EmptyDirGrace:        emptyDirGrace,
+		AuditSink:            auditSink,
+		Spending:             spending,
+		API:                  limiter,
+		IncludeExtensions:    siasync.ParseExtensions(include),
+		ExcludeExtensions:    siasync.ParseExtensions(exclude),
+		ExcludePatterns:      excludePatterns,
+		SyncHidden:           syncHidden,
+		MinFileSize:          minFileSize,
+		SanitizeNames:        sanitizeNames,
+		Strict:               strict,
+		ChangeDetection:      changeDetection,
+		SettleDuration:       settleDuration,
+		RescanInterval:       rescanInterval,
+		Poll:                 poll,
+		PollInterval:         pollInterval,
+		StateFile:            stateFile,
+		Rescan:               rescan,
+		NoCache:              noCache,
+		ScanWorkers:          scanWorkers,
+		SyncOnly:             syncOnly,
+		SkipInitialSync:      pruneOnly || verifyOnly,
+		DryRun:               dryRun,
+		DryRunOutput:         dryRunOutput,
+		UploadOrder:          uploadOrder,
+		MaxUploads:           maxUploads,
+		MaxUploadsPerHour:    maxUploadsPerHour,
+		MaxConcurrentBytes:   maxConcurrentSize * 1e6,
+		UploadWindows:        uploadWindows,
+		UploadWindowLocation: uploadLocation,
+		MaxUploadAttempts:    maxUploadAttempts,
+		MaxDeletes:           maxDeletes,
+		MaxDeletesPercent:    maxDeletesPercent,
+		DeleteWindow:         deleteWindow,
+		ForceResumeDeletes:   forceDeletes,
+		ShutdownTimeout:      shutdownTimeout,
+		APITimeout:           apiTimeout,
+		OnUpload:             onUpload,
+		OnDelete:             onDelete,
+		OnError:              onError,
+	}
+	// the folders share one upload queue, so that the upload limits hold
+	// for all of them together
+	config.Uploads = siasync.NewUploadQueue(config)
+	if confirm {
+		config.ConfirmUpload = func(summary siasync.UploadSummary) bool {
+			return siasync.ConfirmUpload(summary, assumeYes, siasync.IsTerminal(os.Stdin), os.Stdin, os.Stdout)
+		}
+	}
+
+	if restoreOnly {
+		for _, mapping := range mappings {
+			config.Prefix = mapping.sia
+			err := siasync.Restore(sc, mapping.local, config, restoreWorkers)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Fatal("Could not restore files")
+			}
+		}
+		log.Info("Done")
+		return
+	}
+
+	var folders []*siasync.SiaFolder
+	closeFolders := func() error {
+		var closeErr error
+		for _, sf := range folders {
+			err := sf.Close()
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"directory": sf.Path(),
+					"error":     err.Error(),
+				}).Error("Could not shut down cleanly")
+				closeErr = err
+			}
+		}
+		return closeErr
+	}
+
+	// the status server answers health probes during the initial sync
+	statusFolders := siasync.NewFolderList(len(mappings))
+	if !syncOnly && statusAddr != "" {
+		server, err := siasync.ServeStatus(statusAddr, statusFolders, healthTimeout)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatal("Could not serve status")
+		}
+		defer server.Close()
+	}
+
+	// an interrupt or SIGTERM stops the folders and shuts siasync down, a
+	// second one kills it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	for _, mapping := range mappings {
+		config.Prefix = mapping.sia
+		sf, err := siasync.NewSiafolder(ctx, mapping.local, sc, config)
+		if err != nil {
+			closeFolders()
+			log.WithFields(logrus.Fields{
+				"directory": mapping.local,
+				"error":     err.Error(),
+			}).Fatal("Could not create new Siafolder")
+		}
+		folders = append(folders, sf)
+		statusFolders.Add(sf)
+	}
+
+	// without watching, the initial sync is all there is to do. An interrupt
+	// stops it, siasync then shuts down like after a finished sync.
+	interrupted := false
+	if syncOnly {
+		for _, sf := range folders {
+			err = sf.WaitInitialSync()
+			if err != nil && ctx.Err() != nil {
+				log.Error("caught quit signal, exiting...")
+				interrupted = true
+				break
+			}
+			if err != nil {
+				closeFolders()
+				log.WithFields(logrus.Fields{
+					"directory": sf.Path(),
+					"error":     err.Error(),
+				}).Fatal("Initial sync failed")
+			}
+		}
+	}
+
+	if pruneOnly && !interrupted {
+		for _, sf := range folders {
+			_, err = sf.Prune(assumeYes, os.Stdin, os.Stdout)
+			if err != nil {
+				closeFolders()
+				log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Fatal("Could not prune files")
+			}
+		}
+	}
+
+	if verifyOnly && !interrupted {
+		var report siasync.VerifyReport
+		for _, sf := range folders {
+			folderReport, err := sf.Verify()
+			if err != nil {
+				closeFolders()
+				log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Fatal("Could not verify files")
+			}
+			if len(folders) == 1 {
+				report = folderReport
+			} else {
+				report.Add(folderReport, sf.Prefix())
+			}
+		}
+		closeFolders()
+		if jsonOutput {
+			err = report.WriteJSON(os.Stdout)
+		} else {
+			err = report.WriteTable(os.Stdout)
+		}
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatal("Could not write verify report")
+		}
+		if !report.OK() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if !syncOnly {
+		for _, sf := range folders {
+			fields := logrus.Fields{
+				"directory": sf.Path(),
+				"subfolder": sf.Prefix(),
+			}
+			if sf.SingleFile() != "" {
+				fields["file"] = sf.SingleFile()
+			}
+			log.WithFields(fields).Info("Watching Directory for changes")
+		}
+
+		stopMonitor := make(chan struct{})
+		if !skipPreflight {
+			go siasync.MonitorNode(sc, folders, preflightData, preflightParity, stopMonitor)
+		}
+
+		// SIGUSR1 toggles whether syncing is paused
+		toggle := make(chan os.Signal, 1)
+		notifyPause(toggle)
+		go func() {
+			for range toggle {
+				siasync.TogglePause(folders)
+			}
+		}()
+
+		// SIGUSR2 logs the statistics so far
+		dump := make(chan os.Signal, 1)
+		notifyStats(dump)
+		go func() {
+			for range dump {
+				siasync.LogStats(folders, "Sync statistics")
+			}
+		}()
+
+		// SIGHUP reloads the ignore files and the exclude patterns of the
+		// config file and rescans the directories. Other settings from the
+		// config file need a restart.
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		go func() {
+			for range reload {
+				log.Info("caught reload signal")
+				if logOutput != nil {
+					err := logOutput.Reopen()
+					if err != nil {
+						log.WithFields(logrus.Fields{
+							"error": err.Error(),
+						}).Error("Could not reopen log file")
+					}
+				}
+				if auditOutput != nil {
+					err := auditOutput.Reopen()
+					if err != nil {
+						log.WithFields(logrus.Fields{
+							"error": err.Error(),
+						}).Error("Could not reopen audit log")
+					}
+				}
+				// the exclude patterns of the config file are applied,
+				// unless they were overridden on the command line
+				if configPath != "" {
+					patterns, ok := reloadConfigFile(configPath, configSettings)
+					if ok && !excludeOnCommandLine {
+						for _, sf := range folders {
+							sf.SetExcludePatterns(patterns)
+						}
+					}
+				}
+				for _, sf := range folders {
+					sf.Reload()
+				}
+			}
+		}()
+
+		// the folders are watched while their initial sync runs
+		synced := make(chan error, 1)
+		go func() {
+			for _, sf := range folders {
+				err := sf.WaitInitialSync()
+				if err != nil {
+					synced <- fmt.Errorf("initial sync of %v failed: %v", sf.Path(), err)
+					return
+				}
+			}
+			synced <- nil
+		}()
+
+		// under systemd, report once the initial sync is done and keep the
+		// watchdog fed until siasync quits
+		var watchdogTick <-chan time.Time
+		if interval := watchdogInterval(); interval > 0 {
+			watchdogTicker := time.NewTicker(interval)
+			defer watchdogTicker.Stop()
+			watchdogTick = watchdogTicker.C
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				break wait
+			case err := <-synced:
+				synced = nil
+				if err != nil && ctx.Err() != nil {
+					break wait
+				}
+				if err != nil {
+					closeFolders()
+					log.WithFields(logrus.Fields{
+						"error": err.Error(),
+					}).Fatal("Initial sync failed")
+				}
+				log.Info("Initial sync done")
+				notifySystemd("READY=1")
+			case <-watchdogTick:
+				notifySystemd("WATCHDOG=1")
+			}
+		}
+		log.Error("caught quit signal, exiting...")
+		notifySystemd("STOPPING=1")
+		close(stopMonitor)
+	}
+
+	err = closeFolders()
+	siasync.LogStats(folders, "Sync summary")
+	spending.LogSummary()
+	limiter.LogSummary()
+	failed := 0
+	for _, sf := range folders {
+		failed += len(sf.FailedUploads())
+	}
+	if oneShot && (err != nil || failed > 0) {
+		log.WithFields(logrus.Fields{
+			"failed": failed,
+		}).Fatal("Sync did not complete")
+	}
+	log.Info("Done")
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MSevey/siasync"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/build"
+	"gitlab.com/NebulousLabs/Sia/modules"
+)
+
+// TestMain runs siasync instead of the tests if SIASYNC_TEST_ARGS is set, so
+// that a test can run it as a command and check how it exits.
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv("SIASYNC_TEST_ARGS"); ok {
+		if os.Getenv("SIASYNC_TEST_FAIL_UPLOADS") != "" {
+			newMockBackend = func(redundancyRate float64) siasync.SiaBackend {
+				return failingUploads{siasync.NewMockClient(redundancyRate)}
+			}
+		}
+		os.Args = append([]string{"siasync"}, strings.Fields(args)...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// failingUploads is a MockClient that rejects every upload.
+type failingUploads struct {
+	*siasync.MockClient
+}
+
+func (f failingUploads) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	return errors.New("upload rejected")
+}
+
+// TestInitLoggerJSON verifies that json logs keep the fields of the log site
+// and the level filters messages.
+func TestInitLoggerJSON(t *testing.T) {
+	defer initLogger("info", "text")
+	initLogger("warn", "json")
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+
+	log.Info("not logged")
+	log.WithFields(logrus.Fields{
+		"event": "upload",
+		"file":  "/tmp/foo/file",
+		"bytes": 4,
+	}).Warn("Uploaded file")
+
+	var entry map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("expected a single json object, got %q: %v", buf.String(), err)
+	}
+	expected := map[string]interface{}{
+		"level": "warning",
+		"msg":   "Uploaded file",
+		"event": "upload",
+		"file":  "/tmp/foo/file",
+		"bytes": 4.0,
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("expected %v to be %v, got %v", key, value, entry[key])
+		}
+	}
+	if _, ok := entry["timestamp"]; !ok {
+		t.Error("expected a timestamp")
+	}
+}
+
+// TestFindAPIPassword verifies that the -password flag takes precedence over
+// SIA_API_PASSWORD, which takes precedence over the apipassword file in
+// SIA_DIR.
+func TestFindAPIPassword(t *testing.T) {
+	initLogger("info", "text")
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := build.APIPasswordFile(dir)
+	for _, name := range []string{"SIA_API_PASSWORD", "SIA_DIR"} {
+		if old, set := os.LookupEnv(name); set {
+			defer os.Setenv(name, old)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+	os.Setenv("SIA_DIR", dir)
+
+	tests := []struct {
+		flag, env, file  string
+		password, source string
+	}{
+		{"flag", "env", "file", "flag", "the -password flag"},
+		{"flag", "", "", "flag", "the -password flag"},
+		{"", "env", "file", "env", "the SIA_API_PASSWORD environment variable"},
+		{"", "", "file\n", "file", passwordFile},
+		{"", "", "", "", passwordFile},
+	}
+	for _, test := range tests {
+		os.Setenv("SIA_API_PASSWORD", test.env)
+		os.Remove(passwordFile)
+		if test.file != "" {
+			err = ioutil.WriteFile(passwordFile, []byte(test.file), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		password, source := findAPIPassword(test.flag)
+		if password != test.password || source != test.source {
+			t.Errorf("flag %q, env %q, file %q: got %q from %v, want %q from %v", test.flag, test.env, test.file, password, source, test.password, test.source)
+		}
+	}
+}
+
+// TestAPIPasswordError verifies that siad rejecting the API password is
+// reported as a wrong password and other errors aren't.
+func TestAPIPasswordError(t *testing.T) {
+	// siad answers a wrong password like this
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"message": "API authentication failed."})
+	}))
+	defer server.Close()
+	client := siasync.NewFailoverClient([]string{server.Listener.Addr().String()}, "wrong", "Sia-Agent")
+	_, rejected := client.DaemonVersionGet()
+	if rejected == nil {
+		t.Fatal("siad should have rejected the password")
+	}
+
+	tests := []struct {
+		err   error
+		wrong bool
+	}{
+		{nil, false},
+		{rejected, true},
+		{errors.New("unexpected status 401"), true},
+		{errors.New("dial tcp 127.0.0.1:9980: connect: connection refused"), false},
+	}
+	for _, test := range tests {
+		err := apiPasswordError(test.err, "the -password flag")
+		if (err != nil) != test.wrong {
+			t.Errorf("%v: got %v, want a wrong password error: %v", test.err, err, test.wrong)
+			continue
+		}
+		if err != nil && (!strings.Contains(err.Error(), "wrong API password from the -password flag") || !strings.Contains(err.Error(), test.err.Error())) {
+			t.Errorf("%v: the message should name the password source and keep the error, got %v", test.err, err)
+		}
+	}
+}
+
+// TestOneShotExitStatus verifies that -one-shot exits with status 0 if every
+// file was uploaded and with a non-zero status if an upload failed.
+func TestOneShotExitStatus(t *testing.T) {
+	for _, failUploads := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "siasync")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		err = ioutil.WriteFile(filepath.Join(dir, "file"), []byte("one shot"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(os.Args[0])
+		cmd.Env = append(os.Environ(), "SIASYNC_TEST_ARGS=-backend mock -one-shot -upload-attempts 1 -mock-redundancy-rate 0 "+dir)
+		if failUploads {
+			cmd.Env = append(cmd.Env, "SIASYNC_TEST_FAIL_UPLOADS=1")
+		}
+		out, err := cmd.CombinedOutput()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			t.Fatal(err)
+		}
+		if failUploads && err == nil {
+			t.Errorf("-one-shot should exit with a non-zero status if an upload failed:\n%s", out)
+		}
+		if !failUploads && err != nil {
+			t.Errorf("-one-shot should exit with status 0 if every file was uploaded, got %v:\n%s", err, out)
+		}
+	}
+}
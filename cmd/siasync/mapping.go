@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// folderMapping maps a local directory to the folder on Sia it is synced to.
+type folderMapping struct {
+	local string
+	sia   string
+}
+
+// mappingFlag is a flag.Value that collects every -mapping flag. A mapping
+// is written as local=<directory>,sia=<folder on Sia>.
+type mappingFlag []folderMapping
+
+// String implements flag.Value.
+func (m *mappingFlag) String() string {
+	var mappings []string
+	for _, mapping := range *m {
+		mappings = append(mappings, "local="+mapping.local+",sia="+mapping.sia)
+	}
+	return strings.Join(mappings, " ")
+}
+
+// Set implements flag.Value.
+func (m *mappingFlag) Set(value string) error {
+	var mapping folderMapping
+	for _, field := range strings.Split(value, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid mapping field %q, expected key=value", field)
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "local":
+			mapping.local = strings.TrimSpace(kv[1])
+		case "sia":
+			mapping.sia = strings.Trim(strings.TrimSpace(kv[1]), "/")
+		default:
+			return fmt.Errorf("unknown mapping key %q", kv[0])
+		}
+	}
+	if mapping.local == "" || mapping.sia == "" {
+		return fmt.Errorf("mapping %q needs both local and sia", value)
+	}
+	*m = append(*m, mapping)
+	return nil
+}
+
+// stringSliceFlag is a flag.Value that collects every occurrence of a
+// repeatable flag.
+type stringSliceFlag []string
+
+// String implements flag.Value.
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set implements flag.Value.
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// checkMappings verifies that no two mappings sync to the same folder on Sia
+// or to folders inside each other, which would make one folder delete the
+// files of the other.
+func checkMappings(mappings []folderMapping) error {
+	for i, a := range mappings {
+		for _, b := range mappings[i+1:] {
+			if a.sia == b.sia || strings.HasPrefix(a.sia, b.sia+"/") || strings.HasPrefix(b.sia, a.sia+"/") {
+				return fmt.Errorf("%v and %v are synced to overlapping folders %v and %v on Sia", a.local, b.local, a.sia, b.sia)
+			}
+		}
+	}
+	return nil
+}
+
+// siaPrefixVariables are the variables that can be used in -siapath-prefix.
+var siaPrefixVariables = []string{"{hostname}", "{dir}"}
+
+// expandSiaPrefix replaces {hostname} in the -siapath-prefix template with
+// hostname and {dir} with the name of the synced directory dir, so that
+// several machines or directories can sync into the same folder on Sia
+// without colliding. Other variables are rejected.
+func expandSiaPrefix(template, dir, hostname string) (string, error) {
+	rest := strings.NewReplacer("{hostname}", "", "{dir}", "").Replace(template)
+	if i := strings.Index(rest, "{"); i >= 0 && strings.Contains(rest[i:], "}") {
+		return "", fmt.Errorf("unknown variable in %q, expected %v", template, strings.Join(siaPrefixVariables, " or "))
+	}
+	if strings.Contains(template, "{hostname}") && hostname == "" {
+		return "", fmt.Errorf("the host name for %q is unknown", template)
+	}
+	prefix := strings.NewReplacer("{hostname}", hostname, "{dir}", filepath.Base(dir)).Replace(template)
+	return strings.Trim(prefix, "/"), nil
+}
+
+// expandHome replaces a leading ~ in path with the home directory of the
+// current user.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// checkDirectory returns the absolute path of the directory or single file to
+// sync, after expanding ~. It fails if the path is neither, or is on a Sia
+// FUSE mount, which would upload the files on Sia again.
+func checkDirectory(dir string) (string, error) {
+	dir, err := expandHome(dir)
+	if err != nil {
+		return "", err
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !f.IsDir() && !f.Mode().IsRegular() {
+		return "", fmt.Errorf("%v is neither a directory nor a regular file", dir)
+	}
+	if mount := siaMount(dir); mount != "" {
+		return "", fmt.Errorf("%v is on the Sia FUSE mount %v, syncing it would upload files from Sia to Sia again", dir, mount)
+	}
+	return dir, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMappingFlag verifies that -mapping values are parsed and that
+// overlapping folders on Sia are rejected.
+func TestMappingFlag(t *testing.T) {
+	var m mappingFlag
+	for _, value := range []string{"local=/mnt/movies,sia=media/movies", "sia=/media/tv/, local=/mnt/tv"} {
+		err := m.Set(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []folderMapping{{"/mnt/movies", "media/movies"}, {"/mnt/tv", "media/tv"}}
+	if len(m) != len(expected) || m[0] != expected[0] || m[1] != expected[1] {
+		t.Fatalf("expected %v, got %v", expected, m)
+	}
+	if err := checkMappings(m); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []string{"/mnt/movies", "local=/mnt/movies", "local=/mnt/movies,sia=movies,foo=bar"} {
+		if err := m.Set(value); err == nil {
+			t.Errorf("%q should be rejected", value)
+		}
+	}
+
+	for _, mappings := range [][]folderMapping{
+		{{"/a", "media"}, {"/b", "media"}},
+		{{"/a", "media"}, {"/b", "media/tv"}},
+	} {
+		if err := checkMappings(mappings); err == nil {
+			t.Errorf("%v should be rejected", mappings)
+		}
+	}
+}
+
+// TestCheckDirectory verifies that the directory or file to sync is made
+// absolute, ~ is expanded and missing paths are rejected.
+func TestCheckDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(file, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checked, err := checkDirectory(dir + "/.")
+	if err != nil || checked != dir {
+		t.Errorf("expected %v, got %v, %v", dir, checked, err)
+	}
+	checked, err = checkDirectory(file)
+	if err != nil || checked != file {
+		t.Errorf("expected %v, got %v, %v", file, checked, err)
+	}
+	missing := filepath.Join(dir, "missing")
+	if _, err := checkDirectory(missing); err == nil {
+		t.Errorf("%v should be rejected", missing)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	if expanded, err := expandHome("~/foo"); err != nil || expanded != filepath.Join(home, "foo") {
+		t.Errorf("expected ~/foo in %v, got %v, %v", home, expanded, err)
+	}
+	if expanded, _ := expandHome("~foo"); expanded != "~foo" {
+		t.Errorf("~foo should be left alone, got %v", expanded)
+	}
+}
+
+// TestFindSiaMount verifies that directories on a Sia FUSE mount are found in
+// the mount table, and directories on other filesystems are not.
+func TestFindSiaMount(t *testing.T) {
+	mountinfo := `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+40 22 0:40 / /mnt/sia\040files rw,nosuid,nodev,relatime shared:20 - fuse.siad sia rw,user_id=1000
+41 22 0:41 / /mnt/other rw,nosuid,nodev,relatime - fuse.sshfs host:/data rw
+42 40 8:2 / /mnt/sia\040files/local rw,relatime - ext4 /dev/sdb1 rw
+`
+	tests := map[string]string{
+		"/home/user":                  "",
+		"/mnt/sia files":              "/mnt/sia files",
+		"/mnt/sia files/movies":       "/mnt/sia files",
+		"/mnt/sia files/local/movies": "",
+		"/mnt/other/movies":           "",
+		"/mnt/sia filesystem":         "",
+	}
+	for dir, expected := range tests {
+		if mount := findSiaMount(mountinfo, dir); mount != expected {
+			t.Errorf("expected %q to be on %q, got %q", dir, expected, mount)
+		}
+	}
+}
+
+// TestExpandSiaPrefix verifies that the variables of -siapath-prefix are
+// replaced and unknown ones are rejected.
+func TestExpandSiaPrefix(t *testing.T) {
+	tests := []struct {
+		template string
+		dir      string
+		hostname string
+		expected string
+		fails    bool
+	}{
+		{"backups", "/home/sia/movies", "machineA", "backups", false},
+		{"{hostname}", "/home/sia/movies", "machineA", "machineA", false},
+		{"/staging/{hostname}/{dir}/", "/home/sia/movies", "machineA", "staging/machineA/movies", false},
+		{"{dir}-{dir}", filepath.Join("home", "tv"), "machineA", "tv-tv", false},
+		{"{hostname}", "/home/sia/movies", "", "", true},
+		{"{user}", "/home/sia/movies", "machineA", "", true},
+		{"{dir}/{host}", "/home/sia/movies", "machineA", "", true},
+	}
+	for _, test := range tests {
+		prefix, err := expandSiaPrefix(test.template, test.dir, test.hostname)
+		if test.fails {
+			if err == nil {
+				t.Errorf("expandSiaPrefix(%q, %q, %q): expected an error, got %q", test.template, test.dir, test.hostname, prefix)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandSiaPrefix(%q, %q, %q): %v", test.template, test.dir, test.hostname, err)
+		} else if prefix != test.expected {
+			t.Errorf("expandSiaPrefix(%q, %q, %q): expected %q, got %q", test.template, test.dir, test.hostname, test.expected, prefix)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+)
+
+// findSiaMount returns the mount point of the Sia FUSE mount that dir is on,
+// given the contents of /proc/self/mountinfo, or "" if dir is on another
+// filesystem. Sia FUSE mounts are recognized by a fuse filesystem type with
+// sia in its type or source.
+func findSiaMount(mountinfo, dir string) string {
+	var mountPoint, fsType, source string
+	for _, line := range strings.Split(mountinfo, "\n") {
+		// the fields after the mount point and options are optional and
+		// end with a single -, followed by the type and source
+		fields := strings.Fields(line)
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || sep+2 >= len(fields) {
+			continue
+		}
+		point := strings.Replace(fields[4], `\040`, " ", -1)
+		if point != "/" && point != dir && !strings.HasPrefix(dir, point+"/") {
+			continue
+		}
+		// the mount point closest to dir is the one it's on
+		if len(point) >= len(mountPoint) {
+			mountPoint, fsType, source = point, fields[sep+1], fields[sep+2]
+		}
+	}
+	fsType, source = strings.ToLower(fsType), strings.ToLower(source)
+	if strings.HasPrefix(fsType, "fuse") && (strings.Contains(fsType, "sia") || strings.Contains(source, "sia")) {
+		return mountPoint
+	}
+	return ""
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"io/ioutil"
+)
+
+// siaMount returns the mount point of the Sia FUSE mount that dir is on, or
+// "" if it isn't on one or the mounts can't be read.
+func siaMount(dir string) string {
+	mountinfo, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	return findSiaMount(string(mountinfo), dir)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// siaMount returns "", Sia FUSE mounts are only detected on Linux.
+func siaMount(dir string) string {
+	return ""
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// notifySystemd sends state, like READY=1, to systemd if siasync runs as a
+// Type=notify service, and does nothing otherwise.
+func notifySystemd(state string) {
+	err := sdNotify(os.Getenv("NOTIFY_SOCKET"), state)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"state": state,
+			"error": err.Error(),
+		}).Warn("Could not notify systemd")
+	}
+}
+
+// sdNotify implements the sd_notify protocol: state is sent as a single
+// datagram to the unix socket at socket, which is in the abstract namespace
+// if it starts with @. An empty socket does nothing.
+func sdNotify(socket, state string) error {
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns how often to send WATCHDOG=1, half the timeout
+// systemd set in WATCHDOG_USEC as it recommends, or 0 if the watchdog isn't
+// enabled for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
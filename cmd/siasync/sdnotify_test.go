@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestSdNotify verifies that states are sent as datagrams to the notify
+// socket, and that nothing is sent without one.
+func TestSdNotify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix datagram sockets are not supported on Windows")
+	}
+	err := sdNotify("", "READY=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, state := range []string{"READY=1", "WATCHDOG=1", "STOPPING=1"} {
+		err = sdNotify(socket, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != state {
+			t.Fatalf("expected %q, got %q", state, buf[:n])
+		}
+	}
+
+	if err := sdNotify(filepath.Join(dir, "missing"), "READY=1"); err == nil {
+		t.Fatal("expected an error for a missing socket")
+	}
+}
+
+// TestWatchdogInterval verifies that the watchdog is pinged at half its
+// timeout, and only if it is enabled for this process.
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Unsetenv("WATCHDOG_USEC")
+	if interval := watchdogInterval(); interval != 0 {
+		t.Fatalf("expected no watchdog, got %v", interval)
+	}
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	if interval := watchdogInterval(); interval != 15*time.Second {
+		t.Fatalf("expected 15s, got %v", interval)
+	}
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if interval := watchdogInterval(); interval != 15*time.Second {
+		t.Fatalf("expected 15s, got %v", interval)
+	}
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if interval := watchdogInterval(); interval != 0 {
+		t.Fatalf("expected no watchdog for another process, got %v", interval)
+	}
+}
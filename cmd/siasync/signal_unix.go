@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyPause relays the signal that toggles whether syncing is paused to c.
+func notifyPause(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
+
+// notifyStats relays the signal that logs the sync statistics to c.
+func notifyStats(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
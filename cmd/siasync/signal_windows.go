@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+)
+
+// notifyPause does nothing, Windows has no signal to toggle whether syncing is
+// paused. The status endpoint can be used instead.
+func notifyPause(c chan<- os.Signal) {}
+
+// notifyStats does nothing, Windows has no signal to log the sync
+// statistics. They are logged on exit.
+func notifyStats(c chan<- os.Signal) {}
@@ -0,0 +1,127 @@
+package siasync
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CategorySettings override the erasure coding and the minimum redundancy for
+// the files in a top-level folder below the prefix on Sia. A zero
+// minRedundancy keeps the one of the SiaFolder.
+type CategorySettings struct {
+	dataPieces    uint64
+	parityPieces  uint64
+	minRedundancy float64
+}
+
+// ParseCategorySettings parses a comma separated list of category settings
+// written as <folder>:<data pieces>/<parity pieces>[:<min redundancy>], like
+// movies:10/20:1.0,home:10/40:2.0.
+func ParseCategorySettings(list string) (map[string]CategorySettings, error) {
+	categories := make(map[string]CategorySettings)
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		fields := strings.Split(s, ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid category %q, expected <folder>:<data pieces>/<parity pieces>[:<min redundancy>]", s)
+		}
+		folder := strings.Trim(strings.TrimSpace(fields[0]), "/")
+		if err := checkSiaPath(folder); err != nil || strings.Contains(folder, "/") {
+			return nil, fmt.Errorf("invalid category %q, %q is not a single folder name", s, folder)
+		}
+		if _, exists := categories[folder]; exists {
+			return nil, fmt.Errorf("category %q is given twice", folder)
+		}
+
+		var settings CategorySettings
+		pieces := strings.Split(fields[1], "/")
+		if len(pieces) != 2 {
+			return nil, fmt.Errorf("invalid erasure coding in %q, expected <data pieces>/<parity pieces>", s)
+		}
+		var err error
+		settings.dataPieces, err = strconv.ParseUint(strings.TrimSpace(pieces[0]), 10, 64)
+		if err != nil || settings.dataPieces == 0 {
+			return nil, fmt.Errorf("invalid data pieces in %q, expected a number of at least 1", s)
+		}
+		settings.parityPieces, err = strconv.ParseUint(strings.TrimSpace(pieces[1]), 10, 64)
+		if err != nil || settings.parityPieces == 0 {
+			return nil, fmt.Errorf("invalid parity pieces in %q, expected a number of at least 1", s)
+		}
+		if len(fields) == 3 {
+			settings.minRedundancy, err = strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+			if err != nil || settings.minRedundancy <= 0 {
+				return nil, fmt.Errorf("invalid minimum redundancy in %q, expected a positive number", s)
+			}
+		}
+		categories[folder] = settings
+	}
+	return categories, nil
+}
+
+// MostContracts returns the erasure coding of the default and the category
+// settings that needs the most active contracts, which is what the node must
+// have to accept every upload.
+func MostContracts(dataPieces, parityPieces uint64, categories map[string]CategorySettings) (uint64, uint64) {
+	for _, settings := range categories {
+		if requiredContracts(settings.dataPieces, settings.parityPieces) > requiredContracts(dataPieces, parityPieces) {
+			dataPieces, parityPieces = settings.dataPieces, settings.parityPieces
+		}
+	}
+	return dataPieces, parityPieces
+}
+
+// categorySettingsOf returns the settings of the top-level folder below the
+// prefix on Sia that the file at relpath is synced into, with the ones of the
+// SiaFolder filled in for files outside of any configured category.
+func (sf *SiaFolder) categorySettingsOf(relpath string) CategorySettings {
+	settings := CategorySettings{
+		dataPieces:    sf.dataPieces,
+		parityPieces:  sf.parityPieces,
+		minRedundancy: sf.minRedundancy,
+	}
+	if len(sf.categories) == 0 {
+		return settings
+	}
+	path := sf.categorize(relpath)
+	i := strings.Index(path, "/")
+	if i < 0 {
+		return settings
+	}
+	if category, ok := sf.categories[path[:i]]; ok {
+		settings.dataPieces = category.dataPieces
+		settings.parityPieces = category.parityPieces
+		if category.minRedundancy > 0 {
+			settings.minRedundancy = category.minRedundancy
+		}
+	}
+	return settings
+}
+
+// requiredContracts returns the number of active contracts the renter needs
+// for the erasure coding. The renter refuses to upload unless it has at least
+// (data + parity + data) / 2 contracts, rounded down.
+func requiredContracts(dataPieces, parityPieces uint64) uint64 {
+	return (2*dataPieces + parityPieces) / 2
+}
+
+// checkErasureCoding verifies that the erasure coding parameters can be used
+// by the renter given the number of active contracts.
+func checkErasureCoding(dataPieces, parityPieces uint64, contracts int) error {
+	if dataPieces == 0 {
+		return errors.New("data pieces must be at least 1")
+	}
+	if parityPieces == 0 {
+		return errors.New("parity pieces must be at least 1")
+	}
+
+	required := requiredContracts(dataPieces, parityPieces)
+	if uint64(contracts) < required {
+		return fmt.Errorf("%v data pieces and %v parity pieces need at least %v active contracts, only %v available", dataPieces, parityPieces, required, contracts)
+	}
+	return nil
+}
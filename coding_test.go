@@ -0,0 +1,152 @@
+package siasync
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseCategorySettings verifies that category settings are parsed and
+// that invalid ones are rejected.
+func TestParseCategorySettings(t *testing.T) {
+	categories, err := ParseCategorySettings("movies:10/20:1.0, home:10/40:2.5,tv:4/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]CategorySettings{
+		"movies": {dataPieces: 10, parityPieces: 20, minRedundancy: 1},
+		"home":   {dataPieces: 10, parityPieces: 40, minRedundancy: 2.5},
+		"tv":     {dataPieces: 4, parityPieces: 8},
+	}
+	if !reflect.DeepEqual(categories, expected) {
+		t.Fatalf("expected %v, got %v", expected, categories)
+	}
+
+	categories, err = ParseCategorySettings("")
+	if err != nil || len(categories) != 0 {
+		t.Fatalf("expected no categories, got %v, %v", categories, err)
+	}
+
+	for _, list := range []string{
+		"movies",
+		"movies:10",
+		"movies:10/20:1.0:2",
+		"movies:0/20",
+		"movies:10/0",
+		"movies:ten/20",
+		"movies:10/20:0",
+		"movies:10/20:-1",
+		"movies/hd:10/20",
+		":10/20",
+		"movies:10/20,movies:10/30",
+	} {
+		if _, err := ParseCategorySettings(list); err == nil {
+			t.Errorf("expected %q to be rejected", list)
+		}
+	}
+}
+
+// TestMostContracts verifies that the erasure coding needing the most
+// contracts is picked.
+func TestMostContracts(t *testing.T) {
+	categories := map[string]CategorySettings{
+		"movies": {dataPieces: 10, parityPieces: 20},
+		"home":   {dataPieces: 10, parityPieces: 40},
+	}
+	data, parity := MostContracts(10, 30, categories)
+	if data != 10 || parity != 40 {
+		t.Fatalf("expected 10/40, got %v/%v", data, parity)
+	}
+	data, parity = MostContracts(40, 80, categories)
+	if data != 40 || parity != 80 {
+		t.Fatalf("expected 40/80, got %v/%v", data, parity)
+	}
+}
+
+// TestRequiredContracts verifies that the contracts the renter needs are
+// rounded down like siad does when data + parity + data is odd.
+func TestRequiredContracts(t *testing.T) {
+	tests := []struct {
+		dataPieces, parityPieces uint64
+		expected                 uint64
+	}{
+		{10, 30, 25},
+		{10, 20, 20},
+		{10, 5, 12},
+		{2, 1, 2},
+		{1, 1, 1},
+		{1, 0, 1},
+	}
+	for _, test := range tests {
+		if contracts := requiredContracts(test.dataPieces, test.parityPieces); contracts != test.expected {
+			t.Errorf("%v/%v: expected %v contracts, got %v", test.dataPieces, test.parityPieces, test.expected, contracts)
+		}
+	}
+}
+
+// TestCheckErasureCoding verifies that erasure coding needing more contracts
+// than available, or without data or parity pieces, is rejected.
+func TestCheckErasureCoding(t *testing.T) {
+	tests := []struct {
+		dataPieces, parityPieces uint64
+		contracts                int
+		ok                       bool
+	}{
+		{10, 30, 25, true},
+		{10, 30, 24, false},
+		{10, 20, 20, true},
+		{10, 20, 19, false},
+		{10, 5, 12, true},
+		{10, 5, 11, false},
+		{2, 1, 2, true},
+		{2, 1, 1, false},
+		{1, 1, 1, true},
+		{1, 1, 0, false},
+		{0, 30, 50, false},
+		{10, 0, 50, false},
+	}
+	for _, test := range tests {
+		err := checkErasureCoding(test.dataPieces, test.parityPieces, test.contracts)
+		if (err == nil) != test.ok {
+			t.Errorf("%v/%v with %v contracts: expected ok %v, got %v", test.dataPieces, test.parityPieces, test.contracts, test.ok, err)
+		}
+	}
+}
+
+// TestCategorySettingsOf verifies that files get the settings of their
+// top-level folder on Sia, and the defaults outside of any category.
+func TestCategorySettingsOf(t *testing.T) {
+	sf := &SiaFolder{
+		dataPieces:    10,
+		parityPieces:  30,
+		minRedundancy: 1.5,
+		categories: map[string]CategorySettings{
+			"movies": {dataPieces: 10, parityPieces: 20, minRedundancy: 1},
+			"home":   {dataPieces: 10, parityPieces: 40},
+		},
+	}
+	tests := []struct {
+		relpath  string
+		expected CategorySettings
+	}{
+		{"movies/film.mkv", CategorySettings{10, 20, 1}},
+		{"home/2020/video.mp4", CategorySettings{10, 40, 1.5}},
+		{"movies", CategorySettings{10, 30, 1.5}},
+		{"other/file", CategorySettings{10, 30, 1.5}},
+		{"documents/movies/file", CategorySettings{10, 30, 1.5}},
+	}
+	for _, test := range tests {
+		if settings := sf.categorySettingsOf(test.relpath); settings != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.relpath, test.expected, settings)
+		}
+	}
+
+	pattern, err := CompileCategoryPattern("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.categoryPattern = pattern
+	sf.categoryDefault = "movies"
+	if settings := sf.categorySettingsOf("Movie.Name.2019.mkv"); settings != (CategorySettings{10, 20, 1}) {
+		t.Errorf("expected the movies settings with auto categorization, got %v", settings)
+	}
+}
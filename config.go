@@ -0,0 +1,227 @@
+package siasync
+
+import "time"
+
+// Config holds the settings of a SiaFolder. The zero value of a field is a
+// reasonable default unless noted otherwise.
+type Config struct {
+	// Prefix is the folder on Sia that files are synced to.
+	Prefix string
+
+	// Archive keeps files on Sia after they are deleted locally.
+	Archive bool
+
+	// RemoveSourceFiles removes the local copy of a file once it is
+	// available on Sia, while the directory is watched. If DoneDir is set,
+	// the file is moved there instead, keeping its path relative to the
+	// synced directory. Both imply Archive.
+	RemoveSourceFiles bool
+	DoneDir           string
+
+	// MaxVersions is the number of versions of a changed file kept on Sia
+	// in archive mode, including the current one. Older versions are
+	// removed, 0 keeps them all.
+	MaxVersions int
+
+	// RestoreAt makes restore download the version of every file that was
+	// current at that time, leaving out the files uploaded later. The
+	// zero value restores the current versions.
+	RestoreAt time.Time
+
+	// DataPieces and ParityPieces are the erasure coding parameters used
+	// when uploading files to Sia.
+	DataPieces   uint64
+	ParityPieces uint64
+
+	// Categories override the erasure coding and MinRedundancy for the
+	// files in some top-level folders below Prefix on Sia, which are the
+	// category folders with AutoCategorize.
+	Categories map[string]CategorySettings
+
+	// IncludeExtensions, if not empty, are the only file extensions that
+	// are synced. Otherwise files with one of ExcludeExtensions are skipped.
+	// Extensions are lower case and without a leading dot.
+	IncludeExtensions []string
+	ExcludeExtensions []string
+
+	// ExcludePatterns are glob patterns of files and directories that are
+	// never synced, in addition to the ones in the ignore file and
+	// defaultExcludePatterns. SyncHidden leaves out defaultExcludePatterns.
+	ExcludePatterns []string
+	SyncHidden      bool
+
+	// MinFileSize is the size in bytes below which files are tracked but
+	// not uploaded until they grow.
+	MinFileSize int64
+
+	// Dedupe records files with the same content as an uploaded file as
+	// its duplicates in the manifest instead of uploading them again. It
+	// needs sha256 change detection and implies Manifest.
+	Dedupe bool
+
+	// AutoCategorize syncs files whose relative path matches
+	// CategoryPattern, by default season and episode numbers, into the tv
+	// folder on Sia and all others into CategoryDefault, "movies" if empty.
+	AutoCategorize  bool
+	CategoryPattern string
+	CategoryDefault string
+
+	// AuditSink, if set, receives a record of every change made to Sia.
+	AuditSink AuditSink
+
+	// Spending, if set, is sampled before and after every reconciliation
+	// and every spendingInterval while watching. It can be shared by several
+	// SiaFolders.
+	Spending *SpendingTracker
+
+	// API, if set, is the rate limited client of the folder, whose API
+	// call counters the Status reports.
+	API *LimitedClient
+
+	// EmptyDirGrace is how long a directory below Prefix on Sia must have
+	// been empty before it is removed, 0 keeps empty directories.
+	EmptyDirGrace time.Duration
+
+	// SanitizeNames maps file names Sia doesn't accept to names it does,
+	// instead of skipping those files.
+	SanitizeNames bool
+
+	// Strict makes NewSiafolder fail on the first file or directory that
+	// can't be read, instead of skipping it.
+	Strict bool
+
+	// ChangeDetection is how changed files are detected: sha256, size or
+	// mtime. sha256 is used if it is empty.
+	ChangeDetection string
+
+	// SettleDuration is how long a file must stop changing before it is
+	// uploaded, at least minSettleDuration.
+	SettleDuration time.Duration
+
+	// RescanInterval is how often the directory is walked again while it
+	// is watched, to catch up on missed events. 0 never walks it again.
+	RescanInterval time.Duration
+
+	// Poll walks the directory for changes every PollInterval, or every
+	// DefaultPollInterval if it is 0, instead of watching it. The directory
+	// is polled anyway if the watcher doesn't receive events for it.
+	Poll         bool
+	PollInterval time.Duration
+
+	// StateFile is where the state of synced files is kept between runs,
+	// DefaultStateFile in the synced directory if empty. Rescan ignores the
+	// existing state file, NoCache neither reads nor writes it.
+	StateFile string
+	Rescan    bool
+	NoCache   bool
+
+	// ScanWorkers is the number of files checksummed at the same time when
+	// the directory is scanned at startup, and when files change while it is
+	// watched, at least 1.
+	ScanWorkers int
+
+	// HealthInterval is how often the redundancy of uploaded files is
+	// checked while the directory is watched, 0 never. Files below
+	// MinRedundancy are logged, and with AutoRepair uploaded again if they
+	// stay below it and are unchanged locally.
+	HealthInterval time.Duration
+	MinRedundancy  float64
+	AutoRepair     bool
+
+	// ProgressInterval is how often the upload progress of files that siad
+	// is still uploading is logged while the directory is watched, 0 never.
+	ProgressInterval time.Duration
+
+	// StallTimeout is how long the upload progress of a file may not
+	// increase before the upload is considered stalled while the directory
+	// is watched, 0 never. StallAction is one of StallActions, alert is
+	// used if it is empty.
+	StallTimeout time.Duration
+	StallAction  string
+
+	// Manifest keeps a manifest of the uploaded files and their checksums
+	// in the folder on Sia.
+	Manifest bool
+
+	// PreserveMetadata records the modification time, permissions and
+	// owner of uploaded files in the manifest, which restore applies to
+	// the restored files. It implies Manifest.
+	PreserveMetadata bool
+
+	// SyncOnly syncs the directory once without watching it for changes.
+	SyncOnly bool
+
+	// SkipInitialSync doesn't upload or delete anything when the SiaFolder
+	// is created, for inspecting the directory with verify or prune.
+	SkipInitialSync bool
+
+	// DryRun doesn't change anything on Sia, the changes that would have
+	// been made are logged on Close and written to DryRunOutput as JSON if
+	// it is set.
+	DryRun       bool
+	DryRunOutput string
+
+	// UploadOrder is the order in which queued files are uploaded, one of
+	// UploadOrders. fifo is used if it is empty.
+	UploadOrder string
+
+	// MaxUploads is the number of files uploaded at the same time, at
+	// least 1. MaxUploadAttempts is how often a file is tried before it is given up,
+	// at least 1.
+	MaxUploads        int
+	MaxUploadAttempts int
+
+	// MaxUploadsPerHour limits how many files are handed to siad per hour,
+	// and MaxConcurrentBytes how many bytes siad may be uploading before
+	// the next file is handed to it. 0 is unlimited.
+	MaxUploadsPerHour  int
+	MaxConcurrentBytes int64
+
+	// UploadWindows are the times of day during which files are handed to
+	// siad, in UploadWindowLocation or local time if nil. Files are queued
+	// outside of them. No windows means always.
+	UploadWindows        []UploadWindow
+	UploadWindowLocation *time.Location
+
+	// Uploads is the upload queue shared with other SiaFolders, so that
+	// MaxUploads, MaxUploadsPerHour, MaxConcurrentBytes and UploadWindows
+	// hold for all of them together. The upload order and limits the queue
+	// was created with are used instead of the ones above then. If nil,
+	// the SiaFolder gets a queue of its own.
+	Uploads *UploadQueue
+
+	// ConfirmUpload, if set, is called with a summary of the uploads of the
+	// initial sync before any of them starts. NewSiafolder returns
+	// errUploadAborted if it returns false. It isn't called in a dry run or
+	// if there is nothing to upload.
+	ConfirmUpload func(summary UploadSummary) bool
+
+	// MaxDeletes and MaxDeletesPercent limit the number and percentage of
+	// the tracked files removed from Sia within DeleteWindow, or
+	// DefaultDeleteWindow if it is 0. Once a limit is exceeded, removals
+	// are held back until ResumeDeletes is called. 0 is unlimited.
+	// ForceResumeDeletes lets the removals of the initial sync through, to
+	// acknowledge files deleted while siasync wasn't running.
+	MaxDeletes         int
+	MaxDeletesPercent  float64
+	DeleteWindow       time.Duration
+	ForceResumeDeletes bool
+
+	// ShutdownTimeout is how long Close waits for uploads in progress, 0
+	// waits forever.
+	ShutdownTimeout time.Duration
+
+	// APITimeout is how long a siad API call may take before it is given
+	// up, 0 waits forever. Downloads take as long as they take.
+	APITimeout time.Duration
+
+	// OnUpload, OnDelete and OnError are scripts run after an upload, after
+	// a deletion from Sia and when an upload is given up.
+	OnUpload string
+	OnDelete string
+	OnError  string
+
+	// EventBuffer is the number of events buffered by the Events channel,
+	// defaultEventBuffer if 0. Events that don't fit are dropped.
+	EventBuffer int
+}
@@ -0,0 +1,130 @@
+package siasync
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errUploadAborted is returned by NewSiafolder if the initial upload was not
+// confirmed.
+var errUploadAborted = errors.New("initial upload not confirmed")
+
+// UploadSummary is what the initial sync of a SiaFolder is about to upload.
+type UploadSummary struct {
+	Directory string
+	Prefix    string
+	Files     int
+	Bytes     int64
+
+	// Folders breaks the files down by top-level local directory, largest
+	// first. Files directly in Directory are in ".".
+	Folders []UploadFolderSummary
+}
+
+// UploadFolderSummary is the part of an UploadSummary in a top-level local
+// directory.
+type UploadFolderSummary struct {
+	Folder string
+	Files  int
+	Bytes  int64
+}
+
+// uploadSummary returns a summary of the queued uploads.
+func (sf *SiaFolder) uploadSummary() UploadSummary {
+	summary := UploadSummary{Directory: sf.path, Prefix: sf.prefix}
+	folders := make(map[string]*UploadFolderSummary)
+	for _, file := range sf.uploads.files() {
+		stat, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		relpath, err := filepath.Rel(sf.path, file)
+		if err != nil {
+			continue
+		}
+		folder := "."
+		if parts := strings.SplitN(filepath.ToSlash(relpath), "/", 2); len(parts) == 2 {
+			folder = parts[0]
+		}
+		if folders[folder] == nil {
+			folders[folder] = &UploadFolderSummary{Folder: folder}
+		}
+		folders[folder].Files++
+		folders[folder].Bytes += stat.Size()
+		summary.Files++
+		summary.Bytes += stat.Size()
+	}
+	for _, folder := range folders {
+		summary.Folders = append(summary.Folders, *folder)
+	}
+	sort.Slice(summary.Folders, func(i, j int) bool {
+		a, b := summary.Folders[i], summary.Folders[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Folder < b.Folder
+	})
+	return summary
+}
+
+// write writes the summary as a table to out.
+func (s UploadSummary) write(out io.Writer) {
+	fmt.Fprintf(out, "%v files, %v, to upload from %v to %v on Sia\n", s.Files, formatBytes(float64(s.Bytes)), s.Directory, s.Prefix)
+	for _, folder := range s.Folders {
+		fmt.Fprintf(out, "  %-40v %8v files %12v\n", folder.Folder, folder.Files, formatBytes(float64(folder.Bytes)))
+	}
+}
+
+// ConfirmUpload writes the summary to out and asks for confirmation on in,
+// unless yes is set. If in is not a terminal, the upload is only confirmed
+// with yes, so that an unattended siasync doesn't hang or upload the wrong
+// directory.
+func ConfirmUpload(summary UploadSummary, yes, terminal bool, in io.Reader, out io.Writer) bool {
+	summary.write(out)
+	if yes {
+		return true
+	}
+	if !terminal {
+		log.Error("Not asking for confirmation of the initial upload without a terminal, pass -yes to upload anyway")
+		return false
+	}
+	fmt.Fprintf(out, "Upload %v files to Sia? [y/N] ", summary.Files)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// IsTerminal reports whether f is a terminal rather than a file or a pipe.
+func IsTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmInitialUpload summarizes the queued uploads of the initial sync and
+// has them confirmed before they start. It returns errUploadAborted if they
+// weren't.
+func (sf *SiaFolder) confirmInitialUpload(confirm func(UploadSummary) bool) error {
+	summary := sf.uploadSummary()
+	if summary.Files == 0 {
+		return nil
+	}
+	if !confirm(summary) {
+		return errUploadAborted
+	}
+	log.WithFields(logrus.Fields{
+		"files": summary.Files,
+		"bytes": summary.Bytes,
+	}).Info("Initial upload confirmed")
+	return nil
+}
@@ -0,0 +1,47 @@
+package siasync
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestConfirmUpload verifies that the initial upload is only confirmed by an
+// answer on a terminal, or by -yes.
+func TestConfirmUpload(t *testing.T) {
+	summary := UploadSummary{
+		Directory: "/data",
+		Prefix:    "siasync",
+		Files:     3,
+		Bytes:     3 << 20,
+		Folders: []UploadFolderSummary{
+			{Folder: "movies", Files: 2, Bytes: 2 << 20},
+			{Folder: ".", Files: 1, Bytes: 1 << 20},
+		},
+	}
+	tests := []struct {
+		yes, terminal bool
+		answer        string
+		confirmed     bool
+	}{
+		{false, true, "y\n", true},
+		{false, true, "YES\n", true},
+		{false, true, "n\n", false},
+		{false, true, "\n", false},
+		{false, true, "", false},
+		{false, false, "y\n", false},
+		{true, false, "", true},
+		{true, true, "n\n", true},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		confirmed := ConfirmUpload(summary, test.yes, test.terminal, strings.NewReader(test.answer), &out)
+		if confirmed != test.confirmed {
+			t.Errorf("yes %v, terminal %v, answer %q: expected %v, got %v", test.yes, test.terminal, test.answer, test.confirmed, confirmed)
+		}
+		for _, s := range []string{"3 files, 3.0 MiB", "movies", "2.0 MiB"} {
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("the summary should contain %q, got %q", s, out.String())
+			}
+		}
+	}
+}
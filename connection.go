@@ -0,0 +1,104 @@
+package siasync
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// reconnectInterval is how often siad is probed after the connection to it was
+// lost.
+var reconnectInterval = 5 * time.Second
+
+// siadReachable reports whether siad answers API requests.
+func (sf *SiaFolder) siadReachable() bool {
+	_, err := sf.client.DaemonVersionGet()
+	return err == nil
+}
+
+// handleDisconnect is called by an upload worker when siad stopped answering.
+// The first worker to notice pauses the upload queue, so that files keep being
+// queued but are not uploaded, and probes siad until it answers again. With
+// standby siad nodes, it fails over to the next one that answers after
+// failoverAfter failed probes. Once reconnected, Sia is reconciled with the
+// tracked files to catch up on the changes that could not be synced, or that
+// the new siad never saw, and uploads are resumed.
+func (sf *SiaFolder) handleDisconnect() {
+	sf.mu.Lock()
+	if sf.disconnected {
+		sf.mu.Unlock()
+		return
+	}
+	sf.disconnected = true
+	sf.mu.Unlock()
+
+	sf.uploads.pause("disconnected")
+	log.Warn("Lost connection to siad, pausing uploads")
+
+	ticker := time.NewTicker(reconnectInterval)
+	defer ticker.Stop()
+	failures := 0
+	for !sf.siadReachable() {
+		failures++
+		if sf.nodes != nil && failures >= failoverAfter && sf.nodes.failover(sf.nodes.activeAddress()) {
+			continue
+		}
+		select {
+		case <-ticker.C:
+		case <-sf.ctx.Done():
+			return
+		}
+	}
+	log.Info("Reconnected to siad")
+
+	// the Sia folder may have changed while siad was unreachable
+	sf.reconcileNode()
+
+	sf.mu.Lock()
+	sf.disconnected = false
+	sf.mu.Unlock()
+	sf.uploads.resume("disconnected")
+}
+
+// reconcileNode reconciles Sia with the tracked files and records the siad it
+// was reconciled with.
+func (sf *SiaFolder) reconcileNode() {
+	var node string
+	if sf.nodes != nil {
+		node = sf.nodes.activeAddress()
+	}
+	sf.listing.invalidate()
+	err := sf.reconcile()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error reconciling files after reconnecting")
+		return
+	}
+	sf.mu.Lock()
+	sf.node = node
+	sf.mu.Unlock()
+}
+
+// checkNode reconciles Sia with the tracked files if another SiaFolder sharing
+// the client failed over to another siad since this one last reconciled, so
+// that the new siad gets the files of idle folders too. The initial sync
+// reconciles with the active siad by itself. It runs on the eventWatcher
+// goroutine.
+func (sf *SiaFolder) checkNode() {
+	if sf.nodes == nil || !sf.Ready() {
+		return
+	}
+	node := sf.nodes.activeAddress()
+	sf.mu.Lock()
+	changed := node != sf.node && !sf.disconnected
+	sf.mu.Unlock()
+	if !changed {
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"directory": sf.path,
+		"siad":      node,
+	}).Info("siad changed, reconciling files with it")
+	sf.reconcileNode()
+}
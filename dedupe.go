@@ -0,0 +1,150 @@
+package siasync
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dedupeRetryDelay is how long a file waits while another file with the same
+// content is uploaded.
+const dedupeRetryDelay = time.Second
+
+// original reports whether the file was uploaded with its own content, so that
+// files with the same checksum can be recorded as its duplicates. Empty files
+// are never deduplicated, every empty file looks the same and uploading one
+// costs nothing.
+func (fs fileState) original() bool {
+	return fs.Uploaded && fs.DuplicateOf == "" && fs.Checksum != "" && fs.Size > 0
+}
+
+// indexFile updates the index of uploaded contents after the state of key
+// changed from old to fs, and returns the duplicates of key that lost their
+// content on Sia because key was removed or changed. They are marked as not
+// uploaded. The caller must hold sf.mu.
+func (sf *SiaFolder) indexFile(key string, old, fs fileState) []string {
+	if sf.originals == nil {
+		sf.originals = make(map[string]string)
+	}
+	if old.DuplicateOf != "" {
+		sf.duplicates--
+	}
+	if fs.DuplicateOf != "" {
+		sf.duplicates++
+	}
+
+	var orphaned []string
+	if old.original() && !(fs.original() && fs.Checksum == old.Checksum) {
+		if sf.originals[old.Checksum] == key {
+			delete(sf.originals, old.Checksum)
+		}
+		for k, st := range sf.state {
+			if sf.duplicates == 0 {
+				break
+			}
+			if st.DuplicateOf != key {
+				continue
+			}
+			st.DuplicateOf = ""
+			st.Uploaded = false
+			sf.state[k] = st
+			sf.duplicates--
+			orphaned = append(orphaned, sf.keyPath(k))
+		}
+	}
+	if fs.original() {
+		if _, exists := sf.originals[fs.Checksum]; !exists {
+			sf.originals[fs.Checksum] = key
+		}
+	}
+	return orphaned
+}
+
+// moveIndex points the index and the duplicates of a file that moved from
+// oldKey to key at its new key. The caller must hold sf.mu.
+func (sf *SiaFolder) moveIndex(oldKey, key string, fs fileState) {
+	if sf.originals[fs.Checksum] == oldKey {
+		sf.originals[fs.Checksum] = key
+	}
+	if sf.duplicates == 0 {
+		return
+	}
+	for k, st := range sf.state {
+		if st.DuplicateOf == oldKey {
+			st.DuplicateOf = key
+			sf.state[k] = st
+		}
+	}
+}
+
+// uploadOrphaned queues the duplicates whose original is gone from Sia, so
+// that their content is uploaded for real.
+func (sf *SiaFolder) uploadOrphaned(files []string) {
+	for _, file := range files {
+		log.WithFields(logrus.Fields{
+			"file": file,
+		}).Info("Original of duplicate file is gone, uploading it")
+		sf.queueUpload(file)
+	}
+}
+
+// checkDuplicate returns the key of an uploaded file with the same content as
+// file, whose upload file can share instead of being uploaded again, or "" if
+// there is none. In that case file claims its content until releaseContent is
+// called, and checkDuplicate returns errUploadingContent for other files with
+// the same content so that they are tried again once it is uploaded. Files
+// are only deduplicated in sha256 mode, other checksums say nothing about the
+// content.
+func (sf *SiaFolder) checkDuplicate(file string, fs fileState) (string, error) {
+	if !sf.dedupe || sf.changeDetection != "sha256" || fs.Size == 0 {
+		return "", nil
+	}
+	key := sf.fileKey(file)
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if original, exists := sf.originals[fs.Checksum]; exists && original != key {
+		return original, nil
+	}
+	if uploading, exists := sf.uploadingContent[fs.Checksum]; exists && uploading != key {
+		return "", errUploadingContent
+	}
+	sf.uploadingContent[fs.Checksum] = key
+	return "", nil
+}
+
+// releaseContent releases the claim of file on its content taken by
+// checkDuplicate.
+func (sf *SiaFolder) releaseContent(file string, fs fileState) {
+	key := sf.fileKey(file)
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.uploadingContent[fs.Checksum] == key {
+		delete(sf.uploadingContent, fs.Checksum)
+	}
+}
+
+// originalTracked reports whether the original of a duplicate is still
+// tracked with the same content.
+func (sf *SiaFolder) originalTracked(fs fileState) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	original, exists := sf.state[fs.DuplicateOf]
+	return exists && original.original() && original.Checksum == fs.Checksum
+}
+
+// trackDuplicate records file as a duplicate of original instead of uploading
+// it. Restores copy it from the original, which the manifest records.
+func (sf *SiaFolder) trackDuplicate(file, original string, fs fileState) {
+	log.WithFields(logrus.Fields{
+		"event":    "duplicate",
+		"file":     file,
+		"original": original,
+	}).Info("Duplicate of " + original + ", skipping upload")
+	fs.Uploaded = !sf.dryRun
+	if fs.Uploaded {
+		fs.UploadTime = time.Now()
+	}
+	fs.DuplicateOf = original
+	sf.trackFile(file, fs)
+	sf.stats.update(func(s *Stats) { s.Deduplicated++ })
+}
@@ -0,0 +1,113 @@
+package siasync
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultDeleteWindow is the window the deletion limits apply to if no window
+// is configured.
+const DefaultDeleteWindow = 5 * time.Minute
+
+// minDeletesForPercent is the number of files that must be removed within the
+// delete window before the percentage limit applies, so that removing a
+// couple of files from a small directory isn't taken for a mass deletion.
+const minDeletesForPercent = 10
+
+// holdRemoval counts the removal of a file from Sia against the deletion
+// limits and returns true if it must be held back instead, because more files
+// were removed within the delete window than the limits allow, now or before.
+// The first removal that exceeds them logs an alarm and runs the error hook.
+// With forceDeletes the removals of the initial sync are never held back.
+func (sf *SiaFolder) holdRemoval(file string, fs fileState) bool {
+	sf.mu.Lock()
+	if sf.maxDeletes == 0 && sf.maxDeletesPercent == 0 || sf.forceDeletes && !sf.ready {
+		sf.mu.Unlock()
+		return false
+	}
+	if sf.deletesHeld {
+		sf.heldRemovals[file] = fs
+		sf.mu.Unlock()
+		return true
+	}
+
+	now := time.Now()
+	var recent []time.Time
+	for _, removed := range sf.recentDeletes {
+		if now.Sub(removed) < sf.deleteWindow {
+			recent = append(recent, removed)
+		}
+	}
+	recent = append(recent, now)
+	sf.recentDeletes = recent
+
+	// the percentage is of the files tracked when the window started
+	tracked := len(sf.state)
+	if _, ok := sf.state[sf.fileKey(file)]; ok {
+		tracked--
+	}
+	removed := len(recent)
+	percent := 100 * float64(removed) / float64(tracked+removed)
+	exceeded := sf.maxDeletes > 0 && removed > sf.maxDeletes ||
+		sf.maxDeletesPercent > 0 && removed >= minDeletesForPercent && percent > sf.maxDeletesPercent
+	if !exceeded {
+		sf.mu.Unlock()
+		return false
+	}
+	sf.deletesHeld = true
+	sf.recentDeletes = nil
+	sf.heldRemovals[file] = fs
+	sf.mu.Unlock()
+
+	err := fmt.Errorf("%v files (%.0f%%) removed within %v, holding back removals from Sia until resumed", removed, percent, sf.deleteWindow)
+	log.WithFields(logrus.Fields{
+		"directory": sf.path,
+		"files":     removed,
+		"percent":   percent,
+		"window":    sf.deleteWindow,
+		"error":     err.Error(),
+	}).Error("Too many files removed, holding back removals from Sia. Resume them with POST /resume-deletes or by restarting with -force-resume-deletes")
+	sf.emit(Event{Type: FileDeleteHeld, Path: file, Size: fs.Size, Err: err})
+	return true
+}
+
+// DeletesHeld reports whether removals from Sia are held back because too
+// many files were removed.
+func (sf *SiaFolder) DeletesHeld() bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.deletesHeld
+}
+
+// ResumeDeletes removes the files whose removal from Sia was held back from
+// Sia, except the ones that are back locally, and counts removals against the
+// limits again from now on.
+func (sf *SiaFolder) ResumeDeletes() {
+	sf.mu.Lock()
+	if !sf.deletesHeld {
+		sf.mu.Unlock()
+		return
+	}
+	sf.deletesHeld = false
+	removals := sf.heldRemovals
+	sf.heldRemovals = make(map[string]fileState)
+	sf.mu.Unlock()
+
+	log.WithFields(logrus.Fields{
+		"directory": sf.path,
+		"removals":  len(removals),
+	}).Info("Resumed removals from Sia")
+	for file, fs := range removals {
+		if _, tracked := sf.trackedFile(file); tracked || sf.deferRemoval(file, fs) {
+			continue
+		}
+		err := sf.removePaused(file, fs)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Error removing file after resuming removals")
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package siasync
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// renamedDir is a watched directory that received a RENAME event and has not
+// been paired with the CREATE event of its new name yet. files holds the
+// tracked files inside by their path relative to the directory, dirs the
+// directory and its subdirectories as they were watched.
+type renamedDir struct {
+	files map[string]fileState
+	dirs  map[string]dirState
+	at    time.Time
+}
+
+// dropDir drops a watched directory and its subdirectories from the watcher
+// and the dirs map, and forgets the pending events and renames of the files
+// inside. It returns the dropped directories.
+func (sf *SiaFolder) dropDir(dir string) map[string]dirState {
+	dropped := make(map[string]dirState)
+	sf.mu.Lock()
+	for d, state := range sf.dirs {
+		if d != dir && !isWithin(dir, d) {
+			continue
+		}
+		if state.watched {
+			// the watch is usually gone already if the directory was deleted
+			sf.watcher.Remove(d)
+		}
+		dropped[d] = state
+		delete(sf.dirs, d)
+	}
+	sf.mu.Unlock()
+	for file := range sf.pending {
+		if isWithin(dir, file) {
+			delete(sf.pending, file)
+		}
+	}
+	for file := range sf.renamed {
+		if isWithin(dir, file) {
+			delete(sf.renamed, file)
+		}
+	}
+	return dropped
+}
+
+// deferDirRename remembers a watched directory that was renamed away, so that
+// a following CREATE event of a directory with the same files can be turned
+// into remote renames instead of removing and uploading every file again. It
+// returns false if no tracked file is inside, there is nothing to pair then.
+func (sf *SiaFolder) deferDirRename(dir string) bool {
+	files := make(map[string]fileState)
+	for _, file := range sf.trackedFiles() {
+		if !isWithin(dir, file) {
+			continue
+		}
+		relpath, err := filepath.Rel(dir, file)
+		if err != nil {
+			return false
+		}
+		fs, _ := sf.trackedFile(file)
+		files[relpath] = fs
+	}
+	if len(files) == 0 {
+		return false
+	}
+	log.WithFields(logrus.Fields{
+		"directory": dir,
+	}).Debug("Directory rename detected, waiting for its new name")
+	sf.renamedDirs[dir] = renamedDir{
+		files: files,
+		dirs:  sf.dropDir(dir),
+		at:    time.Now(),
+	}
+	return true
+}
+
+// matchDirRename returns the old path of the recently renamed directory whose
+// tracked files are exactly the files inside dir, with the same relative
+// paths, sizes and checksums. Nothing is matched if several directories
+// match, their files are removed and uploaded again instead.
+func (sf *SiaFolder) matchDirRename(dir string) (string, bool) {
+	if len(sf.renamedDirs) == 0 {
+		return "", false
+	}
+
+	// the files that would be synced from dir
+	sizes := make(map[string]int64)
+	err := filepath.Walk(dir, func(walkpath string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walkpath = sf.composedPath(walkpath)
+		if sf.isExcluded(walkpath) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f.IsDir() {
+			return nil
+		}
+		goodForWrite, err := sf.checkFile(walkpath)
+		if err != nil {
+			return err
+		}
+		if !goodForWrite || !sf.checkName(walkpath) {
+			return nil
+		}
+		relpath, err := filepath.Rel(dir, walkpath)
+		if err != nil {
+			return err
+		}
+		sizes[relpath] = f.Size()
+		return nil
+	})
+	if err != nil {
+		return "", false
+	}
+
+	checksums := make(map[string]string)
+	var match string
+	for olddir, rd := range sf.renamedDirs {
+		if len(rd.files) != len(sizes) {
+			continue
+		}
+		same := true
+		for relpath, fs := range rd.files {
+			size, exists := sizes[relpath]
+			if !exists || size != fs.Size {
+				same = false
+				break
+			}
+			checksum, checked := checksums[relpath]
+			if !checked {
+				checksum, err = sf.checksumFile(filepath.Join(dir, relpath))
+				if err != nil {
+					return "", false
+				}
+				checksums[relpath] = checksum
+			}
+			if checksum != fs.Checksum {
+				same = false
+				break
+			}
+		}
+		if !same {
+			continue
+		}
+		if match != "" {
+			log.WithFields(logrus.Fields{
+				"directory": dir,
+			}).Debug("Several renamed directories match, uploading the files again")
+			return "", false
+		}
+		match = olddir
+	}
+	return match, match != ""
+}
+
+// handleDirRename renames the remote files of the renamed directory olddir to
+// match its new name dir, and watches dir and its subdirectories instead. A
+// file that can't be renamed is removed and uploaded again.
+func (sf *SiaFolder) handleDirRename(olddir, dir string) {
+	rd := sf.renamedDirs[olddir]
+	delete(sf.renamedDirs, olddir)
+	log.WithFields(logrus.Fields{
+		"from": olddir,
+		"to":   dir,
+	}).Info("Renamed directory")
+
+	// the new directories exist on Sia before the files are moved into them
+	err := filepath.Walk(dir, func(walkpath string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walkpath = sf.composedPath(walkpath)
+		if !f.IsDir() {
+			return nil
+		}
+		if sf.isExcluded(walkpath) {
+			return filepath.SkipDir
+		}
+		sf.watchDir(walkpath)
+		return nil
+	})
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error watching renamed directory")
+	}
+
+	for relpath := range rd.files {
+		oldname, filename := filepath.Join(olddir, relpath), filepath.Join(dir, relpath)
+		err := sf.handleRename(oldname, filename)
+		if err == nil {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error with handleRename, uploading the file again")
+		sf.handleRemoved(oldname)
+		sf.handleChange(filename, fsnotify.Create)
+	}
+
+	sf.removeRemoteDirs(createdDirs(rd.dirs))
+}
+
+// createdDirs returns the directories of dirs that siasync created on Sia.
+func createdDirs(dirs map[string]dirState) []string {
+	var created []string
+	for d, state := range dirs {
+		if state.created {
+			created = append(created, d)
+		}
+	}
+	return created
+}
+
+// inRenamedDir reports whether file is inside a directory that is waiting for
+// its new name.
+func (sf *SiaFolder) inRenamedDir(file string) bool {
+	for dir := range sf.renamedDirs {
+		if isWithin(dir, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// expireDirRenames handles the directories that were renamed away longer than
+// renameWindow ago without a matching CREATE event as removed.
+func (sf *SiaFolder) expireDirRenames() {
+	for dir, rd := range sf.renamedDirs {
+		if time.Since(rd.at) < renameWindow {
+			continue
+		}
+		delete(sf.renamedDirs, dir)
+		sf.removeDirFiles(dir, rd.dirs)
+	}
+}
@@ -0,0 +1,123 @@
+package siasync
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// plannedUpload is a file that would have been uploaded in a dry run.
+type plannedUpload struct {
+	Path    string `json:"path"`
+	SiaPath string `json:"siapath"`
+	Size    int64  `json:"size"`
+}
+
+// plannedDeletion is a file that would have been deleted from Sia in a dry
+// run.
+type plannedDeletion struct {
+	Path    string `json:"path"`
+	SiaPath string `json:"siapath"`
+}
+
+// plannedRename is a file that would have been renamed on Sia in a dry run.
+type plannedRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// dryRunPlan collects the changes a dry run would have made to Sia.
+type dryRunPlan struct {
+	mu        sync.Mutex
+	uploads   map[string]plannedUpload
+	deletions map[string]plannedDeletion
+	renames   []plannedRename
+}
+
+// newDryRunPlan returns an empty plan.
+func newDryRunPlan() *dryRunPlan {
+	return &dryRunPlan{
+		uploads:   make(map[string]plannedUpload),
+		deletions: make(map[string]plannedDeletion),
+	}
+}
+
+// upload records a file that would have been uploaded.
+func (p *dryRunPlan) upload(path, siaPath string, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.deletions, path)
+	p.uploads[path] = plannedUpload{Path: path, SiaPath: siaPath, Size: size}
+}
+
+// delete records a file that would have been deleted from Sia.
+func (p *dryRunPlan) delete(path, siaPath string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.uploads, path)
+	p.deletions[path] = plannedDeletion{Path: path, SiaPath: siaPath}
+}
+
+// rename records a file that would have been renamed on Sia.
+func (p *dryRunPlan) rename(from, to string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.renames = append(p.renames, plannedRename{From: from, To: to})
+}
+
+// MarshalJSON implements json.Marshaler, listing uploads and deletions sorted
+// by path.
+func (p *dryRunPlan) MarshalJSON() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	plan := struct {
+		Uploads   []plannedUpload   `json:"uploads"`
+		Deletions []plannedDeletion `json:"deletions"`
+		Renames   []plannedRename   `json:"renames"`
+	}{
+		Uploads:   make([]plannedUpload, 0, len(p.uploads)),
+		Deletions: make([]plannedDeletion, 0, len(p.deletions)),
+		Renames:   append([]plannedRename{}, p.renames...),
+	}
+	for _, u := range p.uploads {
+		plan.Uploads = append(plan.Uploads, u)
+	}
+	sort.Slice(plan.Uploads, func(i, j int) bool {
+		return plan.Uploads[i].Path < plan.Uploads[j].Path
+	})
+	for _, d := range p.deletions {
+		plan.Deletions = append(plan.Deletions, d)
+	}
+	sort.Slice(plan.Deletions, func(i, j int) bool {
+		return plan.Deletions[i].Path < plan.Deletions[j].Path
+	})
+	return json.Marshal(plan)
+}
+
+// logSummary logs how many files the dry run would have changed.
+func (p *dryRunPlan) logSummary() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var size int64
+	for _, u := range p.uploads {
+		size += u.Size
+	}
+	log.WithFields(logrus.Fields{
+		"uploads":   len(p.uploads),
+		"bytes":     size,
+		"deletions": len(p.deletions),
+		"renames":   len(p.renames),
+	}).Info("Dry run summary")
+}
+
+// writeFile writes the plan as JSON to path.
+func (p *dryRunPlan) writeFile(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
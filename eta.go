@@ -0,0 +1,60 @@
+package siasync
+
+import (
+	"fmt"
+	"time"
+)
+
+// redundancySamples is how many redundancy samples of an uploading file are
+// kept to estimate when it reaches its minimum redundancy. A file whose
+// redundancy didn't grow across all of them is stalled.
+const redundancySamples = 5
+
+// redundancySample is the redundancy of a file at a point in time.
+type redundancySample struct {
+	at         time.Time
+	redundancy float64
+}
+
+// redundancyETA estimates from the samples, oldest first, how long it takes
+// until the redundancy reaches target at the rate it grew across them. ok is
+// false if there are fewer than two samples or the redundancy didn't grow,
+// stalled is set if it didn't grow across redundancySamples samples.
+func redundancyETA(samples []redundancySample, target float64) (eta time.Duration, ok, stalled bool) {
+	if len(samples) < 2 {
+		return 0, false, false
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	if last.redundancy >= target {
+		return 0, true, false
+	}
+	grown := last.redundancy - first.redundancy
+	elapsed := last.at.Sub(first.at)
+	if grown <= 0 || elapsed <= 0 {
+		return 0, false, len(samples) >= redundancySamples
+	}
+	rate := grown / elapsed.Seconds()
+	return time.Duration((target - last.redundancy) / rate * float64(time.Second)), true, false
+}
+
+// appendRedundancySample appends a sample to the history of a file, keeping
+// the last redundancySamples.
+func appendRedundancySample(samples []redundancySample, sample redundancySample) []redundancySample {
+	samples = append(append([]redundancySample(nil), samples...), sample)
+	if len(samples) > redundancySamples {
+		samples = samples[len(samples)-redundancySamples:]
+	}
+	return samples
+}
+
+// formatETA formats an ETA to the minute.
+func formatETA(eta time.Duration) string {
+	switch {
+	case eta < time.Minute:
+		return "<1 min"
+	case eta < time.Hour:
+		return fmt.Sprintf("%.0f min", eta.Minutes())
+	default:
+		return fmt.Sprintf("%.1f h", eta.Hours())
+	}
+}
@@ -0,0 +1,91 @@
+package siasync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRedundancyETA verifies the estimate of when a file reaches its minimum
+// redundancy and that a redundancy that stopped growing is stalled.
+func TestRedundancyETA(t *testing.T) {
+	start := time.Now()
+	samplesOf := func(redundancies ...float64) []redundancySample {
+		var samples []redundancySample
+		for i, redundancy := range redundancies {
+			samples = append(samples, redundancySample{at: start.Add(time.Duration(i) * time.Minute), redundancy: redundancy})
+		}
+		return samples
+	}
+	tests := []struct {
+		samples     []redundancySample
+		eta         time.Duration
+		ok, stalled bool
+	}{
+		{samplesOf(), 0, false, false},
+		{samplesOf(0.5), 0, false, false},
+		{samplesOf(0.5, 0.6), 4 * time.Minute, true, false},
+		{samplesOf(0.2, 0.4, 0.6), 2 * time.Minute, true, false},
+		{samplesOf(0.5, 1.2), 0, true, false},
+		{samplesOf(0.5, 0.5, 0.5), 0, false, false},
+		{samplesOf(0.5, 0.5, 0.5, 0.5, 0.5), 0, false, true},
+	}
+	for _, test := range tests {
+		eta, ok, stalled := redundancyETA(test.samples, 1)
+		if eta.Round(time.Second) != test.eta || ok != test.ok || stalled != test.stalled {
+			t.Errorf("%v: expected %v, %v, %v, got %v, %v, %v", test.samples, test.eta, test.ok, test.stalled, eta, ok, stalled)
+		}
+	}
+
+	var samples []redundancySample
+	for i := 0; i < 2*redundancySamples; i++ {
+		samples = appendRedundancySample(samples, redundancySample{redundancy: float64(i)})
+	}
+	if len(samples) != redundancySamples || samples[0].redundancy != redundancySamples {
+		t.Fatalf("only the last %v samples should be kept, got %v", redundancySamples, samples)
+	}
+
+	for eta, formatted := range map[time.Duration]string{
+		30 * time.Second: "<1 min",
+		25 * time.Minute: "25 min",
+		90 * time.Minute: "1.5 h",
+	} {
+		if formatETA(eta) != formatted {
+			t.Errorf("expected %v to be formatted as %v, got %v", eta, formatted, formatETA(eta))
+		}
+	}
+}
+
+// TestSiafolderRedundancyETA verifies that the status reports when files that
+// siad is still uploading reach their minimum redundancy.
+func TestSiafolderRedundancyETA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewMockClient(0.1)
+	config := testConfig()
+	config.MinRedundancy = 2
+	config.ProgressInterval = 100 * time.Millisecond
+	sf, err := newSyncedSiafolder(dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	time.Sleep(500 * time.Millisecond)
+	status, err := sf.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Files) != 1 || status.Files[0].ETA != "<1 min" || status.Files[0].Stalled {
+		t.Fatalf("file should reach 2x redundancy in less than a minute, got %+v", status.Files)
+	}
+}
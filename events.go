@@ -0,0 +1,217 @@
+package siasync
+
+import (
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// eventQueue holds the events of the watcher between readEvents, which drains
+// the watcher as fast as it delivers them, and eventWatcher, which handles
+// them. The kernel drops events once its own queue is full, which it would
+// while eventWatcher is busy if nothing else read the watcher.
+type eventQueue struct {
+	mu     sync.Mutex
+	events []fsnotify.Event
+	last   map[string]int // last is the index of the last queued event of each path
+
+	// ready has a value while events are queued.
+	ready chan struct{}
+}
+
+// newEventQueue returns an empty eventQueue.
+func newEventQueue() *eventQueue {
+	return &eventQueue{
+		last:  make(map[string]int),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// push queues an event. A WRITE or CHMOD event, or a repeat of the last queued
+// event of the same path, is merged into that event, eventWatcher looks at the
+// file once it handles it anyway. Other events are queued in order, so that a
+// file removed and created again or renamed away is handled as it happened.
+func (q *eventQueue) push(event fsnotify.Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if i, ok := q.last[event.Name]; ok {
+		if event.Op&^(fsnotify.Write|fsnotify.Chmod) == 0 || event.Op == q.events[i].Op {
+			q.events[i].Op |= event.Op
+			return
+		}
+	}
+	q.last[event.Name] = len(q.events)
+	q.events = append(q.events, event)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the queued events in order and empties the queue.
+func (q *eventQueue) take() []fsnotify.Event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	events := q.events
+	q.events = nil
+	q.last = make(map[string]int)
+	return events
+}
+
+// readEvents moves the events of the watcher to the event queue until the
+// SiaFolder is closed, and logs the errors of the watcher.
+func (sf *SiaFolder) readEvents() {
+	defer sf.watching.Done()
+	for {
+		select {
+		case <-sf.ctx.Done():
+			return
+		case event, ok := <-sf.watcher.Events:
+			if !ok {
+				return
+			}
+			sf.events.push(event)
+		case err, ok := <-sf.watcher.Errors:
+			if !ok {
+				return
+			}
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Error("fsevents error")
+			}
+		}
+	}
+}
+
+// checkJob is a tracked file that got a CREATE or WRITE event and is
+// checksummed by a check worker to see whether it changed. replaced is set if
+// another file was renamed over it.
+type checkJob struct {
+	file     string
+	replaced bool
+}
+
+// checkedFile is a checkJob with the state of the file, or the error reading
+// it.
+type checkedFile struct {
+	checkJob
+	fs  fileState
+	err error
+}
+
+// checkQueue is a queue of files waiting for one of the check workers. A file
+// is only queued once until a worker picks it up.
+type checkQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	files  []string
+	queued map[string]bool // queued maps the queued files to whether they were replaced
+	closed bool
+}
+
+// newCheckQueue returns an empty checkQueue.
+func newCheckQueue() *checkQueue {
+	q := &checkQueue{queued: make(map[string]bool)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a file to the end of the queue unless it is already queued or the
+// queue is closed.
+func (q *checkQueue) push(job checkJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	if replaced, exists := q.queued[job.file]; exists {
+		q.queued[job.file] = replaced || job.replaced
+		return
+	}
+	q.queued[job.file] = job.replaced
+	q.files = append(q.files, job.file)
+	q.cond.Signal()
+}
+
+// pop blocks until a file is queued and returns it. It returns false once the
+// queue is closed.
+func (q *checkQueue) pop() (checkJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.files) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return checkJob{}, false
+	}
+	job := checkJob{file: q.files[0], replaced: q.queued[q.files[0]]}
+	q.files = q.files[1:]
+	delete(q.queued, job.file)
+	return job, true
+}
+
+// close drops the queued files and wakes up all waiting workers.
+func (q *checkQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.files = nil
+	q.queued = make(map[string]bool)
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// checkWorker checksums the files pushed to the check queue and hands them
+// back to eventWatcher until the SiaFolder is closed. The files are checksummed
+// off eventWatcher, which keeps handling events meanwhile, and eventWatcher
+// alone updates their state.
+func (sf *SiaFolder) checkWorker() {
+	defer sf.watching.Done()
+	for {
+		job, ok := sf.checks.pop()
+		if !ok {
+			return
+		}
+		fs, err := sf.statFile(job.file)
+		select {
+		case sf.checked <- checkedFile{checkJob: job, fs: fs, err: err}:
+		case <-sf.ctx.Done():
+			return
+		}
+	}
+}
+
+// handleChecked handles a file checksummed by a check worker, unless it was
+// removed or renamed away in the meantime, in which case its events took care
+// of it. It runs on the eventWatcher goroutine.
+func (sf *SiaFolder) handleChecked(checked checkedFile) {
+	if _, tracked := sf.trackedFile(checked.file); !tracked {
+		return
+	}
+	if checked.err != nil {
+		checked.err = sf.skipUnreadable(checked.file, checked.err)
+		if checked.err != nil {
+			log.WithFields(logrus.Fields{
+				"error": checked.err.Error(),
+			}).Error("Error checksumming changed file")
+		}
+		return
+	}
+
+	if checked.replaced {
+		err := sf.handleReplaced(checked.file, checked.fs)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Error with handleReplaced")
+		}
+		return
+	}
+	err := sf.handleFileWrite(checked.file, checked.fs)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error with handleFileWrite")
+	}
+}
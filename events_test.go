@@ -0,0 +1,78 @@
+package siasync
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// TestEventQueue verifies that the event queue keeps events in order and
+// coalesces repeated events of a path.
+func TestEventQueue(t *testing.T) {
+	q := newEventQueue()
+	select {
+	case <-q.ready:
+		t.Fatal("an empty queue shouldn't be ready")
+	default:
+	}
+
+	q.push(fsnotify.Event{Name: "a", Op: fsnotify.Create})
+	q.push(fsnotify.Event{Name: "a", Op: fsnotify.Write})
+	q.push(fsnotify.Event{Name: "a", Op: fsnotify.Write})
+	q.push(fsnotify.Event{Name: "b", Op: fsnotify.Rename})
+	q.push(fsnotify.Event{Name: "a", Op: fsnotify.Chmod})
+	q.push(fsnotify.Event{Name: "a", Op: fsnotify.Remove})
+	q.push(fsnotify.Event{Name: "a", Op: fsnotify.Create})
+	q.push(fsnotify.Event{Name: "c", Op: fsnotify.Write})
+	q.push(fsnotify.Event{Name: "c", Op: fsnotify.Write})
+
+	select {
+	case <-q.ready:
+	default:
+		t.Fatal("the queue should be ready")
+	}
+	expected := []fsnotify.Event{
+		{Name: "a", Op: fsnotify.Create | fsnotify.Write | fsnotify.Chmod},
+		{Name: "b", Op: fsnotify.Rename},
+		{Name: "a", Op: fsnotify.Remove},
+		{Name: "a", Op: fsnotify.Create},
+		{Name: "c", Op: fsnotify.Write},
+	}
+	if events := q.take(); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected %v, got %v", expected, events)
+	}
+	if events := q.take(); len(events) != 0 {
+		t.Fatalf("the queue should be empty, got %v", events)
+	}
+}
+
+// TestCheckQueue verifies that the check queue hands out files in order, only
+// once while they are queued, and unblocks workers when closed.
+func TestCheckQueue(t *testing.T) {
+	q := newCheckQueue()
+	q.push(checkJob{file: "a"})
+	q.push(checkJob{file: "b"})
+	q.push(checkJob{file: "a", replaced: true})
+
+	for _, expected := range []checkJob{{file: "a", replaced: true}, {file: "b"}} {
+		job, ok := q.pop()
+		if !ok || job != expected {
+			t.Fatalf("expected %+v, got %+v", expected, job)
+		}
+	}
+
+	popped := make(chan bool)
+	go func() {
+		_, ok := q.pop()
+		popped <- ok
+	}()
+	q.close()
+	if <-popped {
+		t.Fatal("pop should return false once the queue is closed")
+	}
+	q.push(checkJob{file: "c"})
+	if _, ok := q.pop(); ok {
+		t.Fatal("pop should return false once the queue is closed")
+	}
+}
@@ -0,0 +1,193 @@
+package siasync
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultEventBuffer is the number of events Events buffers if
+// Config.EventBuffer isn't set.
+const defaultEventBuffer = 256
+
+// consumerEventBuffer is the number of events buffered for the consumers
+// within siasync, like the upload log and the hook scripts.
+const consumerEventBuffer = 1024
+
+// EventType is the kind of an Event.
+type EventType string
+
+const (
+	// FileQueued is published when a new or changed file is queued for
+	// upload.
+	FileQueued EventType = "queued"
+
+	// FileUploaded is published once a file was handed to siad.
+	FileUploaded EventType = "uploaded"
+
+	// FileUploadFailed is published when the upload of a file is given up,
+	// or stopped making progress.
+	FileUploadFailed EventType = "uploadfailed"
+
+	// FileDeleted is published once a file was removed from Sia.
+	FileDeleted EventType = "deleted"
+
+	// FileDeleteHeld is published when the removal of a file from Sia is
+	// held back because too many files were removed.
+	FileDeleteHeld EventType = "deleteheld"
+
+	// ReconcileComplete is published when a comparison of the directory with
+	// Sia is done, with its error if it failed.
+	ReconcileComplete EventType = "reconcilecomplete"
+)
+
+// Event is something that happened to a SiaFolder, as published on its
+// Events channel. Path, SiaPath and Size describe the file, they are empty for
+// ReconcileComplete.
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Path    string // Path is the absolute local path of the file
+	SiaPath string // SiaPath is where the file is, or would be, on Sia
+	Size    int64
+	Err     error
+}
+
+// eventSubscriber is a channel the events of a SiaFolder are published on.
+// An event that doesn't fit into its buffer is dropped.
+type eventSubscriber struct {
+	name    string
+	ch      chan Event
+	dropped int
+}
+
+// eventStream publishes the events of a SiaFolder to its subscribers without
+// ever blocking the goroutine publishing them.
+type eventStream struct {
+	mu          sync.Mutex
+	subscribers []*eventSubscriber
+	closed      bool
+
+	// consuming counts the consumers within siasync that still handle
+	// events.
+	consuming sync.WaitGroup
+}
+
+// subscribe returns a channel receiving every event published from now on,
+// buffering up to size events.
+func (s *eventStream) subscribe(name string, size int) <-chan Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := &eventSubscriber{name: name, ch: make(chan Event, size)}
+	s.subscribers = append(s.subscribers, sub)
+	return sub.ch
+}
+
+// consume calls handle with every event published from now on, on a
+// goroutine of its own, until the stream is closed.
+func (s *eventStream) consume(name string, handle func(Event)) {
+	events := s.subscribe(name, consumerEventBuffer)
+	s.consuming.Add(1)
+	go func() {
+		defer s.consuming.Done()
+		for e := range events {
+			handle(e)
+		}
+	}()
+}
+
+// publish hands e to every subscriber that has room for it.
+func (s *eventStream) publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	for _, sub := range s.subscribers {
+		select {
+		case sub.ch <- e:
+			continue
+		default:
+		}
+		sub.dropped++
+		if sub.dropped == 1 {
+			log.WithFields(logrus.Fields{
+				"consumer": sub.name,
+				"event":    e.Type,
+				"path":     e.Path,
+			}).Warn("Event consumer can't keep up, dropping events")
+		}
+	}
+}
+
+// close closes the channels of all subscribers, no more events are published.
+func (s *eventStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, sub := range s.subscribers {
+		close(sub.ch)
+		if sub.dropped > 0 {
+			log.WithFields(logrus.Fields{
+				"consumer": sub.name,
+				"dropped":  sub.dropped,
+			}).Warn("Events were dropped")
+		}
+	}
+}
+
+// Events returns the channel the events of the SiaFolder are published on.
+// It buffers Config.EventBuffer events, events that don't fit are dropped
+// rather than holding up syncing. It is closed by Close.
+func (sf *SiaFolder) Events() <-chan Event {
+	return sf.published
+}
+
+// emit publishes an event of the SiaFolder. The siapath of a file is filled
+// in from its tracked state unless it is set.
+func (sf *SiaFolder) emit(e Event) {
+	e.Time = time.Now()
+	if e.Path != "" && e.SiaPath == "" {
+		if relpath, err := filepath.Rel(sf.path, e.Path); err == nil {
+			fs, _ := sf.trackedFile(e.Path)
+			if siaPath, err := sf.uploadedSiaPath(relpath, fs); err == nil {
+				e.SiaPath = siaPath.String()
+			}
+		}
+	}
+	sf.stream.publish(e)
+}
+
+// queueUpload queues a new or changed file for upload. FileQueued is only
+// emitted if the file wasn't queued already.
+func (sf *SiaFolder) queueUpload(file string) {
+	if sf.uploads.push(file) {
+		sf.emit(Event{Type: FileQueued, Path: file})
+	}
+}
+
+// logEvent logs the uploads and deletions of files, it consumes the events
+// of the SiaFolder.
+func logEvent(e Event) {
+	switch e.Type {
+	case FileUploaded:
+		log.WithFields(logrus.Fields{
+			"event":   "upload",
+			"file":    e.Path,
+			"siapath": e.SiaPath,
+			"bytes":   e.Size,
+		}).Info("Uploaded file")
+	case FileDeleted:
+		log.WithFields(logrus.Fields{
+			"event":   "delete",
+			"file":    e.Path,
+			"siapath": e.SiaPath,
+			"bytes":   e.Size,
+		}).Info("Deleted file")
+	}
+}
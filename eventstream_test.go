@@ -0,0 +1,67 @@
+package siasync
+
+import (
+	"testing"
+)
+
+// TestEventStream verifies that events are dropped rather than blocking while
+// a subscriber is full, and that nothing is published once the stream is
+// closed.
+func TestEventStream(t *testing.T) {
+	var stream eventStream
+	full := stream.subscribe("full", 1)
+	roomy := stream.subscribe("roomy", 10)
+	var consumed []EventType
+	stream.consume("consumer", func(e Event) {
+		consumed = append(consumed, e.Type)
+	})
+
+	for _, typ := range []EventType{FileQueued, FileUploaded, FileDeleted} {
+		stream.publish(Event{Type: typ})
+	}
+	stream.close()
+	stream.consuming.Wait()
+	stream.publish(Event{Type: ReconcileComplete})
+
+	var received []EventType
+	for e := range full {
+		received = append(received, e.Type)
+	}
+	if len(received) != 1 || received[0] != FileQueued {
+		t.Fatalf("a full subscriber should only get the first event, got %v", received)
+	}
+	received = nil
+	for e := range roomy {
+		received = append(received, e.Type)
+	}
+	if len(received) != 3 {
+		t.Fatalf("expected all 3 events, got %v", received)
+	}
+	if len(consumed) != 3 {
+		t.Fatalf("expected the consumer to handle all 3 events, got %v", consumed)
+	}
+	if stream.subscribers[0].dropped != 2 {
+		t.Fatalf("expected 2 dropped events, got %v", stream.subscribers[0].dropped)
+	}
+}
+
+// TestQueueUploadEvents verifies that FileQueued is only emitted for a file
+// that wasn't queued already.
+func TestQueueUploadEvents(t *testing.T) {
+	sf := &SiaFolder{uploads: newUploadQueue("fifo")}
+	events := sf.stream.subscribe("test", 10)
+	sf.queueUpload("a")
+	sf.queueUpload("b")
+	sf.queueUpload("a")
+	sf.stream.close()
+
+	var queued []string
+	for e := range events {
+		if e.Type == FileQueued {
+			queued = append(queued, e.Path)
+		}
+	}
+	if len(queued) != 2 || queued[0] != "a" || queued[1] != "b" {
+		t.Fatalf("expected FileQueued for a and b, got %v", queued)
+	}
+}
@@ -0,0 +1,143 @@
+package siasync
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFile is the name of the file in the root of the synced directory that
+// lists exclude patterns, one per line.
+const IgnoreFile = ".siasyncignore"
+
+// LockFileName is the name of the lock file in the root of the synced
+// directory that keeps a second siasync from syncing the same directory. It
+// is never synced.
+const LockFileName = ".siasync.lock"
+
+// defaultExcludePatterns are the hidden files and the files left behind by
+// editors, file managers and download tools that are never synced, unless
+// hidden files are synced too. They are extended by the configured patterns.
+var defaultExcludePatterns = []string{
+	".*",           // hidden files and directories, like .DS_Store, .nfs*, and rsync's .~tmp~
+	"Thumbs.db",    // Windows thumbnail cache
+	"desktop.ini",  // Windows folder settings
+	"*~",           // editor backups
+	"*.swp",        // vim swap files
+	"~$*",          // Office lock files
+	"*.tmp",        // temporary files written before being renamed into place
+	"*.temp",       // temporary files
+	"*.part",       // partial downloads of browsers, curl and rsync
+	"*.partial",    // partial downloads
+	"*.crdownload", // Chrome downloads
+}
+
+// readIgnoreFile returns the patterns listed in the ignore file in dir. Empty
+// lines and lines starting with # are skipped. A missing ignore file is not an
+// error.
+func readIgnoreFile(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// matchPattern reports whether the slash separated relative path matches the
+// exclude pattern. A pattern without a slash matches a file or directory of
+// that name at any depth, and a ** segment matches any number of directories.
+func matchPattern(pattern, relpath string) bool {
+	pattern = strings.Trim(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(relpath, "/"))
+}
+
+// matchSegments matches path segments against pattern segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	matched, err := path.Match(pattern[0], segments[0])
+	if err != nil || !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// isExcluded reports whether the file or directory at file, or any of its
+// parent directories below the sync root, matches an exclude pattern.
+func (sf *SiaFolder) isExcluded(file string) bool {
+	return sf.excludedBy(file) != ""
+}
+
+// excludedBy returns the rule that excludes the file or directory at file,
+// which is the exclude pattern it matches, or "" if it isn't excluded.
+func (sf *SiaFolder) excludedBy(file string) string {
+	// never sync siasync's own state file, manifest, lock file and watcher
+	// test file, or the files moved to the done directory
+	if sf.stateFile != "" && (file == sf.stateFile || file == sf.stateFile+".tmp") {
+		return "state file"
+	}
+	if file == filepath.Join(sf.path, watchTestFile) || file == filepath.Join(sf.path, LockFileName) {
+		return "siasync file"
+	}
+	if sf.manifestFile != "" && (file == sf.manifestFile || file == sf.manifestFile+".tmp") {
+		return "manifest"
+	}
+	if sf.doneDir != "" && (file == sf.doneDir || isWithin(sf.doneDir, file)) {
+		return "done directory"
+	}
+	if sf.singleFile != "" && file != sf.singleFile && file != sf.path {
+		return "not the synced file"
+	}
+
+	relpath, err := relSlashPath(sf.path, file, isWindows)
+	if err != nil || relpath == "" {
+		return ""
+	}
+
+	sf.patternsMu.Lock()
+	patterns := sf.excludePatterns
+	sf.patternsMu.Unlock()
+	for {
+		for _, pattern := range patterns {
+			if matchPattern(pattern, relpath) {
+				return pattern
+			}
+		}
+		i := strings.LastIndex(relpath, "/")
+		if i < 0 {
+			return ""
+		}
+		relpath = relpath[:i]
+	}
+}
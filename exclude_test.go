@@ -0,0 +1,119 @@
+package siasync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMatchPattern probes matchPattern with plain, nested and ** patterns.
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		relpath string
+		match   bool
+	}{
+		{"*.part", "movie.part", true},
+		{"*.part", "movies/movie.part", true},
+		{"*.part", "movie.mkv", false},
+		{"*.!ut", "a/b/c.!ut", true},
+		{"sample", "movies/sample", true},
+		{"sample/", "sample", true},
+		{"movies/*.nfo", "movies/foo.nfo", true},
+		{"movies/*.nfo", "movies/foo/bar.nfo", false},
+		{"movies/**/*.nfo", "movies/foo/bar.nfo", true},
+		{"movies/**/*.nfo", "movies/bar.nfo", true},
+		{"**/extras/**", "tv/show/extras/clip.mkv", true},
+		{"**/extras/**", "tv/show/clip.mkv", false},
+		{"tv/**", "tv", true},
+		{"tv/**", "movies/tv", false},
+	}
+	for _, test := range tests {
+		if matchPattern(test.pattern, test.relpath) != test.match {
+			t.Errorf("matchPattern(%q, %q) should be %v", test.pattern, test.relpath, test.match)
+		}
+	}
+}
+
+// TestIsExcluded verifies that files inside an excluded directory are
+// excluded as well.
+func TestIsExcluded(t *testing.T) {
+	root, err := filepath.Abs(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf := &SiaFolder{
+		path:            root,
+		excludePatterns: []string{"sample", "*.part"},
+	}
+
+	tests := []struct {
+		relpath  string
+		excluded bool
+	}{
+		{"", false},
+		{"movie.mkv", false},
+		{"movie.part", true},
+		{"sample", true},
+		{"sample/movie.mkv", true},
+		{"show/sample/movie.mkv", true},
+		{"samples/movie.mkv", false},
+	}
+	for _, test := range tests {
+		if sf.isExcluded(filepath.Join(root, test.relpath)) != test.excluded {
+			t.Errorf("isExcluded(%q) should be %v", test.relpath, test.excluded)
+		}
+	}
+}
+
+// TestDefaultExcludePatterns verifies that hidden and temporary files are
+// excluded by default, reporting the rule that matched, and synced with
+// SyncHidden.
+func TestDefaultExcludePatterns(t *testing.T) {
+	root, err := filepath.Abs(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf := &SiaFolder{
+		path:            root,
+		excludePatterns: append(append([]string{}, defaultExcludePatterns...), "*.nfo"),
+	}
+
+	tests := map[string]string{
+		".DS_Store":                 ".*",
+		"photos/Thumbs.db":          "Thumbs.db",
+		".~tmp~/movie.mkv":          ".*",
+		"movies/.nfs000001":         ".*",
+		"notes.txt~":                "*~",
+		"docs/~$report.docx":        "~$*",
+		"movie.part":                "*.part",
+		"report.docx.tmp":           "*.tmp",
+		"movies/movie.nfo":          "*.nfo",
+		"movie.mkv":                 "",
+		"photos/holiday.jpg":        "",
+		"movies/file.with.dots.mkv": "",
+	}
+	for relpath, rule := range tests {
+		if matched := sf.excludedBy(filepath.Join(root, relpath)); matched != rule {
+			t.Errorf("expected %q to be excluded by %q, got %q", relpath, rule, matched)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := testConfig()
+	config.SyncOnly = true
+	config.SyncHidden = true
+	sf, err = newSyncedSiafolder(dir, newTestingClient(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	if sf.isExcluded(filepath.Join(dir, ".hidden")) {
+		t.Error("hidden files should be synced with SyncHidden")
+	}
+}
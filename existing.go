@@ -0,0 +1,71 @@
+package siasync
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/modules"
+)
+
+// sameContent reports whether the file on Sia has the content of the local
+// file described by fs. The checksum in the manifest decides if there is one,
+// otherwise the sizes must match.
+func (sf *SiaFolder) sameContent(file string, siafile modules.FileInfo, fs fileState) bool {
+	if int64(siafile.Filesize) != fs.Size {
+		return false
+	}
+	if sf.previousManifest == nil || sf.changeDetection != "sha256" {
+		return true
+	}
+	entry, ok := sf.previousManifest.Files[sf.fileKey(file)]
+	if !ok || entry.SHA256 == "" {
+		return true
+	}
+	return entry.SHA256 == fs.Checksum
+}
+
+// uploadExisting handles the upload of a file that siad rejected because a
+// file already exists at its siapath, like the upload of a siasync that
+// crashed before it saved its state. A file on Sia with the same content is
+// adopted as the upload of the local file, and true is returned. A different
+// file is removed and the local file uploaded again, or in archive mode the
+// local file is uploaded as the next version that isn't on Sia yet. It
+// returns the siapath the file was uploaded to, fs as the version it was
+// uploaded as and the upload error.
+func (sf *SiaFolder) uploadExisting(file, abspath string, siaPath modules.SiaPath, coding CategorySettings, fs fileState) (modules.SiaPath, fileState, bool, error) {
+	rf, err := sf.client.RenterFileGet(siaPath)
+	if err != nil {
+		return siaPath, fs, false, fmt.Errorf("error getting the file already at %v: %v", siaPath, err)
+	}
+	if sf.sameContent(file, rf.File, fs) {
+		fs.Uploaded = true
+		fs.UploadTime = rf.File.CreateTime
+		sf.trackFile(file, fs)
+		log.WithFields(logrus.Fields{
+			"file":    file,
+			"siapath": siaPath.String(),
+		}).Info("File is already on Sia, adopting it")
+		return siaPath, fs, true, nil
+	}
+
+	if sf.archive {
+		siaPath, fs, err = sf.freeVersion(file, fs, rf.File)
+		if err != nil {
+			return siaPath, fs, false, err
+		}
+	} else {
+		err = sf.client.RenterDeletePost(siaPath)
+		sf.audit(AuditRecord{Op: auditDelete, Path: file, SiaPath: siaPath.String(), Size: int64(rf.File.Filesize)}, err)
+		if err != nil {
+			return siaPath, fs, false, fmt.Errorf("error removing the different file already at %v: %v", siaPath, err)
+		}
+	}
+	log.WithFields(logrus.Fields{
+		"file":    file,
+		"siapath": siaPath.String(),
+	}).Info("A different file is already on Sia, uploading the file again")
+	err = sf.client.RenterUploadPost(abspath, siaPath, coding.dataPieces, coding.parityPieces)
+	sf.audit(AuditRecord{Op: auditUpload, Path: file, SiaPath: siaPath.String(), Size: fs.Size, Checksum: fs.Checksum}, err)
+	sf.listing.invalidate()
+	return siaPath, fs, false, err
+}
@@ -0,0 +1,208 @@
+package siasync
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/node/api"
+	sia "gitlab.com/NebulousLabs/Sia/node/api/client"
+)
+
+// DefaultAddress is the address of siad without -address.
+const DefaultAddress = "127.0.0.1:9980"
+
+// failoverAfter is how many probes in a row the active siad must fail before
+// siasync fails over to the next one.
+const failoverAfter = 3
+
+// siaFailover is implemented by siaClients that can switch to another siad,
+// like FailoverClient.
+type siaFailover interface {
+	// activeAddress returns the address of the siad calls go to.
+	activeAddress() string
+	// failover switches from the siad at address to the next one that
+	// answers and reports whether the siad changed.
+	failover(from string) bool
+}
+
+// wrappedClient is implemented by SiaClients that pass the calls on to
+// another one, like LimitedClient.
+type wrappedClient interface {
+	// unwrap returns the client the calls are passed on to.
+	unwrap() SiaClient
+}
+
+// failoverOf returns the siaFailover of client or of a client it wraps, and
+// false if none of them can switch to another siad.
+func failoverOf(client SiaClient) (siaFailover, bool) {
+	for {
+		if nodes, ok := client.(siaFailover); ok {
+			return nodes, true
+		}
+		wrapped, ok := client.(wrappedClient)
+		if !ok {
+			return nil, false
+		}
+		client = wrapped.unwrap()
+	}
+}
+
+// FailoverClient is a Sia API client for a primary siad and standby ones. All
+// calls go to the active siad, failover switches to the next one that answers
+// once it stopped answering. There is no automatic switch back.
+type FailoverClient struct {
+	mu      sync.Mutex
+	clients []*sia.Client
+	active  int
+}
+
+// NewFailoverClient returns a client for the siad at every address, the first
+// one being active.
+func NewFailoverClient(addresses []string, password, userAgent string) *FailoverClient {
+	c := &FailoverClient{}
+	for _, address := range addresses {
+		client := sia.New(address)
+		client.Password = password
+		client.UserAgent = userAgent
+		c.clients = append(c.clients, client)
+	}
+	return c
+}
+
+// ParseAddresses returns the addresses of -address, which can be repeated and
+// comma separated, or the default siad address if there are none.
+func ParseAddresses(values []string) []string {
+	var addresses []string
+	for _, value := range values {
+		for _, address := range strings.Split(value, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		addresses = []string{DefaultAddress}
+	}
+	return addresses
+}
+
+// client returns the client of the active siad.
+func (c *FailoverClient) client() *sia.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clients[c.active]
+}
+
+// activeAddress implements siaFailover.
+func (c *FailoverClient) activeAddress() string {
+	return c.client().Address
+}
+
+// Connect makes the first siad that answers the active one, so that siasync
+// starts while the primary siad is down. If none answers, the primary stays
+// active.
+func (c *FailoverClient) Connect() {
+	for i, client := range c.clients {
+		if _, err := client.DaemonVersionGet(); err != nil {
+			log.WithFields(logrus.Fields{
+				"address": client.Address,
+				"error":   err.Error(),
+			}).Warn("siad is not answering")
+			continue
+		}
+		if i > 0 {
+			log.WithFields(logrus.Fields{
+				"address": client.Address,
+			}).Warn("Starting with standby siad")
+		}
+		c.mu.Lock()
+		c.active = i
+		c.mu.Unlock()
+		return
+	}
+}
+
+// failover implements siaFailover. The other nodes are probed in order after
+// the active one, without holding up calls to the active one meanwhile. If
+// another caller failed over from the same siad already, nothing changes.
+func (c *FailoverClient) failover(from string) bool {
+	c.mu.Lock()
+	active := c.active
+	c.mu.Unlock()
+	if c.clients[active].Address != from {
+		return true
+	}
+	for i := 1; i < len(c.clients); i++ {
+		next := (active + i) % len(c.clients)
+		if _, err := c.clients[next].DaemonVersionGet(); err != nil {
+			continue
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.active != active {
+			return true
+		}
+		log.WithFields(logrus.Fields{
+			"from": from,
+			"to":   c.clients[next].Address,
+		}).Warn("siad is not answering, failing over to the next siad")
+		c.active = next
+		return true
+	}
+	return false
+}
+
+func (c *FailoverClient) DaemonVersionGet() (api.DaemonVersionGet, error) {
+	return c.client().DaemonVersionGet()
+}
+
+func (c *FailoverClient) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	return c.client().RenterUploadPost(path, siaPath, dataPieces, parityPieces)
+}
+
+func (c *FailoverClient) RenterDeletePost(siaPath modules.SiaPath) error {
+	return c.client().RenterDeletePost(siaPath)
+}
+
+func (c *FailoverClient) RenterFileGet(siaPath modules.SiaPath) (api.RenterFile, error) {
+	return c.client().RenterFileGet(siaPath)
+}
+
+func (c *FailoverClient) RenterGetDir(siaPath modules.SiaPath) (api.RenterDirectory, error) {
+	return c.client().RenterGetDir(siaPath)
+}
+
+func (c *FailoverClient) RenterRenamePost(siaPathOld, siaPathNew modules.SiaPath) error {
+	return c.client().RenterRenamePost(siaPathOld, siaPathNew)
+}
+
+func (c *FailoverClient) RenterDirCreatePost(siaPath modules.SiaPath) error {
+	return c.client().RenterDirCreatePost(siaPath)
+}
+
+func (c *FailoverClient) RenterDirDeletePost(siaPath modules.SiaPath) error {
+	return c.client().RenterDirDeletePost(siaPath)
+}
+
+func (c *FailoverClient) RenterDownloadFullGet(siaPath modules.SiaPath, destination string, async bool) error {
+	_, err := c.client().RenterDownloadFullGet(siaPath, destination, async)
+	return err
+}
+
+func (c *FailoverClient) ConsensusGet() (api.ConsensusGET, error) {
+	return c.client().ConsensusGet()
+}
+
+func (c *FailoverClient) WalletGet() (api.WalletGET, error) {
+	return c.client().WalletGet()
+}
+
+func (c *FailoverClient) RenterGet() (api.RenterGET, error) {
+	return c.client().RenterGet()
+}
+
+func (c *FailoverClient) RenterDisabledContractsGet() (api.RenterContracts, error) {
+	return c.client().RenterDisabledContractsGet()
+}
@@ -0,0 +1,191 @@
+package siasync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/node/api"
+)
+
+// TestParseAddresses verifies that -address can be repeated and comma
+// separated, and defaults to the local siad.
+func TestParseAddresses(t *testing.T) {
+	tests := []struct {
+		values    []string
+		addresses []string
+	}{
+		{nil, []string{DefaultAddress}},
+		{[]string{""}, []string{DefaultAddress}},
+		{[]string{"10.0.0.1:9980"}, []string{"10.0.0.1:9980"}},
+		{[]string{"10.0.0.1:9980, 10.0.0.2:9980"}, []string{"10.0.0.1:9980", "10.0.0.2:9980"}},
+		{[]string{"10.0.0.1:9980", "10.0.0.2:9980,"}, []string{"10.0.0.1:9980", "10.0.0.2:9980"}},
+	}
+	for _, test := range tests {
+		addresses := ParseAddresses(test.values)
+		if !reflect.DeepEqual(addresses, test.addresses) {
+			t.Errorf("ParseAddresses(%q) = %q, expected %q", test.values, addresses, test.addresses)
+		}
+	}
+}
+
+// failoverTestingClient is a SiaClient failing over between testingClients.
+type failoverTestingClient struct {
+	mu      sync.Mutex
+	clients []*testingClient
+	active  int
+}
+
+func (c *failoverTestingClient) client() *testingClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clients[c.active]
+}
+
+func (c *failoverTestingClient) activeAddress() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return string('a' + rune(c.active))
+}
+
+func (c *failoverTestingClient) failover(from string) bool {
+	if c.activeAddress() != from {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := 1; i < len(c.clients); i++ {
+		next := (c.active + i) % len(c.clients)
+		if _, err := c.clients[next].DaemonVersionGet(); err == nil {
+			c.active = next
+			return true
+		}
+	}
+	return false
+}
+
+func (c *failoverTestingClient) DaemonVersionGet() (api.DaemonVersionGet, error) {
+	return c.client().DaemonVersionGet()
+}
+
+func (c *failoverTestingClient) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	return c.client().RenterUploadPost(path, siaPath, dataPieces, parityPieces)
+}
+
+func (c *failoverTestingClient) RenterDeletePost(siaPath modules.SiaPath) error {
+	return c.client().RenterDeletePost(siaPath)
+}
+
+func (c *failoverTestingClient) RenterFileGet(siaPath modules.SiaPath) (api.RenterFile, error) {
+	return c.client().RenterFileGet(siaPath)
+}
+
+func (c *failoverTestingClient) RenterGetDir(siaPath modules.SiaPath) (api.RenterDirectory, error) {
+	return c.client().RenterGetDir(siaPath)
+}
+
+func (c *failoverTestingClient) RenterRenamePost(siaPathOld, siaPathNew modules.SiaPath) error {
+	return c.client().RenterRenamePost(siaPathOld, siaPathNew)
+}
+
+func (c *failoverTestingClient) RenterDirCreatePost(siaPath modules.SiaPath) error {
+	return c.client().RenterDirCreatePost(siaPath)
+}
+
+func (c *failoverTestingClient) RenterDirDeletePost(siaPath modules.SiaPath) error {
+	return c.client().RenterDirDeletePost(siaPath)
+}
+
+func (c *failoverTestingClient) RenterDownloadFullGet(siaPath modules.SiaPath, destination string, async bool) error {
+	return c.client().RenterDownloadFullGet(siaPath, destination, async)
+}
+
+// TestFailoverOf verifies that the FailoverClient is found behind the
+// LimitedClient main wraps it in, and that a client without standby siads
+// has none.
+func TestFailoverOf(t *testing.T) {
+	client := NewLimitedClient(NewFailoverClient([]string{"localhost:9980", "localhost:9981"}, "", "Sia-Agent"), 20)
+	nodes, ok := failoverOf(client)
+	if !ok || nodes.activeAddress() != "localhost:9980" {
+		t.Fatal("the FailoverClient should be found behind the LimitedClient")
+	}
+	if _, ok := failoverOf(NewLimitedClient(NewMockClient(0), 20)); ok {
+		t.Fatal("a MockClient can't fail over")
+	}
+}
+
+// failoverTestingBackend is a failoverTestingClient with the node calls of a
+// MockClient, so that it can be wrapped in a LimitedClient.
+type failoverTestingBackend struct {
+	*failoverTestingClient
+	NodeClient
+}
+
+// TestSiafolderFailover verifies that a SiaFolder fails over to the standby
+// siad once the primary stopped answering, and uploads the files the standby
+// is missing to it.
+func TestSiafolderFailover(t *testing.T) {
+	testFailover(t, false)
+}
+
+// TestSiafolderFailoverLimited verifies that a SiaFolder fails over when the
+// failover client is wrapped in a LimitedClient, like main does.
+func TestSiafolderFailoverLimited(t *testing.T) {
+	testFailover(t, true)
+}
+
+// testFailover takes the primary siad of a SiaFolder offline and checks that
+// it fails over to the standby, through a LimitedClient if limited is set.
+func testFailover(t *testing.T, limited bool) {
+	defer func(d time.Duration) {
+		reconnectInterval = d
+	}(reconnectInterval)
+	reconnectInterval = 100 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "old"), []byte("old"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary, standby := newTestingClient(), newTestingClient()
+	client := &failoverTestingClient{clients: []*testingClient{primary, standby}}
+	var sc SiaClient = client
+	if limited {
+		sc = NewLimitedClient(failoverTestingBackend{client, NewMockClient(0)}, 0)
+	}
+	sf, err := newSyncedSiafolder(dir, sc, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	if _, exists := primary.file("old"); !exists {
+		t.Fatal("old should have been uploaded to the primary siad")
+	}
+
+	primary.setOffline(true)
+	err = ioutil.WriteFile(filepath.Join(dir, "new"), []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if client.activeAddress() != "b" {
+		t.Fatal("siasync should have failed over to the standby siad")
+	}
+	for _, name := range []string{"old", "new"} {
+		if _, exists := standby.file(name); !exists {
+			t.Fatalf("%v should have been uploaded to the standby siad", name)
+		}
+	}
+	if _, exists := primary.file("new"); exists {
+		t.Fatal("new should not have been uploaded to the offline primary siad")
+	}
+}
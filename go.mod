@@ -0,0 +1,32 @@
+module github.com/MSevey/siasync
+
+go 1.25.0
+
+require (
+	github.com/fsnotify/fsnotify v1.4.7
+	github.com/sirupsen/logrus v1.10.2
+	gitlab.com/NebulousLabs/Sia v1.4.1
+	golang.org/x/text v0.28.0
+)
+
+require (
+	github.com/coreos/bbolt v1.3.2 // indirect
+	github.com/dchest/threefish v0.0.0-20120919164726-3ecf4c494abf // indirect
+	github.com/inconshreveable/go-update v0.0.0-20160112193335-8152e7eb6ccf // indirect
+	github.com/julienschmidt/httprouter v1.2.0 // indirect
+	github.com/kardianos/osext v0.0.0-20190222173326-2bc1f35cddc0 // indirect
+	github.com/karrick/godirwalk v1.10.12 // indirect
+	github.com/klauspost/cpuid v1.2.1 // indirect
+	github.com/klauspost/reedsolomon v1.9.2 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	gitlab.com/NebulousLabs/entropy-mnemonics v0.0.0-20181018051301-7532f67e3500 // indirect
+	gitlab.com/NebulousLabs/errors v0.0.0-20171229012116-7ead97ef90b8 // indirect
+	gitlab.com/NebulousLabs/fastrand v0.0.0-20181126182046-603482d69e40 // indirect
+	gitlab.com/NebulousLabs/merkletree v0.0.0-20190207030457-bc4a11e31a0d // indirect
+	gitlab.com/NebulousLabs/ratelimit v0.0.0-20180716154200-1308156c2eaf // indirect
+	gitlab.com/NebulousLabs/writeaheadlog v0.0.0-20190703190009-cb822c37bc94 // indirect
+	go.etcd.io/bbolt v1.5.0 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/net v0.43.0 // indirect
+	golang.org/x/sys v0.45.0 // indirect
+)
@@ -0,0 +1,97 @@
+package siasync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/modules"
+)
+
+// HealthChecksBeforeRepair is the number of health checks in a row a file
+// must be below the minimum redundancy before it is repaired, so that files
+// siad is still repairing itself are left alone.
+const HealthChecksBeforeRepair = 3
+
+// checkHealth looks for uploaded files whose redundancy on Sia dropped below
+// minRedundancy, or the one of their category. Files that are still uploading are skipped. With autoRepair,
+// a file that stayed below the minimum for HealthChecksBeforeRepair checks in
+// a row and is unchanged locally is deleted from Sia and uploaded again,
+// unless syncing is paused. It runs on the eventWatcher goroutine.
+func (sf *SiaFolder) checkHealth() {
+	sf.listing.invalidate()
+	renterFiles, err := sf.getSiaFiles()
+	if err != nil && !strings.Contains(err.Error(), errNoFiles.Error()) {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error listing files to check their health")
+		return
+	}
+
+	unhealthy := make(map[string]int)
+	for _, file := range sf.trackedFiles() {
+		fs, _ := sf.trackedFile(file)
+		if !fs.Uploaded {
+			continue
+		}
+		relpath, err := filepath.Rel(sf.path, file)
+		if err != nil {
+			continue
+		}
+		siaPath, err := sf.uploadedSiaPath(relpath, fs)
+		if err != nil {
+			continue
+		}
+		siafile, ok := renterFiles[siaPath]
+		if !ok || siafile.UploadProgress < 100 || siafile.Redundancy >= sf.categorySettingsOf(relpath).minRedundancy {
+			continue
+		}
+
+		checks := sf.unhealthy[file] + 1
+		if checks == 1 {
+			log.WithFields(logrus.Fields{
+				"file":       file,
+				"redundancy": siafile.Redundancy,
+				"health":     siafile.Health,
+			}).Warn("File redundancy dropped below the minimum")
+		}
+		if !sf.autoRepair || checks < HealthChecksBeforeRepair || sf.Paused() {
+			unhealthy[file] = checks
+			continue
+		}
+		stat, err := os.Stat(file)
+		if err != nil || !fs.unchanged(stat) {
+			unhealthy[file] = checks
+			continue
+		}
+
+		log.WithFields(logrus.Fields{
+			"file":       file,
+			"redundancy": siafile.Redundancy,
+		}).Info("Repairing file by uploading it again")
+		err = sf.uploadAgain(file, fs, siafile.SiaPath)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Error deleting file to repair it")
+			unhealthy[file] = checks
+		}
+	}
+	sf.unhealthy = unhealthy
+}
+
+// uploadAgain deletes the remote file at siaPath and queues the tracked file
+// for upload again.
+func (sf *SiaFolder) uploadAgain(file string, fs fileState, siaPath modules.SiaPath) error {
+	err := sf.client.RenterDeletePost(siaPath)
+	sf.audit(AuditRecord{Op: auditDelete, Path: file, SiaPath: siaPath.String(), Size: fs.Size, Checksum: fs.Checksum}, err)
+	sf.listing.invalidate()
+	if err != nil {
+		return err
+	}
+	fs.Uploaded = false
+	sf.trackFile(file, fs)
+	sf.queueUpload(file)
+	return nil
+}
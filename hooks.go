@@ -0,0 +1,66 @@
+package siasync
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxRunningHooks is the maximum number of hook scripts run at the same time.
+const maxRunningHooks = 4
+
+// hookEvents maps the events hook scripts are run for to the name of the
+// hook, which is one of upload, delete or error.
+var hookEvents = map[EventType]string{
+	FileUploaded:     "upload",
+	FileDeleted:      "delete",
+	FileUploadFailed: "error",
+	FileDeleteHeld:   "error",
+}
+
+// runHook runs the hook script configured for the event, if any, in the
+// background. The script gets the event in its environment. A failing script
+// is logged together with its stderr. It consumes the events of the
+// SiaFolder.
+func (sf *SiaFolder) runHook(e Event) {
+	hook := hookEvents[e.Type]
+	script := sf.hooks[hook]
+	if script == "" || e.SiaPath == "" {
+		return
+	}
+	env := append(os.Environ(),
+		"SIASYNC_EVENT="+hook,
+		"SIASYNC_LOCAL_PATH="+e.Path,
+		"SIASYNC_SIAPATH="+e.SiaPath,
+		"SIASYNC_SIZE="+strconv.FormatInt(e.Size, 10),
+	)
+	if e.Err != nil {
+		env = append(env, "SIASYNC_ERROR="+e.Err.Error())
+	}
+
+	sf.hooksRunning.Add(1)
+	go func() {
+		defer sf.hooksRunning.Done()
+		sf.hookSlots <- struct{}{}
+		defer func() { <-sf.hookSlots }()
+
+		var stderr bytes.Buffer
+		cmd := exec.Command(script)
+		cmd.Env = env
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"hook":   script,
+				"event":  hook,
+				"file":   e.Path,
+				"error":  err.Error(),
+				"stderr": strings.TrimSpace(stderr.String()),
+			}).Error("Error running hook")
+		}
+	}()
+}
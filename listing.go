@@ -0,0 +1,47 @@
+package siasync
+
+import (
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/Sia/modules"
+)
+
+// listingMaxAge is how long a listing of the Sia folder is reused before it is
+// fetched from siad again.
+var listingMaxAge = 30 * time.Second
+
+// siaListing caches the files below the prefix of a SiaFolder, so that
+// reconciling, verifying and status requests close to each other share one
+// walk of the Sia folder instead of listing every directory again.
+type siaListing struct {
+	mu      sync.Mutex
+	files   map[modules.SiaPath]modules.FileInfo
+	fetched time.Time
+}
+
+// get returns the cached files if they are younger than listingMaxAge, and
+// otherwise lists them again with fetch. A failed fetch is not cached. The
+// returned map is shared and must not be modified.
+func (l *siaListing) get(fetch func() (map[modules.SiaPath]modules.FileInfo, error)) (map[modules.SiaPath]modules.FileInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.files != nil && time.Since(l.fetched) < listingMaxAge {
+		return l.files, nil
+	}
+	files, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	l.files = files
+	l.fetched = time.Now()
+	return files, nil
+}
+
+// invalidate drops the cached files, the next get lists them again. It is
+// called after every change siasync makes to the Sia folder.
+func (l *siaListing) invalidate() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files = nil
+}
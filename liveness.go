@@ -0,0 +1,194 @@
+package siasync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/node/api"
+)
+
+// apiProbeInterval is how long siad may go without a successful API call
+// before eventWatcher probes it, so that an idle SiaFolder still knows
+// whether siad answers.
+const apiProbeInterval = time.Minute
+
+// apiTrackingClient is a SiaClient that records the time of every successful
+// API call in its SiaFolder. Every call is given up once apiTimeout passed or
+// Close gave up on the calls in progress, siad may hang without closing the
+// connection. The Sia client can't cancel a call, a call given up on still
+// runs in the background until siad answers.
+type apiTrackingClient struct {
+	SiaClient
+	sf *SiaFolder
+}
+
+// apiResult is the result of an API call made by call.
+type apiResult struct {
+	value interface{}
+	err   error
+}
+
+// call makes an API call, giving up once timeout passed, or never if it is 0,
+// and records its result.
+func (c *apiTrackingClient) call(timeout time.Duration, f func() (interface{}, error)) (interface{}, error) {
+	ctx := c.sf.apiCtx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	done := make(chan apiResult, 1)
+	go func() {
+		value, err := f()
+		done <- apiResult{value: value, err: err}
+	}()
+	var r apiResult
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		r.err = fmt.Errorf("giving up on siad: %v", ctx.Err())
+	}
+	c.sf.apiCalled(r.err)
+	return r.value, r.err
+}
+
+func (c *apiTrackingClient) DaemonVersionGet() (api.DaemonVersionGet, error) {
+	v, err := c.call(c.sf.apiTimeout, func() (interface{}, error) {
+		return c.SiaClient.DaemonVersionGet()
+	})
+	dvg, _ := v.(api.DaemonVersionGet)
+	return dvg, err
+}
+
+func (c *apiTrackingClient) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	_, err := c.call(c.sf.apiTimeout, func() (interface{}, error) {
+		return nil, c.SiaClient.RenterUploadPost(path, siaPath, dataPieces, parityPieces)
+	})
+	return classifyUploadError(err)
+}
+
+// classifyUploadError wraps an error siad returned for an upload in the
+// sentinel error it stands for, siad only returns the message. This is the
+// one place the messages are matched.
+func classifyUploadError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "not enough contracts to upload file") {
+		return fmt.Errorf("%w: %v", errNotEnoughContracts, err)
+	}
+	return err
+}
+
+func (c *apiTrackingClient) RenterDeletePost(siaPath modules.SiaPath) error {
+	_, err := c.call(c.sf.apiTimeout, func() (interface{}, error) {
+		return nil, c.SiaClient.RenterDeletePost(siaPath)
+	})
+	return err
+}
+
+func (c *apiTrackingClient) RenterFileGet(siaPath modules.SiaPath) (api.RenterFile, error) {
+	v, err := c.call(c.sf.apiTimeout, func() (interface{}, error) {
+		return c.SiaClient.RenterFileGet(siaPath)
+	})
+	rf, _ := v.(api.RenterFile)
+	return rf, err
+}
+
+func (c *apiTrackingClient) RenterGetDir(siaPath modules.SiaPath) (api.RenterDirectory, error) {
+	v, err := c.call(c.sf.apiTimeout, func() (interface{}, error) {
+		return c.SiaClient.RenterGetDir(siaPath)
+	})
+	rd, _ := v.(api.RenterDirectory)
+	return rd, err
+}
+
+func (c *apiTrackingClient) RenterRenamePost(siaPathOld, siaPathNew modules.SiaPath) error {
+	_, err := c.call(c.sf.apiTimeout, func() (interface{}, error) {
+		return nil, c.SiaClient.RenterRenamePost(siaPathOld, siaPathNew)
+	})
+	return err
+}
+
+func (c *apiTrackingClient) RenterDirCreatePost(siaPath modules.SiaPath) error {
+	_, err := c.call(c.sf.apiTimeout, func() (interface{}, error) {
+		return nil, c.SiaClient.RenterDirCreatePost(siaPath)
+	})
+	return err
+}
+
+func (c *apiTrackingClient) RenterDirDeletePost(siaPath modules.SiaPath) error {
+	_, err := c.call(c.sf.apiTimeout, func() (interface{}, error) {
+		return nil, c.SiaClient.RenterDirDeletePost(siaPath)
+	})
+	return err
+}
+
+// RenterDownloadFullGet takes as long as the download, so it has no timeout.
+func (c *apiTrackingClient) RenterDownloadFullGet(siaPath modules.SiaPath, destination string, async bool) error {
+	_, err := c.call(0, func() (interface{}, error) {
+		return nil, c.SiaClient.RenterDownloadFullGet(siaPath, destination, async)
+	})
+	return err
+}
+
+// apiCalled records the result of a siad API call.
+func (sf *SiaFolder) apiCalled(err error) {
+	if err != nil {
+		return
+	}
+	sf.mu.Lock()
+	sf.lastAPISuccess = time.Now()
+	sf.mu.Unlock()
+}
+
+// probeSiad calls siad if no API call succeeded for apiProbeInterval. It runs
+// on the eventWatcher goroutine.
+func (sf *SiaFolder) probeSiad() {
+	sf.mu.Lock()
+	idle := time.Since(sf.lastAPISuccess) >= apiProbeInterval
+	sf.mu.Unlock()
+	if idle {
+		sf.siadReachable()
+	}
+}
+
+// heartbeat records that eventWatcher is running.
+func (sf *SiaFolder) heartbeat() {
+	sf.mu.Lock()
+	sf.lastHeartbeat = time.Now()
+	sf.mu.Unlock()
+}
+
+// Healthy returns an error unless eventWatcher ran and a siad API call
+// succeeded within timeout. eventWatcher runs from the start, alongside the
+// initial sync. A closed SiaFolder isn't healthy.
+func (sf *SiaFolder) Healthy(timeout time.Duration) error {
+	sf.mu.Lock()
+	lastHeartbeat, lastAPISuccess := sf.lastHeartbeat, sf.lastAPISuccess
+	sf.mu.Unlock()
+	select {
+	case <-sf.ctx.Done():
+		return fmt.Errorf("%v is closed", sf.path)
+	default:
+	}
+	if since := time.Since(lastHeartbeat); since > timeout {
+		return fmt.Errorf("the event watcher of %v hasn't run for %v", sf.path, since.Round(time.Second))
+	}
+	if lastAPISuccess.IsZero() {
+		return fmt.Errorf("no siad API call of %v succeeded", sf.path)
+	}
+	if since := time.Since(lastAPISuccess); since > timeout {
+		return fmt.Errorf("no siad API call of %v succeeded for %v", sf.path, since.Round(time.Second))
+	}
+	return nil
+}
+
+// Ready reports whether the initial sync of the SiaFolder is done. It watches
+// for changes before that.
+func (sf *SiaFolder) Ready() bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.ready
+}
@@ -0,0 +1,153 @@
+package siasync
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSiafolderHealthy verifies that a SiaFolder is healthy while siad
+// answers, and unhealthy once no API call succeeded for the timeout or it is
+// closed.
+func TestSiafolderHealthy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sf.Ready() {
+		t.Fatal("the SiaFolder should be ready after the initial sync")
+	}
+	if err := sf.Healthy(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// siad goes away and the last successful call is long ago
+	mockClient.setOffline(true)
+	sf.mu.Lock()
+	sf.lastAPISuccess = time.Now().Add(-2 * apiProbeInterval)
+	sf.mu.Unlock()
+	sf.probeSiad()
+	if err := sf.Healthy(time.Minute); err == nil {
+		t.Fatal("the SiaFolder should be unhealthy while siad doesn't answer")
+	}
+	mockClient.setOffline(false)
+	sf.probeSiad()
+	if err := sf.Healthy(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// the event watcher stopped running
+	sf.mu.Lock()
+	sf.lastHeartbeat = time.Now().Add(-2 * time.Minute)
+	sf.mu.Unlock()
+	if err := sf.Healthy(time.Minute); err == nil {
+		t.Fatal("the SiaFolder should be unhealthy while the event watcher doesn't run")
+	}
+	time.Sleep(time.Second + 100*time.Millisecond)
+	if err := sf.Healthy(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	err = sf.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sf.Healthy(time.Minute); err == nil {
+		t.Fatal("a closed SiaFolder should be unhealthy")
+	}
+}
+
+// TestServeHealth verifies that /readyz only succeeds once every folder was
+// created, and that /healthz reports the health of the folders.
+func TestServeHealth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// find a free port for the server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	folders := NewFolderList(1)
+	server, err := ServeStatus(addr, folders, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	get := func(path string) int {
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected /readyz to fail during the initial sync, got %v", code)
+	}
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Fatalf("expected /healthz to succeed during the initial sync, got %v", code)
+	}
+
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	folders.Add(sf)
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Fatalf("expected /readyz to succeed after the initial sync, got %v", code)
+	}
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Fatalf("expected /healthz to succeed, got %v", code)
+	}
+
+	mockClient.setOffline(true)
+	sf.mu.Lock()
+	sf.lastAPISuccess = time.Now().Add(-2 * time.Minute)
+	sf.mu.Unlock()
+	if code := get("/healthz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected /healthz to fail while siad doesn't answer, got %v", code)
+	}
+}
+
+// TestClassifyUploadError verifies that only the error siad returns for an
+// upload without enough contracts is classified as errNotEnoughContracts.
+func TestClassifyUploadError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("not enough contracts to upload file: got 3, needed 25"), true},
+		{errors.New("error listing contracts"), false},
+		{errors.New("API authentication failed"), false},
+	}
+	for _, test := range tests {
+		err := classifyUploadError(test.err)
+		if got := errors.Is(err, errNotEnoughContracts); got != test.want {
+			t.Errorf("classifyUploadError(%v) is errNotEnoughContracts: got %v, want %v", test.err, got, test.want)
+		}
+		if test.err != nil && !strings.Contains(err.Error(), test.err.Error()) {
+			t.Errorf("classifyUploadError(%v) lost the message: %v", test.err, err)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package siasync
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// log is the logger siasync logs to.
+var log = logrus.New()
+
+// SetLogger makes siasync log to l. It must be called before the first
+// SiaFolder is created.
+func SetLogger(l *logrus.Logger) {
+	log = l
+}
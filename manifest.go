@@ -0,0 +1,207 @@
+package siasync
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// manifestName is the name of the manifest in the Sia folder, and of its
+// local copy next to the state file.
+const manifestName = ".siasync-manifest.json"
+
+// manifestInterval is how often the manifest is uploaded again while the
+// directory is watched, if any file changed.
+var manifestInterval = 5 * time.Minute
+
+// manifestEntry describes an uploaded file in the manifest. SHA256 is only
+// known in sha256 change detection mode, Uploaded is zero if the file was
+// already on Sia before it was tracked. DuplicateOf is the path of the file in
+// the manifest whose upload has the content of a file that wasn't uploaded
+// itself. In archive mode Version is the version of the file's current
+// content and Versions are its earlier versions still on Sia, oldest first.
+// Metadata is only recorded with -preserve-metadata, and is missing for files
+// uploaded without it.
+type manifestEntry struct {
+	Size        int64             `json:"size"`
+	SHA256      string            `json:"sha256,omitempty"`
+	Uploaded    time.Time         `json:"uploaded"`
+	DuplicateOf string            `json:"duplicateof,omitempty"`
+	Version     int               `json:"version,omitempty"`
+	Versions    []manifestVersion `json:"versions,omitempty"`
+	Metadata    *fileMetadata     `json:"metadata,omitempty"`
+}
+
+// manifest lists the files uploaded by siasync, keyed by their slash separated
+// path relative to the synced directory. It is kept on Sia next to the files
+// so that restore and verify can check file contents and not just sizes.
+type manifest struct {
+	Files map[string]manifestEntry `json:"files"`
+}
+
+// buildManifest returns the manifest of every uploaded file. Files of the
+// previous manifest that aren't tracked anymore but are still on Sia, like
+// files removed locally in archive mode, are kept.
+func (sf *SiaFolder) buildManifest() (manifest, error) {
+	renterFiles, err := sf.getSiaFiles()
+	if err != nil && !strings.Contains(err.Error(), errNoFiles.Error()) {
+		return manifest{}, err
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	m := manifest{Files: make(map[string]manifestEntry)}
+	if sf.previousManifest != nil {
+		for relpath, entry := range sf.previousManifest.Files {
+			// a duplicate is kept as long as its original is on Sia
+			uploaded, version := relpath, entry.Version
+			if entry.DuplicateOf != "" {
+				uploaded, version = entry.DuplicateOf, sf.previousManifest.Files[entry.DuplicateOf].Version
+			}
+			siaPath, err := sf.uploadedSiaPath(filepath.FromSlash(uploaded), fileState{Version: version})
+			if err != nil {
+				continue
+			}
+			if _, ok := renterFiles[siaPath]; ok {
+				m.Files[relpath] = entry
+			}
+		}
+	}
+	for key, fs := range sf.state {
+		if !fs.Uploaded {
+			continue
+		}
+		entry := manifestEntry{
+			Size:        fs.Size,
+			Uploaded:    fs.UploadTime,
+			DuplicateOf: fs.DuplicateOf,
+			Version:     fs.Version,
+			Versions:    sf.manifestVersionsOf(fs),
+			Metadata:    sf.manifestMetadata(key, fs),
+		}
+		if sf.changeDetection == "sha256" {
+			entry.SHA256 = fs.Checksum
+		}
+		m.Files[key] = entry
+	}
+	return m, nil
+}
+
+// uploadManifest uploads the manifest to Sia if it changed since it was last
+// uploaded. The manifest is uploaded under a temporary name first and then
+// renamed, so a crash never leaves a partial manifest behind.
+func (sf *SiaFolder) uploadManifest() error {
+	m, err := sf.buildManifest()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, sf.lastManifest) {
+		return nil
+	}
+
+	// siad uploads from the local file, so the local copy is kept
+	tmpFile := sf.manifestFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile, sf.manifestFile)
+	if err != nil {
+		return err
+	}
+
+	siaPath, err := sf.rootSiaPath(manifestName)
+	if err != nil {
+		return err
+	}
+	tmpSiaPath, err := sf.rootSiaPath(manifestName + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = sf.client.RenterDeletePost(tmpSiaPath)
+	sf.audit(AuditRecord{Op: auditDelete, SiaPath: tmpSiaPath.String()}, err)
+	err = sf.client.RenterUploadPost(sf.manifestFile, tmpSiaPath, sf.dataPieces, sf.parityPieces)
+	sf.audit(AuditRecord{Op: auditUpload, Path: sf.manifestFile, SiaPath: tmpSiaPath.String(), Size: int64(len(data))}, err)
+	if err != nil {
+		return err
+	}
+	err = sf.client.RenterDeletePost(siaPath)
+	sf.audit(AuditRecord{Op: auditDelete, SiaPath: siaPath.String()}, err)
+	if err != nil && !strings.Contains(err.Error(), "no file known") {
+		return err
+	}
+	err = sf.client.RenterRenamePost(tmpSiaPath, siaPath)
+	sf.audit(AuditRecord{Op: auditRename, SiaPath: tmpSiaPath.String(), To: siaPath.String()}, err)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		"siapath": siaPath.String(),
+		"files":   len(m.Files),
+	}).Debug("Uploaded manifest")
+	sf.lastManifest = data
+	sf.previousManifest = &m
+	return nil
+}
+
+// uploadManifestLogged uploads the manifest if it is enabled, logging any
+// error.
+func (sf *SiaFolder) uploadManifestLogged() {
+	if sf.manifestFile == "" || sf.dryRun || sf.Paused() {
+		return
+	}
+	err := sf.uploadManifest()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error uploading manifest")
+	}
+}
+
+// downloadManifest downloads the manifest from Sia. It returns nil if there is
+// no manifest, falling back to the temporary manifest left by an interrupted
+// upload.
+func (sf *SiaFolder) downloadManifest() (*manifest, error) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{manifestName, manifestName + ".tmp"} {
+		siaPath, err := sf.rootSiaPath(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := sf.client.RenterFileGet(siaPath); err != nil {
+			continue
+		}
+		file := filepath.Join(dir, name)
+		err = sf.client.RenterDownloadFullGet(siaPath, file, false)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var m manifest
+		err = json.Unmarshal(data, &m)
+		if err != nil {
+			return nil, err
+		}
+		return &m, nil
+	}
+	return nil, nil
+}
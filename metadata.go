@@ -0,0 +1,110 @@
+package siasync
+
+import (
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fileOwner is the numeric owner and group of a file.
+type fileOwner struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// fileMetadata is what Sia doesn't keep of an uploaded file, recorded in the
+// manifest with -preserve-metadata so that restore can apply it again. Owner
+// is nil on systems without numeric owners.
+type fileMetadata struct {
+	ModTime time.Time   `json:"modtime"`
+	Mode    os.FileMode `json:"mode"`
+	Owner   *fileOwner  `json:"owner,omitempty"`
+}
+
+// setMetadata records the permissions and owner of the file described by
+// stat in fs, if metadata is preserved.
+func (sf *SiaFolder) setMetadata(fs *fileState, stat os.FileInfo) {
+	if !sf.preserveMetadata {
+		return
+	}
+	fs.Mode = stat.Mode().Perm()
+	fs.Owner = ownerOf(stat)
+}
+
+// sameMetadata reports whether fs and other record the same modification
+// time, permissions and owner.
+func (fs fileState) sameMetadata(other fileState) bool {
+	if !fs.ModTime.Equal(other.ModTime) || fs.Mode != other.Mode {
+		return false
+	}
+	if fs.Owner == nil || other.Owner == nil {
+		return fs.Owner == other.Owner
+	}
+	return *fs.Owner == *other.Owner
+}
+
+// updateMetadata records the modification time, permissions and owner in md
+// as the metadata of a tracked file whose content didn't change, so that the
+// manifest picks it up.
+func (sf *SiaFolder) updateMetadata(file string, md fileState) {
+	old, tracked := sf.trackedFile(file)
+	if !tracked || !sf.preserveMetadata || old.sameMetadata(md) {
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"file": file,
+	}).Debug("Metadata of file changed")
+	fs := old
+	fs.ModTime, fs.Mode, fs.Owner = md.ModTime, md.Mode, md.Owner
+	sf.trackFile(file, fs)
+}
+
+// manifestMetadata returns the metadata of a tracked file for the manifest,
+// nil unless metadata is preserved. Files tracked before metadata was
+// preserved, which have no permissions recorded, are stat'ed once if they
+// are unchanged. It is called with sf.mu held.
+func (sf *SiaFolder) manifestMetadata(key string, fs fileState) *fileMetadata {
+	if !sf.preserveMetadata {
+		return nil
+	}
+	if fs.Mode == 0 {
+		stat, err := os.Stat(sf.keyPath(key))
+		if err != nil || !fs.unchanged(stat) {
+			return nil
+		}
+		sf.setMetadata(&fs, stat)
+		sf.state[key] = fs
+		sf.stateDirty = true
+	}
+	return &fileMetadata{ModTime: fs.ModTime, Mode: fs.Mode, Owner: fs.Owner}
+}
+
+// applyMetadata gives a restored file the modification time, permissions
+// and owner recorded in the manifest. Files uploaded without metadata are
+// left alone. Only root may change the owner of a file, failing to do so is
+// logged.
+func applyMetadata(file string, md *fileMetadata) error {
+	if md == nil {
+		return nil
+	}
+	if md.Owner != nil {
+		err := os.Lchown(file, md.Owner.UID, md.Owner.GID)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"file":  file,
+				"error": err.Error(),
+			}).Debug("Could not restore the owner of file")
+		}
+	}
+	if md.Mode != 0 {
+		err := os.Chmod(file, md.Mode)
+		if err != nil {
+			return err
+		}
+	}
+	if !md.ModTime.IsZero() {
+		return os.Chtimes(file, md.ModTime, md.ModTime)
+	}
+	return nil
+}
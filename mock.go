@@ -0,0 +1,427 @@
+package siasync
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/modules/renter/siafile"
+	"gitlab.com/NebulousLabs/Sia/node/api"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// mockContracts is the number of active contracts the MockClient reports, so
+// that preflight passes for any sensible erasure coding.
+const mockContracts = 50
+
+// mockAllowance is the allowance in siacoins the MockClient reports. Every GiB
+// uploaded to it costs a siacoin.
+const mockAllowance = 500
+
+var (
+	errMockOffline = errors.New("connection refused")
+	errMockNoFile  = errors.New("no file known with that path")
+)
+
+// SiaBackend is the Sia node siasync syncs to, siad or a MockClient.
+type SiaBackend interface {
+	SiaClient
+	NodeClient
+}
+
+// mockFile is a file uploaded to a MockClient.
+type mockFile struct {
+	data     []byte
+	uploaded time.Time
+
+	// redundancy is the redundancy the file reaches once fully uploaded
+	redundancy float64
+}
+
+// MockClient is an in-memory Sia node, used by -backend mock and the tests to
+// run siasync without siad. It records every upload, deletion and rename, and
+// keeps the content of the uploaded files in memory so that they can be
+// restored, which makes it a fit for scratch directories only. Uploaded files
+// gain redundancy at RedundancyRate per second until they reach the redundancy
+// of their erasure coding, 0 makes them fully redundant right away. The node
+// always reports being synced, with an unlocked wallet, an allowance and
+// enough contracts, and spends a siacoin of the allowance per uploaded GiB.
+type MockClient struct {
+	mu       sync.Mutex
+	files    map[string]*mockFile
+	dirs     map[string]time.Time
+	ops      []string
+	offline  bool
+	uploaded uint64 // uploaded is the number of bytes uploaded so far
+
+	// RedundancyRate is the redundancy uploaded files gain per second.
+	RedundancyRate float64
+}
+
+// NewMockClient returns an empty MockClient whose files gain redundancyRate
+// redundancy per second.
+func NewMockClient(redundancyRate float64) *MockClient {
+	return &MockClient{
+		files:          make(map[string]*mockFile),
+		dirs:           make(map[string]time.Time),
+		RedundancyRate: redundancyRate,
+	}
+}
+
+// Operations returns the changes made so far, like "upload siasync/file", in
+// order.
+func (m *MockClient) Operations() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.ops...)
+}
+
+// Files returns the siapaths of the files on the MockClient, sorted.
+func (m *MockClient) Files() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var files []string
+	for path := range m.files {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Contents returns the content of the file at siaPath and whether it exists.
+func (m *MockClient) Contents(siaPath modules.SiaPath) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	file, exists := m.files[siaPath.String()]
+	if !exists {
+		return nil, false
+	}
+	return file.data, true
+}
+
+// SetOffline makes every call fail as if siad was down, or answer again.
+func (m *MockClient) SetOffline(offline bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.offline = offline
+}
+
+// record appends an operation and logs it. The caller must hold m.mu.
+func (m *MockClient) record(op string, siaPaths ...modules.SiaPath) {
+	var paths []string
+	for _, siaPath := range siaPaths {
+		paths = append(paths, siaPath.String())
+	}
+	m.ops = append(m.ops, op+" "+strings.Join(paths, " "))
+	log.WithFields(logrus.Fields{
+		"op":      op,
+		"siapath": strings.Join(paths, " -> "),
+	}).Debug("Mock Sia node changed")
+}
+
+// fileInfo returns the FileInfo of a file, with the redundancy it reached so
+// far. The caller must hold m.mu.
+func (m *MockClient) fileInfo(siaPath modules.SiaPath, file *mockFile) modules.FileInfo {
+	redundancy := file.redundancy
+	if m.RedundancyRate > 0 {
+		redundancy = math.Min(redundancy, m.RedundancyRate*time.Since(file.uploaded).Seconds())
+	}
+	// siad reports a health of 0 for a fully redundant file and 1 for one at
+	// the minimum redundancy
+	health := 1 - (redundancy-1)/(file.redundancy-1)
+	return modules.FileInfo{
+		SiaPath:        siaPath,
+		Filesize:       uint64(len(file.data)),
+		CreateTime:     file.uploaded,
+		ModTime:        file.uploaded,
+		Available:      redundancy >= 1,
+		Recoverable:    redundancy >= 1,
+		Redundancy:     redundancy,
+		Health:         health,
+		MaxHealth:      health,
+		UploadProgress: 100 * redundancy / file.redundancy,
+		UploadedBytes:  uint64(float64(len(file.data)) * redundancy),
+	}
+}
+
+// dirExists reports whether the directory exists, created explicitly or by
+// the files inside. The caller must hold m.mu.
+func (m *MockClient) dirExists(path string) bool {
+	if _, exists := m.dirs[path]; exists {
+		return true
+	}
+	for dir := range m.dirs {
+		if strings.HasPrefix(dir, path+"/") {
+			return true
+		}
+	}
+	for file := range m.files {
+		if strings.HasPrefix(file, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MockClient) DaemonVersionGet() (api.DaemonVersionGet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.offline {
+		return api.DaemonVersionGet{}, errMockOffline
+	}
+	return api.DaemonVersionGet{Version: "mock"}, nil
+}
+
+func (m *MockClient) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.offline {
+		return errMockOffline
+	}
+	if _, exists := m.files[siaPath.String()]; exists {
+		return siafile.ErrPathOverload
+	}
+	// like siad, use the default erasure coding if none is given
+	if dataPieces == 0 && parityPieces == 0 {
+		dataPieces, parityPieces = 10, 20
+	}
+	m.files[siaPath.String()] = &mockFile{
+		data:       data,
+		uploaded:   time.Now(),
+		redundancy: float64(dataPieces+parityPieces) / float64(dataPieces),
+	}
+	m.uploaded += uint64(len(data))
+	m.record("upload", siaPath)
+	return nil
+}
+
+func (m *MockClient) RenterDeletePost(siaPath modules.SiaPath) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.offline {
+		return errMockOffline
+	}
+	if _, exists := m.files[siaPath.String()]; !exists {
+		return errMockNoFile
+	}
+	delete(m.files, siaPath.String())
+	m.record("delete", siaPath)
+	return nil
+}
+
+func (m *MockClient) RenterFileGet(siaPath modules.SiaPath) (api.RenterFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.offline {
+		return api.RenterFile{}, errMockOffline
+	}
+	file, exists := m.files[siaPath.String()]
+	if !exists {
+		return api.RenterFile{}, errMockNoFile
+	}
+	return api.RenterFile{File: m.fileInfo(siaPath, file)}, nil
+}
+
+func (m *MockClient) RenterGetDir(siaPath modules.SiaPath) (api.RenterDirectory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.offline {
+		return api.RenterDirectory{}, errMockOffline
+	}
+	if !siaPath.IsRoot() && !m.dirExists(siaPath.String()) {
+		return api.RenterDirectory{}, errNoFiles
+	}
+
+	// siad lists the requested directory itself first
+	info, subdirs := m.dirInfo(siaPath)
+	rd := api.RenterDirectory{Directories: []modules.DirectoryInfo{info}}
+	for _, subdir := range subdirs {
+		subdirSiaPath, err := modules.NewSiaPath(subdir)
+		if err != nil {
+			return api.RenterDirectory{}, err
+		}
+		info, _ := m.dirInfo(subdirSiaPath)
+		rd.Directories = append(rd.Directories, info)
+	}
+	prefix := dirPrefix(siaPath)
+	for path, file := range m.files {
+		if !strings.HasPrefix(path, prefix) || strings.Contains(strings.TrimPrefix(path, prefix), "/") {
+			continue
+		}
+		fileSiaPath, err := modules.NewSiaPath(path)
+		if err != nil {
+			return api.RenterDirectory{}, err
+		}
+		rd.Files = append(rd.Files, m.fileInfo(fileSiaPath, file))
+	}
+	return rd, nil
+}
+
+// dirPrefix returns the prefix of the siapaths inside a directory.
+func dirPrefix(siaPath modules.SiaPath) string {
+	if siaPath.IsRoot() {
+		return ""
+	}
+	return siaPath.String() + "/"
+}
+
+// dirInfo returns the number of files and subdirectories directly inside a
+// directory and its creation time as its modification time, and the siapaths
+// of the subdirectories. The caller must hold m.mu.
+func (m *MockClient) dirInfo(siaPath modules.SiaPath) (modules.DirectoryInfo, []string) {
+	info := modules.DirectoryInfo{
+		SiaPath:           siaPath,
+		MostRecentModTime: m.dirs[siaPath.String()],
+	}
+	prefix := dirPrefix(siaPath)
+	subdirs := make(map[string]bool)
+	for dir := range m.dirs {
+		if strings.HasPrefix(dir, prefix) && dir != siaPath.String() {
+			subdirs[prefix+strings.SplitN(strings.TrimPrefix(dir, prefix), "/", 2)[0]] = true
+		}
+	}
+	for path := range m.files {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(path, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			subdirs[prefix+rest[:i]] = true
+			continue
+		}
+		info.NumFiles++
+	}
+	var paths []string
+	for subdir := range subdirs {
+		paths = append(paths, subdir)
+	}
+	sort.Strings(paths)
+	info.NumSubDirs = uint64(len(paths))
+	return info, paths
+}
+
+func (m *MockClient) RenterRenamePost(siaPathOld, siaPathNew modules.SiaPath) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.offline {
+		return errMockOffline
+	}
+	file, exists := m.files[siaPathOld.String()]
+	if !exists {
+		return errMockNoFile
+	}
+	if _, exists := m.files[siaPathNew.String()]; exists {
+		return siafile.ErrPathOverload
+	}
+	delete(m.files, siaPathOld.String())
+	m.files[siaPathNew.String()] = file
+	m.record("rename", siaPathOld, siaPathNew)
+	return nil
+}
+
+func (m *MockClient) RenterDirCreatePost(siaPath modules.SiaPath) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.offline {
+		return errMockOffline
+	}
+	if m.dirExists(siaPath.String()) {
+		return errDirExists
+	}
+	m.dirs[siaPath.String()] = time.Now()
+	m.record("createdir", siaPath)
+	return nil
+}
+
+func (m *MockClient) RenterDirDeletePost(siaPath modules.SiaPath) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.offline {
+		return errMockOffline
+	}
+	path := siaPath.String()
+	if !m.dirExists(path) {
+		return errNoFiles
+	}
+	// siad deletes directories recursively
+	delete(m.dirs, path)
+	for dir := range m.dirs {
+		if strings.HasPrefix(dir, path+"/") {
+			delete(m.dirs, dir)
+		}
+	}
+	for file := range m.files {
+		if strings.HasPrefix(file, path+"/") {
+			delete(m.files, file)
+		}
+	}
+	m.record("deletedir", siaPath)
+	return nil
+}
+
+func (m *MockClient) RenterDownloadFullGet(siaPath modules.SiaPath, destination string, async bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.offline {
+		return errMockOffline
+	}
+	file, exists := m.files[siaPath.String()]
+	if !exists {
+		return errMockNoFile
+	}
+	return ioutil.WriteFile(destination, file.data, 0644)
+}
+
+func (m *MockClient) ConsensusGet() (api.ConsensusGET, error) {
+	if _, err := m.DaemonVersionGet(); err != nil {
+		return api.ConsensusGET{}, err
+	}
+	return api.ConsensusGET{Synced: true}, nil
+}
+
+func (m *MockClient) WalletGet() (api.WalletGET, error) {
+	if _, err := m.DaemonVersionGet(); err != nil {
+		return api.WalletGET{}, err
+	}
+	return api.WalletGET{Encrypted: true, Unlocked: true}, nil
+}
+
+func (m *MockClient) RenterGet() (api.RenterGET, error) {
+	if _, err := m.DaemonVersionGet(); err != nil {
+		return api.RenterGET{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	funds := types.SiacoinPrecision.Mul64(mockAllowance)
+	spent := types.SiacoinPrecision.Mul64(m.uploaded).Div64(1 << 30)
+	if spent.Cmp(funds) > 0 {
+		spent = funds
+	}
+	return api.RenterGET{
+		Settings: modules.RenterSettings{
+			Allowance: modules.Allowance{Funds: funds},
+		},
+		FinancialMetrics: modules.ContractorSpending{
+			UploadSpending: spent,
+			TotalAllocated: funds,
+			Unspent:        funds.Sub(spent),
+		},
+	}, nil
+}
+
+func (m *MockClient) RenterDisabledContractsGet() (api.RenterContracts, error) {
+	if _, err := m.DaemonVersionGet(); err != nil {
+		return api.RenterContracts{}, err
+	}
+	return api.RenterContracts{ActiveContracts: make([]api.RenterContract, mockContracts)}, nil
+}
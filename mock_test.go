@@ -0,0 +1,123 @@
+package siasync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestMockClientRedundancy verifies that files uploaded to a MockClient gain
+// redundancy over time until they reach the one of their erasure coding.
+func TestMockClientRedundancy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(path, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewMockClient(10)
+	err = client.RenterUploadPost(path, testSiaPath("file"), 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf, err := client.RenterFileGet(testSiaPath("file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rf.File.Redundancy >= 3 || rf.File.UploadProgress >= 100 {
+		t.Fatalf("a new file should still be uploading, got %v redundancy", rf.File.Redundancy)
+	}
+	time.Sleep(400 * time.Millisecond)
+	rf, err = client.RenterFileGet(testSiaPath("file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rf.File.Redundancy != 3 || rf.File.UploadProgress != 100 || rf.File.Health != 0 || !rf.File.Available {
+		t.Fatalf("the file should have reached 3x redundancy, got %+v", rf.File)
+	}
+
+	client = NewMockClient(0)
+	err = client.RenterUploadPost(path, testSiaPath("file"), 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf, err = client.RenterFileGet(testSiaPath("file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rf.File.Redundancy != 3 {
+		t.Fatalf("a rate of 0 should make files fully redundant right away, got %v", rf.File.Redundancy)
+	}
+
+	problems, err := Preflight(client, 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("the mock node should be ready for uploads, got %v", problems)
+	}
+}
+
+// TestSiafolderMockClient verifies that a SiaFolder syncs uploads, renames and
+// deletions to a MockClient.
+func TestSiafolderMockClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "old"), []byte("old"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewMockClient(0)
+	sf, err := newSyncedSiafolder(dir, client, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	err = ioutil.WriteFile(filepath.Join(dir, "new"), []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(filepath.Join(dir, "old"), filepath.Join(dir, "renamed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	err = os.Remove(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	expected := []string{
+		"upload " + testSiaPath("old").String(),
+		"upload " + testSiaPath("new").String(),
+		"rename " + testSiaPath("old").String() + " " + testSiaPath("renamed").String(),
+		"delete " + testSiaPath("new").String(),
+	}
+	ops := client.Operations()
+	if len(ops) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, ops)
+	}
+	// the upload of new and the rename of old race
+	if ops[0] != expected[0] || ops[3] != expected[3] {
+		t.Fatalf("expected %v, got %v", expected, ops)
+	}
+	if !reflect.DeepEqual(client.Files(), []string{testSiaPath("renamed").String()}) {
+		t.Fatalf("only renamed should be left, got %v", client.Files())
+	}
+	if data, exists := client.Contents(testSiaPath("renamed")); !exists || string(data) != "old" {
+		t.Fatal("renamed should have the content of old")
+	}
+}
@@ -0,0 +1,125 @@
+package siasync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gitlab.com/NebulousLabs/Sia/modules"
+)
+
+// maxNameLength is the longest file or directory name in bytes that siasync
+// uploads to Sia, which is the limit of most filesystems Sia files end up on
+// when they are restored.
+const maxNameLength = 255
+
+// newSiaPath returns the SiaPath of a slash separated path, or an error if it
+// has a name Sia doesn't accept. The empty path is the root of Sia.
+func newSiaPath(path string) (modules.SiaPath, error) {
+	if path == "" {
+		return modules.RootSiaPath(), nil
+	}
+	err := checkSiaPath(path)
+	if err != nil {
+		return modules.SiaPath{}, err
+	}
+	return modules.NewSiaPath(path)
+}
+
+// checkSiaPath returns an error if a name in the slash separated path can't
+// be synced to Sia.
+func checkSiaPath(path string) error {
+	for _, name := range strings.Split(path, "/") {
+		switch {
+		case name == "" || name == "." || name == "..":
+			return fmt.Errorf("invalid name %q in %q", name, path)
+		case !utf8.ValidString(name):
+			return fmt.Errorf("name %q is not valid UTF-8", name)
+		case strings.TrimSpace(name) != name:
+			return fmt.Errorf("name %q starts or ends with a space", name)
+		case len(name) > maxNameLength:
+			return fmt.Errorf("name %q is longer than %v bytes", name, maxNameLength)
+		case strings.IndexFunc(name, unicode.IsControl) >= 0:
+			return fmt.Errorf("name %q contains a control character", name)
+		}
+	}
+	return nil
+}
+
+// sanitizeSiaPath makes every name in the slash separated path acceptable to
+// Sia with sanitizeName.
+func sanitizeSiaPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	names := strings.Split(path, "/")
+	for i, name := range names {
+		names[i] = sanitizeName(name)
+	}
+	return strings.Join(names, "/")
+}
+
+// sanitizeName maps a name Sia doesn't accept to one it does. Invalid UTF-8,
+// control characters and leading or trailing spaces are replaced with
+// underscores, and names that are too long are shortened to end in a hash of
+// the whole name. The same name always maps to the same result, and a
+// sanitized name stays the same when sanitized again.
+func sanitizeName(name string) string {
+	if checkSiaPath(name) == nil {
+		return name
+	}
+
+	var b strings.Builder
+	for i, r := range name {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(name[i:]); size == 1 {
+				b.WriteRune('_')
+				continue
+			}
+		}
+		if unicode.IsControl(r) {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	sanitized := b.String()
+	trimmed := strings.TrimLeftFunc(sanitized, unicode.IsSpace)
+	sanitized = strings.Repeat("_", len(sanitized)-len(trimmed)) + trimmed
+	trimmed = strings.TrimRightFunc(sanitized, unicode.IsSpace)
+	sanitized = trimmed + strings.Repeat("_", len(sanitized)-len(trimmed))
+	if sanitized == "" || sanitized == "." || sanitized == ".." {
+		sanitized = strings.Repeat("_", len(name)+1)
+	}
+
+	if len(sanitized) > maxNameLength {
+		hash := sha256.Sum256([]byte(name))
+		suffix := "~" + hex.EncodeToString(hash[:4])
+		cut := maxNameLength - len(suffix)
+		// don't cut a character in half
+		for cut > 0 && !utf8.RuneStart(sanitized[cut]) {
+			cut--
+		}
+		sanitized = sanitized[:cut] + suffix
+	}
+	return sanitized
+}
+
+// checkName reports whether Sia accepts the path of the file. A file with a
+// name Sia doesn't accept is logged once and listed as skipped in the status,
+// everything else is synced as usual.
+func (sf *SiaFolder) checkName(file string) bool {
+	relpath, err := filepath.Rel(sf.path, file)
+	if err == nil {
+		_, err = sf.getSiaPath(relpath)
+	}
+	if err == nil {
+		return true
+	}
+
+	sf.skipFile(file, err, "Skipping file with a name Sia doesn't accept")
+	return false
+}
@@ -0,0 +1,55 @@
+package siasync
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCheckSiaPath verifies that names Sia doesn't accept are rejected.
+func TestCheckSiaPath(t *testing.T) {
+	valid := []string{"movie.mkv", "movies/2020/movie.mkv", "Amélie.mkv", "a b", strings.Repeat("a", maxNameLength)}
+	for _, path := range valid {
+		if err := checkSiaPath(path); err != nil {
+			t.Errorf("%q should be valid: %v", path, err)
+		}
+	}
+	invalid := []string{"", "movies//movie.mkv", "movies/../movie.mkv", "./movie.mkv", " movie.mkv", "movie.mkv ", "movies /movie.mkv", "bad\x01name", "tab\tname", "bad\xffname", strings.Repeat("a", maxNameLength+1)}
+	for _, path := range invalid {
+		if err := checkSiaPath(path); err == nil {
+			t.Errorf("%q should be invalid", path)
+		}
+	}
+}
+
+// TestSanitizeName verifies that sanitized names are valid, deterministic and
+// stay the same when sanitized again.
+func TestSanitizeName(t *testing.T) {
+	tests := map[string]string{
+		"movie.mkv":   "movie.mkv",
+		" movie.mkv":  "_movie.mkv",
+		"movie.mkv  ": "movie.mkv__",
+		"bad\x01name": "bad_name",
+		"bad\xffname": "bad_name",
+		"Amélie.mkv":  "Amélie.mkv",
+	}
+	for name, expected := range tests {
+		if sanitized := sanitizeName(name); sanitized != expected {
+			t.Errorf("sanitizeName(%q): expected %q, got %q", name, expected, sanitized)
+		}
+	}
+
+	long := strings.Repeat("é", maxNameLength)
+	sanitized := sanitizeName(long)
+	if err := checkSiaPath(sanitized); err != nil {
+		t.Fatalf("sanitized long name is invalid: %v", err)
+	}
+	if sanitized != sanitizeName(long) || sanitized == sanitizeName(long+"é") {
+		t.Fatal("long names should map to a name depending on the whole name")
+	}
+	if sanitizeName(sanitized) != sanitized {
+		t.Fatal("a sanitized name should stay the same")
+	}
+	if path := sanitizeSiaPath("siasync/ movies/bad\x01name"); path != "siasync/_movies/bad_name" {
+		t.Fatalf("unexpected sanitized path %q", path)
+	}
+}
@@ -0,0 +1,133 @@
+package siasync
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// offlineWarnInterval is how often it is logged that the synced directory is
+// still offline.
+var offlineWarnInterval = time.Minute
+
+// sourcePresent reports whether the synced directory is there. It is missing
+// if it doesn't exist, or if it was replaced by an empty directory, like the
+// mountpoint of a drive that was unplugged. A directory with files that
+// replaced it is synced from then on.
+func (sf *SiaFolder) sourcePresent() bool {
+	stat, err := os.Stat(sf.path)
+	if err != nil || !stat.IsDir() {
+		return false
+	}
+	sf.mu.Lock()
+	same := sf.sourceInfo == nil || os.SameFile(sf.sourceInfo, stat)
+	sf.mu.Unlock()
+	if !same {
+		empty, err := dirEmpty(sf.path)
+		if err != nil || empty {
+			return false
+		}
+	}
+	sf.mu.Lock()
+	sf.sourceInfo = stat
+	sf.mu.Unlock()
+	return true
+}
+
+// dirEmpty reports whether the directory at path has no entries.
+func dirEmpty(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+// sourceOffline reports whether the synced directory was missing when it was
+// last checked.
+func (sf *SiaFolder) sourceOffline() bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.offline
+}
+
+// checkSource checks whether the synced directory went offline or came back,
+// and returns whether it is online. While it is offline nothing is uploaded,
+// events are dropped and nothing is removed from Sia or locally, because the
+// files that seem to be gone are only on a drive that isn't there. That it is
+// still offline is logged every offlineWarnInterval. It runs on the
+// eventWatcher goroutine.
+func (sf *SiaFolder) checkSource() bool {
+	present := sf.sourcePresent()
+	sf.mu.Lock()
+	wasOffline := sf.offline
+	sf.offline = !present
+	sf.mu.Unlock()
+
+	switch {
+	case present && wasOffline:
+		sf.sourceBack()
+	case !present && !wasOffline:
+		sf.uploads.pause("offline")
+		// the renamed files and directories aren't removed and the pending
+		// events are rescanned once the directory is back
+		sf.pending = make(map[string]*pendingEvent)
+		sf.renamed = make(map[string]renamedFile)
+		sf.renamedDirs = make(map[string]renamedDir)
+		sf.offlineWarned = time.Now()
+		log.WithFields(logrus.Fields{
+			"directory": sf.path,
+		}).Warn("Synced directory is gone, suspending syncing until it is back")
+	case !present && time.Since(sf.offlineWarned) >= offlineWarnInterval:
+		sf.offlineWarned = time.Now()
+		log.WithFields(logrus.Fields{
+			"directory": sf.path,
+		}).Warn("Synced directory is still gone, syncing is suspended")
+	}
+	return present
+}
+
+// sourceBack resumes syncing once the synced directory is back. Its
+// directories are watched again, as their watches went away with it, and it is
+// rescanned and reconciled with Sia to catch up on what changed meanwhile.
+func (sf *SiaFolder) sourceBack() {
+	log.WithFields(logrus.Fields{
+		"directory": sf.path,
+	}).Info("Synced directory is back, resuming syncing")
+
+	if sf.watcher != nil {
+		err := sf.watcher.Add(sf.path)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Error watching the synced directory again")
+		}
+		sf.mu.Lock()
+		dirs := make([]string, 0, len(sf.dirs))
+		for dir := range sf.dirs {
+			dirs = append(dirs, dir)
+		}
+		sf.mu.Unlock()
+		for _, dir := range dirs {
+			// directories removed meanwhile are polled until the rescan
+			// finds them gone
+			watched := sf.watcher.Add(dir) == nil
+			sf.mu.Lock()
+			if state, ok := sf.dirs[dir]; ok {
+				state.watched = watched
+				sf.dirs[dir] = state
+			}
+			sf.mu.Unlock()
+		}
+	}
+
+	sf.uploads.resume("offline")
+	sf.rescan()
+}
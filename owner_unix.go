@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package siasync
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownerOf returns the numeric owner and group of the file described by stat.
+func ownerOf(stat os.FileInfo) *fileOwner {
+	st, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &fileOwner{UID: int(st.Uid), GID: int(st.Gid)}
+}
@@ -0,0 +1,8 @@
+package siasync
+
+import "os"
+
+// ownerOf returns nil, Windows files have no numeric owner.
+func ownerOf(stat os.FileInfo) *fileOwner {
+	return nil
+}
@@ -0,0 +1,110 @@
+package siasync
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// isWindows is whether local paths use backslashes and drive letters.
+var isWindows = runtime.GOOS == "windows"
+
+// slashPath converts a local path to a clean slash separated path, which is
+// what siapaths and the keys of the state file and manifest are made of. On
+// Windows both separators are accepted, since fsnotify and users mix them.
+// The path "." becomes "".
+func slashPath(p string, windows bool) string {
+	if windows {
+		p = strings.Replace(p, `\`, "/", -1)
+	}
+	p = path.Clean(p)
+	if p == "." {
+		return ""
+	}
+	return p
+}
+
+// volumeName returns the drive letter of a slash separated Windows path, like
+// "C:", or "" for any other path.
+func volumeName(p string, windows bool) string {
+	if !windows || len(p) < 2 || p[1] != ':' {
+		return ""
+	}
+	c := p[0]
+	if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') {
+		return p[:2]
+	}
+	return ""
+}
+
+// relSlashPath returns the slash separated path of file relative to root, or
+// "" if they are the same. Unlike filepath.Rel it doesn't depend on the
+// platform it runs on: with windows set, both separators are accepted and
+// drive letters are compared regardless of their case. It returns an error
+// if file isn't inside root.
+func relSlashPath(root, file string, windows bool) (string, error) {
+	cleanRoot, cleanFile := slashPath(root, windows), slashPath(file, windows)
+	rootVolume, fileVolume := volumeName(cleanRoot, windows), volumeName(cleanFile, windows)
+	if !strings.EqualFold(rootVolume, fileVolume) {
+		return "", fmt.Errorf("%v is not on the same drive as %v", file, root)
+	}
+	cleanRoot, cleanFile = cleanRoot[len(rootVolume):], cleanFile[len(fileVolume):]
+	if rootVolume != "" && cleanRoot == "" {
+		// path.Clean turns C:/ into C:
+		cleanRoot = "/"
+	}
+
+	if cleanFile == cleanRoot {
+		return "", nil
+	}
+	if cleanRoot == "" {
+		if cleanFile == ".." || strings.HasPrefix(cleanFile, "../") || strings.HasPrefix(cleanFile, "/") {
+			return "", fmt.Errorf("%v is not inside %v", file, root)
+		}
+		return cleanFile, nil
+	}
+	if !strings.HasSuffix(cleanRoot, "/") {
+		cleanRoot += "/"
+	}
+	if !strings.HasPrefix(cleanFile, cleanRoot) {
+		return "", fmt.Errorf("%v is not inside %v", file, root)
+	}
+	return cleanFile[len(cleanRoot):], nil
+}
+
+// siaPathString joins the slash separated prefix on Sia with the local path
+// relative to the synced directory into the string of a siapath. The siapath
+// is in Unicode normalization form C, macOS returns file names decomposed and
+// a file must end up at the same siapath whichever system it is synced from.
+func siaPathString(prefix, relpath string, windows bool) string {
+	p := strings.Trim(path.Join(slashPath(prefix, windows), slashPath(relpath, windows)), "/")
+	return norm.NFC.String(p)
+}
+
+// composedPath returns the local path in Unicode normalization form C if that
+// names the same file, which it does on macOS, so that a file is tracked under
+// one path however the filesystem spells it. Filesystems that tell both forms
+// apart keep the path as it is. A path that is gone is composed if the
+// composed path is tracked, so that its removal is recognized.
+func (sf *SiaFolder) composedPath(p string) string {
+	if norm.NFC.IsNormalString(p) {
+		return p
+	}
+	composed := norm.NFC.String(p)
+	stat, err := os.Lstat(p)
+	if err != nil {
+		if _, tracked := sf.trackedFile(composed); tracked || sf.isWatchedDir(composed) {
+			return composed
+		}
+		return p
+	}
+	composedStat, err := os.Lstat(composed)
+	if err != nil || !os.SameFile(stat, composedStat) {
+		return p
+	}
+	return composed
+}
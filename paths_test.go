@@ -0,0 +1,95 @@
+package siasync
+
+import (
+	"testing"
+)
+
+// TestSlashPath verifies that local paths are turned into clean slash
+// separated paths, accepting backslashes only on Windows.
+func TestSlashPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		windows  bool
+		expected string
+	}{
+		{"movies/movie.mkv", false, "movies/movie.mkv"},
+		{`movies\movie.mkv`, false, `movies\movie.mkv`},
+		{`movies\movie.mkv`, true, "movies/movie.mkv"},
+		{`movies/2020\movie.mkv`, true, "movies/2020/movie.mkv"},
+		{`movies\\2020\.\movie.mkv`, true, "movies/2020/movie.mkv"},
+		{`C:\Users\sia\movies\`, true, "C:/Users/sia/movies"},
+		{".", false, ""},
+		{`.\`, true, ""},
+	}
+	for _, test := range tests {
+		if p := slashPath(test.path, test.windows); p != test.expected {
+			t.Errorf("slashPath(%q, %v): expected %q, got %q", test.path, test.windows, test.expected, p)
+		}
+	}
+}
+
+// TestRelSlashPath verifies that relative paths are slash separated on every
+// platform, with drive letters compared regardless of their case on Windows.
+func TestRelSlashPath(t *testing.T) {
+	tests := []struct {
+		root     string
+		file     string
+		windows  bool
+		expected string
+		fails    bool
+	}{
+		{"/home/sia/sync", "/home/sia/sync/movies/movie.mkv", false, "movies/movie.mkv", false},
+		{"/home/sia/sync", "/home/sia/sync", false, "", false},
+		{"/home/sia/sync", "/home/sia/synced/movie.mkv", false, "", true},
+		{"/home/sia/sync", "/home/sia/movie.mkv", false, "", true},
+		{"/", "/movie.mkv", false, "movie.mkv", false},
+		{"sync", "sync/movie.mkv", false, "movie.mkv", false},
+		{".", "movie.mkv", false, "movie.mkv", false},
+		{".", "../movie.mkv", false, "", true},
+		{`C:\Users\sia\sync`, `C:\Users\sia\sync\movies\movie.mkv`, true, "movies/movie.mkv", false},
+		{`C:\Users\sia\sync`, `C:\Users\sia\sync/movies\movie.mkv`, true, "movies/movie.mkv", false},
+		{`c:\Users\sia\sync`, `C:\Users\sia\sync\movie.mkv`, true, "movie.mkv", false},
+		{`C:\`, `C:\movie.mkv`, true, "movie.mkv", false},
+		{`C:\Users\sia\sync`, `D:\Users\sia\sync\movie.mkv`, true, "", true},
+		{`C:\Users\sia\sync`, `C:\Users\sia\synced\movie.mkv`, true, "", true},
+	}
+	for _, test := range tests {
+		relpath, err := relSlashPath(test.root, test.file, test.windows)
+		if test.fails {
+			if err == nil {
+				t.Errorf("relSlashPath(%q, %q, %v): expected an error, got %q", test.root, test.file, test.windows, relpath)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("relSlashPath(%q, %q, %v): %v", test.root, test.file, test.windows, err)
+		} else if relpath != test.expected {
+			t.Errorf("relSlashPath(%q, %q, %v): expected %q, got %q", test.root, test.file, test.windows, test.expected, relpath)
+		}
+	}
+}
+
+// TestSiaPathString verifies that siapaths never contain backslashes of
+// Windows paths.
+func TestSiaPathString(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		relpath  string
+		windows  bool
+		expected string
+	}{
+		{"siasync", "movies/movie.mkv", false, "siasync/movies/movie.mkv"},
+		{"siasync", `movies\movie.mkv`, true, "siasync/movies/movie.mkv"},
+		{`backup\laptop`, `movies\2020/movie.mkv`, true, "backup/laptop/movies/2020/movie.mkv"},
+		{"/siasync/", "movie.mkv", false, "siasync/movie.mkv"},
+		{"", `movies\movie.mkv`, true, "movies/movie.mkv"},
+		{"siasync", "", false, "siasync"},
+		{"siasync", "movies/Ame\u0301lie.mkv", false, "siasync/movies/Am\u00e9lie.mkv"},
+		{"sauvegarde-e\u0301te\u0301", "Cafe\u0301.txt", false, "sauvegarde-\u00e9t\u00e9/Caf\u00e9.txt"},
+	}
+	for _, test := range tests {
+		if s := siaPathString(test.prefix, test.relpath, test.windows); s != test.expected {
+			t.Errorf("siaPathString(%q, %q, %v): expected %q, got %q", test.prefix, test.relpath, test.windows, test.expected, s)
+		}
+	}
+}
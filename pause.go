@@ -0,0 +1,119 @@
+package siasync
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Pause stops the SiaFolder from changing anything on Sia until Resume is
+// called. Changes to the directory are still tracked and files are still
+// queued for upload, but uploads that haven't started wait, and files removed
+// or renamed locally are only removed or renamed on Sia once syncing is
+// resumed. Uploads in progress are finished.
+func (sf *SiaFolder) Pause() {
+	sf.mu.Lock()
+	if sf.paused {
+		sf.mu.Unlock()
+		return
+	}
+	sf.paused = true
+	sf.mu.Unlock()
+
+	sf.uploads.pause("paused")
+	log.WithFields(logrus.Fields{
+		"directory": sf.path,
+	}).Info("Paused syncing")
+}
+
+// Resume syncs the changes made while the SiaFolder was paused. The files
+// removed in the meantime are removed from Sia first, so that files replaced
+// while paused are uploaded after their old version is gone, then the queued
+// uploads are handed out again.
+func (sf *SiaFolder) Resume() {
+	sf.mu.Lock()
+	if !sf.paused {
+		sf.mu.Unlock()
+		return
+	}
+	sf.paused = false
+	removals := sf.pausedRemovals
+	sf.pausedRemovals = make(map[string]fileState)
+	sf.mu.Unlock()
+
+	log.WithFields(logrus.Fields{
+		"directory": sf.path,
+		"removals":  len(removals),
+	}).Info("Resumed syncing")
+	for file, fs := range removals {
+		err := sf.removePaused(file, fs)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Error removing file after resuming")
+		}
+	}
+	sf.uploads.resume("paused")
+}
+
+// deferRemoval remembers a file removed while paused, to remove it from Sia
+// once resumed. It returns false if the SiaFolder isn't paused.
+func (sf *SiaFolder) deferRemoval(file string, fs fileState) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if !sf.paused {
+		return false
+	}
+	sf.pausedRemovals[file] = fs
+	return true
+}
+
+// removePaused removes a file that was removed locally while paused from Sia.
+func (sf *SiaFolder) removePaused(file string, fs fileState) error {
+	relpath, err := filepath.Rel(sf.path, file)
+	if err != nil {
+		return err
+	}
+	siaPath, err := sf.uploadedSiaPath(relpath, fs)
+	if err != nil {
+		return err
+	}
+	err = sf.client.RenterDeletePost(siaPath)
+	sf.audit(AuditRecord{Op: auditDelete, Path: file, SiaPath: siaPath.String(), Size: fs.Size, Checksum: fs.Checksum}, err)
+	sf.listing.invalidate()
+	if err != nil && !strings.Contains(err.Error(), "no file known") {
+		return err
+	}
+	sf.removeVersions(file, fs)
+	if err == nil {
+		sf.stats.update(func(s *Stats) { s.Deleted++ })
+		sf.emit(Event{Type: FileDeleted, Path: file, SiaPath: siaPath.String(), Size: fs.Size})
+	}
+	return nil
+}
+
+// Paused reports whether syncing is paused.
+func (sf *SiaFolder) Paused() bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.paused
+}
+
+// TogglePause pauses every folder if any of them is syncing, and resumes them
+// all otherwise.
+func TogglePause(folders []*SiaFolder) {
+	pause := false
+	for _, sf := range folders {
+		if !sf.Paused() {
+			pause = true
+		}
+	}
+	for _, sf := range folders {
+		if pause {
+			sf.Pause()
+		} else {
+			sf.Resume()
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package siasync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// watchTestFile is created in the synced directory at startup to test whether
+// the watcher receives events for it.
+const watchTestFile = ".siasync-watch-test"
+
+// DefaultPollInterval is how often the directory is polled if no interval is
+// configured.
+const DefaultPollInterval = time.Minute
+
+// watchTestTimeout is how long to wait for the event of watchTestFile.
+var watchTestTimeout = 5 * time.Second
+
+// watcherWorks creates watchTestFile in the synced directory and reports
+// whether the watcher received an event for it within watchTestTimeout. It
+// consumes the watcher's events, so it must be called before the directory is
+// scanned and eventWatcher is started. A directory that can't be written to is
+// assumed to be watched fine.
+func (sf *SiaFolder) watcherWorks() bool {
+	testFile := filepath.Join(sf.path, watchTestFile)
+	err := ioutil.WriteFile(testFile, nil, 0600)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Debug("Could not create a file to test the watcher")
+		return true
+	}
+	defer os.Remove(testFile)
+
+	timeout := time.After(watchTestTimeout)
+	for {
+		select {
+		case event := <-sf.watcher.Events:
+			if filepath.Clean(event.Name) == testFile {
+				return true
+			}
+		case <-sf.watcher.Errors:
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// watchFailed logs a subdirectory that could not be added to the watcher. The
+// first time the watch limit of the system is reached, a hint on how to raise
+// it is logged too.
+func (sf *SiaFolder) watchFailed(dir string, err error) {
+	log.WithFields(logrus.Fields{
+		"directory": dir,
+		"error":     err.Error(),
+	}).Debug("Could not watch directory, polling it instead")
+	if err != syscall.ENOSPC {
+		return
+	}
+	if !sf.watchLimitHinted {
+		sf.watchLimitHinted = true
+		log.WithFields(logrus.Fields{
+			"interval": sf.pollInterval,
+		}).Warn("Reached the limit of watched directories, directories that can't be watched are polled instead. " +
+			"On Linux raise the limit with: sysctl fs.inotify.max_user_watches=524288")
+	}
+}
+
+// unwatchedDirs returns the sorted subdirectories that could not be added to
+// the watcher, leaving out the ones inside another unwatched directory.
+func (sf *SiaFolder) unwatchedDirs() []string {
+	sf.mu.Lock()
+	var dirs []string
+	for dir, state := range sf.dirs {
+		if !state.watched {
+			dirs = append(dirs, dir)
+		}
+	}
+	sf.mu.Unlock()
+
+	sort.Strings(dirs)
+	var roots []string
+	for _, dir := range dirs {
+		if len(roots) > 0 && isWithin(roots[len(roots)-1], dir) {
+			continue
+		}
+		roots = append(roots, dir)
+	}
+	return roots
+}
+
+// poll walks the directory for changes if it isn't watched, and otherwise the
+// subdirectories that could not be watched.
+func (sf *SiaFolder) poll() {
+	if sf.watcher == nil {
+		sf.scanChanges(sf.path)
+	} else {
+		for _, dir := range sf.unwatchedDirs() {
+			sf.scanChanges(dir)
+		}
+	}
+	sf.saveStateLogged()
+}
@@ -0,0 +1,107 @@
+package siasync
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/node/api"
+)
+
+// preflightInterval is how often the node is checked again while siasync is
+// watching for changes.
+var preflightInterval = time.Minute
+
+// NodeClient is the part of the Sia API client used to check that the node can
+// accept uploads. It is satisfied by *client.Client.
+type NodeClient interface {
+	ConsensusGet() (api.ConsensusGET, error)
+	WalletGet() (api.WalletGET, error)
+	RenterGet() (api.RenterGET, error)
+	RenterDisabledContractsGet() (api.RenterContracts, error)
+}
+
+// Preflight checks that the node is synced, has an unlocked wallet, an
+// allowance and enough active contracts for the erasure coding. It returns a
+// description of every problem that keeps the node from accepting uploads.
+func Preflight(c NodeClient, dataPieces, parityPieces uint64) ([]string, error) {
+	var problems []string
+
+	cg, err := c.ConsensusGet()
+	if err != nil {
+		return nil, fmt.Errorf("could not get consensus info: %v", err)
+	}
+	if !cg.Synced {
+		problems = append(problems, fmt.Sprintf("Sia is not synced with the network yet (height %v), wait for siad to finish syncing", cg.Height))
+	}
+
+	wg, err := c.WalletGet()
+	if err != nil {
+		return nil, fmt.Errorf("could not get wallet info: %v", err)
+	}
+	if !wg.Encrypted {
+		problems = append(problems, "The wallet has not been created, run siac wallet init")
+	} else if !wg.Unlocked {
+		problems = append(problems, "The wallet is locked, run siac wallet unlock")
+	}
+
+	rg, err := c.RenterGet()
+	if err != nil {
+		return nil, fmt.Errorf("could not get renter info: %v", err)
+	}
+	if rg.Settings.Allowance.Funds.IsZero() {
+		problems = append(problems, "No allowance is set, run siac renter setallowance")
+		return problems, nil
+	}
+
+	rc, err := c.RenterDisabledContractsGet()
+	if err != nil {
+		return nil, fmt.Errorf("could not get renter contracts: %v", err)
+	}
+	if len(rc.ActiveContracts) == 0 {
+		problems = append(problems, "There are no active contracts yet, wait for the renter to form contracts with hosts")
+	} else if err := checkErasureCoding(dataPieces, parityPieces, len(rc.ActiveContracts)); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems, nil
+}
+
+// MonitorNode runs the preflight checks every preflightInterval until stop is
+// closed. Uploads of every folder are paused while the node can't accept them.
+func MonitorNode(c NodeClient, folders []*SiaFolder, dataPieces, parityPieces uint64, stop <-chan struct{}) {
+	ticker := time.NewTicker(preflightInterval)
+	defer ticker.Stop()
+	healthy := true
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		problems, err := Preflight(c, dataPieces, parityPieces)
+		if err != nil {
+			// an unreachable siad is handled by the upload workers
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Debug("Could not check the node")
+			continue
+		}
+		if len(problems) > 0 && healthy {
+			for _, problem := range problems {
+				log.Warn(problem)
+			}
+			log.Warn("Sia can't accept uploads, pausing uploads")
+			for _, sf := range folders {
+				sf.uploads.pause("preflight")
+			}
+		}
+		if len(problems) == 0 && !healthy {
+			log.Info("Sia can accept uploads again, resuming uploads")
+			for _, sf := range folders {
+				sf.uploads.resume("preflight")
+			}
+		}
+		healthy = len(problems) == 0
+	}
+}
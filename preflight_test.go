@@ -0,0 +1,71 @@
+package siasync
+
+import (
+	"strings"
+	"testing"
+
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/node/api"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// testingNode is a NodeClient that reports a fixed node state.
+type testingNode struct {
+	consensus api.ConsensusGET
+	wallet    api.WalletGET
+	renter    api.RenterGET
+	contracts api.RenterContracts
+}
+
+func (n *testingNode) ConsensusGet() (api.ConsensusGET, error) { return n.consensus, nil }
+func (n *testingNode) WalletGet() (api.WalletGET, error)       { return n.wallet, nil }
+func (n *testingNode) RenterGet() (api.RenterGET, error)       { return n.renter, nil }
+func (n *testingNode) RenterDisabledContractsGet() (api.RenterContracts, error) {
+	return n.contracts, nil
+}
+
+// TestPreflight verifies that preflight reports every problem that keeps the
+// node from accepting uploads.
+func TestPreflight(t *testing.T) {
+	ready := func() *testingNode {
+		return &testingNode{
+			consensus: api.ConsensusGET{Synced: true},
+			wallet:    api.WalletGET{Encrypted: true, Unlocked: true},
+			renter: api.RenterGET{Settings: modules.RenterSettings{
+				Allowance: modules.Allowance{Funds: types.NewCurrency64(1)},
+			}},
+			contracts: api.RenterContracts{ActiveContracts: make([]api.RenterContract, 50)},
+		}
+	}
+
+	problems, err := Preflight(ready(), 10, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("a ready node should have no problems, got %v", problems)
+	}
+
+	tests := []struct {
+		change  func(n *testingNode)
+		problem string
+	}{
+		{func(n *testingNode) { n.consensus.Synced = false }, "not synced"},
+		{func(n *testingNode) { n.wallet.Encrypted = false }, "wallet init"},
+		{func(n *testingNode) { n.wallet.Unlocked = false }, "wallet unlock"},
+		{func(n *testingNode) { n.renter.Settings.Allowance.Funds = types.Currency{} }, "setallowance"},
+		{func(n *testingNode) { n.contracts.ActiveContracts = nil }, "no active contracts"},
+		{func(n *testingNode) { n.contracts.ActiveContracts = make([]api.RenterContract, 20) }, "contracts"},
+	}
+	for _, test := range tests {
+		n := ready()
+		test.change(n)
+		problems, err := Preflight(n, 10, 30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 1 || !strings.Contains(problems[0], test.problem) {
+			t.Errorf("expected a problem about %q, got %v", test.problem, problems)
+		}
+	}
+}
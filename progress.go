@@ -0,0 +1,82 @@
+package siasync
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// reportProgress logs the upload progress and redundancy of every uploaded
+// file that siad hasn't finished uploading yet. A file is reported on every
+// call until its upload reaches 100%, which is logged once. Files below their
+// minimum redundancy are reported with an estimate of when they reach it, at
+// the rate their redundancy grew across the last calls, and a warning is
+// logged once if it stopped growing. It runs on the eventWatcher goroutine.
+func (sf *SiaFolder) reportProgress() {
+	sf.listing.invalidate()
+	renterFiles, err := sf.getSiaFiles()
+	if err != nil && !strings.Contains(err.Error(), errNoFiles.Error()) {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error listing files to report their upload progress")
+		return
+	}
+
+	sf.mu.Lock()
+	previous := sf.redundancy
+	sf.mu.Unlock()
+	now := time.Now()
+	redundancy := make(map[string][]redundancySample)
+	uploading := make(map[string]struct{})
+	for _, file := range sf.trackedFiles() {
+		fs, _ := sf.trackedFile(file)
+		if !fs.Uploaded {
+			continue
+		}
+		relpath, err := filepath.Rel(sf.path, file)
+		if err != nil {
+			continue
+		}
+		siaPath, err := sf.uploadedSiaPath(relpath, fs)
+		if err != nil {
+			continue
+		}
+		siafile, ok := renterFiles[siaPath]
+		if !ok {
+			continue
+		}
+
+		fields := logrus.Fields{
+			"file":       file,
+			"siapath":    siafile.SiaPath.String(),
+			"progress":   siafile.UploadProgress,
+			"redundancy": siafile.Redundancy,
+		}
+		if siafile.UploadProgress < 100 {
+			uploading[file] = struct{}{}
+			key := sf.fileKey(file)
+			target := sf.categorySettingsOf(relpath).minRedundancy
+			_, _, wasStalled := redundancyETA(previous[key], target)
+			redundancy[key] = appendRedundancySample(previous[key], redundancySample{at: now, redundancy: siafile.Redundancy})
+			msg := fmt.Sprintf("%v %.0f%% uploaded, %.1fx redundancy", relpath, siafile.UploadProgress, siafile.Redundancy)
+			eta, ok, stalled := redundancyETA(redundancy[key], target)
+			if ok && siafile.Redundancy < target {
+				fields["eta"] = eta.Round(time.Second).String()
+				msg += fmt.Sprintf(", ~%v to %.1fx", formatETA(eta), target)
+			}
+			if stalled && !wasStalled {
+				log.WithFields(fields).Warnf("Redundancy of %v didn't grow across %v progress reports", relpath, redundancySamples)
+			}
+			log.WithFields(fields).Info(msg)
+		} else if _, reported := sf.uploading[file]; reported {
+			log.WithFields(fields).Info("Finished uploading file")
+		}
+	}
+	sf.uploading = uploading
+	sf.mu.Lock()
+	sf.redundancy = redundancy
+	sf.mu.Unlock()
+}
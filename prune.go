@@ -0,0 +1,66 @@
+package siasync
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errPruneAborted is returned by prune if the deletions were not confirmed.
+var errPruneAborted = errors.New("prune aborted")
+
+// Prune deletes the files below the prefix on Sia that don't exist in the
+// local directory anymore, even in archive mode. The files are listed on out
+// first and, unless confirmed is set or this is a dry run, only deleted once
+// the user answers yes on in. Prune returns the number of deleted files.
+func (sf *SiaFolder) Prune(confirmed bool, in io.Reader, out io.Writer) (int, error) {
+	files, err := sf.deletedFiles()
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		log.Info("Nothing to prune")
+		return 0, nil
+	}
+
+	for _, file := range files {
+		relpath, err := filepath.Rel(sf.path, file)
+		if err != nil {
+			return 0, err
+		}
+		siaPath, err := sf.getSiaPath(relpath)
+		if err != nil {
+			return 0, err
+		}
+		fmt.Fprintf(out, "delete %v\n", siaPath)
+	}
+	if !confirmed && !sf.dryRun {
+		fmt.Fprintf(out, "Delete %v files from Sia? [y/N] ", len(files))
+		answer, _ := bufio.NewReader(in).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer != "y" && answer != "yes" {
+			return 0, errPruneAborted
+		}
+	}
+
+	deleted := 0
+	for _, file := range files {
+		err = sf.handleRemove(file)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Error with handleRemove")
+			continue
+		}
+		deleted++
+	}
+	log.WithFields(logrus.Fields{
+		"files": deleted,
+	}).Info("Pruned files missing from local directory")
+	return deleted, nil
+}
@@ -0,0 +1,407 @@
+package siasync
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UploadOrders are the supported orders in which queued files are uploaded.
+var UploadOrders = []string{"fifo", "smallest-first", "largest-first", "newest-first"}
+
+// uploadJob is a file waiting in the upload queue.
+type uploadJob struct {
+	folder    *uploadQueue // folder is the part of the queue the job belongs to
+	file      string
+	attempts  int       // attempts is the number of failed uploads so far
+	notBefore time.Time // notBefore is when the file may be retried
+
+	// seq is the order in which the file was first queued, size and
+	// modTime are the file's when it was first queued.
+	seq     uint64
+	size    int64
+	modTime time.Time
+}
+
+// UploadQueue is the queue of files waiting to be uploaded, shared by the
+// SiaFolders it is passed to in Config.Uploads, so that the upload limits hold
+// for all of them together. Jobs of all SiaFolders are kept sorted by the
+// upload order, and handed out in that order to the upload workers of the
+// SiaFolder they belong to. Failed uploads can be queued again to be retried
+// after a delay.
+//
+// siad transfers the files in the background, so the rate at which files are
+// handed to siad is what limits the upload bandwidth siasync uses. The queue
+// hands out at most maxInFlight files at the same time and maxPerHour files
+// per hour, and no new file while siad is still uploading maxBytes bytes,
+// unless siad is idle. maxPerHour and maxBytes are unlimited if 0. If windows
+// are set, files are only handed out during them, going by the wall clock in
+// location. The limits must be set before the queue is used.
+type UploadQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	order    string
+	seq      uint64
+	jobs     []uploadJob
+	inFlight int
+
+	maxInFlight   int
+	maxPerHour    int
+	maxBytes      int64
+	handedOut     []time.Time // handedOut is when files were handed out in the last hour if maxPerHour is set
+	inFlightBytes int64       // inFlightBytes is the size of the jobs being handled
+	siadBytes     int64       // siadBytes is the size of the files siad is still uploading
+	throttle      string      // throttle is the limit that holds back the next job, if any
+	windows       []UploadWindow
+	location      *time.Location
+}
+
+// NewUploadQueue returns an empty upload queue with the upload order and
+// limits of config, to be shared by SiaFolders in Config.Uploads.
+func NewUploadQueue(config Config) *UploadQueue {
+	q := &UploadQueue{
+		order:       config.UploadOrder,
+		maxInFlight: config.MaxUploads,
+		maxPerHour:  config.MaxUploadsPerHour,
+		maxBytes:    config.MaxConcurrentBytes,
+		windows:     config.UploadWindows,
+		location:    config.UploadWindowLocation,
+	}
+	if q.maxInFlight < 1 {
+		q.maxInFlight = 1
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// uploadQueue is the part of an UploadQueue that belongs to one SiaFolder. A
+// file is only queued once until a worker picks it up. Pausing and closing it
+// only affects the jobs of its SiaFolder.
+type uploadQueue struct {
+	*UploadQueue
+	queued    map[string]struct{}
+	inFlight  int
+	uploading map[string]int // uploading counts the jobs of each file being handled
+	siadBytes int64          // siadBytes is the size of the files siad is still uploading
+	paused    map[string]struct{}
+	closed    bool
+}
+
+// folder returns a new part of the queue for a SiaFolder.
+func (q *UploadQueue) folder() *uploadQueue {
+	return &uploadQueue{
+		UploadQueue: q,
+		queued:      make(map[string]struct{}),
+		uploading:   make(map[string]int),
+		paused:      make(map[string]struct{}),
+	}
+}
+
+// newUploadQueue returns an empty upload queue of its own that hands out
+// files in the given order, one of UploadOrders. Any other order is fifo.
+func newUploadQueue(order string) *uploadQueue {
+	return NewUploadQueue(Config{UploadOrder: order}).folder()
+}
+
+// less reports whether job a is handed out before job b. Ties are broken by
+// the file name, so that the order doesn't depend on timing.
+func (q *UploadQueue) less(a, b uploadJob) bool {
+	switch q.order {
+	case "smallest-first":
+		if a.size != b.size {
+			return a.size < b.size
+		}
+	case "largest-first":
+		if a.size != b.size {
+			return a.size > b.size
+		}
+	case "newest-first":
+		if !a.modTime.Equal(b.modTime) {
+			return a.modTime.After(b.modTime)
+		}
+	default:
+		return a.seq < b.seq
+	}
+	return a.file < b.file
+}
+
+// push adds a file to the queue at its place in the upload order unless it
+// is already queued or the queue is closed, and reports whether it was added.
+func (q *uploadQueue) push(file string) bool {
+	return q.pushJob(uploadJob{file: file})
+}
+
+// retry queues a failed job again, to be handed out once delay has passed.
+func (q *uploadQueue) retry(job uploadJob, delay time.Duration) {
+	job.attempts++
+	job.notBefore = time.Now().Add(delay)
+	q.pushJob(job)
+}
+
+// pushJob adds a job to the queue at its place in the upload order unless its
+// file is already queued or the queue is closed, and reports whether it was
+// added.
+func (q *uploadQueue) pushJob(job uploadJob) bool {
+	if job.seq == 0 && ((q.order != "fifo" && q.order != "") || q.maxBytes > 0) {
+		if stat, err := os.Stat(job.file); err == nil {
+			job.size = stat.Size()
+			job.modTime = stat.ModTime()
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, exists := q.queued[job.file]; exists || q.closed {
+		return false
+	}
+	if job.seq == 0 {
+		q.UploadQueue.seq++
+		job.seq = q.UploadQueue.seq
+	}
+	job.folder = q
+	q.queued[job.file] = struct{}{}
+	i := sort.Search(len(q.jobs), func(i int) bool {
+		return q.less(job, q.jobs[i])
+	})
+	q.jobs = append(q.jobs, uploadJob{})
+	copy(q.jobs[i+1:], q.jobs[i:])
+	q.jobs[i] = job
+	q.cond.Broadcast()
+	return true
+}
+
+// pop blocks until a job of this part of the queue is the next one to be
+// handed out, and returns it. Jobs of paused parts are passed over, the other
+// jobs are handed out in order once no limit holds them back. The caller must
+// call done once it has handled the job. pop returns false once the queue is
+// closed.
+func (q *uploadQueue) pop() (uploadJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.closed {
+			return uploadJob{}, false
+		}
+		if len(q.paused) > 0 || q.UploadQueue.inFlight >= q.maxInFlight {
+			q.cond.Wait()
+			continue
+		}
+
+		// hand out the first job that is ready if it is one of ours, or
+		// wait until the earliest retry is due or the limits allow the
+		// next one
+		now := time.Now()
+		var next time.Time
+		q.throttle = ""
+		for i, job := range q.jobs {
+			if len(job.folder.paused) > 0 {
+				continue
+			}
+			if !job.notBefore.After(now) {
+				if throttle, until := q.limit(job, now); throttle != "" {
+					q.throttle = throttle
+					if !until.IsZero() && (next.IsZero() || until.Before(next)) {
+						next = until
+					}
+					break
+				}
+				if job.folder != q {
+					// a worker of its SiaFolder hands it out
+					break
+				}
+				q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+				delete(q.queued, job.file)
+				q.inFlight++
+				q.uploading[job.file]++
+				q.UploadQueue.inFlight++
+				q.inFlightBytes += job.size
+				if q.maxPerHour > 0 {
+					q.handedOut = append(q.handedOut, now)
+				}
+				// the job after it may be another SiaFolder's
+				q.cond.Broadcast()
+				return job, true
+			}
+			if next.IsZero() || job.notBefore.Before(next) {
+				next = job.notBefore
+			}
+		}
+		if !next.IsZero() {
+			timer := time.AfterFunc(next.Sub(now), func() {
+				q.mu.Lock()
+				q.cond.Broadcast()
+				q.mu.Unlock()
+			})
+			q.cond.Wait()
+			timer.Stop()
+			continue
+		}
+		q.cond.Wait()
+	}
+}
+
+// limit returns the limit that holds back job, and when it allows the next
+// job if that only depends on time.
+func (q *UploadQueue) limit(job uploadJob, now time.Time) (string, time.Time) {
+	if !q.windowOpen(now) {
+		return windowThrottle, q.nextWindowOpen(now)
+	}
+	if q.maxPerHour > 0 {
+		hourAgo := now.Add(-time.Hour)
+		for len(q.handedOut) > 0 && !q.handedOut[0].After(hourAgo) {
+			q.handedOut = q.handedOut[1:]
+		}
+		if len(q.handedOut) >= q.maxPerHour {
+			return "uploads per hour", q.handedOut[0].Add(time.Hour)
+		}
+	}
+	busy := q.inFlightBytes + q.siadBytes
+	if q.maxBytes > 0 && busy > 0 && busy+job.size > q.maxBytes {
+		return "concurrent bytes", time.Time{}
+	}
+	return "", time.Time{}
+}
+
+// windowOpen reports whether files may be handed out at t according to the
+// upload windows.
+func (q *UploadQueue) windowOpen(t time.Time) bool {
+	return windowsOpen(q.windows, t, q.loc())
+}
+
+// nextWindowOpen returns when the next upload window opens after t.
+func (q *UploadQueue) nextWindowOpen(t time.Time) time.Time {
+	return nextWindowOpen(q.windows, t, q.loc())
+}
+
+// loc returns the location the upload windows are in, local time by default.
+func (q *UploadQueue) loc() *time.Location {
+	if q.location == nil {
+		return time.Local
+	}
+	return q.location
+}
+
+// setUploadingBytes records the size of the files of this SiaFolder siad is
+// still uploading, which counts against maxBytes.
+func (q *uploadQueue) setUploadingBytes(bytes int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.UploadQueue.siadBytes += bytes - q.siadBytes
+	q.siadBytes = bytes
+	q.cond.Broadcast()
+}
+
+// throttled returns the limit that held back the next job the last time a
+// worker asked for one, or "" if none did.
+func (q *UploadQueue) throttled() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.throttle
+}
+
+// pause stops handing out the jobs of this part of the queue until resume is
+// called with the same reason. Jobs can still be queued while it is paused.
+func (q *uploadQueue) pause(reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.paused[reason] = struct{}{}
+	q.cond.Broadcast()
+}
+
+// resume hands out jobs again once the queue isn't paused for any other
+// reason.
+func (q *uploadQueue) resume(reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.paused, reason)
+	q.cond.Broadcast()
+}
+
+// len returns the number of queued jobs and jobs being handled.
+func (q *uploadQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queued) + q.inFlight
+}
+
+// files returns the files of the queued jobs, in upload order.
+func (q *uploadQueue) files() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	files := make([]string, 0, len(q.queued))
+	for _, job := range q.jobs {
+		if job.folder == q {
+			files = append(files, job.file)
+		}
+	}
+	return files
+}
+
+// pending returns the files of the queued jobs and the jobs being handled.
+// A file is tracked once its job is done.
+func (q *uploadQueue) pending() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	files := make([]string, 0, len(q.queued)+len(q.uploading))
+	for file := range q.queued {
+		files = append(files, file)
+	}
+	for file := range q.uploading {
+		if _, queued := q.queued[file]; !queued {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// done marks a job returned by pop as handled. Its file is counted as being
+// uploaded by siad until the next setUploadingBytes.
+func (q *uploadQueue) done(job uploadJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inFlight--
+	q.uploading[job.file]--
+	if q.uploading[job.file] == 0 {
+		delete(q.uploading, job.file)
+	}
+	q.UploadQueue.inFlight--
+	q.inFlightBytes -= job.size
+	q.siadBytes += job.size
+	q.UploadQueue.siadBytes += job.size
+	q.cond.Broadcast()
+}
+
+// wait blocks until no job is queued or being handled, or the queue is
+// closed.
+func (q *uploadQueue) wait() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for (len(q.queued) > 0 || q.inFlight > 0) && !q.closed {
+		q.cond.Wait()
+	}
+}
+
+// close cancels every queued job and wakes up all waiting workers. The files
+// siad is still uploading no longer count against maxBytes. It returns the
+// number of jobs that were still queued.
+func (q *uploadQueue) close() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dropped := 0
+	jobs := q.jobs[:0]
+	for _, job := range q.jobs {
+		if job.folder == q {
+			dropped++
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	q.jobs = jobs
+	q.queued = make(map[string]struct{})
+	q.closed = true
+	q.UploadQueue.siadBytes -= q.siadBytes
+	q.siadBytes = 0
+	q.cond.Broadcast()
+	return dropped
+}
@@ -0,0 +1,258 @@
+package siasync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestUploadQueue verifies that the upload queue hands out files in order,
+// ignores duplicates and unblocks workers when closed.
+func TestUploadQueue(t *testing.T) {
+	q := newUploadQueue("fifo")
+	if !q.push("a") || !q.push("b") {
+		t.Fatal("new files should be added")
+	}
+	if q.push("a") {
+		t.Fatal("a file that is already queued shouldn't be added")
+	}
+
+	for _, expected := range []string{"a", "b"} {
+		job, ok := q.pop()
+		if !ok || job.file != expected {
+			t.Fatalf("expected %v, got %v", expected, job.file)
+		}
+		q.done(job)
+	}
+
+	// a file can be queued again once it was popped
+	q.push("a")
+	job, ok := q.pop()
+	if !ok || job.file != "a" {
+		t.Fatalf("expected a, got %v", job.file)
+	}
+	q.done(job)
+	q.wait()
+	if len(q.handedOut) != 0 {
+		t.Fatalf("hand outs shouldn't be recorded without a limit per hour, got %v", len(q.handedOut))
+	}
+
+	popped := make(chan bool)
+	go func() {
+		_, ok := q.pop()
+		popped <- ok
+	}()
+	select {
+	case <-popped:
+		t.Fatal("pop should block on an empty queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	q.push("c")
+	if dropped := q.close(); dropped > 1 {
+		t.Fatalf("expected at most 1 dropped file, got %v", dropped)
+	}
+	<-popped
+	q.push("d")
+	if _, ok := q.pop(); ok {
+		t.Fatal("pop should return false once the queue is closed")
+	}
+}
+
+// TestUploadQueueRetry verifies that a retried job is handed out after its
+// delay, and that jobs which are ready are handed out before it.
+func TestUploadQueueRetry(t *testing.T) {
+	q := newUploadQueue("fifo")
+	q.push("a")
+	job, _ := q.pop()
+	q.retry(job, 200*time.Millisecond)
+	q.done(job)
+	q.push("b")
+
+	start := time.Now()
+	job, ok := q.pop()
+	if !ok || job.file != "b" {
+		t.Fatalf("expected b, got %v", job.file)
+	}
+	q.done(job)
+	job, ok = q.pop()
+	if !ok || job.file != "a" || job.attempts != 1 {
+		t.Fatalf("expected a after 1 attempt, got %v after %v", job.file, job.attempts)
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Fatal("retried job was handed out before its delay")
+	}
+	q.done(job)
+	q.wait()
+}
+
+// TestUploadQueueOrder verifies that files are handed out in the upload
+// order, including files queued after others were handed out.
+func TestUploadQueueOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	files := map[string]struct {
+		size int
+		age  time.Duration
+	}{
+		"big":    {300, 3 * time.Hour},
+		"medium": {200, time.Hour},
+		"small":  {100, 2 * time.Hour},
+		"tie":    {100, 2 * time.Hour},
+		"late":   {50, 0},
+	}
+	for name, f := range files {
+		path := filepath.Join(dir, name)
+		err = ioutil.WriteFile(path, make([]byte, f.size), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(path, now.Add(-f.age), now.Add(-f.age))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]string{
+		"fifo":           "medium,big,small,tie,late",
+		"smallest-first": "small,late,tie,medium,big",
+		"largest-first":  "big,medium,small,tie,late",
+		"newest-first":   "medium,late,small,tie,big",
+	}
+	for order, expected := range tests {
+		q := newUploadQueue(order)
+		for _, name := range []string{"medium", "big", "small", "tie"} {
+			q.push(filepath.Join(dir, name))
+		}
+		var popped []string
+		for i := 0; i < len(files); i++ {
+			// late is queued after the first file was handed out
+			if i == 1 {
+				q.push(filepath.Join(dir, "late"))
+			}
+			job, _ := q.pop()
+			popped = append(popped, filepath.Base(job.file))
+			q.done(job)
+		}
+		if strings.Join(popped, ",") != expected {
+			t.Errorf("expected %v to hand out %v, got %v", order, expected, popped)
+		}
+	}
+}
+
+// TestUploadQueueLimits verifies that the queue stops handing out files once
+// the uploads per hour or the concurrent bytes are used up, and reports the
+// limit that holds them back.
+func TestUploadQueueLimits(t *testing.T) {
+	popped := func(q *uploadQueue) (uploadJob, bool) {
+		jobs := make(chan uploadJob, 1)
+		go func() {
+			if job, ok := q.pop(); ok {
+				jobs <- job
+			}
+		}()
+		select {
+		case job := <-jobs:
+			return job, true
+		case <-time.After(100 * time.Millisecond):
+			return uploadJob{}, false
+		}
+	}
+
+	q := newUploadQueue("fifo")
+	q.maxPerHour = 2
+	for _, file := range []string{"a", "b", "c"} {
+		q.push(file)
+	}
+	for _, expected := range []string{"a", "b"} {
+		job, ok := popped(q)
+		if !ok || job.file != expected {
+			t.Fatalf("expected %v, got %v", expected, job.file)
+		}
+		q.done(job)
+	}
+	if job, ok := popped(q); ok {
+		t.Fatalf("expected no file past the hourly limit, got %v", job.file)
+	}
+	if throttle := q.throttled(); throttle != "uploads per hour" {
+		t.Fatalf("expected the hourly limit to hold back c, got %q", throttle)
+	}
+	q.close()
+
+	// the first file is handed out even if it is larger than the limit
+	q = newUploadQueue("fifo")
+	q.maxBytes = 10
+	q.pushJob(uploadJob{file: "a", seq: 1, size: 20})
+	q.pushJob(uploadJob{file: "b", seq: 2, size: 5})
+	job, ok := popped(q)
+	if !ok || job.file != "a" {
+		t.Fatalf("expected a, got %v", job.file)
+	}
+	q.done(job)
+	jobs := make(chan uploadJob)
+	go func() {
+		job, _ := q.pop()
+		jobs <- job
+	}()
+	select {
+	case job := <-jobs:
+		t.Fatalf("expected no file while siad uploads a, got %v", job.file)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if throttle := q.throttled(); throttle != "concurrent bytes" {
+		t.Fatalf("expected the size limit to hold back b, got %q", throttle)
+	}
+
+	// b is handed out once siad has uploaded enough
+	q.setUploadingBytes(5)
+	select {
+	case job := <-jobs:
+		if job.file != "b" {
+			t.Fatalf("expected b, got %v", job.file)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("b should be handed out once siad uploaded a")
+	}
+	if throttle := q.throttled(); throttle != "" {
+		t.Fatalf("expected no limit, got %q", throttle)
+	}
+	q.close()
+
+	// files are queued but not handed out outside the upload window
+	now := time.Now().UTC()
+	start := (now.Hour()*60 + now.Minute() + 120) % (24 * 60)
+	q = newUploadQueue("fifo")
+	q.windows = []UploadWindow{{start, (start + 60) % (24 * 60)}}
+	q.location = time.UTC
+	q.push("a")
+	if job, ok := popped(q); ok {
+		t.Fatalf("expected no file outside the upload window, got %v", job.file)
+	}
+	if throttle := q.throttled(); throttle != windowThrottle {
+		t.Fatalf("expected the upload window to hold back a, got %q", throttle)
+	}
+	if q.len() != 1 {
+		t.Fatalf("expected a to stay queued, got %v queued files", q.len())
+	}
+	q.close()
+}
+
+// TestUploadBackoff verifies that the retry delay doubles up to the cap.
+func TestUploadBackoff(t *testing.T) {
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for attempts, delay := range expected {
+		if d := uploadBackoff(attempts); d != delay {
+			t.Errorf("expected %v after %v attempts, got %v", delay, attempts, d)
+		}
+	}
+	if d := uploadBackoff(100); d != maxRetryBackoff {
+		t.Errorf("expected the backoff to be capped at %v, got %v", maxRetryBackoff, d)
+	}
+}
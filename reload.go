@@ -0,0 +1,120 @@
+package siasync
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// Reload asks the SiaFolder to read its ignore file again and rescan the
+// directory with the new exclude patterns, including the ones set by
+// SetExcludePatterns. The queued uploads are kept. It doesn't wait for the
+// reload, which runs on the eventWatcher goroutine, and does nothing if the
+// directory isn't watched.
+func (sf *SiaFolder) Reload() {
+	select {
+	case sf.reloadChan <- struct{}{}:
+	default:
+		// a reload is already pending
+	}
+}
+
+// SetExcludePatterns replaces the exclude patterns given in Config. They are
+// applied by the next Reload.
+func (sf *SiaFolder) SetExcludePatterns(patterns []string) {
+	patterns = sf.withDefaultPatterns(patterns)
+	sf.patternsMu.Lock()
+	defer sf.patternsMu.Unlock()
+	sf.configExcludePatterns = patterns
+}
+
+// withDefaultPatterns returns the default exclude patterns, unless hidden
+// files are synced, followed by patterns.
+func (sf *SiaFolder) withDefaultPatterns(patterns []string) []string {
+	var all []string
+	if !sf.syncHidden {
+		all = append(all, defaultExcludePatterns...)
+	}
+	return append(all, patterns...)
+}
+
+// reload reads the ignore file again, applies the exclude patterns set by
+// SetExcludePatterns and logs the exclude patterns that were added or
+// removed. Files and directories that are excluded now stop being synced but
+// are left on Sia, like files that are excluded at startup, then the
+// directory is rescanned to pick up the files that aren't excluded anymore.
+func (sf *SiaFolder) reload() {
+	log.WithFields(logrus.Fields{
+		"directory": sf.path,
+	}).Info("Reloading configuration")
+
+	ignorePatterns, err := readIgnoreFile(sf.path)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error reading ignore file, keeping the current exclude patterns")
+	} else {
+		sf.patternsMu.Lock()
+		patterns := append(append([]string{}, sf.configExcludePatterns...), ignorePatterns...)
+		added, removed := diffStrings(sf.excludePatterns, patterns)
+		sf.excludePatterns = patterns
+		sf.patternsMu.Unlock()
+		if len(added) > 0 || len(removed) > 0 {
+			log.WithFields(logrus.Fields{
+				"added":   added,
+				"removed": removed,
+			}).Info("Exclude patterns changed")
+		}
+		sf.dropExcluded()
+	}
+
+	sf.rescan()
+}
+
+// dropExcluded stops watching the directories and tracking the files that
+// match the current exclude patterns. Nothing is changed on Sia.
+func (sf *SiaFolder) dropExcluded() {
+	sf.mu.Lock()
+	var dirs []string
+	for dir := range sf.dirs {
+		dirs = append(dirs, dir)
+	}
+	sf.mu.Unlock()
+	for _, dir := range dirs {
+		if !sf.isExcluded(dir) {
+			continue
+		}
+		sf.mu.Lock()
+		if sf.dirs[dir].watched {
+			sf.watcher.Remove(dir)
+		}
+		delete(sf.dirs, dir)
+		sf.mu.Unlock()
+	}
+
+	for _, file := range sf.trackedFiles() {
+		if !sf.isExcluded(file) {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"file": file,
+		}).Info("File is excluded now, no longer syncing it")
+		delete(sf.pending, file)
+		delete(sf.renamed, file)
+		sf.untrackFile(file)
+	}
+}
+
+// diffStrings returns the strings in b that aren't in a, and the strings in a
+// that aren't in b.
+func diffStrings(a, b []string) (added, removed []string) {
+	for _, s := range b {
+		if !contains(a, s) {
+			added = append(added, s)
+		}
+	}
+	for _, s := range a {
+		if !contains(b, s) {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
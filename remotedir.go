@@ -0,0 +1,199 @@
+package siasync
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/modules"
+)
+
+// emptyDirCheckInterval is how often removeEmptyDirs looks for empty
+// directories on Sia.
+const emptyDirCheckInterval = 10 * time.Minute
+
+// dirState is what a SiaFolder knows about a watched subdirectory.
+type dirState struct {
+	// watched is whether the watcher accepted the directory, the others are
+	// polled.
+	watched bool
+
+	// created is whether siasync created the directory on Sia, rather than
+	// finding it there already. Only those are removed from Sia again.
+	created bool
+}
+
+// dirSiaPath returns the SiaPath of a local subdirectory.
+func (sf *SiaFolder) dirSiaPath(dir string) (modules.SiaPath, error) {
+	relpath, err := filepath.Rel(sf.path, dir)
+	if err != nil {
+		return modules.SiaPath{}, err
+	}
+	return sf.getSiaPath(relpath)
+}
+
+// createRemoteDir creates the directory on Sia of a local subdirectory, so
+// that it exists even while the local one is empty. It returns whether
+// siasync created it, false if it existed already or couldn't be created.
+// With auto categorization a local directory has no single category folder
+// on Sia, so directories are only created by the files uploaded into them.
+func (sf *SiaFolder) createRemoteDir(dir string) bool {
+	if sf.dryRun || sf.categoryPattern != nil {
+		return false
+	}
+	siaPath, err := sf.dirSiaPath(dir)
+	if err == nil {
+		err = sf.client.RenterDirCreatePost(siaPath)
+		sf.audit(AuditRecord{Op: auditCreateDir, Path: dir, SiaPath: siaPath.String()}, err)
+	}
+	if err != nil {
+		if !strings.Contains(err.Error(), errDirExists.Error()) {
+			log.WithFields(logrus.Fields{
+				"directory": dir,
+				"error":     err.Error(),
+			}).Error("Error creating directory on Sia")
+		}
+		return false
+	}
+	log.WithFields(logrus.Fields{
+		"directory": dir,
+		"siapath":   siaPath.String(),
+	}).Debug("Created directory on Sia")
+	return true
+}
+
+// removeRemoteDirs removes the directories on Sia of local subdirectories that
+// were removed, deepest first so that parents are empty by the time they are
+// checked. Directories that still hold files or other directories are kept,
+// siad would delete them recursively.
+func (sf *SiaFolder) removeRemoteDirs(dirs []string) {
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		siaPath, err := sf.dirSiaPath(dir)
+		if err != nil || !sf.remoteDirEmpty(siaPath) {
+			continue
+		}
+		err = sf.client.RenterDirDeletePost(siaPath)
+		sf.audit(AuditRecord{Op: auditDeleteDir, Path: dir, SiaPath: siaPath.String()}, err)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"directory": dir,
+				"error":     err.Error(),
+			}).Error("Error removing directory from Sia")
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"directory": dir,
+			"siapath":   siaPath.String(),
+		}).Debug("Removed empty directory from Sia")
+	}
+}
+
+// remoteDirEmpty reports whether the directory on Sia exists and holds no
+// files or directories. siad removes directories recursively, so this is
+// checked right before removing one.
+func (sf *SiaFolder) remoteDirEmpty(siaPath modules.SiaPath) bool {
+	siaDir, err := sf.client.RenterGetDir(siaPath)
+	if err != nil {
+		return false
+	}
+	for _, subdir := range siaDir.Directories {
+		// siad lists the requested directory itself first
+		if !subdir.SiaPath.Equals(siaPath) {
+			return false
+		}
+	}
+	return len(siaDir.Files) == 0
+}
+
+// keptDirs returns the siapaths of the directories on Sia that removeEmptyDirs
+// must keep even when they are empty: the top-level category folders and the
+// directories of local subdirectories, which mirror empty local ones.
+func (sf *SiaFolder) keptDirs() map[string]bool {
+	var categories []string
+	if sf.categoryPattern != nil {
+		categories = append(categories, tvCategory, sf.categoryDefault)
+	}
+	for category := range sf.categories {
+		categories = append(categories, category)
+	}
+	kept := make(map[string]bool)
+	for _, category := range categories {
+		if siaPath, err := sf.rootSiaPath(category); err == nil {
+			kept[siaPath.String()] = true
+		}
+	}
+
+	sf.mu.Lock()
+	var dirs []string
+	for dir := range sf.dirs {
+		dirs = append(dirs, dir)
+	}
+	sf.mu.Unlock()
+	for _, dir := range dirs {
+		if siaPath, err := sf.dirSiaPath(dir); err == nil {
+			kept[siaPath.String()] = true
+		}
+	}
+	return kept
+}
+
+// removeEmptyDirs removes the directories below the prefix on Sia that hold
+// no files or directories and haven't changed for emptyDirGrace, which
+// deleted and renamed files leave behind. A directory whose subdirectories
+// were removed is removed by a later run, once it stayed empty for the grace
+// period too. Nothing is removed in a dry run, while paused, before the
+// initial sync is done, or if the prefix is the root of Sia, which siasync
+// doesn't own. It runs on the eventWatcher goroutine.
+func (sf *SiaFolder) removeEmptyDirs() {
+	if sf.dryRun || sf.Paused() || !sf.Ready() || sf.prefix == "" {
+		return
+	}
+	root, err := newSiaPath(sf.prefix)
+	if err != nil {
+		return
+	}
+	kept := sf.keptDirs()
+	now := time.Now()
+	dirs := []modules.SiaPath{root}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		siaDir, err := sf.client.RenterGetDir(dir)
+		if err != nil {
+			if !strings.Contains(err.Error(), errNoFiles.Error()) {
+				log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Error("Error listing directories to remove empty ones")
+			}
+			return
+		}
+		for _, subdir := range siaDir.Directories {
+			// siad lists the requested directory itself first
+			if subdir.SiaPath.Equals(dir) {
+				continue
+			}
+			if subdir.NumFiles > 0 || subdir.NumSubDirs > 0 {
+				dirs = append(dirs, subdir.SiaPath)
+				continue
+			}
+			if kept[subdir.SiaPath.String()] || now.Sub(subdir.MostRecentModTime) < sf.emptyDirGrace || !sf.remoteDirEmpty(subdir.SiaPath) {
+				continue
+			}
+			err = sf.client.RenterDirDeletePost(subdir.SiaPath)
+			sf.audit(AuditRecord{Op: auditDeleteDir, SiaPath: subdir.SiaPath.String()}, err)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"siapath": subdir.SiaPath.String(),
+					"error":   err.Error(),
+				}).Error("Error removing empty directory from Sia")
+				continue
+			}
+			log.WithFields(logrus.Fields{
+				"siapath": subdir.SiaPath.String(),
+			}).Info("Removed empty directory from Sia")
+		}
+	}
+}
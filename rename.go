@@ -0,0 +1,177 @@
+package siasync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// renameWindow is how long a file that was renamed away is remembered so that
+// it can be paired with the CREATE event of its new name.
+const renameWindow = 2 * time.Second
+
+// renamedFile is a tracked file that received a RENAME event and has not been
+// paired with a CREATE event yet.
+type renamedFile struct {
+	fs fileState
+	at time.Time
+}
+
+// deferRename remembers a tracked file that was renamed away so that a
+// following CREATE event with the same content can be turned into a remote
+// rename. It returns false if the file isn't tracked.
+func (sf *SiaFolder) deferRename(filename string) bool {
+	fs, exists := sf.trackedFile(filename)
+	if !exists {
+		return false
+	}
+	sf.renamed[filename] = renamedFile{
+		fs: fs,
+		at: time.Now(),
+	}
+	return true
+}
+
+// matchRename returns the old path of a recently renamed file with the same
+// size and modification time as filename, which a rename keeps. Empty files
+// are never matched, every empty file looks the same and uploading one again
+// costs nothing. filename is only checksummed if several renamed files match,
+// as it runs on the eventWatcher goroutine and hashing a large file holds up
+// every event. Of the files with the same checksum, one with the same name is
+// preferred, otherwise the most recently renamed one.
+func (sf *SiaFolder) matchRename(filename string) (string, bool) {
+	if len(sf.renamed) == 0 {
+		return "", false
+	}
+	stat, err := os.Stat(filename)
+	if err != nil || stat.Size() == 0 {
+		return "", false
+	}
+
+	var candidates []string
+	for oldname, rf := range sf.renamed {
+		if rf.fs.Size == stat.Size() && rf.fs.ModTime.Equal(stat.ModTime()) {
+			candidates = append(candidates, oldname)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	if len(candidates) == 1 {
+		return candidates[0], true
+	}
+
+	checksum, err := sf.checksumFile(filename)
+	if err != nil {
+		return "", false
+	}
+	var match string
+	var matchedAt time.Time
+	for _, oldname := range candidates {
+		rf := sf.renamed[oldname]
+		if rf.fs.Checksum != checksum {
+			continue
+		}
+		if filepath.Base(oldname) == filepath.Base(filename) {
+			return oldname, true
+		}
+		if match == "" || rf.at.After(matchedAt) {
+			match, matchedAt = oldname, rf.at
+		}
+	}
+	return match, match != ""
+}
+
+// pairRename renames the remote file of a file that was just renamed away to
+// filename, if filename has its content, and reports whether it did. While
+// paused nothing is paired, the old name is removed once resumed and the file
+// uploaded again. If the remote rename fails the old name is handled as
+// removed and false is returned, so that the file is uploaded again.
+func (sf *SiaFolder) pairRename(filename string) bool {
+	oldname, ok := sf.matchRename(filename)
+	if !ok || sf.Paused() {
+		return false
+	}
+	err := sf.handleRename(oldname, filename)
+	if err == nil {
+		return true
+	}
+	log.WithFields(logrus.Fields{
+		"error": err.Error(),
+	}).Error("Error with handleRename, uploading the file again")
+	delete(sf.renamed, oldname)
+	sf.handleRemoved(oldname)
+	return false
+}
+
+// handleRename renames the remote file of oldname, and its earlier versions in
+// archive mode, to match filename and moves its entry in the files map.
+func (sf *SiaFolder) handleRename(oldname, filename string) error {
+	oldRelpath, err := filepath.Rel(sf.path, oldname)
+	if err != nil {
+		return fmt.Errorf("error getting relative path to rename: %v", err)
+	}
+	relpath, err := filepath.Rel(sf.path, filename)
+	if err != nil {
+		return fmt.Errorf("error getting relative path to rename: %v", err)
+	}
+	fs, _ := sf.trackedFile(oldname)
+	oldSiaPath, err := sf.uploadedSiaPath(oldRelpath, fs)
+	if err != nil {
+		return err
+	}
+	siaPath, err := sf.uploadedSiaPath(relpath, fs)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		"from": oldname,
+		"to":   filename,
+	}).Debug("File rename detected, renaming file")
+
+	// a duplicate isn't on Sia, its new name shares the original's upload
+	if fs.DuplicateOf != "" {
+		log.WithFields(logrus.Fields{
+			"from": oldname,
+			"to":   filename,
+		}).Debug("Renamed file is a duplicate, nothing to rename on Sia")
+	} else if !sf.dryRun {
+		err = sf.client.RenterRenamePost(oldSiaPath, siaPath)
+		sf.audit(AuditRecord{Op: auditRename, Path: filename, SiaPath: oldSiaPath.String(), To: siaPath.String(), Size: fs.Size, Checksum: fs.Checksum}, err)
+		sf.listing.invalidate()
+		if err != nil {
+			return fmt.Errorf("error renaming %v to %v: %v", oldname, filename, err)
+		}
+		sf.renameVersions(filename, oldRelpath, relpath, fs)
+		log.WithFields(logrus.Fields{
+			"event":   "rename",
+			"file":    filename,
+			"from":    oldSiaPath.String(),
+			"siapath": siaPath.String(),
+		}).Info("Renamed file")
+		sf.stats.update(func(s *Stats) { s.Renamed++ })
+	} else {
+		sf.plan.rename(oldSiaPath.String(), siaPath.String())
+		sf.audit(AuditRecord{Op: auditRename, Path: filename, SiaPath: oldSiaPath.String(), To: siaPath.String(), Size: fs.Size, Checksum: fs.Checksum}, nil)
+	}
+
+	delete(sf.renamed, oldname)
+	sf.moveFile(oldname, filename)
+	return nil
+}
+
+// expireRenames handles the files that were renamed away longer than
+// renameWindow ago without a matching CREATE event as removed.
+func (sf *SiaFolder) expireRenames() {
+	for filename, rf := range sf.renamed {
+		if time.Since(rf.at) < renameWindow {
+			continue
+		}
+		delete(sf.renamed, filename)
+		sf.handleRemoved(filename)
+	}
+}
@@ -0,0 +1,141 @@
+package siasync
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// rescan walks the synced directory again and handles every difference to the
+// tracked files, then reconciles Sia with the tracked files. It catches up on
+// events that fsnotify dropped and runs on the eventWatcher goroutine, so it
+// never races with the handling of an event. Until the initial sync is done
+// there is nothing to catch up on.
+func (sf *SiaFolder) rescan() {
+	if !sf.Ready() {
+		return
+	}
+	log.Debug("Rescanning directory")
+	if !sf.scanChanges(sf.path) {
+		return
+	}
+
+	// Sia may have changed too
+	sf.listing.invalidate()
+	err := sf.reconcile()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error reconciling files after rescanning")
+	}
+	sf.saveStateLogged()
+}
+
+// scanChanges walks root, the synced directory or one of its subdirectories,
+// and handles every difference to the tracked files below it as if its event
+// had been received. Unchanged files are recognized by their size and
+// modification time without reading them. It returns false if root could not
+// be walked, or if the synced directory is offline and everything below it
+// would seem to be removed.
+func (sf *SiaFolder) scanChanges(root string) bool {
+	if sf.sourceOffline() || !sf.sourcePresent() {
+		return false
+	}
+	below := func(path string) bool {
+		return root == sf.path || path == root || isWithin(root, path)
+	}
+
+	seen := make(map[string]struct{})
+	err := filepath.Walk(root, func(walkpath string, f os.FileInfo, err error) error {
+		if err != nil {
+			// files removed during the walk are handled below
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if sf.strict || walkpath == root {
+				return err
+			}
+			seen[walkpath] = struct{}{}
+			sf.skipFile(walkpath, err, "Skipping path that can't be read")
+			return nil
+		}
+		if walkpath == sf.path {
+			return nil
+		}
+		walkpath = sf.composedPath(walkpath)
+		if sf.isExcluded(walkpath) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		seen[walkpath] = struct{}{}
+		if f.IsDir() {
+			if !sf.isWatchedDir(walkpath) {
+				sf.watchDir(walkpath)
+			}
+			return nil
+		}
+
+		goodForWrite, err := sf.checkFile(walkpath)
+		if err != nil {
+			return err
+		}
+		if !goodForWrite || !sf.checkName(walkpath) {
+			return nil
+		}
+		if _, pending := sf.pending[walkpath]; pending {
+			return nil
+		}
+		op := fsnotify.Create
+		if fs, tracked := sf.trackedFile(walkpath); tracked {
+			if fs.unchanged(f) {
+				return nil
+			}
+			op = fsnotify.Write
+		}
+		if sf.settleDuration > 0 {
+			sf.deferEvent(walkpath, op)
+		} else {
+			sf.handleChange(walkpath, op)
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error rescanning directory")
+		return false
+	}
+
+	// directories and files that are gone, files and directories renamed
+	// away are still waiting for their new name. Skipped files that are gone are forgotten.
+	sf.mu.Lock()
+	var removedDirs []string
+	for dir := range sf.dirs {
+		if _, ok := seen[dir]; !ok && below(dir) {
+			removedDirs = append(removedDirs, dir)
+		}
+	}
+	for file := range sf.skipped {
+		if _, ok := seen[file]; !ok && below(file) {
+			delete(sf.skipped, file)
+		}
+	}
+	sf.mu.Unlock()
+	for _, dir := range removedDirs {
+		if sf.isWatchedDir(dir) {
+			sf.handleDirRemoved(dir)
+		}
+	}
+	for _, file := range sf.trackedFiles() {
+		_, ok := seen[file]
+		_, renamed := sf.renamed[file]
+		if !ok && !renamed && !sf.inRenamedDir(file) && below(file) {
+			sf.handleRemoved(file)
+		}
+	}
+	return true
+}
@@ -0,0 +1,327 @@
+package siasync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/modules"
+)
+
+// restoreSuffix is appended to the name of a file while it is downloaded, so
+// that an interrupted restore never leaves a partial file under the real name.
+const restoreSuffix = ".siasync-download"
+
+// Restore downloads every file below the configured prefix on Sia into the
+// directory at path, preserving their paths relative to the prefix. Files that already
+// exist with the size of the file on Sia are skipped, so an interrupted
+// Restore can be resumed by running it again. If there is a manifest on Sia,
+// existing and downloaded files must match its sha256 checksums too, and the
+// duplicates it records are copied from their restored original. Of a file
+// with several versions in the manifest the latest version is restored under
+// the file's name, or the one current at config.RestoreAt. The modification
+// time, permissions and owner recorded with -preserve-metadata are applied to
+// the restored files.
+// concurrency is the number of files downloaded at the same time.
+func Restore(client SiaClient, path string, config Config, concurrency int) error {
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	sf := &SiaFolder{
+		path:   abspath,
+		client: client,
+		prefix: siaPathString(config.Prefix, "", isWindows),
+		dryRun: config.DryRun,
+	}
+	if config.AutoCategorize {
+		sf.categoryPattern, err = CompileCategoryPattern(config.CategoryPattern)
+		if err != nil {
+			return err
+		}
+		sf.categoryDefault = config.CategoryDefault
+		if sf.categoryDefault == "" {
+			sf.categoryDefault = DefaultCategory
+		}
+	}
+	renterFiles, err := sf.getSiaFiles()
+	if err != nil && !strings.Contains(err.Error(), errNoFiles.Error()) {
+		return err
+	}
+	m, err := sf.downloadManifest()
+	if err != nil {
+		return err
+	}
+
+	jobs, err := sf.restoreJobs(renterFiles, m, config.RestoreAt)
+	if err != nil {
+		return err
+	}
+
+	files := make(chan restoreJob)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range files {
+				err := sf.restoreFile(job)
+				if err != nil {
+					log.WithFields(logrus.Fields{
+						"siapath": job.fi.SiaPath.String(),
+						"error":   err.Error(),
+					}).Error("Error restoring file")
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		files <- job
+	}
+	close(files)
+	wg.Wait()
+
+	// duplicates aren't on Sia, they are copied from their restored original
+	duplicates := 0
+	if m != nil {
+		for relpath, entry := range m.Files {
+			if entry.DuplicateOf == "" || !config.RestoreAt.IsZero() && entry.Uploaded.After(config.RestoreAt) {
+				continue
+			}
+			duplicates++
+			err := sf.restoreDuplicate(relpath, entry, m)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"file":  relpath,
+					"error": err.Error(),
+				}).Error("Error restoring duplicate file")
+				failed++
+			}
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"files":      len(jobs),
+		"duplicates": duplicates,
+		"failed":     failed,
+	}).Info("Restore finished")
+	if failed > 0 {
+		return errors.New("some files could not be restored")
+	}
+	return nil
+}
+
+// restoreJob is a file on Sia that restore downloads, the version of the
+// local file at relpath that was uploaded at uploaded. checksum is its sha256
+// checksum and metadata its metadata in the manifest, if known. Only the
+// current version of a file has metadata.
+type restoreJob struct {
+	fi       modules.FileInfo
+	relpath  string
+	version  int
+	checksum string
+	uploaded time.Time
+	metadata *fileMetadata
+}
+
+// restoreJobs returns the files on Sia that restore downloads. Only one
+// version of a file is restored, the latest one, or with a non-zero at the
+// latest one uploaded at or before at. Files only uploaded after at are left
+// out. Versions are told apart from other files by the manifest, without one
+// every file on Sia is restored.
+func (sf *SiaFolder) restoreJobs(renterFiles map[modules.SiaPath]modules.FileInfo, m *manifest, at time.Time) ([]restoreJob, error) {
+	root, err := newSiaPath(sf.prefix)
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string][]restoreJob)
+	for _, fi := range renterFiles {
+		relpath := sf.uncategorize(strings.TrimPrefix(fi.SiaPath.String(), root.String()+"/"))
+		job := restoreJob{fi: fi, relpath: relpath, version: 1, uploaded: fi.CreateTime}
+		if m != nil {
+			if file, version, ok := m.versionOf(relpath); ok {
+				job.relpath, job.version = file, version
+			} else if entry, ok := m.Files[relpath]; ok && entry.Version > 1 {
+				job.version = entry.Version
+			}
+			entry := m.Files[job.relpath]
+			if v, ok := entry.version(job.version); ok {
+				job.checksum = v.SHA256
+				if !v.Uploaded.IsZero() {
+					job.uploaded = v.Uploaded
+				}
+			}
+			if job.version <= 1 && entry.Version <= 1 || job.version == entry.Version {
+				job.metadata = entry.Metadata
+			}
+		}
+		versions[job.relpath] = append(versions[job.relpath], job)
+	}
+
+	jobs := make([]restoreJob, 0, len(versions))
+	for relpath, candidates := range versions {
+		found := false
+		var pick restoreJob
+		for _, job := range candidates {
+			if !at.IsZero() && job.uploaded.After(at) {
+				continue
+			}
+			if !found || job.version > pick.version {
+				pick, found = job, true
+			}
+		}
+		if !found {
+			log.WithFields(logrus.Fields{
+				"file": relpath,
+			}).Debug("Skipping file, uploaded after the restored time")
+			continue
+		}
+		jobs = append(jobs, pick)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].relpath < jobs[j].relpath
+	})
+	return jobs, nil
+}
+
+// ParseRestoreTime parses the time given with -restore-at, either a date in
+// local time or an RFC 3339 time. An empty string is the zero time.
+func ParseRestoreTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		// the whole day
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a date like 2006-01-02 nor a time like 2006-01-02T15:04:05Z07:00", s)
+	}
+	return t, nil
+}
+
+// restoreFile downloads a single file from Sia into the restored directory
+// unless it is already there.
+func (sf *SiaFolder) restoreFile(job restoreJob) error {
+	fi, checksum := job.fi, job.checksum
+	file := filepath.Join(sf.path, filepath.FromSlash(job.relpath))
+
+	if stat, err := os.Stat(file); err == nil && uint64(stat.Size()) == fi.Filesize && matchesChecksum(file, checksum) {
+		log.WithFields(logrus.Fields{
+			"file": file,
+		}).Debug("Skipping file, already restored")
+		if sf.dryRun {
+			return nil
+		}
+		return applyMetadata(file, job.metadata)
+	}
+
+	log.WithFields(logrus.Fields{
+		"file":    file,
+		"version": job.version,
+	}).Info("Restoring file")
+	if sf.dryRun {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	tmpFile := file + restoreSuffix
+	err = sf.client.RenterDownloadFullGet(fi.SiaPath, tmpFile, false)
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	stat, err := os.Stat(tmpFile)
+	if err != nil {
+		return err
+	}
+	if uint64(stat.Size()) != fi.Filesize {
+		os.Remove(tmpFile)
+		return fmt.Errorf("downloaded %v bytes of %v, expected %v", stat.Size(), file, fi.Filesize)
+	}
+	if !matchesChecksum(tmpFile, checksum) {
+		os.Remove(tmpFile)
+		return fmt.Errorf("downloaded %v doesn't match its checksum in the manifest", file)
+	}
+	err = applyMetadata(tmpFile, job.metadata)
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, file)
+}
+
+// restoreDuplicate copies the restored original of a duplicate file in the
+// manifest to the duplicate's path, unless it is already there.
+func (sf *SiaFolder) restoreDuplicate(relpath string, entry manifestEntry, m *manifest) error {
+	file := filepath.Join(sf.path, filepath.FromSlash(relpath))
+	if stat, err := os.Stat(file); err == nil && stat.Size() == entry.Size && matchesChecksum(file, entry.SHA256) {
+		log.WithFields(logrus.Fields{
+			"file": file,
+		}).Debug("Skipping duplicate file, already restored")
+		if sf.dryRun {
+			return nil
+		}
+		return applyMetadata(file, entry.Metadata)
+	}
+
+	original := filepath.Join(sf.path, filepath.FromSlash(entry.DuplicateOf))
+	log.WithFields(logrus.Fields{
+		"file":     file,
+		"original": original,
+	}).Info("Restoring duplicate file")
+	if sf.dryRun {
+		return nil
+	}
+	if !matchesChecksum(original, m.Files[entry.DuplicateOf].SHA256) {
+		return fmt.Errorf("original %v wasn't restored", original)
+	}
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	tmpFile := file + restoreSuffix
+	os.Remove(tmpFile)
+	err = copyFile(original, tmpFile)
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	if !matchesChecksum(tmpFile, entry.SHA256) {
+		os.Remove(tmpFile)
+		return fmt.Errorf("copy of %v doesn't match its checksum in the manifest", file)
+	}
+	err = applyMetadata(tmpFile, entry.Metadata)
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, file)
+}
+
+// matchesChecksum reports whether the sha256 checksum of file is checksum. Any
+// file matches an empty checksum.
+func matchesChecksum(file, checksum string) bool {
+	if checksum == "" {
+		return true
+	}
+	actual, err := sha256File(file)
+	return err == nil && actual == checksum
+}
@@ -0,0 +1,120 @@
+package siasync
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// scan walks the synced directory once at startup, adding every subdirectory
+// to the watcher and tracking every file that isn't filtered out. Files that
+// are unchanged since previousState keep their state, the others are
+// checksummed by up to workers goroutines at the same time. The walk itself
+// stays on the calling goroutine, so every directory is watched once scan
+// returns. Files and directories that can't be read are skipped, unless the
+// SiaFolder is strict, in which case the first error is returned.
+func (sf *SiaFolder) scan(previousState map[string]fileState, workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		wg      sync.WaitGroup
+		errMu   sync.Mutex
+		scanErr error
+	)
+	scanFailed := func() error {
+		errMu.Lock()
+		defer errMu.Unlock()
+		return scanErr
+	}
+	paths := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if scanFailed() != nil {
+					continue
+				}
+				log.WithFields(logrus.Fields{
+					"file": path,
+				}).Debug("Calculating checksum for file")
+				fs, err := sf.statFile(path)
+				if err != nil && !sf.strict {
+					sf.skipFile(path, err, "Skipping file that can't be read")
+					continue
+				}
+				if err != nil {
+					errMu.Lock()
+					if scanErr == nil {
+						scanErr = err
+					}
+					errMu.Unlock()
+					continue
+				}
+				sf.trackFile(path, fs)
+			}
+		}()
+	}
+
+	err := filepath.Walk(sf.path, func(walkpath string, f os.FileInfo, err error) error {
+		if err != nil && (sf.strict || walkpath == sf.path) {
+			return err
+		}
+		if err != nil {
+			sf.skipFile(walkpath, err, "Skipping path that can't be read")
+			return nil
+		}
+		if err := scanFailed(); err != nil {
+			return err
+		}
+		if walkpath == sf.path {
+			return nil
+		}
+		walkpath = sf.composedPath(walkpath)
+
+		// Skip excluded files and directories entirely
+		if rule := sf.excludedBy(walkpath); rule != "" {
+			log.WithFields(logrus.Fields{
+				"path": walkpath,
+				"rule": rule,
+			}).Debug("Skipping excluded path")
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Check if a Directory was found
+		if f.IsDir() {
+			// subdirectories must be added to the watcher.
+			sf.watchDir(walkpath)
+			return nil
+		}
+
+		// File Found, skip it if its extension is filtered out
+		goodForWrite, err := sf.checkFile(walkpath)
+		if err != nil {
+			return err
+		}
+		if !goodForWrite || !sf.checkName(walkpath) {
+			return nil
+		}
+		sf.stats.update(func(s *Stats) { s.Scanned++ })
+		if fs, ok := previousState[sf.fileKey(walkpath)]; ok && fs.unchanged(f) {
+			sf.trackFile(walkpath, fs)
+			return nil
+		}
+		paths <- walkpath
+		return nil
+	})
+	close(paths)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	return scanFailed()
+}
@@ -0,0 +1,84 @@
+package siasync
+
+import (
+	"os"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pendingEvent is a CREATE or WRITE event waiting for its file to settle.
+type pendingEvent struct {
+	op      fsnotify.Op
+	size    int64
+	modTime time.Time
+	changed time.Time // changed is when the size or modification time last changed
+}
+
+// minSettleDuration is how long a file must stop changing before it is
+// uploaded without a settle duration. The CREATE and WRITE events of a file
+// that is created and written to right away are coalesced in that time, so
+// that it is uploaded once with its content rather than empty first.
+const minSettleDuration = 100 * time.Millisecond
+
+// settleCheckInterval returns how often pending events are checked for the
+// given settle duration.
+func settleCheckInterval(settle time.Duration) time.Duration {
+	interval := settle / 4
+	if interval > time.Second {
+		interval = time.Second
+	}
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	return interval
+}
+
+// deferEvent records a CREATE or WRITE event for a file. Events for a file
+// that already has a pending event are coalesced into it.
+func (sf *SiaFolder) deferEvent(filename string, op fsnotify.Op) {
+	pe, exists := sf.pending[filename]
+	if !exists {
+		pe = &pendingEvent{}
+		sf.pending[filename] = pe
+	}
+	pe.op |= op
+	pe.changed = time.Now()
+	if stat, err := os.Stat(filename); err == nil {
+		pe.size = stat.Size()
+		pe.modTime = stat.ModTime()
+	}
+}
+
+// processSettled handles the pending events of every file whose size and
+// modification time have not changed for the settle duration.
+func (sf *SiaFolder) processSettled() {
+	settle := sf.settleDuration
+	if settle < minSettleDuration {
+		settle = minSettleDuration
+	}
+	now := time.Now()
+	for filename, pe := range sf.pending {
+		stat, err := os.Stat(filename)
+		if err != nil {
+			// the file is gone, its REMOVE event takes care of it
+			delete(sf.pending, filename)
+			continue
+		}
+		if stat.Size() != pe.size || !stat.ModTime().Equal(pe.modTime) {
+			pe.size = stat.Size()
+			pe.modTime = stat.ModTime()
+			pe.changed = now
+			continue
+		}
+		if now.Sub(pe.changed) < settle {
+			continue
+		}
+
+		delete(sf.pending, filename)
+		if pe.op&fsnotify.Create == fsnotify.Create && sf.pairRename(filename) {
+			continue
+		}
+		sf.handleChange(filename, pe.op)
+	}
+}
@@ -1,41 +1,385 @@
-package main
+// Package siasync syncs local directories to Sia. A SiaFolder uploads the
+// files of a directory to a folder on Sia and keeps them in sync as they
+// change, the siasync command in cmd/siasync runs one for every synced
+// directory.
+package siasync
 
 import (
+	"context"
 	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
 	"gitlab.com/NebulousLabs/Sia/modules"
 	"gitlab.com/NebulousLabs/Sia/modules/renter/siafile"
-
-	sia "gitlab.com/NebulousLabs/Sia/node/api/client"
+	"gitlab.com/NebulousLabs/Sia/node/api"
 )
 
 var (
+	// ChangeDetectionModes are the supported ways of detecting that a file
+	// has changed.
+	ChangeDetectionModes = []string{"sha256", "size", "mtime"}
+
 	// errNoFiles is the error that will be returned if the siasync directory on
 	// the Sia network has not been created yet by the first upload.
 	errNoFiles = errors.New("no such file or directory")
+
+	// errDirExists is the error siad returns when creating a directory that
+	// already exists on Sia.
+	errDirExists = errors.New("a siadir already exists at that location")
+
+	// errInitialSyncClosed is returned by WaitInitialSync if the SiaFolder
+	// was closed before the initial sync was done.
+	errInitialSyncClosed = errors.New("closed before the initial sync was done")
+
+	// errEmptyFile is returned when siad rejects the upload of an empty
+	// file, which older versions of siad do. Retrying doesn't help, the file
+	// is uploaded once it has content.
+	errEmptyFile = errors.New("siad doesn't accept empty files, use -min-file-size 1 to skip them until they have content")
+
+	// errNotEnoughContracts is returned when the renter rejects an upload
+	// because it doesn't have the contracts the erasure coding needs.
+	errNotEnoughContracts = errors.New("the renter doesn't have enough contracts for the erasure coding")
+
+	// errUploadingContent is returned when another file with the same
+	// content is being uploaded, the file is tried again once it is done to
+	// find out whether it is a duplicate.
+	errUploadingContent = errors.New("a file with the same content is being uploaded")
+
+	// retryBackoff is how long to wait before retrying a failed upload for
+	// the first time. The delay doubles with every failed attempt up to
+	// maxRetryBackoff.
+	retryBackoff    = time.Second
+	maxRetryBackoff = 5 * time.Minute
 )
 
+// SiaClient is the part of the Sia API client used by a SiaFolder. It is
+// satisfied by *client.Client.
+type SiaClient interface {
+	DaemonVersionGet() (api.DaemonVersionGet, error)
+	RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error
+	RenterDeletePost(siaPath modules.SiaPath) error
+	RenterFileGet(siaPath modules.SiaPath) (api.RenterFile, error)
+	RenterGetDir(siaPath modules.SiaPath) (api.RenterDirectory, error)
+	RenterRenamePost(siaPathOld, siaPathNew modules.SiaPath) error
+	RenterDirCreatePost(siaPath modules.SiaPath) error
+	RenterDirDeletePost(siaPath modules.SiaPath) error
+	RenterDownloadFullGet(siaPath modules.SiaPath, destination string, async bool) error
+}
+
 // SiaFolder is a folder that is synchronized to a Sia node.
 type SiaFolder struct {
 	path    string
-	client  *sia.Client
+	client  SiaClient
 	archive bool
 	prefix  string
 	watcher *fsnotify.Watcher
 
-	files map[string]string // files is a map of file paths to SHA256 checksums, used to reconcile file changes
-
-	closeChan chan struct{}
+	// maxVersions is the number of versions of a file kept on Sia in
+	// archive mode, 0 is unlimited.
+	maxVersions int
+
+	// singleFile is set if only this file in path is synced, everything
+	// else in path is excluded.
+	singleFile string
+
+	// events holds the events readEvents drained from the watcher until
+	// eventWatcher handles them. checks is the queue of changed files the
+	// checkWorkers check workers checksum, which hand them back to
+	// eventWatcher on checked. Without eventWatcher there are none.
+	events       *eventQueue
+	checks       *checkQueue
+	checkWorkers int
+	checked      chan checkedFile
+
+	// dataPieces and parityPieces are the erasure coding parameters used
+	// when uploading files to Sia.
+	dataPieces   uint64
+	parityPieces uint64
+	categories   map[string]CategorySettings
+
+	// auditSink receives a record of every change made to Sia.
+	auditSink AuditSink
+
+	// spending, if set, tracks the spending of the renter and pauses
+	// uploads while the allowance is low.
+	spending *SpendingTracker
+
+	// api, if set, counts the API calls of siasync as a whole.
+	api *LimitedClient
+
+	// emptyDirGrace is how long a directory on Sia must have been empty
+	// before removeEmptyDirs removes it, 0 disables it.
+	emptyDirGrace time.Duration
+
+	// settleDuration is how long a file's size and modification time must
+	// stay the same after a CREATE or WRITE event before it is uploaded, at
+	// least minSettleDuration. pending holds the events waiting for their
+	// file to settle.
+	settleDuration time.Duration
+	pending        map[string]*pendingEvent
+
+	// rescanInterval is how often the directory is walked again to catch
+	// up on events fsnotify dropped, 0 never.
+	rescanInterval time.Duration
+
+	// removeSourceFiles removes the local copy of files once they are on
+	// Sia, or moves it into doneDir if that is set.
+	removeSourceFiles bool
+	doneDir           string
+
+	// healthInterval is how often the redundancy of uploaded files is
+	// checked, 0 never. Files below minRedundancy are uploaded again with
+	// autoRepair. unhealthy counts the checks in a row a file was below the
+	// minimum and is only used by eventWatcher.
+	healthInterval time.Duration
+	minRedundancy  float64
+	autoRepair     bool
+	unhealthy      map[string]int
+
+	// progressInterval is how often the upload progress of files that siad
+	// is still uploading is logged, 0 never. uploading holds the files that
+	// were reported as uploading and is only used by eventWatcher.
+	progressInterval time.Duration
+	uploading        map[string]struct{}
+
+	// redundancy holds the last redundancy samples of the files siad is
+	// uploading by their key, taken by reportProgress. It is guarded by mu.
+	redundancy map[string][]redundancySample
+
+	// stallTimeout is how long the upload progress of a file may not
+	// increase before stallAction is taken, 0 never. progress holds the
+	// progress of the files siad is uploading and is only used by
+	// eventWatcher.
+	stallTimeout time.Duration
+	stallAction  string
+	progress     map[string]uploadProgress
+
+	// throttle is the upload limit that was last logged as holding back
+	// queued files, windowOpen whether the upload window was last logged as
+	// open. Both are only used by eventWatcher.
+	throttle   string
+	windowOpen bool
+
+	// siadUploading holds the files handed to siad that weren't fully
+	// uploaded when checkThrottle last asked about them, it is only kept with
+	// a limit on the bytes siad uploads. siadUploadingSeeded is set once the
+	// files siad was already uploading were looked up in the listing of Sia,
+	// it is only used by eventWatcher.
+	siadUploading       map[modules.SiaPath]struct{}
+	siadUploadingSeeded bool
+
+	// manifestFile is the local copy of the manifest, empty if no manifest
+	// is kept on Sia. previousManifest is the manifest that is on Sia, and
+	// lastManifest its encoding if it was uploaded by this SiaFolder.
+	manifestFile     string
+	previousManifest *manifest
+	lastManifest     []byte
+
+	// preserveMetadata records the modification time, permissions and
+	// owner of uploaded files in the manifest.
+	preserveMetadata bool
+
+	// pollInterval is how often the directory is walked for changes if it
+	// isn't watched, or else the subdirectories the watcher couldn't watch.
+	// It is 0 if the directory isn't synced continuously.
+	// watchLimitHinted is set once the hint on raising the watch limit was
+	// logged, it is only used by watchDir.
+	pollInterval     time.Duration
+	watchLimitHinted bool
+
+	// renamed holds tracked files that were renamed away and may be paired
+	// with the CREATE event of their new name.
+	renamed map[string]renamedFile
+
+	// renamedDirs holds watched directories that were renamed away and may
+	// be paired with the CREATE event of their new name.
+	renamedDirs map[string]renamedDir
+
+	// includeExtensions and excludeExtensions filter the synced files by
+	// extension, excludePatterns are the patterns of files that are never
+	// synced. configExcludePatterns are the default and configured ones, the
+	// patterns of the ignore file are added to them. The defaults are left
+	// out if syncHidden is set. Both can change on reload, so they are
+	// protected by patternsMu.
+	includeExtensions     []string
+	excludeExtensions     []string
+	syncHidden            bool
+	configExcludePatterns []string
+	excludePatterns       []string
+	patternsMu            sync.Mutex
+
+	// changeDetection is how changed files are detected.
+	changeDetection string
+
+	// minFileSize is the size below which files are tracked but not
+	// uploaded.
+	minFileSize int64
+
+	// categoryPattern routes the files whose relative path it matches into
+	// the tv folder on Sia and the others into categoryDefault. It is nil
+	// without auto categorization.
+	categoryPattern *regexp.Regexp
+	categoryDefault string
+
+	// dedupe records files with the same content as an uploaded file as its
+	// duplicates instead of uploading them. originals maps the checksums of
+	// uploaded files to the key of one of them, duplicates is the number of
+	// tracked duplicates. uploadingContent maps the checksums of files being
+	// uploaded to their key. All are protected by mu.
+	dedupe           bool
+	originals        map[string]string
+	duplicates       int
+	uploadingContent map[string]string
+
+	// sanitizeNames maps names Sia doesn't accept to names it does,
+	// otherwise those files are skipped and listed in skipped with the
+	// reason.
+	sanitizeNames bool
+	skipped       map[string]string
+
+	// strict stops the initial scan at the first file or directory that
+	// can't be read, instead of skipping it.
+	strict bool
+
+	// dryRun doesn't change anything on Sia, dryRunOutput is where the plan
+	// is written to on Close.
+	dryRun       bool
+	dryRunOutput string
+
+	// mu protects dirs, files, state, stateDirty, failed, disconnected, the
+	// paused, held deletes and offline state and the liveness fields below,
+	// which are shared between the startup walk, eventWatcher and the upload
+	// workers. pending, renamed and renamedDirs are only used by
+	// eventWatcher.
+	mu sync.Mutex
+
+	dirs  map[string]dirState // dirs is a map of watched subdirectories to what is known about them
+	files map[string]string   // files is a map of file keys to SHA256 checksums, used to reconcile file changes
+
+	// state holds the checksum, size, modification time and upload status of
+	// every file in files. Both are keyed by fileKey, the slash separated
+	// path relative to path, however the file was found. It is persisted to
+	// stateFile so that unchanged files are not checksummed again on
+	// restart, unless noCache is set.
+	state      map[string]fileState
+	stateFile  string
+	stateDirty bool
+	noCache    bool
+
+	// failed maps files that could not be uploaded after maxUploadAttempts
+	// attempts to the last upload error.
+	failed map[string]string
+
+	// disconnected is set while siad is unreachable and uploads are paused.
+	disconnected bool
+
+	// nodes is set if the client can fail over to standby siad nodes, node
+	// is the address of the one Sia was last reconciled with.
+	nodes siaFailover
+	node  string
+
+	// paused is set while syncing is paused by the user, pausedRemovals
+	// holds the files removed in the meantime until syncing is resumed.
+	paused         bool
+	pausedRemovals map[string]fileState
+
+	// maxDeletes and maxDeletesPercent limit the number and percentage of
+	// tracked files removed from Sia within deleteWindow, 0 doesn't.
+	// recentDeletes holds when the files removed within deleteWindow were
+	// removed. Once a limit is exceeded deletesHeld is set and heldRemovals
+	// holds the files removed in the meantime until ResumeDeletes.
+	// forceDeletes lets the removals of the initial sync through.
+	maxDeletes        int
+	maxDeletesPercent float64
+	deleteWindow      time.Duration
+	recentDeletes     []time.Time
+	deletesHeld       bool
+	heldRemovals      map[string]fileState
+	forceDeletes      bool
+
+	// offline is set while the synced directory is missing, like a drive
+	// that was unplugged, and syncing is suspended. sourceInfo identifies
+	// the synced directory while it is there. offlineWarned is when it was
+	// last logged to be offline and is only used by eventWatcher.
+	offline       bool
+	sourceInfo    os.FileInfo
+	offlineWarned time.Time
+
+	// ready is set once the initial sync is done, lastHeartbeat is the last
+	// time eventWatcher ran and lastAPISuccess the last time a siad API call
+	// succeeded. They are reported by Healthy. initialSyncDone is closed
+	// once the initial sync ended, with initialSyncErr.
+	ready           bool
+	lastHeartbeat   time.Time
+	lastAPISuccess  time.Time
+	initialSyncDone chan struct{}
+	initialSyncErr  error
+
+	// changedDuringSync holds the paths eventWatcher got events of while
+	// the initial sync runs, whose deletion or change the reconciliation
+	// leaves to eventWatcher. It is nil once the initial sync is done.
+	changedDuringSync map[string]struct{}
+
+	// uploads is the queue of files waiting for one of the upload workers.
+	uploads  *uploadQueue
+	workers  sync.WaitGroup
+	watching sync.WaitGroup
+
+	// maxUploadAttempts is how often a file is tried before it is given up.
+	maxUploadAttempts int
+
+	// hooks maps upload, delete and error events to the script run for
+	// them. hookSlots limits the number of scripts running at once.
+	hooks        map[string]string
+	hookSlots    chan struct{}
+	hooksRunning sync.WaitGroup
+
+	// stream publishes the events of the folder, published is the channel
+	// returned by Events. The upload log and the hook scripts consume them
+	// too.
+	stream    eventStream
+	published <-chan Event
+
+	// plan collects the changes that would have been made to Sia in a dry
+	// run.
+	plan *dryRunPlan
+
+	// listing caches the files on Sia below prefix.
+	listing siaListing
+
+	// stats counts what the SiaFolder did since it was created.
+	stats syncStats
+
+	// shutdownTimeout is how long Close waits for the event watcher and the
+	// uploads in progress, 0 waits forever.
+	shutdownTimeout time.Duration
+
+	// reloadChan asks eventWatcher to reload the ignore file and rescan.
+	reloadChan chan struct{}
+
+	// ctx stops the goroutines of the SiaFolder once Close cancels it, or
+	// the context it was created with is done. API calls are made with
+	// contexts derived from apiCtx instead, which Close only cancels once
+	// shutdownTimeout passed, so that uploads in progress can finish.
+	// apiTimeout is how long a single API call may take, 0 is unlimited.
+	ctx        context.Context
+	cancel     context.CancelFunc
+	apiCtx     context.Context
+	cancelAPI  context.CancelFunc
+	apiTimeout time.Duration
 }
 
 // contains checks if a string exists in a []strings.
@@ -48,21 +392,40 @@ func contains(a []string, x string) bool {
 	return false
 }
 
+// ParseExtensions splits a comma separated list of file extensions, ignoring
+// case, surrounding whitespace and leading dots.
+func ParseExtensions(list string) []string {
+	var extensions []string
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimLeft(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions
+}
+
 // checkFile checks if a file's extension is included or excluded
 // included takes precedence over excluded.
-func checkFile(path string) (bool, error) {
-	if include != "" {
-		if contains(includeExtensions, strings.TrimLeft(filepath.Ext(path), ".")) {
+func (sf *SiaFolder) checkFile(path string) (bool, error) {
+	ext := strings.ToLower(strings.TrimLeft(filepath.Ext(path), "."))
+	if len(sf.includeExtensions) > 0 {
+		if contains(sf.includeExtensions, ext) {
 			log.Debug("Found extension in include flag")
 			return true, nil
 		}
-		log.Debug("Extension not found in include flag")
+		log.WithFields(logrus.Fields{
+			"file": path,
+		}).Debug("Skipping file, extension not found in include flag")
 		return false, nil
 
 	}
 
-	if exclude != "" {
-		if contains(excludeExtensions, strings.TrimLeft(filepath.Ext(path), ".")) {
+	if len(sf.excludeExtensions) > 0 {
+		if contains(sf.excludeExtensions, ext) {
+			log.WithFields(logrus.Fields{
+				"file": path,
+			}).Debug("Skipping file, extension found in exclude flag")
 			return false, nil
 		}
 		return true, nil
@@ -70,26 +433,200 @@ func checkFile(path string) (bool, error) {
 	return true, nil
 }
 
-// NewSiafolder creates a new SiaFolder using the provided path and api
-// address.
-func NewSiafolder(path string, client *sia.Client) (*SiaFolder, error) {
+// NewSiafolder creates a new SiaFolder that syncs the directory at path to Sia
+// through client, using the settings in config. If path is a regular file,
+// only that file is synced, under its name, by watching its directory. The
+// SiaFolder stops watching once ctx is done, Close must still be called.
+func NewSiafolder(ctx context.Context, path string, client SiaClient, config Config) (*SiaFolder, error) {
 	abspath, err := filepath.Abs(path)
 	if err != nil {
 		return nil, err
 	}
+	var singleFile string
+	if stat, err := os.Stat(abspath); err == nil && stat.Mode().IsRegular() {
+		singleFile, abspath = abspath, filepath.Dir(abspath)
+	}
 
 	sf := &SiaFolder{
-		path:      abspath,
-		files:     make(map[string]string),
-		closeChan: make(chan struct{}),
-		client:    client,
-		archive:   archive,
-		prefix:    prefix,
-		watcher:   nil,
+		path:       abspath,
+		singleFile: singleFile,
+		dirs:       make(map[string]dirState),
+		files:      make(map[string]string),
+		state:      make(map[string]fileState),
+		stats:      syncStats{started: time.Now()},
+		reloadChan: make(chan struct{}, 1),
+		apiTimeout: config.APITimeout,
+		events:     newEventQueue(),
+		checks:     newCheckQueue(),
+		checked:    make(chan checkedFile),
+
+		initialSyncDone:   make(chan struct{}),
+		changedDuringSync: make(map[string]struct{}),
+		client:            client,
+		archive:           config.Archive || config.RemoveSourceFiles || config.DoneDir != "",
+		maxVersions:       config.MaxVersions,
+		prefix:            siaPathString(config.Prefix, "", isWindows),
+		watcher:           nil,
+
+		dataPieces:   config.DataPieces,
+		parityPieces: config.ParityPieces,
+		categories:   config.Categories,
+
+		emptyDirGrace: config.EmptyDirGrace,
+		auditSink:     config.AuditSink,
+		spending:      config.Spending,
+		api:           config.API,
+
+		settleDuration: config.SettleDuration,
+		pending:        make(map[string]*pendingEvent),
+		renamed:        make(map[string]renamedFile),
+		renamedDirs:    make(map[string]renamedDir),
+		rescanInterval: config.RescanInterval,
+
+		removeSourceFiles: config.RemoveSourceFiles || config.DoneDir != "",
+
+		healthInterval: config.HealthInterval,
+		minRedundancy:  config.MinRedundancy,
+		autoRepair:     config.AutoRepair,
+		unhealthy:      make(map[string]int),
+
+		progressInterval: config.ProgressInterval,
+		stallTimeout:     config.StallTimeout,
+		stallAction:      config.StallAction,
+
+		includeExtensions: config.IncludeExtensions,
+		excludeExtensions: config.ExcludeExtensions,
+		changeDetection:   config.ChangeDetection,
+		minFileSize:       config.MinFileSize,
+		dedupe:            config.Dedupe,
+		preserveMetadata:  config.PreserveMetadata,
+		originals:         make(map[string]string),
+		uploadingContent:  make(map[string]string),
+		sanitizeNames:     config.SanitizeNames,
+		strict:            config.Strict,
+		dryRun:            config.DryRun,
+		dryRunOutput:      config.DryRunOutput,
+
+		failed:         make(map[string]string),
+		skipped:        make(map[string]string),
+		pausedRemovals: make(map[string]fileState),
+
+		maxDeletes:        config.MaxDeletes,
+		maxDeletesPercent: config.MaxDeletesPercent,
+		deleteWindow:      config.DeleteWindow,
+		heldRemovals:      make(map[string]fileState),
+		forceDeletes:      config.ForceResumeDeletes,
+
+		maxUploadAttempts: config.MaxUploadAttempts,
+		plan:              newDryRunPlan(),
+
+		hooks: map[string]string{
+			"upload": config.OnUpload,
+			"delete": config.OnDelete,
+			"error":  config.OnError,
+		},
+		hookSlots: make(chan struct{}, maxRunningHooks),
+
+		shutdownTimeout: config.ShutdownTimeout,
+	}
+	sf.ctx, sf.cancel = context.WithCancel(ctx)
+	sf.apiCtx, sf.cancelAPI = context.WithCancel(context.Background())
+	sf.sourceInfo, _ = os.Stat(abspath)
+	// count the successful API calls for Healthy, starting with a probe as
+	// the first calls may fail because the folder on Sia doesn't exist yet
+	if nodes, ok := failoverOf(client); ok {
+		sf.nodes = nodes
+		sf.node = nodes.activeAddress()
+	}
+	sf.client = &apiTrackingClient{SiaClient: client, sf: sf}
+	sf.probeSiad()
+	uploads := config.Uploads
+	if uploads == nil {
+		uploads = NewUploadQueue(config)
+	}
+	sf.uploads = uploads.folder()
+	sf.windowOpen = true
+	sf.checkUploadWindow()
+	if _, err := newSiaPath(sf.prefix); err != nil {
+		return nil, fmt.Errorf("invalid folder on Sia %q: %v", config.Prefix, err)
+	}
+	if config.AutoCategorize {
+		sf.categoryPattern, err = CompileCategoryPattern(config.CategoryPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid category pattern: %v", err)
+		}
+		sf.categoryDefault = config.CategoryDefault
+		if sf.categoryDefault == "" {
+			sf.categoryDefault = DefaultCategory
+		}
+		if err := checkSiaPath(sf.categoryDefault); err != nil || strings.Contains(sf.categoryDefault, "/") || sf.categoryDefault == tvCategory {
+			return nil, fmt.Errorf("invalid default category %q, it must be a single folder name other than %v", sf.categoryDefault, tvCategory)
+		}
+	}
+	if sf.changeDetection == "" {
+		sf.changeDetection = "sha256"
+	}
+
+	if config.DoneDir != "" {
+		sf.doneDir, err = filepath.Abs(config.DoneDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ignorePatterns, err := readIgnoreFile(abspath)
+	if err != nil {
+		return nil, err
+	}
+	sf.syncHidden = config.SyncHidden
+	sf.configExcludePatterns = sf.withDefaultPatterns(config.ExcludePatterns)
+	sf.excludePatterns = append(append([]string{}, sf.configExcludePatterns...), ignorePatterns...)
+
+	// load the state of the previous run, falling back to a full scan if it
+	// is missing or unreadable
+	sf.stateFile = config.StateFile
+	if sf.stateFile == "" {
+		sf.stateFile = filepath.Join(abspath, DefaultStateFile)
+		if singleFile != "" {
+			sf.stateFile = filepath.Join(abspath, "."+filepath.Base(singleFile)+DefaultStateFile)
+		}
+	}
+	sf.stateFile, err = filepath.Abs(sf.stateFile)
+	if err != nil {
+		return nil, err
+	}
+	sf.noCache = config.NoCache
+	if config.Manifest || config.Dedupe || config.PreserveMetadata {
+		sf.manifestFile = filepath.Join(filepath.Dir(sf.stateFile), manifestName)
+		sf.previousManifest, err = sf.downloadManifest()
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Warn("Could not download the manifest, files only on Sia are left out of the new one")
+		}
+	}
+	previousState := make(map[string]fileState)
+	if !config.Rescan && !sf.noCache {
+		loaded, err := sf.loadState()
+		if err != nil && !os.IsNotExist(err) {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Warn("Could not load state file, doing a full scan")
+		}
+		if err == nil {
+			previousState = loaded
+		}
 	}
 
-	// watch for file changes
-	if !syncOnly {
+	// watch for file changes, or poll for them if the watcher doesn't
+	// receive any events
+	if !config.SyncOnly {
+		sf.pollInterval = config.PollInterval
+		if sf.pollInterval <= 0 {
+			sf.pollInterval = DefaultPollInterval
+		}
+	}
+	if !config.SyncOnly && !config.Poll {
 		watcher, err := fsnotify.NewWatcher()
 		if err != nil {
 			return nil, err
@@ -100,93 +637,242 @@ func NewSiafolder(path string, client *sia.Client) (*SiaFolder, error) {
 		}
 
 		sf.watcher = watcher
+		if !sf.watcherWorks() {
+			log.WithFields(logrus.Fields{
+				"directory": abspath,
+				"interval":  sf.pollInterval,
+			}).Warn("No events received from the file watcher, polling the directory instead")
+			sf.watcher.Close()
+			sf.watcher = nil
+		}
 	}
 
-	// walk the provided path, accumulating a slice of files to potentially
-	// upload and adding any subdirectories to the watcher.
-	err = filepath.Walk(abspath, func(walkpath string, f os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	// drain the watcher from now on, eventWatcher handles the events once
+	// the directory is scanned
+	if sf.watcher != nil {
+		sf.watching.Add(1)
+		go sf.readEvents()
+	}
+
+	// walk the provided path, tracking the files to potentially upload and
+	// adding any subdirectories to the watcher.
+	err = sf.scan(previousState, config.ScanWorkers)
+	if err != nil {
+		sf.abort()
+		return nil, err
+	}
+	if unwatched := sf.unwatchedDirs(); sf.watcher != nil && len(unwatched) > 0 {
+		log.WithFields(logrus.Fields{
+			"directories": len(unwatched),
+			"interval":    sf.pollInterval,
+		}).Warn("Some directories could not be watched, polling them for changes instead")
+	}
+
+	// without eventWatcher, changed files are checksummed right away
+	if sf.watcher != nil || sf.pollInterval > 0 {
+		sf.checkWorkers = config.ScanWorkers
+		if sf.checkWorkers < 1 {
+			sf.checkWorkers = 1
 		}
-		if walkpath == path {
-			return nil
+	}
+	if sf.maxUploadAttempts < 1 {
+		sf.maxUploadAttempts = 1
+	}
+	if sf.deleteWindow <= 0 {
+		sf.deleteWindow = DefaultDeleteWindow
+	}
+
+	// the upload log and the hook scripts are fed by the events, like any
+	// user of Events
+	eventBuffer := config.EventBuffer
+	if eventBuffer <= 0 {
+		eventBuffer = defaultEventBuffer
+	}
+	sf.published = sf.stream.subscribe("events", eventBuffer)
+	sf.stream.consume("log", logEvent)
+	if config.OnUpload != "" || config.OnDelete != "" || config.OnError != "" {
+		sf.stream.consume("hooks", sf.runHook)
+	}
+
+	// start the upload workers, as many as files may be uploaded at the
+	// same time
+	for i := 0; i < sf.uploads.maxInFlight; i++ {
+		sf.workers.Add(1)
+		go sf.uploadWorker()
+	}
+
+	// handle changes right away, so that no events pile up in the watcher
+	// while the initial sync runs
+	sf.mu.Lock()
+	sf.lastHeartbeat = time.Now()
+	sf.mu.Unlock()
+	sf.watching.Add(1)
+	go sf.eventWatcher()
+
+	// when inspecting the directory with prune or verify nothing is synced,
+	// deleted files are only removed by prune once they are confirmed. An
+	// initial upload that needs confirmation is confirmed before
+	// NewSiafolder returns.
+	reconcile := !config.SkipInitialSync
+	if reconcile && config.ConfirmUpload != nil && !sf.dryRun {
+		sf.uploads.pause("confirm")
+		err = sf.reconcile()
+		if err == nil {
+			err = sf.confirmInitialUpload(config.ConfirmUpload)
+		}
+		if err != nil {
+			sf.abort()
+			return nil, err
 		}
+		sf.uploads.resume("confirm")
+		reconcile = false
+	}
+	sf.workers.Add(1)
+	go sf.initialSync(reconcile, !config.SkipInitialSync)
 
-		// Check if a Directory was found
-		if f.IsDir() {
-			// subdirectories must be added to the watcher.
-			if sf.watcher != nil {
-				sf.watcher.Add(walkpath)
+	return sf, nil
+}
+
+// initialSync reconciles Sia with the scanned files unless it was done
+// already, waits for the initial uploads and saves the state, while
+// eventWatcher already handles changes. A file queued by both is only
+// uploaded once. Once done, the SiaFolder is ready and WaitInitialSync
+// returns.
+func (sf *SiaFolder) initialSync(reconcile, uploadManifest bool) {
+	defer sf.workers.Done()
+	defer close(sf.initialSyncDone)
+	err := func() error {
+		if reconcile {
+			err := sf.reconcile()
+			if err != nil {
+				return err
 			}
-			return nil
 		}
-
-		// File Found
-		log.WithFields(logrus.Fields{
-			"file": walkpath,
-		}).Debug("Calculating checksum for file")
-		checksum, err := checksumFile(walkpath)
+		if sf.spending != nil {
+			stopSampling := make(chan struct{})
+			go sf.sampleSpendingUntil(stopSampling)
+			defer close(stopSampling)
+		}
+		waited := make(chan struct{})
+		go func() {
+			sf.uploads.wait()
+			close(waited)
+		}()
+		select {
+		case <-waited:
+		case <-sf.ctx.Done():
+			return errInitialSyncClosed
+		}
+		select {
+		case <-sf.ctx.Done():
+			return errInitialSyncClosed
+		default:
+		}
+		err := sf.saveState()
 		if err != nil {
 			return err
 		}
-		sf.files[walkpath] = checksum
+		if uploadManifest {
+			sf.uploadManifestLogged()
+		}
 		return nil
-	})
-	if err != nil {
-		return nil, err
+	}()
+	if err != nil && err != errInitialSyncClosed {
+		log.WithFields(logrus.Fields{
+			"directory": sf.path,
+			"error":     err.Error(),
+		}).Error("Error in the initial sync")
 	}
 
-	log.Info("Uploading files missing from Sia")
-	err = sf.uploadNonExisting()
-	if err != nil {
-		return nil, err
+	sf.mu.Lock()
+	sf.initialSyncErr = err
+	sf.ready = err == nil
+	sf.changedDuringSync = nil
+	sf.mu.Unlock()
+}
+
+// noteChanged records that eventWatcher got an event of path during the
+// initial sync.
+func (sf *SiaFolder) noteChanged(path string) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.changedDuringSync != nil {
+		sf.changedDuringSync[path] = struct{}{}
 	}
+}
 
-	// remove files that are in Sia but not in local directory
-	if !archive {
-		log.Info("Removing files missing from local directory")
-		err = sf.removeDeleted()
-		if err != nil {
-			return nil, err
+// changedByEvent reports whether eventWatcher got an event of path, or of a
+// directory containing it, during the initial sync. Its listing of Sia and the
+// tracked files may be out of date for such a path, so the reconciliation
+// leaves it to eventWatcher instead of deleting or uploading it again.
+func (sf *SiaFolder) changedByEvent(path string) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for changed := range sf.changedDuringSync {
+		if path == changed || isWithin(changed, path) {
+			return true
 		}
 	}
+	return false
+}
 
-	// since there is no simple way to retrieve a sha256 checksum of a remote
-	// file, this only works in size-only mode
-	if sizeOnly {
-		log.Info("Uploading changed files")
-		err = sf.uploadChanged()
-	}
-	if err != nil {
-		return nil, err
-	}
+// WaitInitialSync blocks until the initial sync of the SiaFolder is done and
+// returns its error, if any. NewSiafolder returns before that, with changes
+// being synced already.
+func (sf *SiaFolder) WaitInitialSync() error {
+	<-sf.initialSyncDone
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.initialSyncErr
+}
 
-	go sf.eventWatcher()
+// Path returns the absolute path of the synced directory.
+func (sf *SiaFolder) Path() string {
+	return sf.path
+}
 
-	return sf, nil
+// Prefix returns the folder on Sia the directory is synced to.
+func (sf *SiaFolder) Prefix() string {
+	return sf.prefix
 }
 
-// newSiaPath is a wrapper for modules.NewSiaPath that just panics if there is
-// an error
-func newSiaPath(path string) (siaPath modules.SiaPath) {
-	siaPath, err := modules.NewSiaPath(path)
-	if err != nil {
-		log.WithFields(logrus.Fields{
-			"error": err.Error(),
-		}).Panic("Unable to create new SiaPath")
+// SingleFile returns the file in Path that is synced on its own, or an empty
+// string if the whole directory is synced.
+func (sf *SiaFolder) SingleFile() string {
+	return sf.singleFile
+}
+
+// abort stops a SiaFolder that NewSiafolder doesn't return.
+func (sf *SiaFolder) abort() {
+	sf.cancel()
+	defer sf.cancelAPI()
+	sf.checks.close()
+	sf.watching.Wait()
+	sf.uploads.close()
+	sf.workers.Wait()
+	sf.stream.close()
+	sf.stream.consuming.Wait()
+	if sf.watcher != nil {
+		sf.watcher.Close()
 	}
-	return siaPath
 }
 
-// checksumFile returns a sha256 checksum or size of a given file on disk
-// depending on a options provided
-func checksumFile(path string) (string, error) {
+// checksumBufferSize is the size of the buffer used to stream files through
+// the hash, so that large files are never read into memory at once.
+const checksumBufferSize = 1 << 20
+
+// checksumFile returns a sha256 checksum, size or modification time of a given
+// file on disk depending on the change detection mode
+func (sf *SiaFolder) checksumFile(path string) (string, error) {
 	var checksum string
 	var err error
 
-	if sizeOnly {
+	switch sf.changeDetection {
+	case "size":
 		checksum, err = sizeFile(path)
-	} else {
+	case "mtime":
+		checksum, err = mtimeFile(path)
+	default:
 		checksum, err = sha256File(path)
 	}
 	if err != nil {
@@ -195,7 +881,7 @@ func checksumFile(path string) (string, error) {
 	return checksum, nil
 }
 
-// sha256File returns a sha256 checksum of a given file on disk.
+// sha256File returns the hex encoded sha256 checksum of a given file on disk.
 func sha256File(path string) (string, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -204,11 +890,12 @@ func sha256File(path string) (string, error) {
 	defer f.Close()
 
 	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
+	buf := make([]byte, checksumBufferSize)
+	if _, err := io.CopyBuffer(h, f, buf); err != nil {
 		return "", err
 	}
 
-	return string(h.Sum(nil)), nil
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // sizeFile returns the file size
@@ -222,107 +909,611 @@ func sizeFile(path string) (string, error) {
 	return strconv.FormatInt(size, 10), nil
 }
 
+// mtimeFile returns the file modification time
+func mtimeFile(path string) (string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	mtime := stat.ModTime().UnixNano()
+
+	return strconv.FormatInt(mtime, 10), nil
+}
+
 // eventWatcher continuously listens on the SiaFolder's watcher channels and
 // performs the necessary upload/delete operations.
 func (sf *SiaFolder) eventWatcher() {
-	if sf.watcher == nil {
+	defer sf.watching.Done()
+	if sf.watcher == nil && sf.pollInterval == 0 {
 		return
 	}
+	var queued <-chan struct{}
+	if sf.watcher != nil {
+		queued = sf.events.ready
+	}
+
+	// files that changed are checksummed off this goroutine
+	for i := 0; i < sf.checkWorkers; i++ {
+		sf.watching.Add(1)
+		go sf.checkWorker()
+	}
+
+	// periodically check that the synced directory is still there, whether
+	// files with pending events have settled and whether renamed files were
+	// never paired with a new name
+	ticker := time.NewTicker(settleCheckInterval(sf.settleDuration))
+	defer ticker.Stop()
+
+	// periodically walk the directory again to catch up on dropped events
+	var rescanTick <-chan time.Time
+	if sf.rescanInterval > 0 {
+		rescanTicker := time.NewTicker(sf.rescanInterval)
+		defer rescanTicker.Stop()
+		rescanTick = rescanTicker.C
+	}
+
+	// the directory, or the subdirectories the watcher couldn't watch, are
+	// walked for changes instead
+	var pollTick <-chan time.Time
+	if sf.pollInterval > 0 {
+		pollTicker := time.NewTicker(sf.pollInterval)
+		defer pollTicker.Stop()
+		pollTick = pollTicker.C
+	}
+
+	// periodically upload the manifest if files changed
+	var manifestTick <-chan time.Time
+	if sf.manifestFile != "" {
+		manifestTicker := time.NewTicker(manifestInterval)
+		defer manifestTicker.Stop()
+		manifestTick = manifestTicker.C
+	}
+
+	// periodically check the redundancy of uploaded files
+	var healthTick <-chan time.Time
+	if sf.healthInterval > 0 {
+		healthTicker := time.NewTicker(sf.healthInterval)
+		defer healthTicker.Stop()
+		healthTick = healthTicker.C
+	}
+
+	// periodically report the progress of files siad is uploading
+	var progressTick <-chan time.Time
+	if sf.progressInterval > 0 {
+		progressTicker := time.NewTicker(sf.progressInterval)
+		defer progressTicker.Stop()
+		progressTick = progressTicker.C
+	}
+
+	// periodically count what siad is uploading against the upload limits
+	var throttleTick <-chan time.Time
+	if sf.uploads.maxPerHour > 0 || sf.uploads.maxBytes > 0 {
+		throttleTicker := time.NewTicker(throttleCheckInterval)
+		defer throttleTicker.Stop()
+		throttleTick = throttleTicker.C
+	}
+
+	// periodically log whether the upload window opened or closed
+	var windowTick <-chan time.Time
+	if len(sf.uploads.windows) > 0 {
+		windowTicker := time.NewTicker(windowCheckInterval)
+		defer windowTicker.Stop()
+		windowTick = windowTicker.C
+	}
+
+	// periodically look for uploads that stopped making progress
+	var stallTick <-chan time.Time
+	if sf.stallTimeout > 0 {
+		stallTicker := time.NewTicker(sf.stallTimeout / 4)
+		defer stallTicker.Stop()
+		stallTick = stallTicker.C
+	}
+
+	// periodically check that siad answers while nothing else calls it
+	apiProbeTicker := time.NewTicker(apiProbeInterval)
+	defer apiProbeTicker.Stop()
+	apiProbeTick := apiProbeTicker.C
+
+	// periodically remove directories on Sia that stayed empty
+	var emptyDirTick <-chan time.Time
+	if sf.emptyDirGrace > 0 {
+		emptyDirTicker := time.NewTicker(emptyDirCheckInterval)
+		defer emptyDirTicker.Stop()
+		emptyDirTick = emptyDirTicker.C
+	}
+
+	// periodically remove the local copy of files that are on Sia
+	var removeSourceTick <-chan time.Time
+	if sf.removeSourceFiles {
+		removeSourceTicker := time.NewTicker(removeSourceInterval)
+		defer removeSourceTicker.Stop()
+		removeSourceTick = removeSourceTicker.C
+	}
 
 	for {
 		select {
-		case <-sf.closeChan:
+		case <-sf.ctx.Done():
 			return
-		case event := <-sf.watcher.Events:
-			filename := filepath.Clean(event.Name)
-			f, err := os.Stat(filename)
-			if err == nil && f.IsDir() {
-				sf.watcher.Add(filename)
+		case <-sf.reloadChan:
+			sf.reload()
+		case <-ticker.C:
+			sf.heartbeat()
+			if !sf.checkSource() {
 				continue
 			}
-			goodForWrite, err := checkFile(filename)
-			if err != nil {
-				log.WithFields(logrus.Fields{
-					"error": err.Error(),
-				}).Error("Error with checkFile")
+			sf.processSettled()
+			sf.expireRenames()
+			sf.expireDirRenames()
+			sf.saveStateLogged()
+		case <-rescanTick:
+			sf.rescan()
+		case <-pollTick:
+			sf.poll()
+		case <-removeSourceTick:
+			if !sf.sourceOffline() {
+				sf.removeSources()
+			}
+		case <-manifestTick:
+			sf.uploadManifestLogged()
+		case <-healthTick:
+			sf.checkHealth()
+		case <-progressTick:
+			sf.reportProgress()
+		case <-stallTick:
+			sf.checkStalls()
+		case <-throttleTick:
+			sf.checkThrottle()
+		case <-windowTick:
+			sf.checkUploadWindow()
+		case <-emptyDirTick:
+			if !sf.sourceOffline() {
+				sf.removeEmptyDirs()
 			}
-			if !goodForWrite {
+		case <-apiProbeTick:
+			sf.probeSiad()
+			sf.checkNode()
+			sf.sampleSpending(true)
+		case <-queued:
+			// the events are handled in the order they happened, the
+			// events of a synced directory that went offline are dropped
+			events := sf.events.take()
+			if !sf.checkSource() {
 				continue
 			}
-
-			// WRITE event, checksum the file and re-upload it if it has changed
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				err = sf.handleFileWrite(filename)
-				if err != nil {
-					log.WithFields(logrus.Fields{
-						"error": err.Error(),
-					}).Error("Error with handleFileWrite")
+			for _, event := range events {
+				select {
+				case <-sf.ctx.Done():
+					return
+				default:
 				}
+				sf.handleEvent(event)
 			}
-
-			// REMOVE event
-			if event.Op&fsnotify.Remove == fsnotify.Remove && !sf.archive {
-				log.WithFields(logrus.Fields{
-					"filename": filename,
-				}).Info("File removal detected, removing file")
-				err = sf.handleRemove(filename)
-				if err != nil {
-					log.WithFields(logrus.Fields{
-						"error": err.Error(),
-					}).Error("Error with handleRemove")
-				}
+			sf.saveStateLogged()
+		case checked := <-sf.checked:
+			if sf.sourceOffline() {
+				continue
 			}
+			sf.handleChecked(checked)
+			sf.saveStateLogged()
+		}
+	}
+}
 
-			// CREATE event
-			if event.Op&fsnotify.Create == fsnotify.Create {
-				log.WithFields(logrus.Fields{
-					"filename": filename,
-				}).Info("File creation detected, uploading file")
-				uploadRetry(sf, filename)
-			}
-		case err := <-sf.watcher.Errors:
-			if err != nil {
-				log.WithFields(logrus.Fields{
-					"error": err.Error(),
-				}).Error("fsevents error")
+// handleEvent handles an event of the watcher. It runs on the eventWatcher
+// goroutine.
+func (sf *SiaFolder) handleEvent(event fsnotify.Event) {
+	filename := sf.composedPath(filepath.Clean(event.Name))
+	if rule := sf.excludedBy(filename); rule != "" {
+		log.WithFields(logrus.Fields{
+			"path": filename,
+			"rule": rule,
+		}).Debug("Skipping event of excluded path")
+		return
+	}
+
+	// CHMOD event, permissions aren't synced so it only counts if the file
+	// changed too. Tools that fix the permissions of a whole library cause
+	// floods of them, which must not checksum every file.
+	if event.Op == fsnotify.Chmod {
+		op, changed := sf.chmodChange(filename)
+		if !changed {
+			return
+		}
+		event.Op = op
+	}
+	sf.noteChanged(filename)
+	// REMOVE or RENAME event of a watched directory
+	if sf.isWatchedDir(filename) && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		// a renamed directory is remembered for a moment so that it can be
+		// paired with the CREATE event of its new name, like a file
+		if event.Op&fsnotify.Rename == fsnotify.Rename && sf.deferDirRename(filename) {
+			return
+		}
+		sf.handleDirRemoved(filename)
+		return
+	}
+	f, err := os.Stat(filename)
+	if err == nil && f.IsDir() {
+		// a directory moved into the tree may already contain files, or be
+		// a directory that was just renamed away
+		if event.Op&fsnotify.Create == fsnotify.Create && !sf.isWatchedDir(filename) {
+			if olddir, ok := sf.matchDirRename(filename); ok && !sf.Paused() {
+				sf.handleDirRename(olddir, filename)
+				return
 			}
+			sf.scanDir(filename)
 		}
+		return
+	}
+	goodForWrite, err := sf.checkFile(filename)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error with checkFile")
+	}
+	if !goodForWrite {
+		return
+	}
+	validName := sf.checkName(filename)
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		sf.forgetSkipped(filename)
+	}
+	if !validName {
+		return
+	}
+
+	// REMOVE event
+	if event.Op&fsnotify.Remove == fsnotify.Remove {
+		delete(sf.pending, filename)
+		sf.handleRemoved(filename)
+	}
+
+	// RENAME event, a renamed file is gone from its old path and the new path
+	// gets its own CREATE event. Remember the file for a moment so the two
+	// can be paired into a remote rename.
+	if event.Op&fsnotify.Rename == fsnotify.Rename {
+		delete(sf.pending, filename)
+		if !sf.deferRename(filename) {
+			sf.handleRemoved(filename)
+		}
+	}
+
+	// CREATE event of a file that was just renamed away, in the same
+	// directory or moved from another one
+	if event.Op&fsnotify.Create == fsnotify.Create && sf.pairRename(filename) {
+		return
+	}
+
+	// CREATE and WRITE events, wait for the file to stop changing before
+	// uploading it, so that a file created and written to is uploaded once
+	if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+		sf.deferEvent(filename, event.Op)
 	}
 }
 
-// uploadRetry attempts to reupload a file to Sia
-func uploadRetry(sf *SiaFolder, filename string) {
-	err := sf.handleCreate(filename)
-	if err == nil {
+// chmodChange returns what a CHMOD event of a file amounts to. It is a WRITE
+// if the size or modification time of the tracked file changed, and a WRITE
+// or CREATE if the file was skipped because it couldn't be read, so that it is
+// read again. Otherwise the event changed nothing, except for the permissions
+// and owner recorded with -preserve-metadata.
+func (sf *SiaFolder) chmodChange(file string) (fsnotify.Op, bool) {
+	stat, err := os.Stat(file)
+	if err != nil || stat.IsDir() {
+		return 0, false
+	}
+	sf.mu.Lock()
+	_, skipped := sf.skipped[file]
+	sf.mu.Unlock()
+	fs, tracked := sf.trackedFile(file)
+	switch {
+	case skipped && !tracked:
+		return fsnotify.Create, true
+	case skipped || tracked && !fs.unchanged(stat):
+		return fsnotify.Write, true
+	case tracked && sf.preserveMetadata:
+		md := fileState{ModTime: stat.ModTime()}
+		sf.setMetadata(&md, stat)
+		sf.updateMetadata(file, md)
+	}
+	return 0, false
+}
+
+// handleRemoved handles a file that was removed locally. The remote file is
+// removed too unless the SiaFolder is in archive mode, or its removal is held
+// back because too many files were removed.
+func (sf *SiaFolder) handleRemoved(filename string) {
+	if sf.archive {
+		// keep the file on Sia but stop tracking it locally
+		sf.untrackFile(filename)
+		return
+	}
+	if fs, _ := sf.trackedFile(filename); !sf.dryRun && sf.holdRemoval(filename, fs) {
+		sf.untrackFile(filename)
 		return
 	}
 
-	// If there was an error returned from handleCreate, sleep for 10s and then
-	// remove and try again
-	time.Sleep(10 * time.Second)
+	log.WithFields(logrus.Fields{
+		"file": filename,
+	}).Debug("File removal detected, removing file")
+	err := sf.handleRemove(filename)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error with handleRemove")
+	}
+}
 
-	// check if we have received create event for a file that is already in sia
-	exists, err := sf.isFile(filename)
+// watchDir adds a subdirectory to the watcher and the dirs map, and creates it
+// on Sia so that empty directories are mirrored too.
+func (sf *SiaFolder) watchDir(dir string) {
+	if dir == sf.path {
+		return
+	}
+	var state dirState
+	if sf.watcher != nil {
+		err := sf.watcher.Add(dir)
+		if err != nil {
+			sf.watchFailed(dir, err)
+		}
+		state.watched = err == nil
+	}
+	sf.mu.Lock()
+	old, exists := sf.dirs[dir]
+	sf.mu.Unlock()
+	if exists {
+		state.created = old.created
+	} else {
+		state.created = sf.createRemoteDir(dir)
+	}
+	sf.mu.Lock()
+	sf.dirs[dir] = state
+	sf.mu.Unlock()
+}
+
+// isWatchedDir reports whether dir is in the dirs map.
+func (sf *SiaFolder) isWatchedDir(dir string) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	_, exists := sf.dirs[dir]
+	return exists
+}
+
+// scanDir walks a directory that appeared in the watched tree, watching it and
+// all of its subdirectories and uploading the files inside. Files that are
+// already tracked or waiting to settle are left alone, so CREATE events for
+// them don't cause a second upload, and files that were just renamed away
+// elsewhere in the tree are renamed on Sia.
+func (sf *SiaFolder) scanDir(dir string) {
+	log.WithFields(logrus.Fields{
+		"directory": dir,
+	}).Debug("Directory creation detected, uploading its files")
+
+	err := filepath.Walk(dir, func(walkpath string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walkpath = sf.composedPath(walkpath)
+		if sf.isExcluded(walkpath) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f.IsDir() {
+			sf.watchDir(walkpath)
+			return nil
+		}
+
+		goodForWrite, err := sf.checkFile(walkpath)
+		if err != nil {
+			return err
+		}
+		if !goodForWrite || !sf.checkName(walkpath) {
+			return nil
+		}
+		if _, tracked := sf.trackedFile(walkpath); tracked {
+			return nil
+		}
+		// a file moved into a directory that was just created is found
+		// here rather than by its own CREATE event, and its RENAME event
+		// may still be queued, it is paired once the file settled
+		if sf.pairRename(walkpath) {
+			return nil
+		}
+		sf.deferEvent(walkpath, fsnotify.Create)
+		return nil
+	})
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error": err.Error(),
-		}).Error("Error with isFile")
+		}).Error("Error with scanDir")
+	}
+}
+
+// isWithin reports whether path is inside the directory dir.
+func isWithin(dir, path string) bool {
+	// a root like / or C:\ already ends with a separator
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return path != dir && strings.HasPrefix(path, dir)
+}
+
+// handleDirRemoved handles a watched directory that was removed or renamed
+// away. The directory and its subdirectories are dropped from the watcher,
+// and the files inside are handled as removed. The directories siasync
+// created on Sia are removed there too once they are empty.
+func (sf *SiaFolder) handleDirRemoved(dir string) {
+	log.WithFields(logrus.Fields{
+		"directory": dir,
+	}).Debug("Directory removal detected")
+	sf.removeDirFiles(dir, sf.dropDir(dir))
+}
+
+// removeDirFiles handles the tracked files inside the removed directory dir as
+// removed, and removes the directories of dirs that siasync created on Sia
+// once they are empty.
+func (sf *SiaFolder) removeDirFiles(dir string, dirs map[string]dirState) {
+	for _, file := range sf.trackedFiles() {
+		if isWithin(dir, file) {
+			sf.handleRemoved(file)
+		}
+	}
+	sf.removeRemoteDirs(createdDirs(dirs))
+}
+
+// handleChange handles CREATE and WRITE events for a file.
+func (sf *SiaFolder) handleChange(filename string, op fsnotify.Op) {
+	// CREATE event of a file that isn't tracked yet, tracked files are only
+	// uploaded again if they changed
+	_, tracked := sf.trackedFile(filename)
+	if op&fsnotify.Create == fsnotify.Create && !tracked {
+		log.WithFields(logrus.Fields{
+			"file": filename,
+		}).Debug("File creation detected, uploading file")
+		sf.queueUpload(filename)
+		return
+	}
+
+	// CREATE event of a tracked file, another file was renamed over it, or
+	// WRITE event. A check worker checksums the file, which is re-uploaded
+	// if it has changed.
+	job := checkJob{
+		file:     filename,
+		replaced: op&fsnotify.Create == fsnotify.Create,
+	}
+	if sf.checkWorkers == 0 {
+		fs, err := sf.statFile(filename)
+		sf.handleChecked(checkedFile{checkJob: job, fs: fs, err: err})
+		return
+	}
+	sf.checks.push(job)
+}
+
+// uploadWorker uploads the files pushed to the upload queue until the queue
+// is closed.
+func (sf *SiaFolder) uploadWorker() {
+	defer sf.workers.Done()
+	for {
+		job, ok := sf.uploads.pop()
+		if !ok {
+			return
+		}
+		sf.uploadJob(job)
+		sf.uploads.done(job)
+	}
+}
+
+// uploadJob uploads a file from the upload queue. A failed upload is queued
+// again with exponential backoff until maxUploadAttempts is reached, after
+// which the file is added to the failed uploads.
+func (sf *SiaFolder) uploadJob(job uploadJob) {
+	// the file may have been excluded by a reload since it was queued
+	if sf.isExcluded(job.file) {
+		return
 	}
-	if exists && !archive {
-		err := sf.handleRemove(filename)
+
+	// check if we have received create event for a file that is already in sia
+	if job.attempts > 0 && !sf.archive {
+		exists, err := sf.isFile(job.file)
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"error": err.Error(),
-			}).Error("Error with handleRemove")
+			}).Error("Error with isFile")
+		}
+		if exists {
+			err := sf.handleRemove(job.file)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Error("Error with handleRemove")
+			}
 		}
 	}
 
-	err = sf.handleCreate(filename)
-	if err != nil {
+	err := sf.handleCreate(job.file)
+	if err == nil {
+		sf.clearFailedUpload(job.file)
+		return
+	}
+	if err == errUploadingContent {
+		job.notBefore = time.Now().Add(dedupeRetryDelay)
+		sf.uploads.pushJob(job)
+		return
+	}
+
+	// there is nothing to retry if the file was removed in the meantime
+	if _, statErr := os.Stat(job.file); os.IsNotExist(statErr) {
+		sf.clearFailedUpload(job.file)
+		return
+	}
+
+	// an upload that failed because siad went away doesn't count as an
+	// attempt, it is tried again once siad is back
+	if !sf.siadReachable() {
+		sf.uploads.pushJob(job)
+		sf.handleDisconnect()
+		return
+	}
+
+	if job.attempts+1 >= sf.maxUploadAttempts || errors.Is(err, errEmptyFile) {
+		sf.addFailedUpload(job.file, err)
 		log.WithFields(logrus.Fields{
-			"error": err.Error(),
-		}).Error("Error with handleCreate")
+			"file":     job.file,
+			"attempts": job.attempts + 1,
+			"error":    err.Error(),
+		}).Error("Giving up uploading file")
+		sf.emit(Event{Type: FileUploadFailed, Path: job.file, Err: err})
+		return
+	}
+
+	delay := uploadBackoff(job.attempts)
+	log.WithFields(logrus.Fields{
+		"file":  job.file,
+		"retry": delay,
+		"error": err.Error(),
+	}).Warn("Error uploading file, retrying")
+	sf.uploads.retry(job, delay)
+}
+
+// uploadBackoff returns how long to wait before retrying an upload that has
+// failed attempts+1 times.
+func uploadBackoff(attempts int) time.Duration {
+	delay := retryBackoff
+	for i := 0; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
 	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	return delay
+}
+
+// addFailedUpload records a file that could not be uploaded.
+func (sf *SiaFolder) addFailedUpload(file string, err error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.failed[file] = err.Error()
+	sf.stats.update(func(s *Stats) { s.Failed++ })
+}
+
+// clearFailedUpload removes a file from the failed uploads.
+func (sf *SiaFolder) clearFailedUpload(file string) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	delete(sf.failed, file)
+}
+
+// FailedUploads returns the files that permanently failed to upload, mapped
+// to the last upload error.
+func (sf *SiaFolder) FailedUploads() map[string]string {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	failed := make(map[string]string, len(sf.failed))
+	for file, err := range sf.failed {
+		failed[file] = err
+	}
+	return failed
 }
 
 // isFile checks to see if the file exists on Sia
@@ -331,55 +1522,182 @@ func (sf *SiaFolder) isFile(file string) (bool, error) {
 	if err != nil {
 		return false, fmt.Errorf("error getting relative path: %v", err)
 	}
+	fs, _ := sf.trackedFile(file)
+	siaPath, err := sf.uploadedSiaPath(relpath, fs)
+	if err != nil {
+		return false, err
+	}
 
-	_, err = sf.client.RenterFileGet(newSiaPath(relpath))
-	exists := true
+	_, err = sf.client.RenterFileGet(siaPath)
 	if err != nil && strings.Contains(err.Error(), "no file known") {
-		exists = false
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error getting file %v: %v", file, err)
 	}
-	return exists, nil
+	return true, nil
 }
 
-// handleFileWrite handles a WRITE fsevent.
-func (sf *SiaFolder) handleFileWrite(file string) error {
-	checksum, err := checksumFile(file)
-	if err != nil {
-		return err
+// handleFileWrite handles a WRITE fsevent of a file checksummed as fs.
+func (sf *SiaFolder) handleFileWrite(file string, fs fileState) error {
+	old, exists := sf.trackedFile(file)
+	if exists && old.Checksum != fs.Checksum {
+		// a file that was too small to upload was never on Sia
+		if !old.Uploaded && old.Size < sf.minFileSize {
+			sf.queueUpload(file)
+			return nil
+		}
+		return sf.handleChanged(file, fs)
 	}
+	if exists {
+		// touched without changing its content
+		sf.updateMetadata(file, fs)
+	}
+
+	return nil
+}
 
-	oldChecksum, exists := sf.files[file]
-	if exists && oldChecksum != checksum {
+// handleReplaced handles a tracked file that was replaced by renaming another
+// file over it, like editors and download tools do when they write a
+// temporary file first. The new file, checksummed from scratch as fs, is the
+// content to sync and uploaded once if it differs from the tracked file. In
+// size mode the checksum says nothing about the content, so the
+// modification time, which the rename brings along from the temporary file,
+// has to match too.
+func (sf *SiaFolder) handleReplaced(file string, fs fileState) error {
+	old, _ := sf.trackedFile(file)
+	same := old.Checksum == fs.Checksum
+	if sf.changeDetection == "size" {
+		same = same && old.ModTime.Equal(fs.ModTime)
+	}
+	if same {
 		log.WithFields(logrus.Fields{
 			"file": file,
-		}).Info("Change in file detected, reuploading")
-		sf.files[file] = checksum
-		if !sf.archive {
-			err = sf.handleRemove(file)
-			if err != nil {
-				return err
-			}
-		}
-		err = sf.handleCreate(file)
+		}).Debug("File replaced with the same content, skipping upload")
+		sf.updateMetadata(file, fs)
+		return nil
+	}
+	return sf.handleChanged(file, fs)
+}
+
+// handleChanged queues a changed file for upload, removing the old version
+// from Sia first unless the SiaFolder is in archive mode, where the new content
+// is uploaded as the next version of the file and the old one is kept.
+func (sf *SiaFolder) handleChanged(file string, fs fileState) error {
+	log.WithFields(logrus.Fields{
+		"file": file,
+	}).Debug("Change in file detected, reuploading")
+	sf.stats.update(func(s *Stats) { s.Reuploaded++ })
+	if sf.archive {
+		old, _ := sf.trackedFile(file)
+		fs = old.nextVersion(fs)
+	}
+	sf.trackFile(file, fs)
+	if !sf.archive {
+		err := sf.handleRemove(file)
 		if err != nil {
 			return err
 		}
 	}
-
+	sf.queueUpload(file)
 	return nil
 }
 
-// Close releases any resources allocated by a SiaFolder.
+// Close releases any resources allocated by a SiaFolder. It cancels the
+// context of the SiaFolder and waits for its goroutines, giving up on the
+// API calls still in progress once shutdownTimeout passed.
 func (sf *SiaFolder) Close() error {
-	close(sf.closeChan)
+	var deadline <-chan time.Time
+	if sf.shutdownTimeout > 0 {
+		deadline = time.After(sf.shutdownTimeout)
+	}
+	defer sf.cancelAPI()
+
+	// stop eventWatcher first so that it doesn't queue more uploads, then
+	// cancel queued uploads and wait for the ones in progress
+	sf.cancel()
+	sf.checks.close()
+	finished := waitGroup(&sf.watching, deadline)
+	dropped := sf.uploads.close()
+	if dropped > 0 {
+		log.WithFields(logrus.Fields{
+			"files": dropped,
+		}).Warn("Cancelled queued uploads")
+	}
+	finished = finished && waitGroup(&sf.workers, deadline)
+	sf.stream.close()
+	finished = finished && waitGroup(&sf.stream.consuming, deadline)
+	finished = finished && waitGroup(&sf.hooksRunning, deadline)
+	if !finished {
+		// a hung siad call must not hold up the shutdown any longer
+		sf.cancelAPI()
+	}
+	for file, err := range sf.FailedUploads() {
+		log.WithFields(logrus.Fields{
+			"file":  file,
+			"error": err,
+		}).Error("File could not be uploaded")
+	}
+
 	if sf.watcher != nil {
-		return sf.watcher.Close()
+		err := sf.watcher.Close()
+		if err != nil {
+			return err
+		}
+	}
+	err := sf.saveState()
+	if err != nil {
+		return err
+	}
+	sf.uploadManifestLogged()
+	if sf.dryRun {
+		sf.plan.logSummary()
+		if sf.dryRunOutput != "" {
+			err = sf.plan.writeFile(sf.dryRunOutput)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !finished {
+		return errors.New("timed out waiting for uploads in progress")
 	}
 	return nil
 }
 
-// getSiaPath returns a SiaPath for relative file name with prefix appended
-func getSiaPath(relpath string) modules.SiaPath {
-	return newSiaPath(filepath.Join(prefix, relpath))
+// waitGroup waits for wg until the deadline passes. It returns false if the
+// deadline passed first.
+func waitGroup(wg *sync.WaitGroup, deadline <-chan time.Time) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-deadline:
+		return false
+	}
+}
+
+// getSiaPath returns a SiaPath for relative file name with prefix appended.
+// The relative path is made slash separated first, a backslash in a siapath
+// would become part of a file name on Sia. It returns an error if Sia doesn't
+// accept the name and names aren't sanitized. With auto categorization the
+// file is synced into its category folder.
+func (sf *SiaFolder) getSiaPath(relpath string) (modules.SiaPath, error) {
+	path := siaPathString(sf.prefix, sf.categorize(relpath), isWindows)
+	if sf.sanitizeNames {
+		path = sanitizeSiaPath(path)
+	}
+	return newSiaPath(path)
+}
+
+// rootSiaPath returns the SiaPath of a file that siasync itself keeps in the
+// folder on Sia, like the manifest, which is never categorized.
+func (sf *SiaFolder) rootSiaPath(name string) (modules.SiaPath, error) {
+	return newSiaPath(siaPathString(sf.prefix, name, isWindows))
 }
 
 // handleCreate handles a file creation event. `file` is a relative path to the
@@ -393,26 +1711,104 @@ func (sf *SiaFolder) handleCreate(file string) error {
 	if err != nil {
 		return fmt.Errorf("error getting relative path to upload: %v", err)
 	}
+	if _, err := sf.getSiaPath(relpath); err != nil {
+		return err
+	}
+
+	// checksum the file before uploading it, so that a change made during
+	// the upload is detected afterwards
+	fs, err := sf.statFile(file)
+	if err != nil {
+		return sf.skipUnreadable(file, err)
+	}
+
+	// the version the content is uploaded as, which handleChanged advanced
+	// in archive mode. A file removed and created again in archive mode
+	// continues the versions of the removed file.
+	if old, exists := sf.trackedFile(file); exists {
+		fs.Version, fs.Versions = old.Version, old.Versions
+	} else if sf.archive {
+		fs.Version, fs.Versions = sf.manifestVersions(sf.fileKey(file))
+	}
+	siaPath, err := sf.uploadedSiaPath(relpath, fs)
+	if err != nil {
+		return err
+	}
+
+	// files below the minimum size, like the placeholders download clients
+	// create, are tracked and uploaded once a write makes them large enough
+	if fs.Size < sf.minFileSize {
+		log.WithFields(logrus.Fields{
+			"file":  file,
+			"bytes": fs.Size,
+		}).Debug("File is smaller than the minimum size, uploading it once it grows")
+		sf.trackFile(file, fs)
+		return nil
+	}
+	original, err := sf.checkDuplicate(file, fs)
+	if err != nil {
+		return err
+	}
+	if original != "" {
+		sf.trackDuplicate(file, original, fs)
+		return nil
+	}
+	defer sf.releaseContent(file, fs)
 
 	log.WithFields(logrus.Fields{
 		"abspath": abspath,
 	}).Debug("Uploading file")
 
-	if !dryRun {
-		err = sf.client.RenterUploadPost(abspath, getSiaPath(relpath), dataPieces, parityPieces)
-		if err.Error() == siafile.ErrPathOverload.Error() {
-			return nil
+	if !sf.dryRun {
+		coding := sf.categorySettingsOf(relpath)
+		err = sf.client.RenterUploadPost(abspath, siaPath, coding.dataPieces, coding.parityPieces)
+		sf.audit(AuditRecord{Op: auditUpload, Path: file, SiaPath: siaPath.String(), Size: fs.Size, Checksum: fs.Checksum}, err)
+		sf.listing.invalidate()
+		if err != nil && err.Error() == siafile.ErrPathOverload.Error() {
+			var adopted bool
+			siaPath, fs, adopted, err = sf.uploadExisting(file, abspath, siaPath, coding, fs)
+			if adopted {
+				return nil
+			}
+		}
+		if err != nil && fs.Size == 0 {
+			// track the file so that it is uploaded once it has content
+			sf.trackFile(file, fs)
+			return fmt.Errorf("error uploading %v: %w: %v", file, errEmptyFile, err)
+		}
+		if errors.Is(err, errNotEnoughContracts) {
+			return fmt.Errorf("error uploading %v with %v data pieces and %v parity pieces: %w", file, coding.dataPieces, coding.parityPieces, err)
 		}
 		if err != nil {
 			return fmt.Errorf("error uploading %v: %v", file, err)
 		}
+		sf.addSiadUpload(siaPath)
+	} else {
+		sf.plan.upload(file, siaPath.String(), fs.Size)
+		sf.audit(AuditRecord{Op: auditUpload, Path: file, SiaPath: siaPath.String(), Size: fs.Size, Checksum: fs.Checksum}, nil)
 	}
 
-	checksum, err := checksumFile(file)
-	if err != nil {
-		return err
+	if _, err := os.Stat(file); os.IsNotExist(err) && !sf.dryRun && !sf.archive {
+		// the file was removed while it was uploading, its REMOVE event may
+		// have been handled before the upload finished
+		log.WithFields(logrus.Fields{
+			"file": file,
+		}).Debug("File removed during upload, deleting it")
+		return sf.handleRemove(file)
+	}
+	fs.Uploaded = !sf.dryRun
+	if fs.Uploaded {
+		fs.UploadTime = time.Now()
+	}
+	fs = sf.pruneVersions(file, fs)
+	sf.trackFile(file, fs)
+	if !sf.dryRun {
+		sf.stats.update(func(s *Stats) {
+			s.Uploaded++
+			s.UploadedBytes += fs.Size
+		})
+		sf.emit(Event{Type: FileUploaded, Path: file, SiaPath: siaPath.String(), Size: fs.Size})
 	}
-	sf.files[file] = checksum
 	return nil
 }
 
@@ -422,19 +1818,77 @@ func (sf *SiaFolder) handleRemove(file string) error {
 	if err != nil {
 		return fmt.Errorf("error getting relative path to remove: %v", err)
 	}
+	fs, _ := sf.trackedFile(file)
+	siaPath, err := sf.uploadedSiaPath(relpath, fs)
+	if err != nil {
+		return err
+	}
 
 	log.WithFields(logrus.Fields{
 		"file": file,
 	}).Debug("Deleting file")
 
-	if !dryRun {
-		err = sf.client.RenterDeletePost(getSiaPath(relpath))
+	if !sf.dryRun && sf.deferRemoval(file, fs) {
+		log.WithFields(logrus.Fields{
+			"file": file,
+		}).Debug("Syncing is paused, deleting file once resumed")
+	} else if !sf.dryRun {
+		err = sf.client.RenterDeletePost(siaPath)
+		sf.audit(AuditRecord{Op: auditDelete, Path: file, SiaPath: siaPath.String(), Size: fs.Size, Checksum: fs.Checksum}, err)
+		sf.listing.invalidate()
+		if err != nil && strings.Contains(err.Error(), "no file known") {
+			// nothing to remove from Sia, just stop tracking the file
+			sf.removeVersions(file, fs)
+			sf.untrackFile(file)
+			return nil
+		}
 		if err != nil {
 			return fmt.Errorf("error removing %v: %v", file, err)
 		}
+		sf.removeVersions(file, fs)
+		sf.stats.update(func(s *Stats) { s.Deleted++ })
+		sf.emit(Event{Type: FileDeleted, Path: file, SiaPath: siaPath.String(), Size: fs.Size})
+	} else {
+		sf.plan.delete(file, siaPath.String())
+		sf.audit(AuditRecord{Op: auditDelete, Path: file, SiaPath: siaPath.String(), Size: fs.Size, Checksum: fs.Checksum}, nil)
 	}
 
-	delete(sf.files, file)
+	sf.untrackFile(file)
+	return nil
+}
+
+// reconcile brings Sia in line with the tracked files: files missing from Sia
+// are queued for upload, files deleted locally are removed from Sia and, in
+// size mode, changed files are uploaded again. ReconcileComplete is published
+// once it is done.
+func (sf *SiaFolder) reconcile() (err error) {
+	sf.sampleSpending(false)
+	defer sf.sampleSpending(false)
+	defer func() {
+		sf.emit(Event{Type: ReconcileComplete, Err: err})
+	}()
+
+	log.Info("Uploading files missing from Sia")
+	err = sf.uploadNonExisting()
+	if err != nil {
+		return err
+	}
+
+	// remove files that are in Sia but not in local directory
+	if !sf.archive {
+		log.Info("Removing files missing from local directory")
+		err = sf.removeDeleted()
+		if err != nil {
+			return err
+		}
+	}
+
+	// since there is no simple way to retrieve a sha256 checksum or local
+	// mtime of a remote file, this only works in size mode
+	if sf.changeDetection == "size" {
+		log.Info("Uploading changed files")
+		return sf.uploadChanged()
+	}
 	return nil
 }
 
@@ -446,14 +1900,14 @@ func (sf *SiaFolder) uploadNonExisting() error {
 		return err
 	}
 
-	for file := range sf.files {
-		goodForWrite, err := checkFile(filepath.Clean(file))
+	for _, file := range sf.trackedFiles() {
+		goodForWrite, err := sf.checkFile(filepath.Clean(file))
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"error": err.Error(),
 			}).Error("Error with checkFile")
 		}
-		if !goodForWrite {
+		if !goodForWrite || sf.isExcluded(file) {
 			continue
 		}
 
@@ -461,11 +1915,29 @@ func (sf *SiaFolder) uploadNonExisting() error {
 		if err != nil {
 			return err
 		}
-		if _, ok := renterFiles[getSiaPath(relpath)]; !ok {
-			err := sf.handleCreate(file)
-			if err != nil {
-				return err
+		fs, _ := sf.trackedFile(file)
+		siaPath, err := sf.uploadedSiaPath(relpath, fs)
+		if err != nil {
+			continue
+		}
+
+		// a duplicate shares the upload of its original, unless the
+		// original changed or was removed while siasync wasn't running
+		if fs.DuplicateOf != "" {
+			if sf.originalTracked(fs) {
+				continue
 			}
+			fs.DuplicateOf = ""
+			fs.Uploaded = false
+			sf.trackFile(file, fs)
+		}
+
+		if siafile, ok := renterFiles[siaPath]; !ok {
+			sf.queueUpload(file)
+		} else if fs, _ := sf.trackedFile(file); !fs.Uploaded {
+			fs.Uploaded = true
+			fs.UploadTime = siafile.CreateTime
+			sf.trackFile(file, fs)
 		}
 	}
 
@@ -480,14 +1952,14 @@ func (sf *SiaFolder) uploadChanged() error {
 		return err
 	}
 
-	for file := range sf.files {
-		goodForWrite, err := checkFile(filepath.Clean(file))
+	for _, file := range sf.trackedFiles() {
+		goodForWrite, err := sf.checkFile(filepath.Clean(file))
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"error": err.Error(),
 			}).Error("Error with checkFile")
 		}
-		if !goodForWrite {
+		if !goodForWrite || sf.changedByEvent(file) {
 			continue
 		}
 
@@ -495,11 +1967,14 @@ func (sf *SiaFolder) uploadChanged() error {
 		if err != nil {
 			return err
 		}
-		if siafile, ok := renterFiles[getSiaPath(relpath)]; ok {
-			sf.files[file] = strconv.FormatInt(int64(siafile.Filesize), 10)
-			// set file size to size in Sia and call handleFileWrite
-			// if local file has different size it will reload file to Sia
-			err := sf.handleFileWrite(file)
+		// reload the file to Sia if the local file has a different size
+		fs, _ := sf.trackedFile(file)
+		siaPath, err := sf.uploadedSiaPath(relpath, fs)
+		if err != nil {
+			continue
+		}
+		if siafile, ok := renterFiles[siaPath]; ok && int64(siafile.Filesize) != fs.Size {
+			err := sf.handleChanged(file, fs)
 			if err != nil {
 				return err
 			}
@@ -510,15 +1985,76 @@ func (sf *SiaFolder) uploadChanged() error {
 }
 
 // removeDeleted runs once and removes any files from Sia that don't exist in
-// local directory anymore
+// local directory anymore, unless their removal is held back because too many
+// files were removed.
 func (sf *SiaFolder) removeDeleted() error {
+	files, err := sf.deletedFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !sf.dryRun && sf.holdRemoval(file, fileState{}) {
+			continue
+		}
+		err = sf.handleRemove(file)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Error with handleRemove")
+		}
+	}
+	return nil
+}
+
+// deletedFiles returns the sorted local paths of the files below the prefix on
+// Sia that don't exist in the local directory anymore. Files that are excluded
+// locally are left out.
+func (sf *SiaFolder) deletedFiles() ([]string, error) {
 	renterFiles, err := sf.getSiaFiles()
 	if err != nil && !strings.Contains(err.Error(), errNoFiles.Error()) {
-		return err
+		return nil, err
+	}
+
+	root, err := newSiaPath(sf.prefix)
+	if err != nil {
+		return nil, err
 	}
 
+	// the siapaths of the tracked files and of their versions, which differ
+	// from their local paths if names are sanitized. Files that are queued
+	// or uploading are only tracked once their upload is done, they are
+	// looked up first so that an upload finishing in between is in one of
+	// the two.
+	synced := make(map[modules.SiaPath]struct{})
+	for _, file := range sf.uploads.pending() {
+		relpath, err := filepath.Rel(sf.path, file)
+		if err != nil {
+			continue
+		}
+		fs, _ := sf.trackedFile(file)
+		if siaPath, err := sf.uploadedSiaPath(relpath, fs); err == nil {
+			synced[siaPath] = struct{}{}
+		}
+	}
+	for _, file := range sf.trackedFiles() {
+		relpath, err := filepath.Rel(sf.path, file)
+		if err != nil {
+			continue
+		}
+		fs, _ := sf.trackedFile(file)
+		if siaPath, err := sf.uploadedSiaPath(relpath, fs); err == nil {
+			synced[siaPath] = struct{}{}
+		}
+		if siaPaths, err := sf.versionSiaPaths(relpath, fs); err == nil {
+			for _, siaPath := range siaPaths {
+				synced[siaPath] = struct{}{}
+			}
+		}
+	}
+
+	var files []string
 	for siapath, siafile := range renterFiles {
-		goodForWrite, err := checkFile(filepath.Clean(siafile.SiaPath.Path))
+		goodForWrite, err := sf.checkFile(filepath.Clean(siafile.SiaPath.Path))
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"error": err.Error(),
@@ -528,29 +2064,72 @@ func (sf *SiaFolder) removeDeleted() error {
 			continue
 		}
 
-		filePath := filepath.Join(sf.path, siapath.String())
-		if _, ok := sf.files[filePath]; !ok {
-			err = sf.handleRemove(filePath)
-			if err != nil {
-				log.WithFields(logrus.Fields{
-					"error": err.Error(),
-				}).Error("Error with handleRemove")
-			}
+		// map the siapath back to the local file, files excluded locally are
+		// left alone
+		relpath := sf.uncategorize(strings.TrimPrefix(siapath.String(), root.String()+"/"))
+		filePath := filepath.Join(sf.path, filepath.FromSlash(relpath))
+		if sf.isExcluded(filePath) || sf.changedByEvent(filePath) {
+			continue
+		}
+		if _, ok := synced[siapath]; !ok {
+			files = append(files, filePath)
 		}
 	}
-
-	return nil
+	sort.Strings(files)
+	return files, nil
 }
 
-// filters Sia remote files, only files that match prefix parameter are returned
+// getSiaFiles returns the Sia remote files below the prefix, including the
+// files in its subdirectories. The listing is cached for listingMaxAge, or
+// until siasync changes the Sia folder, and must not be modified.
 func (sf *SiaFolder) getSiaFiles() (map[modules.SiaPath]modules.FileInfo, error) {
-	siaSyncDir, err := sf.client.RenterGetDir(newSiaPath(prefix))
+	return sf.listing.get(sf.listSiaFiles)
+}
+
+// listSiaFiles lists the Sia remote files below the prefix by walking every
+// directory below it.
+func (sf *SiaFolder) listSiaFiles() (map[modules.SiaPath]modules.FileInfo, error) {
+	root, err := newSiaPath(sf.prefix)
 	if err != nil {
 		return nil, err
 	}
-	siaFiles := make(map[modules.SiaPath]modules.FileInfo)
-	for _, file := range siaSyncDir.Files {
-		siaFiles[file.SiaPath] = file
+	var files []modules.FileInfo
+	dirs := []modules.SiaPath{root}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		siaDir, err := sf.client.RenterGetDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, siaDir.Files...)
+		for _, subdir := range siaDir.Directories {
+			// siad lists the requested directory itself first
+			if subdir.SiaPath.Equals(dir) {
+				continue
+			}
+			dirs = append(dirs, subdir.SiaPath)
+		}
+	}
+	siaFiles := filterSiaFiles(files, root)
+
+	// the manifest isn't a synced file
+	for _, name := range []string{manifestName, manifestName + ".tmp"} {
+		if siaPath, err := sf.rootSiaPath(name); err == nil {
+			delete(siaFiles, siaPath)
+		}
 	}
 	return siaFiles, nil
 }
+
+// filterSiaFiles filters Sia remote files, only files below the dir siapath
+// are returned
+func filterSiaFiles(files []modules.FileInfo, dir modules.SiaPath) map[modules.SiaPath]modules.FileInfo {
+	siaFiles := make(map[modules.SiaPath]modules.FileInfo)
+	for _, file := range files {
+		if strings.HasPrefix(file.SiaPath.String(), dir.String()+"/") {
+			siaFiles[file.SiaPath] = file
+		}
+	}
+	return siaFiles
+}
@@ -1,63 +1,379 @@
-package main
+package siasync
 
 import (
-	"net/url"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"gitlab.com/NebulousLabs/Sia/api"
+	"github.com/fsnotify/fsnotify"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/modules/renter/siafile"
+	"gitlab.com/NebulousLabs/Sia/node/api"
 )
 
 var testFiles = []string{"test", "testdir/testfile3.txt", "testdir/testdir2/testfile4.txt", "testfile1.txt", "testfile2.txt"}
 
 const testDir = "test"
 
+// testStateFile is the state file used by every test, so that no state file
+// is written into the test directory.
+var testStateFile string
+
+// TestMain initializes the state file used by every test.
+func TestMain(m *testing.M) {
+	stateDir, err := ioutil.TempDir("", "siasync-state")
+	if err != nil {
+		log.Fatal(err)
+	}
+	testStateFile = filepath.Join(stateDir, DefaultStateFile)
+
+	code := m.Run()
+	os.RemoveAll(stateDir)
+	os.Exit(code)
+}
+
+// testConfig returns the configuration used by the tests, syncing to the
+// siasync folder on Sia.
+func testConfig() Config {
+	return Config{
+		Prefix:    "siasync",
+		StateFile: testStateFile,
+	}
+}
+
+// newSyncedSiafolder returns a SiaFolder syncing path once its initial sync is
+// done.
+func newSyncedSiafolder(path string, client SiaClient, config Config) (*SiaFolder, error) {
+	sf, err := NewSiafolder(context.Background(), path, client, config)
+	if err != nil {
+		return nil, err
+	}
+	err = sf.WaitInitialSync()
+	if err != nil {
+		sf.Close()
+		return nil, err
+	}
+	return sf, nil
+}
+
+// testSiaPath returns the SiaPath of a file synced with testConfig.
+func testSiaPath(relpath string) modules.SiaPath {
+	return mustSiaPath(siaPathString("siasync", relpath, isWindows))
+}
+
+// waitFor polls cond until it is true or 5 seconds passed, and reports
+// whether it became true.
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// mustSiaPath returns the SiaPath of a valid slash separated path.
+func mustSiaPath(path string) modules.SiaPath {
+	siaPath, err := newSiaPath(path)
+	if err != nil {
+		panic(err)
+	}
+	return siaPath
+}
+
+// testingClient is an in-memory SiaClient that records uploads and deletions.
 type testingClient struct {
-	siaFiles map[string]string
+	mu         sync.Mutex
+	siaFiles   map[string]string    // siaFiles maps siapaths to checksums
+	contents   map[string][]byte    // contents maps siapaths to the uploaded data
+	siaDirs    map[string]time.Time // siaDirs maps the explicitly created directories to their creation time
+	ops        []string             // ops is the ordered list of uploads and deletions
+	uploads    int                  // uploads counts every upload request, including rejected ones
+	offline    bool                 // offline makes uploads and version requests fail as if siad was down
+	listings   int                  // listings counts every directory listing request
+	redundancy float64              // redundancy is reported for every file, files with at least 1 are available
+	uploading  bool                 // uploading reports every file as half uploaded
+}
+
+// uploadProgress returns the UploadProgress reported for every file.
+func (t *testingClient) uploadProgress() float64 {
+	if t.uploading {
+		return 50
+	}
+	return 100
 }
 
 func newTestingClient() *testingClient {
 	return &testingClient{
 		siaFiles: make(map[string]string),
+		contents: make(map[string][]byte),
+		siaDirs:  make(map[string]time.Time),
 	}
 }
 
-func (t *testingClient) Get(resource string, obj interface{}) error {
-	if resource == "/renter/contracts" {
-		rc := obj.(*api.RenterContracts)
-		rc.Contracts = make([]api.RenterContract, 50)
-		return nil
+// dirExists reports whether the directory exists on Sia, created explicitly
+// or by the files inside. The caller must hold t.mu.
+func (t *testingClient) dirExists(path string) bool {
+	for dir := range t.siaDirs {
+		if dir == path || strings.HasPrefix(dir, path+"/") {
+			return true
+		}
 	}
-	return nil
+	for file := range t.siaFiles {
+		if strings.HasPrefix(file, path+"/") {
+			return true
+		}
+	}
+	return false
 }
 
-func (t *testingClient) Post(resource string, data string, obj interface{}) error {
-	params, err := url.ParseQuery(data)
+// file returns the checksum of the uploaded file at relpath and whether it
+// exists.
+func (t *testingClient) file(relpath string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	checksum, exists := t.siaFiles[testSiaPath(relpath).String()]
+	return checksum, exists
+}
+
+// uploadRequests returns the number of upload requests so far.
+func (t *testingClient) uploadRequests() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.uploads
+}
+
+// operations returns a copy of the uploads and deletions performed so far.
+func (t *testingClient) operations() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.ops...)
+}
+
+// setOffline simulates siad going away or coming back.
+func (t *testingClient) setOffline(offline bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.offline = offline
+}
+
+func (t *testingClient) DaemonVersionGet() (api.DaemonVersionGet, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.offline {
+		return api.DaemonVersionGet{}, errors.New("connection refused")
+	}
+	return api.DaemonVersionGet{}, nil
+}
+
+func (t *testingClient) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.uploads++
+	if t.offline {
+		return errors.New("connection refused")
+	}
+	if _, exists := t.siaFiles[siaPath.String()]; exists {
+		return siafile.ErrPathOverload
+	}
+	checksum, err := sha256File(path)
 	if err != nil {
 		return err
 	}
-	if strings.Index(resource, "/renter/upload/") == 0 {
-		path := strings.Split(resource, "/renter/upload/")[1]
-		checksum, err := checksumFile(params.Get("source"))
-		if err != nil {
-			return err
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	t.siaFiles[siaPath.String()] = checksum
+	t.contents[siaPath.String()] = data
+	t.ops = append(t.ops, "upload "+siaPath.String())
+	return nil
+}
+
+func (t *testingClient) RenterDeletePost(siaPath modules.SiaPath) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.siaFiles[siaPath.String()]; !exists {
+		return errors.New("no file known with that path")
+	}
+	delete(t.siaFiles, siaPath.String())
+	delete(t.contents, siaPath.String())
+	t.ops = append(t.ops, "delete "+siaPath.String())
+	return nil
+}
+
+func (t *testingClient) RenterFileGet(siaPath modules.SiaPath) (api.RenterFile, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.siaFiles[siaPath.String()]; !exists {
+		return api.RenterFile{}, errors.New("no file known with that path")
+	}
+	return api.RenterFile{File: modules.FileInfo{SiaPath: siaPath, Filesize: uint64(len(t.contents[siaPath.String()]))}}, nil
+}
+
+func (t *testingClient) RenterRenamePost(siaPathOld, siaPathNew modules.SiaPath) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	checksum, exists := t.siaFiles[siaPathOld.String()]
+	if !exists {
+		return errors.New("no file known with that path")
+	}
+	if _, exists := t.siaFiles[siaPathNew.String()]; exists {
+		return siafile.ErrPathOverload
+	}
+	delete(t.siaFiles, siaPathOld.String())
+	t.siaFiles[siaPathNew.String()] = checksum
+	t.contents[siaPathNew.String()] = t.contents[siaPathOld.String()]
+	delete(t.contents, siaPathOld.String())
+	t.ops = append(t.ops, "rename "+siaPathOld.String()+" "+siaPathNew.String())
+	return nil
+}
+
+func (t *testingClient) RenterDirCreatePost(siaPath modules.SiaPath) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.offline {
+		return errors.New("connection refused")
+	}
+	if t.dirExists(siaPath.String()) {
+		return errDirExists
+	}
+	t.siaDirs[siaPath.String()] = time.Now()
+	return nil
+}
+
+func (t *testingClient) RenterDirDeletePost(siaPath modules.SiaPath) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	path := siaPath.String()
+	if !t.dirExists(path) {
+		return errors.New("no such file or directory")
+	}
+	// siad deletes directories recursively
+	for dir := range t.siaDirs {
+		if dir == path || strings.HasPrefix(dir, path+"/") {
+			delete(t.siaDirs, dir)
 		}
-		t.siaFiles[path] = checksum
 	}
-	if strings.Index(resource, "/renter/delete/") == 0 {
-		path := strings.Split(resource, "/renter/delete/")[1]
-		delete(t.siaFiles, path)
+	for file := range t.siaFiles {
+		if strings.HasPrefix(file, path+"/") {
+			delete(t.siaFiles, file)
+			delete(t.contents, file)
+		}
 	}
 	return nil
 }
 
+func (t *testingClient) RenterGetDir(siaPath modules.SiaPath) (api.RenterDirectory, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listings++
+	var rd api.RenterDirectory
+	_, found := t.siaDirs[siaPath.String()]
+	dirs := make(map[string]struct{})
+	for dir := range t.siaDirs {
+		if strings.HasPrefix(dir, siaPath.String()+"/") {
+			found = true
+			rest := strings.TrimPrefix(dir, siaPath.String()+"/")
+			if i := strings.Index(rest, "/"); i >= 0 {
+				rest = rest[:i]
+			}
+			dirs[rest] = struct{}{}
+		}
+	}
+	for path := range t.siaFiles {
+		if !strings.HasPrefix(path, siaPath.String()+"/") {
+			continue
+		}
+		found = true
+		rest := strings.TrimPrefix(path, siaPath.String()+"/")
+		if i := strings.Index(rest, "/"); i >= 0 {
+			dirs[rest[:i]] = struct{}{}
+			continue
+		}
+		fileSiaPath, err := modules.NewSiaPath(path)
+		if err != nil {
+			return api.RenterDirectory{}, err
+		}
+		rd.Files = append(rd.Files, modules.FileInfo{
+			SiaPath:        fileSiaPath,
+			Filesize:       uint64(len(t.contents[path])),
+			Available:      t.redundancy >= 1,
+			Redundancy:     t.redundancy,
+			UploadProgress: t.uploadProgress(),
+		})
+	}
+	if !found {
+		return api.RenterDirectory{}, errNoFiles
+	}
+	for dir := range dirs {
+		dirSiaPath, err := modules.NewSiaPath(siaPath.String() + "/" + dir)
+		if err != nil {
+			return api.RenterDirectory{}, err
+		}
+		rd.Directories = append(rd.Directories, t.dirInfo(dirSiaPath))
+	}
+	return rd, nil
+}
+
+// dirInfo returns the number of files and subdirectories directly inside a
+// directory and its creation time as its modification time. The caller must
+// hold t.mu.
+func (t *testingClient) dirInfo(siaPath modules.SiaPath) modules.DirectoryInfo {
+	info := modules.DirectoryInfo{
+		SiaPath:           siaPath,
+		MostRecentModTime: t.siaDirs[siaPath.String()],
+	}
+	subdirs := make(map[string]struct{})
+	prefix := siaPath.String() + "/"
+	for dir := range t.siaDirs {
+		if strings.HasPrefix(dir, prefix) {
+			subdirs[strings.SplitN(strings.TrimPrefix(dir, prefix), "/", 2)[0]] = struct{}{}
+		}
+	}
+	for file := range t.siaFiles {
+		if !strings.HasPrefix(file, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(file, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			subdirs[rest[:i]] = struct{}{}
+			continue
+		}
+		info.NumFiles++
+	}
+	info.NumSubDirs = uint64(len(subdirs))
+	return info
+}
+
+func (t *testingClient) RenterDownloadFullGet(siaPath modules.SiaPath, destination string, async bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data, exists := t.contents[siaPath.String()]
+	if !exists {
+		return errors.New("no file known with that path")
+	}
+	t.ops = append(t.ops, "download "+siaPath.String())
+	return ioutil.WriteFile(destination, data, 0644)
+}
+
 func TestSiafolder(t *testing.T) {
 	mockClient := newTestingClient()
 
-	sf, err := NewSiafolder(testDir, mockClient)
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -65,17 +381,105 @@ func TestSiafolder(t *testing.T) {
 
 	// should have uploaded all of our test files
 	for _, file := range testFiles {
-		if _, exists := mockClient.siaFiles[file]; !exists {
+		if _, exists := mockClient.file(file); !exists {
 			t.Fatal("our test files should have initially been uploaded if they didnt exist")
 		}
 	}
 }
 
+// TestSiafolderScanWorkers verifies that every file is tracked with its
+// checksum when the directory is scanned by several workers.
+func TestSiafolderScanWorkers(t *testing.T) {
+	config := testConfig()
+	config.Rescan = true
+	config.ScanWorkers = 4
+	sf, err := newSyncedSiafolder(testDir, newTestingClient(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	for _, file := range testFiles {
+		path := filepath.Join(sf.path, filepath.FromSlash(file))
+		checksum, err := sha256File(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fs, ok := sf.trackedFile(path); !ok || fs.Checksum != checksum {
+			t.Fatalf("%v should be tracked with checksum %v, got %+v", file, checksum, fs)
+		}
+	}
+}
+
+// TestSiafolderWatchDuringInitialSync verifies that NewSiafolder returns
+// before the initial uploads are done and that changes are synced meanwhile.
+func TestSiafolderWatchDuringInitialSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "large"), []byte("large"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &blockingClient{
+		testingClient: newTestingClient(),
+		release:       make(chan struct{}),
+		blocked:       "large",
+	}
+	config := testConfig()
+	config.MaxUploads = 2
+	sf, err := NewSiafolder(context.Background(), dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	if sf.Ready() {
+		t.Fatal("the SiaFolder shouldn't be ready while large is uploading")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "new"), []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, exists := client.file("new"); !exists; _, exists = client.file("new") {
+		if time.Now().After(deadline) {
+			t.Fatal("new should be uploaded during the initial sync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	status, err := sf.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Ready {
+		t.Fatal("the status shouldn't be ready while large is uploading")
+	}
+
+	close(client.release)
+	err = sf.WaitInitialSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sf.Ready() {
+		t.Fatal("the SiaFolder should be ready after the initial sync")
+	}
+	if _, exists := client.file("large"); !exists {
+		t.Fatal("large should be uploaded by the initial sync")
+	}
+	if uploads := client.uploadRequests(); uploads != 2 {
+		t.Fatalf("each file should be uploaded once, got %v uploads", uploads)
+	}
+}
+
 // TestSiafolderCreateDelete verifies that files created or removed in the
 // watched directory are correctly uploaded and deleted.
 func TestSiafolderCreateDelete(t *testing.T) {
 	mockClient := newTestingClient()
-	sf, err := NewSiafolder(testDir, mockClient)
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -96,7 +500,7 @@ func TestSiafolderCreateDelete(t *testing.T) {
 	// wait a bit for the filesystem event to propogate
 	time.Sleep(time.Second)
 
-	if _, exists := mockClient.siaFiles["newfile"]; !exists {
+	if _, exists := mockClient.file("newfile"); !exists {
 		t.Fatal("newfile should have been uploaded when it was created on disk")
 	}
 
@@ -106,7 +510,7 @@ func TestSiafolderCreateDelete(t *testing.T) {
 
 	time.Sleep(time.Second)
 
-	if _, exists := mockClient.siaFiles["newfile"]; exists {
+	if _, exists := mockClient.file("newfile"); exists {
 		t.Fatal("newfile should have been deleted when it was removed on disk")
 	}
 
@@ -123,7 +527,7 @@ func TestSiafolderCreateDelete(t *testing.T) {
 
 	time.Sleep(time.Second)
 
-	if _, exists := mockClient.siaFiles["testdir/newfile"]; !exists {
+	if _, exists := mockClient.file("testdir/newfile"); !exists {
 		t.Fatal("newfile should have been uploaded when it was created on disk")
 	}
 
@@ -133,7 +537,7 @@ func TestSiafolderCreateDelete(t *testing.T) {
 
 	time.Sleep(time.Second)
 
-	if _, exists := mockClient.siaFiles["testdir/newfile"]; exists {
+	if _, exists := mockClient.file("testdir/newfile"); exists {
 		t.Fatal("newfile should have been deleted when it was removed on disk")
 	}
 }
@@ -142,7 +546,7 @@ func TestSiafolderCreateDelete(t *testing.T) {
 // directories under the watched directory get correctly uploaded.
 func TestSiafolderCreateDirectory(t *testing.T) {
 	mockClient := newTestingClient()
-	sf, err := NewSiafolder(testDir, mockClient)
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -158,7 +562,7 @@ func TestSiafolderCreateDirectory(t *testing.T) {
 	// should not upload empty directories
 	time.Sleep(time.Second)
 
-	if _, exists := mockClient.siaFiles["newdir"]; exists {
+	if _, exists := mockClient.file("newdir"); exists {
 		t.Fatal("should not upload empty directories")
 	}
 
@@ -171,7 +575,7 @@ func TestSiafolderCreateDirectory(t *testing.T) {
 
 	time.Sleep(time.Second)
 
-	if _, exists := mockClient.siaFiles["newdir/testfile"]; !exists {
+	if _, exists := mockClient.file("newdir/testfile"); !exists {
 		t.Fatal("should have uploaded file in newly created directory")
 	}
 }
@@ -180,7 +584,7 @@ func TestSiafolderCreateDirectory(t *testing.T) {
 // it is changed on disk.
 func TestSiafolderFileWrite(t *testing.T) {
 	mockClient := newTestingClient()
-	sf, err := NewSiafolder(testDir, mockClient)
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -199,7 +603,7 @@ func TestSiafolderFileWrite(t *testing.T) {
 	// wait a bit for the filesystem event to propogate
 	time.Sleep(time.Second)
 
-	oldChecksum, exists := mockClient.siaFiles["newfile"]
+	oldChecksum, exists := mockClient.file("newfile")
 	if !exists {
 		t.Fatal("newfile should have been uploaded when it was created on disk")
 	}
@@ -212,7 +616,7 @@ func TestSiafolderFileWrite(t *testing.T) {
 
 	time.Sleep(time.Second)
 
-	newChecksum, exists := mockClient.siaFiles["newfile"]
+	newChecksum, exists := mockClient.file("newfile")
 	if !exists {
 		t.Fatal("newfile did not exist after writing data to it")
 	}
@@ -220,3 +624,3953 @@ func TestSiafolderFileWrite(t *testing.T) {
 		t.Fatal("checksum did not change")
 	}
 }
+
+// TestSiafolderSingleFile verifies that a SiaFolder syncing a single file
+// uploads and updates only that file, and leaves the rest of its directory
+// and of the folder on Sia alone.
+func TestSiafolderSingleFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "export.db")
+	for _, path := range []string{file, filepath.Join(dir, "sibling"), filepath.Join(dir, "sub", "other")} {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(path), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mockClient := newTestingClient()
+	mockClient.siaFiles[testSiaPath("unrelated").String()] = "unrelated"
+	sf, err := newSyncedSiafolder(file, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	if _, exists := mockClient.file("export.db"); !exists {
+		t.Fatal("export.db should have been uploaded under its name")
+	}
+	for _, relpath := range []string{"sibling", "sub/other"} {
+		if _, exists := mockClient.file(relpath); exists {
+			t.Fatalf("%v should not have been uploaded", relpath)
+		}
+	}
+	if _, exists := mockClient.file("unrelated"); !exists {
+		t.Fatal("files on Sia that aren't the synced file should be left alone")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "new"), []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file, []byte("new export"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	checksum, err := sha256File(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote, _ := mockClient.file("export.db"); remote != checksum {
+		t.Fatal("export.db should have been uploaded again with its new content")
+	}
+	if _, exists := mockClient.file("new"); exists {
+		t.Fatal("new should not have been uploaded")
+	}
+	status, err := sf.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Watched != 1 || len(status.Files) != 1 || status.Files[0].Path != "export.db" {
+		t.Fatalf("only export.db should be watched, got %+v", status)
+	}
+
+	err = os.Remove(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := mockClient.file("export.db"); exists {
+		t.Fatal("export.db should have been removed from Sia")
+	}
+}
+
+// TestSiafolderCreateWrite verifies that a file created and written to right
+// away is uploaded once, with its final content.
+func TestSiafolderCreateWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	newfile := filepath.Join(dir, "newfile")
+	f, err := os.Create(newfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(minSettleDuration / 4)
+	_, err = f.Write([]byte("some data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	checksum, err := sha256File(newfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote, exists := mockClient.file("newfile"); !exists || remote != checksum {
+		t.Fatal("newfile should have been uploaded with its final content")
+	}
+	if fs, _ := sf.trackedFile(newfile); fs.Checksum != checksum {
+		t.Fatalf("newfile should be tracked with checksum %v, got %v", checksum, fs.Checksum)
+	}
+	if uploads := mockClient.uploadRequests(); uploads != 1 {
+		t.Fatalf("newfile should have been uploaded once, got %v uploads", uploads)
+	}
+}
+
+// TestSiafolderChmod verifies that CHMOD events of a file that didn't change
+// are ignored, and that a file whose modification time changed is checked.
+func TestSiafolderChmod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(file, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	for i := 0; i < 100; i++ {
+		err = os.Chmod(file, os.FileMode(0600+i%2*0044))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Second)
+	if ops := mockClient.operations(); len(ops) != 1 {
+		t.Fatalf("CHMOD events should change nothing on Sia, got %v", ops)
+	}
+
+	// without eventWatcher, the events can be handled one at a time
+	config := testConfig()
+	config.SyncOnly = true
+	unwatched, err := newSyncedSiafolder(dir, newTestingClient(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unwatched.Close()
+	unwatched.handleEvent(fsnotify.Event{Name: file, Op: fsnotify.Chmod})
+	if len(unwatched.pending) != 0 {
+		t.Fatal("a CHMOD event of an unchanged file should be ignored")
+	}
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(file, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwatched.handleEvent(fsnotify.Event{Name: file, Op: fsnotify.Chmod})
+	if pe, ok := unwatched.pending[file]; !ok || pe.op != fsnotify.Write {
+		t.Fatal("a CHMOD event of a file with a new modification time should be handled as a WRITE")
+	}
+}
+
+// TestChecksumFileSameSize verifies that rewriting a file with different
+// content of the same length is only detected in sha256 mode.
+func TestChecksumFileSameSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "samesize")
+
+	for _, mode := range []string{"sha256", "size"} {
+		sf := &SiaFolder{changeDetection: mode}
+
+		err = ioutil.WriteFile(file, []byte("aaaaa"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		oldChecksum, err := sf.checksumFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(file, []byte("bbbbb"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newChecksum, err := sf.checksumFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changed := oldChecksum != newChecksum
+		if changed != (mode == "sha256") {
+			t.Fatalf("%v mode: expected change detected to be %v, got %v", mode, mode == "sha256", changed)
+		}
+	}
+}
+
+// TestSiafolderArchive verifies that a changed file is deleted from Sia before
+// being re-uploaded when archive is false, and uploaded next to the remote
+// file, which is left alone, when archive is true.
+func TestSiafolderArchive(t *testing.T) {
+	for _, archive := range []bool{false, true} {
+		config := testConfig()
+		config.Archive = archive
+		mockClient := newTestingClient()
+		sf, err := newSyncedSiafolder(testDir, mockClient, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		newfile := filepath.Join(testDir, "newfile")
+		f, err := os.Create(newfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(time.Second)
+
+		oldChecksum, exists := mockClient.file("newfile")
+		if !exists {
+			t.Fatal("newfile should have been uploaded when it was created on disk")
+		}
+		numOps := len(mockClient.operations())
+
+		_, err = f.Write([]byte{40, 40, 40, 40, 40})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(time.Second)
+
+		siaPath := testSiaPath("newfile").String()
+		ops := mockClient.operations()[numOps:]
+		newChecksum, _ := mockClient.file("newfile")
+		if archive {
+			if len(ops) != 1 || ops[0] != "upload "+siaPath+".v2" {
+				t.Fatalf("archive mode should upload the new content next to the remote file, got %v", ops)
+			}
+			if newChecksum != oldChecksum {
+				t.Fatal("archive mode should not replace the remote file")
+			}
+		} else {
+			if len(ops) != 2 || ops[0] != "delete "+siaPath || ops[1] != "upload "+siaPath {
+				t.Fatalf("expected the remote file to be deleted and re-uploaded, got %v", ops)
+			}
+			if newChecksum == oldChecksum {
+				t.Fatal("checksum did not change")
+			}
+		}
+
+		f.Close()
+		os.Remove(f.Name())
+		time.Sleep(time.Second)
+		sf.Close()
+	}
+}
+
+// TestSiafolderVersions verifies that a file changed in archive mode is
+// uploaded as its next version, that the oldest versions beyond MaxVersions
+// are removed and that restore picks the latest version or the one current at
+// RestoreAt.
+func TestSiafolderVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(file, []byte("one"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig()
+	config.StateFile = filepath.Join(dir, DefaultStateFile)
+	config.Archive = true
+	config.MaxVersions = 2
+	config.Manifest = true
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numOps := len(mockClient.operations())
+
+	err = ioutil.WriteFile(file, []byte("two"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	betweenVersions := time.Now()
+	err = ioutil.WriteFile(file, []byte("three"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	siaPath := testSiaPath("file").String()
+	ops := mockClient.operations()[numOps:]
+	if len(ops) != 3 || ops[0] != "upload "+siaPath+".v2" || ops[1] != "upload "+siaPath+".v3" || ops[2] != "delete "+siaPath {
+		t.Fatalf("expected two versions to be uploaded and the first one removed, got %v", ops)
+	}
+	fs, _ := sf.trackedFile(file)
+	if fs.Version != 3 || len(fs.Versions) != 1 || fs.Versions[0].Version != 2 || fs.Versions[0].Size != 3 {
+		t.Fatalf("unexpected version index %+v", fs)
+	}
+	sf.Close()
+
+	restoreDir, err := ioutil.TempDir("", "siasync-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(restoreDir)
+	for _, test := range []struct {
+		at   time.Time
+		data string
+	}{
+		{time.Time{}, "three"},
+		{betweenVersions, "two"},
+	} {
+		config.RestoreAt = test.at
+		err = Restore(mockClient, restoreDir, config, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored, err := ioutil.ReadFile(filepath.Join(restoreDir, "file"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(restored) != test.data {
+			t.Fatalf("restore at %v should have restored %q, got %q", test.at, test.data, restored)
+		}
+		if _, err := os.Stat(filepath.Join(restoreDir, "file.v2")); !os.IsNotExist(err) {
+			t.Fatal("versions should be restored under the name of their file")
+		}
+	}
+}
+
+// TestCheckFile verifies that the include and exclude extension filters ignore
+// case and leading dots.
+func TestCheckFile(t *testing.T) {
+	tests := []struct {
+		include string
+		exclude string
+		file    string
+		good    bool
+	}{
+		{"", "", "movie.mkv", true},
+		{"mkv,mp4,srt", "", "movie.mkv", true},
+		{"mkv,mp4,srt", "", "movie.MKV", true},
+		{".mkv, .SRT", "", "movie.srt", true},
+		{"mkv,mp4,srt", "", "movie.nfo", false},
+		{"mkv,mp4,srt", "", "movie", false},
+		{"", "part,nfo", "movie.NFO", false},
+		{"", "part,nfo", "movie.mkv", true},
+		{"mkv", "mkv", "movie.mkv", true},
+	}
+	for _, test := range tests {
+		sf := &SiaFolder{
+			includeExtensions: ParseExtensions(test.include),
+			excludeExtensions: ParseExtensions(test.exclude),
+		}
+		good, err := sf.checkFile(test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if good != test.good {
+			t.Errorf("checkFile(%q) with include %q and exclude %q should be %v", test.file, test.include, test.exclude, test.good)
+		}
+	}
+}
+
+// TestSiafolderState verifies that checksums of unchanged files are loaded
+// from the state file, and that a corrupt state file causes a full scan.
+func TestSiafolderState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(file, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := sha256File(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Close()
+	if !sf.state["file"].Uploaded {
+		t.Fatal("file should have been recorded as uploaded")
+	}
+
+	// replace the stored checksum, it should be trusted because the size and
+	// modification time of the file did not change
+	fs := sf.state["file"]
+	fs.Checksum = "cached"
+	sf.trackFile(file, fs)
+	err = sf.saveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err = newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Close()
+	if sf.files["file"] != "cached" {
+		t.Fatal("checksum should have been loaded from the state file")
+	}
+
+	// without the cache the file is checksummed again and the state file is
+	// left alone
+	config := testConfig()
+	config.NoCache = true
+	sf, err = newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Close()
+	if sf.files["file"] != checksum {
+		t.Fatal("file should have been checksummed without the cache")
+	}
+	loaded, err := sf.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded["file"].Checksum != "cached" {
+		t.Fatal("the state file should not have been written without the cache")
+	}
+
+	// a corrupt state file should fall back to checksumming every file
+	err = ioutil.WriteFile(testStateFile, []byte("{corrupt"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err = newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Close()
+	if sf.files["file"] != checksum {
+		t.Fatal("file should have been checksummed after a corrupt state file")
+	}
+}
+
+// TestSiafolderFileKeys verifies that files are tracked under their slash
+// separated path relative to the synced directory, whether the directory is
+// given as a relative or an absolute path.
+func TestSiafolderFileKeys(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "sub/b"} {
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	absdir, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{dir, absdir} {
+		mockClient := newTestingClient()
+		sf, err := newSyncedSiafolder(path, mockClient, testConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, "sub", "new"), []byte("new"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second)
+		sf.Close()
+
+		var keys []string
+		for key := range sf.files {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if strings.Join(keys, ",") != "a,sub/b,sub/new" {
+			t.Fatalf("%v: unexpected keys %v", path, keys)
+		}
+		for _, file := range []string{filepath.Join(dir, "sub", "b"), filepath.Join(absdir, "sub", "b")} {
+			if _, tracked := sf.trackedFile(file); !tracked {
+				t.Fatalf("%v: %v should be tracked", path, file)
+			}
+		}
+		if n := mockClient.uploadRequests(); n != 3 {
+			t.Fatalf("%v: expected 3 uploads, got %v", path, n)
+		}
+		os.Remove(filepath.Join(dir, "sub", "new"))
+	}
+}
+
+// TestSiafolderSettle verifies that a file that is written incrementally is
+// uploaded once, after it stops changing.
+func TestSiafolderSettle(t *testing.T) {
+	config := testConfig()
+	config.SettleDuration = 500 * time.Millisecond
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(testDir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	newfile := filepath.Join(testDir, "newfile")
+	f, err := os.Create(newfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	// keep writing for longer than the settle duration
+	for i := 0; i < 8; i++ {
+		_, err = f.Write([]byte{40, 40, 40, 40, 40})
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if _, exists := mockClient.file("newfile"); exists {
+		t.Fatal("newfile should not be uploaded while it is still being written")
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	uploads := 0
+	siaPath := testSiaPath("newfile").String()
+	for _, op := range mockClient.operations() {
+		if op == "upload "+siaPath {
+			uploads++
+		}
+	}
+	if uploads != 1 {
+		t.Fatalf("newfile should have been uploaded once, got %v uploads", uploads)
+	}
+	checksum, err := sha256File(newfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote, _ := mockClient.file("newfile"); remote != checksum {
+		t.Fatal("the final content of newfile should have been uploaded")
+	}
+}
+
+// TestSiafolderRename verifies that a file renamed inside the watched
+// directory is renamed on Sia instead of being uploaded again, and that a file
+// moved out of the watched directory is removed.
+func TestSiafolderRename(t *testing.T) {
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	oldfile := filepath.Join(testDir, "oldname")
+	err = ioutil.WriteFile(oldfile, []byte("renamed"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(oldfile)
+	time.Sleep(time.Second)
+	checksum, exists := mockClient.file("oldname")
+	if !exists {
+		t.Fatal("oldname should have been uploaded when it was created on disk")
+	}
+	numOps := len(mockClient.operations())
+
+	newfile := filepath.Join(testDir, "newname")
+	err = os.Rename(oldfile, newfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(newfile)
+	time.Sleep(time.Second)
+
+	ops := mockClient.operations()[numOps:]
+	if len(ops) != 1 || ops[0] != "rename "+testSiaPath("oldname").String()+" "+testSiaPath("newname").String() {
+		t.Fatalf("expected a single remote rename, got %v", ops)
+	}
+	if remote, exists := mockClient.file("newname"); !exists || remote != checksum {
+		t.Fatal("newname should have the content of oldname")
+	}
+
+	// move the file out of the watched directory
+	outside, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	err = os.Rename(newfile, filepath.Join(outside, "newname"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(renameWindow + time.Second)
+
+	if _, exists := mockClient.file("newname"); exists {
+		t.Fatal("newname should have been removed after it was moved away")
+	}
+}
+
+// TestSiafolderRenameAway verifies that a tracked file moved out of the
+// watched directory is removed from Sia and is no longer tracked.
+func TestSiafolderRenameAway(t *testing.T) {
+	testRenameAway(t, false)
+}
+
+// TestSiafolderRenameAwayArchive verifies that in archive mode a tracked file
+// moved out of the watched directory is kept on Sia but no longer tracked.
+func TestSiafolderRenameAwayArchive(t *testing.T) {
+	testRenameAway(t, true)
+}
+
+// testRenameAway moves a tracked file out of the watched directory and checks
+// that it is only kept on Sia in archive mode.
+func testRenameAway(t *testing.T, archive bool) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outside, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	file := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(file, []byte("moved away"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig()
+	config.Archive = archive
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	if _, exists := mockClient.file("file"); !exists {
+		t.Fatal("file should have been uploaded by the initial sync")
+	}
+	if _, tracked := sf.trackedFile(file); !tracked {
+		t.Fatal("file should be tracked after the initial sync")
+	}
+
+	err = os.Rename(file, filepath.Join(outside, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(renameWindow + time.Second)
+
+	_, exists := mockClient.file("file")
+	if archive && !exists {
+		t.Fatal("archive mode should keep the file on Sia")
+	}
+	if !archive && exists {
+		t.Fatal("file should have been removed from Sia after it was moved away")
+	}
+	sf.mu.Lock()
+	_, tracked := sf.files["file"]
+	sf.mu.Unlock()
+	if tracked {
+		t.Fatal("file should no longer be tracked after it was moved away")
+	}
+}
+
+// TestSiafolderMoveFile verifies that moving a file to another directory of
+// the watched tree renames it on Sia, also when it is moved into a directory
+// that was just created.
+func TestSiafolderMoveFile(t *testing.T) {
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	movies := filepath.Join(testDir, "movies")
+	for _, dir := range []string{"incoming", "keep"} {
+		err = os.MkdirAll(filepath.Join(movies, dir), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second)
+	}
+	defer os.RemoveAll(movies)
+	err = ioutil.WriteFile(filepath.Join(movies, "incoming", "foo.mkv"), []byte("foo"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	checksum, exists := mockClient.file("movies/incoming/foo.mkv")
+	if !exists {
+		t.Fatal("foo.mkv should have been uploaded")
+	}
+
+	moves := []struct {
+		from, to string
+		mkdir    bool
+	}{
+		{"movies/incoming/foo.mkv", "movies/keep/foo.mkv", false},
+		{"movies/keep/foo.mkv", "movies/archive/2020/foo.mkv", true},
+		{"movies/archive/2020/foo.mkv", "movies/bar.mkv", false},
+	}
+	for _, move := range moves {
+		numOps := len(mockClient.operations())
+		if move.mkdir {
+			err = os.MkdirAll(filepath.Dir(filepath.Join(testDir, move.to)), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = os.Rename(filepath.Join(testDir, move.from), filepath.Join(testDir, move.to))
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second)
+
+		ops := mockClient.operations()[numOps:]
+		if len(ops) != 1 || ops[0] != "rename "+testSiaPath(move.from).String()+" "+testSiaPath(move.to).String() {
+			t.Fatalf("expected moving %v to %v to rename it on Sia, got %v", move.from, move.to, ops)
+		}
+		if remote, exists := mockClient.file(move.to); !exists || remote != checksum {
+			t.Fatalf("%v should have the content of foo.mkv", move.to)
+		}
+		if _, tracked := sf.trackedFile(filepath.Join(sf.path, move.to)); !tracked {
+			t.Fatalf("%v should be tracked under its new name", move.to)
+		}
+	}
+}
+
+// TestSiafolderRenameDirectory verifies that renaming a directory renames its
+// files on Sia instead of uploading them again, and that directories that
+// can't be told apart are removed and uploaded again instead.
+func TestSiafolderRenameDirectory(t *testing.T) {
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	olddir := filepath.Join(testDir, "Old Show")
+	relpaths := []string{"episode1", "season1/episode2"}
+	for _, relpath := range relpaths {
+		file := filepath.Join(olddir, relpath)
+		err = os.MkdirAll(filepath.Dir(file), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second)
+		err = ioutil.WriteFile(file, []byte(relpath), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.RemoveAll(olddir)
+	time.Sleep(time.Second)
+	for _, relpath := range relpaths {
+		if _, exists := mockClient.file("Old Show/" + relpath); !exists {
+			t.Fatalf("%v should have been uploaded", relpath)
+		}
+	}
+	numOps := len(mockClient.operations())
+
+	dir := filepath.Join(testDir, "New Show")
+	err = os.Rename(olddir, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	time.Sleep(time.Second)
+
+	ops := mockClient.operations()[numOps:]
+	sort.Strings(ops)
+	expected := []string{
+		"rename " + testSiaPath("Old Show/episode1").String() + " " + testSiaPath("New Show/episode1").String(),
+		"rename " + testSiaPath("Old Show/season1/episode2").String() + " " + testSiaPath("New Show/season1/episode2").String(),
+	}
+	if !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("expected the files to be renamed on Sia, got %v", ops)
+	}
+	absdir, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sf.isWatchedDir(filepath.Join(absdir, "season1")) {
+		t.Fatal("the renamed directory should be watched under its new name")
+	}
+	if sf.isWatchedDir(filepath.Join(filepath.Dir(absdir), "Old Show", "season1")) {
+		t.Fatal("the old name of the renamed directory should no longer be watched")
+	}
+
+	// files created later in the renamed directory must be watched too
+	err = ioutil.WriteFile(filepath.Join(dir, "season1", "episode3"), []byte("episode3"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := mockClient.file("New Show/season1/episode3"); !exists {
+		t.Fatal("files created in a renamed directory should be uploaded")
+	}
+
+	// two directories with the same files renamed at once can't be told apart
+	copydir := filepath.Join(testDir, "Copy")
+	err = os.Mkdir(copydir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(copydir)
+	time.Sleep(time.Second)
+	for _, d := range []string{dir, copydir} {
+		err = ioutil.WriteFile(filepath.Join(d, "extra"), []byte("extra"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.RemoveAll(filepath.Join(dir, "episode1"))
+	if err == nil {
+		err = os.RemoveAll(filepath.Join(dir, "season1"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	outside, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	for _, d := range []string{dir, copydir} {
+		err = os.Rename(d, filepath.Join(outside, filepath.Base(d)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	numOps = len(mockClient.operations())
+	err = os.Rename(filepath.Join(outside, "Copy"), filepath.Join(testDir, "Third"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Join(testDir, "Third"))
+	time.Sleep(renameWindow + time.Second)
+
+	for _, op := range mockClient.operations()[numOps:] {
+		if strings.HasPrefix(op, "rename") {
+			t.Fatalf("ambiguous directory renames should not rename files, got %v", op)
+		}
+	}
+	if _, exists := mockClient.file("Third/extra"); !exists {
+		t.Fatal("the files of an ambiguous directory rename should be uploaded again")
+	}
+	for _, relpath := range []string{"New Show/extra", "Copy/extra"} {
+		if _, exists := mockClient.file(relpath); exists {
+			t.Fatalf("%v should have been removed after its directory was moved away", relpath)
+		}
+	}
+}
+
+// TestSiafolderAtomicSave verifies that saving a file by writing a temporary
+// file and renaming it over the old one uploads the new content once, without
+// uploading the temporary file.
+func TestSiafolderAtomicSave(t *testing.T) {
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	file := filepath.Join(testDir, "document.txt")
+	err = ioutil.WriteFile(file, []byte("first draft"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	time.Sleep(time.Second)
+	oldChecksum, exists := mockClient.file("document.txt")
+	if !exists {
+		t.Fatal("document.txt should have been uploaded when it was created on disk")
+	}
+	numOps := len(mockClient.operations())
+
+	// save a new version the way editors do
+	tmpfile := file + ".tmp"
+	err = ioutil.WriteFile(tmpfile, []byte("final draft"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile)
+	err = os.Rename(tmpfile, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	newChecksum, err := sha256File(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newChecksum == oldChecksum {
+		t.Fatal("the saved file should have a new checksum")
+	}
+	ops := mockClient.operations()[numOps:]
+	expected := []string{"delete " + testSiaPath("document.txt").String(), "upload " + testSiaPath("document.txt").String()}
+	if strings.Join(ops, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, ops)
+	}
+	if remote, _ := mockClient.file("document.txt"); remote != newChecksum {
+		t.Fatal("document.txt should have been uploaded again with its new content")
+	}
+	if fs, _ := sf.trackedFile(filepath.Join(sf.path, "document.txt")); fs.Checksum != newChecksum {
+		t.Fatal("document.txt should be tracked with its new checksum")
+	}
+
+	// saving the same content again doesn't upload anything
+	numOps = len(mockClient.operations())
+	err = ioutil.WriteFile(tmpfile, []byte("final draft"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(tmpfile, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if ops := mockClient.operations()[numOps:]; len(ops) != 0 {
+		t.Fatalf("saving unchanged content should not upload it again, got %v", ops)
+	}
+}
+
+// TestMatchRename verifies that a created file is only paired with a renamed
+// file that has the same size and modification time, and if several do, the
+// same content, preferring one with the same name.
+func TestMatchRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for name, data := range map[string]string{"moved/movie.mkv": "movie", "copy.mkv": "movie", "other.mkv": "other", "empty": "", "single.bin": "single!", "touched.bin": "touched"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "touched.bin" {
+			err = os.Chtimes(path, modTime, modTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	movie, err := sha256File(filepath.Join(dir, "copy.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := sha256File(filepath.Join(dir, "empty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a single file with the same size and modification time is paired
+	// without reading the created file, so its checksum doesn't matter
+	now := time.Now()
+	sf := &SiaFolder{
+		changeDetection: "sha256",
+		renamed: map[string]renamedFile{
+			filepath.Join(dir, "movie.mkv"):  {fs: fileState{Checksum: movie, Size: 5, ModTime: modTime}, at: now.Add(-time.Second)},
+			filepath.Join(dir, "latest.mkv"): {fs: fileState{Checksum: movie, Size: 5, ModTime: modTime}, at: now},
+			filepath.Join(dir, "old-empty"):  {fs: fileState{Checksum: empty, Size: 0, ModTime: modTime}, at: now},
+			filepath.Join(dir, "gone.bin"):   {fs: fileState{Checksum: "unread", Size: 7, ModTime: modTime}, at: now},
+		},
+	}
+	tests := []struct {
+		file    string
+		oldname string
+	}{
+		{"moved/movie.mkv", "movie.mkv"},
+		{"copy.mkv", "latest.mkv"},
+		{"other.mkv", ""},
+		{"empty", ""},
+		{"single.bin", "gone.bin"},
+		{"touched.bin", ""},
+	}
+	for _, test := range tests {
+		oldname, ok := sf.matchRename(filepath.Join(dir, filepath.FromSlash(test.file)))
+		if test.oldname == "" {
+			if ok {
+				t.Errorf("%v should not have been matched, got %v", test.file, oldname)
+			}
+			continue
+		}
+		if !ok || oldname != filepath.Join(dir, test.oldname) {
+			t.Errorf("%v should have been matched with %v, got %v", test.file, test.oldname, oldname)
+		}
+	}
+}
+
+// TestSiafolderRescan verifies that rescanning the directory catches up on
+// changes that no event was received for.
+func TestSiafolderRescan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"changed", "removed"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := testConfig()
+	config.SyncOnly = true
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	err = ioutil.WriteFile(filepath.Join(dir, "changed"), []byte("new data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(filepath.Join(dir, "removed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "created"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.rescan()
+	sf.uploads.wait()
+
+	if _, exists := mockClient.file("created"); !exists {
+		t.Error("created should have been uploaded")
+	}
+	if _, exists := mockClient.file("removed"); exists {
+		t.Error("removed should have been deleted from Sia")
+	}
+	checksum, err := sha256File(filepath.Join(dir, "changed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote, _ := mockClient.file("changed"); remote != checksum {
+		t.Error("changed should have been uploaded again")
+	}
+}
+
+// TestSiafolderPoll verifies that a polled directory syncs created and removed
+// files, and that a directory the watcher works for isn't polled.
+func TestSiafolderPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sf, err := newSyncedSiafolder(dir, newTestingClient(), testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.watcher == nil {
+		t.Fatal("the directory should have been watched")
+	}
+	sf.Close()
+
+	config := testConfig()
+	config.Poll = true
+	config.PollInterval = 100 * time.Millisecond
+	mockClient := newTestingClient()
+	sf, err = newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	file := filepath.Join(dir, "polled")
+	err = ioutil.WriteFile(file, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := mockClient.file("polled"); !exists {
+		t.Fatal("polled should have been uploaded")
+	}
+	err = os.Remove(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := mockClient.file("polled"); exists {
+		t.Fatal("polled should have been removed from Sia")
+	}
+}
+
+// TestSiafolderPollUnwatched verifies that subdirectories the watcher couldn't
+// watch are polled for changes.
+func TestSiafolderPollUnwatched(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sub := filepath.Join(dir, "sub")
+	err = os.MkdirAll(filepath.Join(sub, "nested"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig()
+	config.PollInterval = 100 * time.Millisecond
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	// pretend the watch limit was reached when sub was added
+	sf.mu.Lock()
+	for _, d := range []string{sub, filepath.Join(sub, "nested")} {
+		sf.watcher.Remove(d)
+		sf.dirs[d] = dirState{}
+	}
+	sf.mu.Unlock()
+	if unwatched := sf.unwatchedDirs(); len(unwatched) != 1 || unwatched[0] != sub {
+		t.Fatalf("expected sub to be the only unwatched directory, got %v", unwatched)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(sub, "nested", "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := mockClient.file("sub/nested/file"); !exists {
+		t.Fatal("the file in the unwatched directory should have been uploaded")
+	}
+}
+
+// TestSiafolderRemoveSourceFiles verifies that local copies are only removed
+// once the files are available on Sia, and that removing them doesn't delete
+// the files from Sia.
+func TestSiafolderRemoveSourceFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "sub", "file")
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(interval time.Duration) {
+		removeSourceInterval = interval
+	}(removeSourceInterval)
+	removeSourceInterval = 100 * time.Millisecond
+	config := testConfig()
+	config.RemoveSourceFiles = true
+	mockClient := newTestingClient()
+	mockClient.redundancy = 0.5
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	time.Sleep(500 * time.Millisecond)
+	if _, err := os.Stat(file); err != nil {
+		t.Fatal("the file should be kept until it is available on Sia")
+	}
+
+	mockClient.mu.Lock()
+	mockClient.redundancy = 1.5
+	mockClient.mu.Unlock()
+	time.Sleep(time.Second)
+	if _, err := os.Stat(filepath.Dir(file)); !os.IsNotExist(err) {
+		t.Fatal("the file and its empty directory should have been removed")
+	}
+	if _, exists := mockClient.file("sub/file"); !exists {
+		t.Fatal("the file should have been kept on Sia")
+	}
+}
+
+// TestSiafolderSourceOffline verifies that nothing is removed from Sia while
+// the synced directory is replaced by an empty one, like an unplugged drive,
+// and that the changes made meanwhile are synced once it is back.
+func TestSiafolderSourceOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	unplugged := dir + "-unplugged"
+	defer os.RemoveAll(unplugged)
+	for _, relpath := range []string{"removed", "sub/file"} {
+		path := filepath.Join(dir, filepath.FromSlash(relpath))
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(relpath), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	err = os.Rename(dir, unplugged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offline := func() bool {
+		status, err := sf.Status()
+		return err == nil && status.Offline
+	}
+	if !waitFor(offline) {
+		t.Fatal("status should report the directory offline")
+	}
+	err = os.Remove(filepath.Join(unplugged, "removed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(unplugged, "new"), []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing changes on Sia, give the events time to be handled
+	time.Sleep(500 * time.Millisecond)
+	for _, relpath := range []string{"removed", "sub/file"} {
+		if _, exists := mockClient.file(relpath); !exists {
+			t.Fatalf("%v should have been kept on Sia while offline", relpath)
+		}
+	}
+
+	err = os.Remove(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(unplugged, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { return !offline() }) {
+		t.Fatal("status should report the directory back")
+	}
+	if !waitFor(func() bool { _, exists := mockClient.file("removed"); return !exists }) {
+		t.Fatal("removed should have been removed from Sia once the directory is back")
+	}
+	if !waitFor(func() bool { _, exists := mockClient.file("new"); return exists }) {
+		t.Fatal("new should have been uploaded once the directory is back")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "sub", "other"), []byte("other"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { _, exists := mockClient.file("sub/other"); return exists }) {
+		t.Fatal("the directories should be watched again once the directory is back")
+	}
+}
+
+// TestSiafolderDoneDir verifies that files on Sia are moved into the done
+// directory, which isn't synced itself, without overwriting files there.
+func TestSiafolderDoneDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doneDir := filepath.Join(dir, "done")
+	for _, file := range []string{filepath.Join(dir, "sub", "file.mkv"), filepath.Join(doneDir, "sub", "file.mkv")} {
+		err = os.MkdirAll(filepath.Dir(file), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file, []byte("data"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(interval time.Duration) {
+		removeSourceInterval = interval
+	}(removeSourceInterval)
+	removeSourceInterval = 100 * time.Millisecond
+	config := testConfig()
+	config.DoneDir = doneDir
+	mockClient := newTestingClient()
+	mockClient.redundancy = 1
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	time.Sleep(time.Second)
+	if _, err := os.Stat(filepath.Join(doneDir, "sub", "file (1).mkv")); err != nil {
+		t.Fatal("the file should have been moved into the done directory with a new name")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub")); !os.IsNotExist(err) {
+		t.Fatal("the empty directory should have been removed")
+	}
+	if _, exists := mockClient.file("done/sub/file.mkv"); exists {
+		t.Fatal("the done directory should not have been synced")
+	}
+	if _, exists := mockClient.file("sub/file.mkv"); !exists {
+		t.Fatal("the file should have been kept on Sia")
+	}
+}
+
+// TestSiafolderHealth verifies that a file that stays below the minimum
+// redundancy is uploaded again with auto repair.
+func TestSiafolderHealth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig()
+	config.HealthInterval = 100 * time.Millisecond
+	config.MinRedundancy = 1
+	config.AutoRepair = true
+	mockClient := newTestingClient()
+	mockClient.redundancy = 2
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	time.Sleep(500 * time.Millisecond)
+	if ops := mockClient.operations(); len(ops) != 1 {
+		t.Fatalf("a healthy file should not be repaired, got %v", ops)
+	}
+
+	mockClient.mu.Lock()
+	mockClient.redundancy = 0.5
+	mockClient.mu.Unlock()
+	time.Sleep(time.Second)
+	ops := mockClient.operations()
+	if len(ops) < 3 || ops[1] != "delete "+testSiaPath("file").String() || ops[2] != "upload "+testSiaPath("file").String() {
+		t.Fatalf("the file should have been uploaded again, got %v", ops)
+	}
+}
+
+// TestSiafolderReload verifies that reloading applies a changed ignore file
+// and the exclude patterns set by SetExcludePatterns, uploading the files that
+// aren't excluded anymore and leaving the files that are excluded now on Sia.
+func TestSiafolderReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.Mkdir(filepath.Join(dir, "data"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		IgnoreFile:                        "*.log\n",
+		"file.log":                        "data",
+		filepath.Join("data", "file.txt"): "data",
+	}
+	for name, data := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	if _, exists := mockClient.file("file.log"); exists {
+		t.Fatal("file.log should be excluded")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, IgnoreFile), []byte("data\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Reload()
+
+	if !waitFor(func() bool { _, exists := mockClient.file("file.log"); return exists }) {
+		t.Fatal("file.log should have been uploaded")
+	}
+	dataExcluded := func() bool {
+		_, tracked := sf.trackedFile(filepath.Join(dir, "data", "file.txt"))
+		return !tracked && !sf.isWatchedDir(filepath.Join(dir, "data"))
+	}
+	if !waitFor(dataExcluded) {
+		t.Fatal("data should not be watched and data/file.txt not be tracked anymore")
+	}
+	if _, exists := mockClient.file("data/file.txt"); !exists {
+		t.Error("data/file.txt should have been left on Sia")
+	}
+
+	// the exclude patterns of the config are replaced on the next reload
+	sf.SetExcludePatterns([]string{"*.log"})
+	sf.Reload()
+	if !waitFor(func() bool { _, tracked := sf.trackedFile(filepath.Join(dir, "file.log")); return !tracked }) {
+		t.Fatal("file.log should not be tracked anymore")
+	}
+	if _, exists := mockClient.file("file.log"); !exists {
+		t.Error("file.log should have been left on Sia")
+	}
+}
+
+// TestSiafolderProgress verifies that files are reported until siad finished
+// uploading them.
+func TestSiafolderProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig()
+	config.SyncOnly = true
+	mockClient := newTestingClient()
+	mockClient.uploading = true
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	sf.reportProgress()
+	if _, ok := sf.uploading[filepath.Join(dir, "file")]; !ok {
+		t.Fatal("file should be reported as uploading")
+	}
+	mockClient.mu.Lock()
+	mockClient.uploading = false
+	mockClient.mu.Unlock()
+	sf.reportProgress()
+	if len(sf.uploading) != 0 {
+		t.Fatalf("no file should be uploading anymore, got %v", sf.uploading)
+	}
+}
+
+// TestSiafolderStall verifies that an upload whose progress doesn't increase
+// is uploaded again, but only once it stalled for the stall timeout.
+func TestSiafolderStall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig()
+	config.SyncOnly = true
+	config.StallTimeout = 100 * time.Millisecond
+	config.StallAction = "reupload"
+	mockClient := newTestingClient()
+	mockClient.uploading = true
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	sf.checkStalls()
+	sf.checkStalls()
+	if ops := mockClient.operations(); len(ops) != 1 {
+		t.Fatalf("the upload should not be stalled yet, got %v", ops)
+	}
+	time.Sleep(200 * time.Millisecond)
+	sf.checkStalls()
+	sf.uploads.wait()
+	ops := mockClient.operations()
+	expected := []string{"upload " + testSiaPath("file").String(), "delete " + testSiaPath("file").String(), "upload " + testSiaPath("file").String()}
+	if strings.Join(ops, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, ops)
+	}
+}
+
+// TestSiafolderStats verifies that the statistics count the scanned,
+// uploaded and deleted files.
+func TestSiafolderStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"file1", "file2"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	err = os.Remove(filepath.Join(dir, "file2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	stats := sf.Stats()
+	if stats.Scanned != 2 || stats.Uploaded != 2 || stats.UploadedBytes != 8 || stats.Deleted != 1 {
+		t.Fatalf("expected 2 scanned and uploaded files of 8 bytes and 1 deletion, got %+v", stats)
+	}
+	if stats.Runtime <= 0 || stats.Throughput() <= 0 {
+		t.Fatalf("expected a runtime and throughput, got %+v", stats)
+	}
+}
+
+// TestSiafolderPause verifies that nothing is changed on Sia while syncing is
+// paused, and that the changes are synced once resumed.
+func TestSiafolderPause(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "old"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	sf.Pause()
+	if status, err := sf.Status(); err != nil || !status.Paused {
+		t.Fatalf("status should report the pause, got %v, %v", status.Paused, err)
+	}
+	err = os.Remove(filepath.Join(dir, "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "new"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if ops := mockClient.operations(); len(ops) != 1 {
+		t.Fatalf("nothing should change on Sia while paused, got %v", ops)
+	}
+	if status, err := sf.Status(); err != nil || status.Pending != 1 {
+		t.Fatalf("the new file should be queued, got %v, %v", status.Pending, err)
+	}
+
+	sf.Resume()
+	sf.uploads.wait()
+	ops := mockClient.operations()
+	expected := []string{"upload " + testSiaPath("old").String(), "delete " + testSiaPath("old").String(), "upload " + testSiaPath("new").String()}
+	if strings.Join(ops, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v once resumed, got %v", expected, ops)
+	}
+	if sf.Paused() {
+		t.Fatal("syncing should be resumed")
+	}
+}
+
+// TestSiafolderMassDeletion verifies that the removal of files beyond the
+// deletion limit is held back until it is resumed, except for files that are
+// back by then.
+func TestSiafolderMassDeletion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	names := []string{"a", "b", "c", "d", "e"}
+	for _, name := range names {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := testConfig()
+	config.MaxDeletes = 2
+	config.DeleteWindow = time.Minute
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	for _, name := range names[:4] {
+		err = os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	removed := 0
+	for _, name := range names[:4] {
+		if _, exists := mockClient.file(name); !exists {
+			removed++
+		}
+	}
+	if removed != 2 {
+		t.Fatalf("only 2 files should have been removed from Sia, got %v", removed)
+	}
+	if !sf.DeletesHeld() {
+		t.Fatal("removals should be held back")
+	}
+	if status, err := sf.Status(); err != nil || status.HeldDeletes != 2 {
+		t.Fatalf("status should report 2 held back removals, got %v, %v", status.HeldDeletes, err)
+	}
+
+	sf.ResumeDeletes()
+	for _, name := range names[:4] {
+		if _, exists := mockClient.file(name); exists {
+			t.Fatalf("%v should have been removed from Sia once resumed", name)
+		}
+	}
+	if _, exists := mockClient.file("e"); !exists {
+		t.Fatal("e should have been kept on Sia")
+	}
+	if sf.DeletesHeld() {
+		t.Fatal("removals should not be held back once resumed")
+	}
+}
+
+// TestSiafolderRemoveDirectory verifies that removing a directory drops it and
+// its subdirectories from the watcher, and removes its files locally and on
+// Sia.
+func TestSiafolderRemoveDirectory(t *testing.T) {
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	dir := filepath.Join(testDir, "removeddir")
+	nested := filepath.Join(dir, "nested")
+	for _, d := range []string{dir, nested} {
+		err = os.Mkdir(d, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(d)
+		time.Sleep(time.Second)
+	}
+	for _, file := range []string{filepath.Join(dir, "file1"), filepath.Join(nested, "file2")} {
+		err = ioutil.WriteFile(file, []byte("data"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Second)
+	for _, relpath := range []string{"removeddir/file1", "removeddir/nested/file2"} {
+		if _, exists := mockClient.file(relpath); !exists {
+			t.Fatalf("%v should have been uploaded", relpath)
+		}
+	}
+
+	err = os.RemoveAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	for _, relpath := range []string{"removeddir/file1", "removeddir/nested/file2"} {
+		if _, exists := mockClient.file(relpath); exists {
+			t.Fatalf("%v should have been removed", relpath)
+		}
+	}
+	absdir, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.mu.Lock()
+	for d := range sf.dirs {
+		if d == absdir || isWithin(absdir, d) {
+			t.Errorf("%v should have been removed from the dirs map", d)
+		}
+	}
+	sf.mu.Unlock()
+	for _, file := range sf.trackedFiles() {
+		if isWithin(absdir, file) {
+			t.Fatalf("%v should have been removed from the files map", file)
+		}
+	}
+}
+
+// TestSiafolderMoveDirectoryIn verifies that the files of a directory tree
+// moved into the watched directory are uploaded, however deeply nested.
+func TestSiafolderMoveDirectoryIn(t *testing.T) {
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	outside, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	relpaths := []string{"show/episode1", "show/season1/episode2", "show/season1/extras/clip"}
+	for _, relpath := range relpaths {
+		file := filepath.Join(outside, relpath)
+		err = os.MkdirAll(filepath.Dir(file), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file, []byte(relpath), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := filepath.Join(testDir, "show")
+	err = os.Rename(filepath.Join(outside, "show"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	time.Sleep(time.Second)
+
+	for _, relpath := range relpaths {
+		if _, exists := mockClient.file(relpath); !exists {
+			t.Fatalf("%v should have been uploaded", relpath)
+		}
+	}
+
+	// files created later in the moved tree must be watched too
+	err = ioutil.WriteFile(filepath.Join(dir, "season1", "extras", "clip2"), []byte("clip2"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := mockClient.file("show/season1/extras/clip2"); !exists {
+		t.Fatal("files created in a moved directory should be uploaded")
+	}
+}
+
+// TestFilterSiaFiles probes filterSiaFiles with files inside and outside of
+// the synced siapath.
+func TestFilterSiaFiles(t *testing.T) {
+	tests := []struct {
+		siaPath string
+		within  bool
+	}{
+		{"siasync/file", true},
+		{"siasync/dir/file", true},
+		{"siasync/dir/nested/file", true},
+		{"siasync", false},
+		{"siasync2/file", false},
+		{"other/file", false},
+		{"other/siasync/file", false},
+	}
+	var files []modules.FileInfo
+	for _, test := range tests {
+		files = append(files, modules.FileInfo{SiaPath: mustSiaPath(test.siaPath)})
+	}
+
+	siaFiles := filterSiaFiles(files, mustSiaPath("siasync"))
+	for _, test := range tests {
+		if _, ok := siaFiles[mustSiaPath(test.siaPath)]; ok != test.within {
+			t.Errorf("%v should be returned: %v", test.siaPath, test.within)
+		}
+	}
+}
+
+// TestSiafolderRestart verifies that restarting a SiaFolder whose files,
+// including nested ones, are already on Sia neither uploads nor removes
+// anything.
+func TestSiafolderRestart(t *testing.T) {
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Close()
+	numOps := len(mockClient.operations())
+	numUploads := mockClient.uploadRequests()
+
+	sf, err = newSyncedSiafolder(testDir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Close()
+	if ops := mockClient.operations()[numOps:]; len(ops) != 0 {
+		t.Fatalf("restart should not change any files on Sia, got %v", ops)
+	}
+	if mockClient.uploadRequests() != numUploads {
+		t.Fatal("restart should not try to upload files that are already on Sia")
+	}
+}
+
+// TestSiafolderIsFile verifies that isFile looks for files below the sia
+// prefix.
+func TestSiafolderIsFile(t *testing.T) {
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	for _, file := range testFiles {
+		exists, err := sf.isFile(filepath.Join(sf.path, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatalf("%v should exist on Sia", file)
+		}
+	}
+	exists, err := sf.isFile(filepath.Join(sf.path, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("missing should not exist on Sia")
+	}
+}
+
+// TestSiafolderUploadExisting verifies that a file already on Sia with the
+// same content is adopted, and that a different one is replaced, or kept next
+// to the new upload in archive mode.
+func TestSiafolderUploadExisting(t *testing.T) {
+	for _, archive := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "siasync")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		config := testConfig()
+		config.SyncOnly = true
+		config.Archive = archive
+		mockClient := newTestingClient()
+		sf, err := newSyncedSiafolder(dir, mockClient, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sf.Close()
+
+		for name, remote := range map[string]string{"same": "same", "different": "old"} {
+			path := filepath.Join(dir, name)
+			err = ioutil.WriteFile(path, []byte(name), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mockClient.mu.Lock()
+			mockClient.siaFiles[testSiaPath(name).String()] = remote
+			mockClient.contents[testSiaPath(name).String()] = []byte(remote)
+			mockClient.mu.Unlock()
+			err = sf.handleCreate(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fs, _ := sf.trackedFile(path); !fs.Uploaded {
+				t.Fatalf("%v should be tracked as uploaded", name)
+			}
+		}
+
+		if remote, _ := mockClient.file("same"); remote != "same" {
+			t.Fatal("the file with the same content should have been adopted")
+		}
+		checksum, err := sha256File(filepath.Join(dir, "different"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops := mockClient.operations()
+		if archive {
+			if remote, _ := mockClient.file("different"); remote != "old" {
+				t.Fatal("archive mode should keep the different file")
+			}
+			if remote, _ := mockClient.file("different.v2"); remote != checksum {
+				t.Fatal("archive mode should upload the file next to the different one")
+			}
+			if len(ops) != 1 || ops[0] != "upload "+testSiaPath("different.v2").String() {
+				t.Fatalf("expected only the versioned upload, got %v", ops)
+			}
+			if fs, _ := sf.trackedFile(filepath.Join(dir, "different")); fs.Version != 2 || len(fs.Versions) != 1 || fs.Versions[0].Size != 3 {
+				t.Fatalf("the different file should be recorded as the first version, got %+v", fs)
+			}
+		} else {
+			if remote, _ := mockClient.file("different"); remote != checksum {
+				t.Fatal("the different file should have been replaced")
+			}
+			expected := []string{"delete " + testSiaPath("different").String(), "upload " + testSiaPath("different").String()}
+			if strings.Join(ops, ",") != strings.Join(expected, ",") {
+				t.Fatalf("expected %v, got %v", expected, ops)
+			}
+		}
+	}
+}
+
+// TestSiafolderStress creates, rewrites and removes files from several
+// goroutines at once. It is meant to be run with the race detector.
+func TestSiafolderStress(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(testDir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	dir := filepath.Join(testDir, "stress")
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	time.Sleep(time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				file := filepath.Join(dir, fmt.Sprintf("file-%v-%v", i, j))
+				if err := ioutil.WriteFile(file, []byte(file), 0644); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := ioutil.WriteFile(file, []byte("rewritten "+file), 0644); err != nil {
+					t.Error(err)
+					return
+				}
+				if j%2 == 0 {
+					os.Remove(file)
+				}
+				sf.trackedFiles()
+			}
+		}(i)
+	}
+	wg.Wait()
+	time.Sleep(2 * time.Second)
+
+	// every remaining file should be on Sia with its final content
+	for i := 0; i < 8; i++ {
+		for j := 1; j < 20; j += 2 {
+			relpath := fmt.Sprintf("stress/file-%v-%v", i, j)
+			checksum, err := sha256File(filepath.Join(testDir, relpath))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if remote, exists := mockClient.file(relpath); !exists || remote != checksum {
+				t.Errorf("%v should have been uploaded with its final content", relpath)
+			}
+		}
+	}
+
+	err = os.RemoveAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Second)
+	for _, file := range sf.trackedFiles() {
+		if strings.Contains(file, "stress") {
+			t.Errorf("%v should not be tracked anymore", file)
+		}
+	}
+}
+
+// slowDeleteClient is a testingClient that takes a while to delete files.
+type slowDeleteClient struct {
+	*testingClient
+}
+
+func (s slowDeleteClient) RenterDeletePost(siaPath modules.SiaPath) error {
+	time.Sleep(20 * time.Millisecond)
+	return s.testingClient.RenterDeletePost(siaPath)
+}
+
+// TestSiafolderEventFlood verifies that no file is missed when hundreds of
+// files are created while eventWatcher is busy removing files from Sia.
+func TestSiafolderEventFlood(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const removed, created = 50, 500
+	for i := 0; i < removed; i++ {
+		err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("removed-%v", i)), []byte("removed"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := slowDeleteClient{newTestingClient()}
+	config := testConfig()
+	config.MaxUploads = 4
+	sf, err := newSyncedSiafolder(dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	for i := 0; i < removed; i++ {
+		err = os.Remove(filepath.Join(dir, fmt.Sprintf("removed-%v", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < created; i++ {
+		err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("created-%v", i)), []byte(fmt.Sprint(i)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for i := 0; i < created; i++ {
+		relpath := fmt.Sprintf("created-%v", i)
+		for _, exists := client.file(relpath); !exists; _, exists = client.file(relpath) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%v should have been uploaded", relpath)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	for i := 0; i < removed; i++ {
+		relpath := fmt.Sprintf("removed-%v", i)
+		for _, exists := client.file(relpath); exists; _, exists = client.file(relpath) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%v should have been removed from Sia", relpath)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// blockingClient is a testingClient whose uploads block until release is
+// closed. If blocked is set, only the uploads of that file block.
+type blockingClient struct {
+	*testingClient
+	release chan struct{}
+	blocked string
+}
+
+func (b *blockingClient) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	if b.blocked == "" || siaPath == testSiaPath(b.blocked) {
+		<-b.release
+	}
+	return b.testingClient.RenterUploadPost(path, siaPath, dataPieces, parityPieces)
+}
+
+// lateClient is a testingClient whose uploads are on Sia right away but only
+// return once release is closed, so that the uploaded file isn't tracked yet.
+type lateClient struct {
+	*testingClient
+	release chan struct{}
+}
+
+func (l *lateClient) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	err := l.testingClient.RenterUploadPost(path, siaPath, dataPieces, parityPieces)
+	<-l.release
+	return err
+}
+
+// TestSiafolderDeletedUploading verifies that a file siad accepted the upload
+// of isn't taken as deleted locally before its upload is done and it is
+// tracked.
+func TestSiafolderDeletedUploading(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	client := &lateClient{
+		testingClient: newTestingClient(),
+		release:       make(chan struct{}),
+	}
+	sf, err := newSyncedSiafolder(dir, client, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	file := filepath.Join(dir, "new")
+	err = ioutil.WriteFile(file, []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, exists := client.file("new"); !exists; _, exists = client.file("new") {
+		if time.Now().After(deadline) {
+			close(client.release)
+			t.Fatal("new should have been uploaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sf.listing.invalidate()
+	deleted, err := sf.deletedFiles()
+	close(client.release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("a file that is uploading shouldn't be deleted, got %v", deleted)
+	}
+}
+
+// TestSiafolderCloseTimeout verifies that Close gives up waiting for an upload
+// in progress after the shutdown timeout.
+func TestSiafolderCloseTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	client := &blockingClient{
+		testingClient: newTestingClient(),
+		release:       make(chan struct{}),
+	}
+	defer close(client.release)
+	config := testConfig()
+	config.ShutdownTimeout = 500 * time.Millisecond
+	sf, err := newSyncedSiafolder(dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	start := time.Now()
+	err = sf.Close()
+	if err == nil {
+		t.Fatal("Close should fail while an upload is stuck")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("Close should return after the shutdown timeout")
+	}
+}
+
+// TestSiafolderContext verifies that an API call siad doesn't answer is given
+// up after the API timeout, and that a SiaFolder stops once its context is
+// done.
+func TestSiafolderContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	client := &blockingClient{
+		testingClient: newTestingClient(),
+		release:       make(chan struct{}),
+	}
+	defer close(client.release)
+	config := testConfig()
+	config.APITimeout = 200 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sf, err := NewSiafolder(ctx, dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sf.WaitInitialSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = sf.client.RenterUploadPost(filepath.Join(dir, "file"), testSiaPath("file"), 10, 20)
+	if err == nil {
+		t.Fatal("an upload siad doesn't answer should fail")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("an upload siad doesn't answer should be given up after the API timeout")
+	}
+
+	if err := sf.Healthy(time.Hour); err != nil {
+		t.Fatalf("the SiaFolder should be healthy, got %v", err)
+	}
+	cancel()
+	if err := sf.Healthy(time.Hour); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("the SiaFolder should be closed once its context is done, got %v", err)
+	}
+	err = sf.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingClient is a testingClient that rejects the first fails uploads.
+type failingClient struct {
+	*testingClient
+	fails int
+}
+
+func (f *failingClient) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	f.mu.Lock()
+	if f.fails > 0 {
+		f.fails--
+		f.uploads++
+		f.mu.Unlock()
+		return errors.New("no workers")
+	}
+	f.mu.Unlock()
+	return f.testingClient.RenterUploadPost(path, siaPath, dataPieces, parityPieces)
+}
+
+// TestSiafolderUploadRetry verifies that failed uploads are retried with
+// backoff and end up in the failed uploads once every attempt failed.
+func TestSiafolderUploadRetry(t *testing.T) {
+	defer func(backoff time.Duration) {
+		retryBackoff = backoff
+	}(retryBackoff)
+	retryBackoff = 50 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "retried"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is uploaded on the third attempt
+	client := &failingClient{testingClient: newTestingClient(), fails: 2}
+	config := testConfig()
+	config.MaxUploadAttempts = 3
+	sf, err := newSyncedSiafolder(dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := client.file("retried"); !exists {
+		t.Fatal("retried should have been uploaded")
+	}
+	if n := client.uploadRequests(); n != 3 {
+		t.Fatalf("expected 3 upload requests, got %v", n)
+	}
+	if len(sf.FailedUploads()) != 0 {
+		t.Fatal("there should be no failed uploads")
+	}
+
+	// the file fails more often than it is tried
+	client.mu.Lock()
+	client.fails = 5
+	client.mu.Unlock()
+	failed := filepath.Join(dir, "failed")
+	err = ioutil.WriteFile(failed, []byte("other data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := client.file("failed"); exists {
+		t.Fatal("failed should not have been uploaded")
+	}
+	if _, exists := sf.FailedUploads()[failed]; !exists {
+		t.Fatal("failed should be in the failed uploads")
+	}
+	if n := client.uploadRequests(); n != 6 {
+		t.Fatalf("expected 6 upload requests, got %v", n)
+	}
+
+	err = sf.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// emptyRejectingClient is a testingClient that rejects empty files like older
+// versions of siad.
+type emptyRejectingClient struct {
+	*testingClient
+}
+
+func (e *emptyRejectingClient) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	if stat, err := os.Stat(path); err == nil && stat.Size() == 0 {
+		e.mu.Lock()
+		e.uploads++
+		e.mu.Unlock()
+		return errors.New("cannot upload an empty file")
+	}
+	return e.testingClient.RenterUploadPost(path, siaPath, dataPieces, parityPieces)
+}
+
+// concurrentClient is a testingClient whose uploads take a while and that
+// records the most uploads running at the same time.
+type concurrentClient struct {
+	*testingClient
+	uploadsMu  sync.Mutex
+	running    int
+	maxRunning int
+}
+
+func (c *concurrentClient) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	c.uploadsMu.Lock()
+	c.running++
+	if c.running > c.maxRunning {
+		c.maxRunning = c.running
+	}
+	c.uploadsMu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	c.uploadsMu.Lock()
+	c.running--
+	c.uploadsMu.Unlock()
+	return c.testingClient.RenterUploadPost(path, siaPath, dataPieces, parityPieces)
+}
+
+// TestSiafolderSharedUploads verifies that the upload limits of an upload
+// queue shared by two SiaFolders hold for both of them together.
+func TestSiafolderSharedUploads(t *testing.T) {
+	client := &concurrentClient{testingClient: newTestingClient()}
+	config := testConfig()
+	config.MaxUploads = 2
+	config.MaxUploadsPerHour = 5
+	config.Uploads = NewUploadQueue(config)
+
+	var folders []*SiaFolder
+	for _, name := range []string{"one", "two"} {
+		dir, err := ioutil.TempDir("", "siasync")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for i := 0; i < 4; i++ {
+			err = ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(i)), []byte(name), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		config.Prefix = name
+		config.StateFile = filepath.Join(dir, DefaultStateFile)
+		sf, err := NewSiafolder(context.Background(), dir, client, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sf.Close()
+		folders = append(folders, sf)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.uploadRequests() < 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 5 uploads, got %v", client.uploadRequests())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if uploads := client.uploadRequests(); uploads != 5 {
+		t.Fatalf("expected 5 uploads per hour for both folders together, got %v", uploads)
+	}
+	client.uploadsMu.Lock()
+	maxRunning := client.maxRunning
+	client.uploadsMu.Unlock()
+	if maxRunning > 2 {
+		t.Fatalf("expected at most 2 uploads at the same time for both folders together, got %v", maxRunning)
+	}
+	pending := 0
+	for _, sf := range folders {
+		pending += sf.uploads.len()
+	}
+	if pending != 3 {
+		t.Fatalf("expected 3 files held back, got %v", pending)
+	}
+	if throttle := folders[0].uploads.throttled(); throttle != "uploads per hour" {
+		t.Fatalf("expected the hourly limit to hold back the files, got %q", throttle)
+	}
+}
+
+// TestSiafolderMinFileSize verifies that files below the minimum size are
+// tracked but only uploaded once they grow, and that siad rejecting an empty
+// file isn't retried.
+func TestSiafolderMinFileSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	placeholder := filepath.Join(dir, "placeholder")
+	err = ioutil.WriteFile(placeholder, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "full"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestingClient()
+	config := testConfig()
+	config.MinFileSize = 4
+	sf, err := newSyncedSiafolder(dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := client.file("full"); !exists {
+		t.Fatal("full should have been uploaded")
+	}
+	if _, exists := client.file("placeholder"); exists {
+		t.Fatal("placeholder should not have been uploaded while it is empty")
+	}
+	if fs, tracked := sf.trackedFile(placeholder); !tracked || fs.Uploaded {
+		t.Fatal("placeholder should be tracked but not uploaded")
+	}
+
+	// the placeholder is uploaded once it has content
+	err = ioutil.WriteFile(placeholder, []byte("downloaded"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := client.file("placeholder"); !exists {
+		t.Fatal("placeholder should have been uploaded once it grew")
+	}
+	if fs, _ := sf.trackedFile(placeholder); !fs.Uploaded {
+		t.Fatal("placeholder should be uploaded")
+	}
+	err = sf.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a minimum size siad rejecting an empty file fails it right
+	// away, and it is uploaded once it has content
+	dir2, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir2)
+	empty := filepath.Join(dir2, "empty")
+	err = ioutil.WriteFile(empty, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejecting := &emptyRejectingClient{testingClient: newTestingClient()}
+	sf, err = newSyncedSiafolder(dir2, rejecting, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	if n := rejecting.uploadRequests(); n != 1 {
+		t.Fatalf("expected 1 upload request, got %v", n)
+	}
+	if msg, failed := sf.FailedUploads()[empty]; !failed || !strings.Contains(msg, "empty files") {
+		t.Fatalf("expected empty to fail with a clear message, got %q", msg)
+	}
+	err = ioutil.WriteFile(empty, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := rejecting.file("empty"); !exists {
+		t.Fatal("empty should have been uploaded once it had content")
+	}
+	if _, failed := sf.FailedUploads()[empty]; failed {
+		t.Fatal("empty should no longer be a failed upload")
+	}
+}
+
+// TestSiafolderReconnect verifies that uploads are paused while siad is down
+// and resumed once it is back.
+func TestSiafolderReconnect(t *testing.T) {
+	defer func(d time.Duration) {
+		reconnectInterval = d
+	}(reconnectInterval)
+	reconnectInterval = 100 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	client := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, client, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	client.setOffline(true)
+	for _, name := range []string{"first", "second"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	requests := client.uploadRequests()
+	if requests > 2 {
+		t.Fatalf("uploads should be paused while siad is down, got %v requests", requests)
+	}
+	if len(sf.FailedUploads()) != 0 {
+		t.Fatal("uploads should not fail while siad is down")
+	}
+
+	client.setOffline(false)
+	time.Sleep(500 * time.Millisecond)
+	for _, name := range []string{"first", "second"} {
+		if _, exists := client.file(name); !exists {
+			t.Fatalf("%v should have been uploaded after reconnecting", name)
+		}
+	}
+}
+
+// TestSiafolderDryRun verifies that a dry run doesn't change anything on Sia
+// and writes the changes it would have made to the plan.
+func TestSiafolderDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outputDir, err := ioutil.TempDir("", "siasync-plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+	config := testConfig()
+	config.DryRun = true
+	config.DryRunOutput = filepath.Join(outputDir, "plan.json")
+
+	err = ioutil.WriteFile(filepath.Join(dir, "new"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestingClient()
+	client.siaFiles[testSiaPath("deleted").String()] = "checksum"
+
+	sf, err := newSyncedSiafolder(dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sf.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ops := client.operations(); len(ops) != 0 {
+		t.Fatalf("a dry run should not change Sia, got %v", ops)
+	}
+
+	data, err := ioutil.ReadFile(config.DryRunOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan struct {
+		Uploads   []plannedUpload   `json:"uploads"`
+		Deletions []plannedDeletion `json:"deletions"`
+	}
+	err = json.Unmarshal(data, &plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Uploads) != 1 || plan.Uploads[0].SiaPath != testSiaPath("new").String() || plan.Uploads[0].Size != 4 {
+		t.Fatalf("unexpected planned uploads %v", plan.Uploads)
+	}
+	if len(plan.Deletions) != 1 || plan.Deletions[0].SiaPath != testSiaPath("deleted").String() {
+		t.Fatalf("unexpected planned deletions %v", plan.Deletions)
+	}
+}
+
+// TestSiafolderConfirmUpload verifies that the initial upload only starts once
+// it is confirmed, and that the summary covers the files to upload.
+func TestSiafolderConfirmUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.Mkdir(filepath.Join(dir, "dir"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, relpath := range []string{"uploaded", "new", "dir/a", "dir/b"} {
+		err = ioutil.WriteFile(filepath.Join(dir, relpath), []byte(relpath), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := newTestingClient()
+	client.siaFiles[testSiaPath("uploaded").String()] = "checksum"
+
+	var summary UploadSummary
+	config := testConfig()
+	config.ConfirmUpload = func(s UploadSummary) bool {
+		summary = s
+		return false
+	}
+	_, err = newSyncedSiafolder(dir, client, config)
+	if err != errUploadAborted {
+		t.Fatalf("expected the upload to be aborted, got %v", err)
+	}
+	if requests := client.uploadRequests(); requests != 0 {
+		t.Fatalf("nothing should be uploaded without confirmation, got %v uploads", requests)
+	}
+	expected := []UploadFolderSummary{
+		{Folder: "dir", Files: 2, Bytes: int64(len("dir/a") + len("dir/b"))},
+		{Folder: ".", Files: 1, Bytes: int64(len("new"))},
+	}
+	if summary.Files != 3 || summary.Bytes != 13 || !reflect.DeepEqual(summary.Folders, expected) {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+
+	config.ConfirmUpload = func(UploadSummary) bool { return true }
+	sf, err := newSyncedSiafolder(dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	for _, relpath := range []string{"new", "dir/a", "dir/b"} {
+		if _, exists := client.file(relpath); !exists {
+			t.Errorf("%v should have been uploaded once confirmed", relpath)
+		}
+	}
+}
+
+// TestSiafolderPrune verifies that prune only deletes the files missing
+// locally once confirmed, and leaves excluded files alone.
+func TestSiafolderPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "kept"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestingClient()
+	for _, relpath := range []string{"kept", "stale", "dir/stale", "excluded.tmp"} {
+		client.siaFiles[testSiaPath(relpath).String()] = "checksum"
+	}
+	config := testConfig()
+	config.Archive = true
+	config.SkipInitialSync = true
+	config.ExcludePatterns = []string{"*.tmp"}
+	sf, err := newSyncedSiafolder(dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	var out strings.Builder
+	_, err = sf.Prune(false, strings.NewReader("n\n"), &out)
+	if err != errPruneAborted {
+		t.Fatalf("expected prune to be aborted, got %v", err)
+	}
+	if ops := client.operations(); len(ops) != 0 {
+		t.Fatalf("nothing should be deleted without confirmation, got %v", ops)
+	}
+	for _, relpath := range []string{"stale", "dir/stale"} {
+		if !strings.Contains(out.String(), testSiaPath(relpath).String()) {
+			t.Errorf("%v should be listed, got %q", relpath, out.String())
+		}
+	}
+
+	deleted, err := sf.Prune(false, strings.NewReader("y\n"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("expected 2 deleted files, got %v", deleted)
+	}
+	for relpath, expected := range map[string]bool{"kept": true, "stale": false, "dir/stale": false, "excluded.tmp": true} {
+		if _, exists := client.file(relpath); exists != expected {
+			t.Errorf("expected %v to exist: %v", relpath, expected)
+		}
+	}
+}
+
+// TestRestore verifies that restore downloads every file below the prefix and
+// skips the files that were already restored.
+func TestRestore(t *testing.T) {
+	client := newTestingClient()
+	for relpath, data := range map[string]string{"file": "data", "dir/nested": "nested data"} {
+		client.siaFiles[testSiaPath(relpath).String()] = "checksum"
+		client.contents[testSiaPath(relpath).String()] = []byte(data)
+	}
+
+	dir, err := ioutil.TempDir("", "siasync-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Restore(client, dir, testConfig(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "dir", "nested"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "nested data" {
+		t.Fatalf("unexpected restored data %q", data)
+	}
+	ops := client.operations()
+	if len(ops) != 1 || ops[0] != "download "+testSiaPath("dir/nested").String() {
+		t.Fatalf("only dir/nested should have been downloaded, got %v", ops)
+	}
+}
+
+// TestSiafolderManifest verifies that the manifest is uploaded without being
+// synced as a file, and that verify and restore check file contents against
+// it.
+func TestSiafolderManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a", "b"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := testConfig()
+	config.StateFile = filepath.Join(dir, DefaultStateFile)
+	config.SyncOnly = true
+	config.Manifest = true
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Close()
+
+	checksum, err := sha256File(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient.mu.Lock()
+	data := mockClient.contents[testSiaPath(manifestName).String()]
+	mockClient.mu.Unlock()
+	var m manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 2 || m.Files["a"].SHA256 != checksum || m.Files["a"].Size != 4 || m.Files["a"].Uploaded.IsZero() {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+
+	// a file changed without changing its size is only found through the
+	// manifest
+	err = ioutil.WriteFile(filepath.Join(dir, "a"), []byte("dat2"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SkipInitialSync = true
+	sf, err = newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	report, err := sf.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.LocalOnly) != 0 || len(report.RemoteOnly) != 0 || len(report.Changed) != 1 || report.Changed[0] != "a" {
+		t.Fatalf("only a should have been reported as changed, got %+v", report)
+	}
+
+	// restoring replaces a with the uploaded content
+	err = Restore(mockClient, dir, config, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ioutil.ReadFile(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(restored) != "data" {
+		t.Fatalf("a should have been restored, got %q", restored)
+	}
+}
+
+// TestSiafolderPreserveMetadata verifies that the modification time and
+// permissions of uploaded files are recorded in the manifest with
+// PreserveMetadata and applied by restore, and that files without metadata
+// are restored too.
+func TestSiafolderPreserveMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(file, []byte("data"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	err = os.Chtimes(file, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig()
+	config.StateFile = filepath.Join(dir, DefaultStateFile)
+	config.SyncOnly = true
+	config.PreserveMetadata = true
+	// keep the file uploaded before metadata was recorded
+	config.Archive = true
+	mockClient := newTestingClient()
+	mockClient.siaFiles[testSiaPath("old").String()] = "checksum"
+	mockClient.contents[testSiaPath("old").String()] = []byte("old")
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Close()
+
+	mockClient.mu.Lock()
+	data := mockClient.contents[testSiaPath(manifestName).String()]
+	mockClient.mu.Unlock()
+	var m manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := m.Files["file"].Metadata
+	if md == nil || !md.ModTime.Equal(modTime) || md.Mode != 0600 {
+		t.Fatalf("unexpected metadata %+v", md)
+	}
+
+	restoreDir, err := ioutil.TempDir("", "siasync-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(restoreDir)
+	err = Restore(mockClient, restoreDir, config, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(filepath.Join(restoreDir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stat.ModTime().Equal(modTime) {
+		t.Fatalf("restored file should have modification time %v, got %v", modTime, stat.ModTime())
+	}
+	if !isWindows && stat.Mode().Perm() != 0600 {
+		t.Fatalf("restored file should have mode 0600, got %v", stat.Mode().Perm())
+	}
+	if _, err := os.Stat(filepath.Join(restoreDir, "old")); err != nil {
+		t.Fatal("a file without metadata should be restored too")
+	}
+}
+
+// TestSiafolderDedupe verifies that files with the same content are uploaded
+// once, recorded as duplicates in the manifest and restored, and that a
+// duplicate is uploaded once its original is removed.
+func TestSiafolderDedupe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"a/subs.srt": "subtitles",
+		"b/subs.srt": "subtitles",
+		"c/subs.srt": "other subtitles",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(file), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := testConfig()
+	config.StateFile = filepath.Join(dir, DefaultStateFile)
+	config.Dedupe = true
+	config.MaxUploads = 4
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	// one of the identical files is uploaded, the other is its duplicate
+	original, duplicate := "a/subs.srt", "b/subs.srt"
+	if fs := sf.state[original]; fs.DuplicateOf != "" {
+		original, duplicate = duplicate, original
+	}
+	if _, exists := mockClient.file(original); !exists {
+		t.Fatalf("%v should have been uploaded", original)
+	}
+	if _, exists := mockClient.file(duplicate); exists {
+		t.Fatalf("%v should not have been uploaded", duplicate)
+	}
+	if _, exists := mockClient.file("c/subs.srt"); !exists {
+		t.Fatal("c/subs.srt should have been uploaded")
+	}
+	if fs := sf.state[duplicate]; fs.DuplicateOf != original || !fs.Uploaded {
+		t.Fatalf("%v should be a duplicate of %v, got %+v", duplicate, original, fs)
+	}
+	if n := sf.Stats().Deduplicated; n != 1 {
+		t.Fatalf("expected 1 deduplicated file, got %v", n)
+	}
+
+	// the manifest records the duplicate, so that restores copy it
+	mockClient.mu.Lock()
+	data := mockClient.contents[testSiaPath(manifestName).String()]
+	mockClient.mu.Unlock()
+	var m manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Files[duplicate].DuplicateOf != original || m.Files[original].DuplicateOf != "" {
+		t.Fatalf("expected %v to be a duplicate of %v in the manifest, got %+v", duplicate, original, m)
+	}
+	restoreDir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(restoreDir)
+	err = Restore(mockClient, restoreDir, config, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		restored, err := ioutil.ReadFile(filepath.Join(restoreDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(restored) != content {
+			t.Fatalf("%v should have been restored, got %q", name, restored)
+		}
+	}
+
+	// the duplicate is uploaded once its original is removed
+	err = os.Remove(filepath.Join(dir, filepath.FromSlash(original)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := mockClient.file(original); exists {
+		t.Fatalf("%v should have been removed from Sia", original)
+	}
+	if _, exists := mockClient.file(duplicate); !exists {
+		t.Fatalf("%v should have been uploaded once its original was removed", duplicate)
+	}
+	if fs, _ := sf.trackedFile(filepath.Join(dir, filepath.FromSlash(duplicate))); fs.DuplicateOf != "" || !fs.Uploaded {
+		t.Fatalf("%v should no longer be a duplicate, got %+v", duplicate, fs)
+	}
+}
+
+// TestSiafolderAutoCategorize verifies that files are synced into their
+// category folder on Sia, that files already there aren't mistaken for
+// deleted ones and that deletions remove them from their category folder.
+func TestSiafolderAutoCategorize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"Show.Name.S02E05.mkv", "Movie.Name.2019.mkv"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := testConfig()
+	config.StateFile = filepath.Join(dir, DefaultStateFile)
+	config.AutoCategorize = true
+	config.Manifest = true
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	for _, relpath := range []string{"tv/Show.Name.S02E05.mkv", "movies/Movie.Name.2019.mkv"} {
+		if _, exists := mockClient.file(relpath); !exists {
+			t.Fatalf("%v should have been uploaded", relpath)
+		}
+	}
+	mockClient.mu.Lock()
+	_, manifestExists := mockClient.contents[testSiaPath(manifestName).String()]
+	mockClient.mu.Unlock()
+	if !manifestExists {
+		t.Fatal("the manifest should not be categorized")
+	}
+	deleted, err := sf.deletedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("expected no deleted files, got %v", deleted)
+	}
+
+	err = os.Remove(filepath.Join(dir, "Show.Name.S02E05.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := mockClient.file("tv/Show.Name.S02E05.mkv"); exists {
+		t.Fatal("the removed episode should have been deleted from Sia")
+	}
+}
+
+// codingClient is a testingClient that records the erasure coding each file
+// was uploaded with.
+type codingClient struct {
+	*testingClient
+	coding map[string][2]uint64
+}
+
+func (c *codingClient) RenterUploadPost(path string, siaPath modules.SiaPath, dataPieces, parityPieces uint64) error {
+	c.mu.Lock()
+	c.coding[siaPath.String()] = [2]uint64{dataPieces, parityPieces}
+	c.mu.Unlock()
+	return c.testingClient.RenterUploadPost(path, siaPath, dataPieces, parityPieces)
+}
+
+// TestSiafolderCategorySettings verifies that files are uploaded with the
+// erasure coding of their top-level folder.
+func TestSiafolderCategorySettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, relpath := range []string{"home/video.mp4", "other/file"} {
+		err = os.MkdirAll(filepath.Join(dir, filepath.Dir(relpath)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, relpath), []byte(relpath), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := testConfig()
+	config.Categories = map[string]CategorySettings{
+		"home": {dataPieces: 10, parityPieces: 40, minRedundancy: 2},
+	}
+	mockClient := &codingClient{testingClient: newTestingClient(), coding: make(map[string][2]uint64)}
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	expected := map[string][2]uint64{
+		"home/video.mp4": {10, 40},
+		"other/file":     {config.DataPieces, config.ParityPieces},
+	}
+	mockClient.mu.Lock()
+	defer mockClient.mu.Unlock()
+	for relpath, coding := range expected {
+		if got := mockClient.coding[testSiaPath(relpath).String()]; got != coding {
+			t.Errorf("%v: expected erasure coding %v, got %v", relpath, coding, got)
+		}
+	}
+}
+
+// TestSiafolderRemoteDirs verifies that local directories are created on Sia,
+// including empty ones, and that only the ones siasync created are removed
+// from Sia with the local directory.
+func TestSiafolderRemoteDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"empty", "existing", "full"} {
+		err = os.Mkdir(filepath.Join(dir, d), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "full", "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := newTestingClient()
+	mockClient.siaDirs[testSiaPath("existing").String()] = time.Now()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	remoteDir := func(relpath string) bool {
+		mockClient.mu.Lock()
+		defer mockClient.mu.Unlock()
+		return mockClient.dirExists(testSiaPath(relpath).String())
+	}
+	for _, d := range []string{"empty", "existing", "full"} {
+		if !remoteDir(d) {
+			t.Fatalf("%v should exist on Sia", d)
+		}
+	}
+	sf.mu.Lock()
+	created := sf.dirs[filepath.Join(sf.path, "empty")].created
+	existed := sf.dirs[filepath.Join(sf.path, "existing")].created
+	sf.mu.Unlock()
+	if !created || existed {
+		t.Fatal("only the directories siasync created should be marked as created")
+	}
+
+	err = os.Mkdir(filepath.Join(dir, "show"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if !remoteDir("show") {
+		t.Fatal("the new empty directory should have been created on Sia")
+	}
+
+	for _, d := range []string{"show", "existing", "full"} {
+		err = os.RemoveAll(filepath.Join(dir, d))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Second)
+	if remoteDir("show") || remoteDir("full") {
+		t.Fatal("the removed directories siasync created should have been removed from Sia")
+	}
+	if !remoteDir("existing") {
+		t.Fatal("the directory that existed on Sia before should have been kept")
+	}
+	if !remoteDir("empty") {
+		t.Fatal("the remaining directory should still exist on Sia")
+	}
+}
+
+// TestSiafolderRemoveEmptyDirs verifies that directories on Sia that stayed
+// empty for the grace period are removed, except the category folders and the
+// directories of local ones.
+func TestSiafolderRemoveEmptyDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.Mkdir(filepath.Join(dir, "local"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	mockClient := newTestingClient()
+	for relpath, modTime := range map[string]time.Time{
+		"old":         old,
+		"recent":      time.Now(),
+		"nested":      old,
+		"nested/deep": old,
+		"full":        old,
+		"tv":          old,
+		"local":       old,
+	} {
+		mockClient.siaDirs[testSiaPath(relpath).String()] = modTime
+	}
+	config := testConfig()
+	config.EmptyDirGrace = time.Hour
+	config.Categories = map[string]CategorySettings{"tv": {dataPieces: 10, parityPieces: 20}}
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	mockClient.mu.Lock()
+	mockClient.siaFiles[testSiaPath("full/file").String()] = "checksum"
+	mockClient.mu.Unlock()
+
+	remoteDir := func(relpath string) bool {
+		mockClient.mu.Lock()
+		defer mockClient.mu.Unlock()
+		return mockClient.dirExists(testSiaPath(relpath).String())
+	}
+	sf.removeEmptyDirs()
+	for relpath, exists := range map[string]bool{
+		"old":         false,
+		"recent":      true,
+		"nested":      true,
+		"nested/deep": false,
+		"full":        true,
+		"tv":          true,
+		"local":       true,
+	} {
+		if remoteDir(relpath) != exists {
+			t.Errorf("%v: expected it to exist on Sia: %v", relpath, exists)
+		}
+	}
+
+	// the parent of a removed directory is removed by the next run
+	sf.removeEmptyDirs()
+	if remoteDir("nested") {
+		t.Fatal("nested should have been removed once it was empty")
+	}
+}
+
+// auditRecorder is an AuditSink that keeps the records in memory.
+type auditRecorder struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (a *auditRecorder) Record(record AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records = append(a.records, record)
+	return nil
+}
+
+// ops returns the operations and siapaths recorded so far.
+func (a *auditRecorder) ops() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var ops []string
+	for _, record := range a.records {
+		op := record.Op + " " + record.SiaPath
+		if record.To != "" {
+			op += " " + record.To
+		}
+		if record.Error != "" {
+			op += " failed"
+		}
+		if record.DryRun {
+			op += " dry run"
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// TestSiafolderAuditLog verifies that uploads, renames and deletions are sent
+// to the audit sink with their result, and dry run ones marked as such.
+func TestSiafolderAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "a"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &auditRecorder{}
+	config := testConfig()
+	config.AuditSink = sink
+	mockClient := newTestingClient()
+	mockClient.siaFiles[testSiaPath("gone").String()] = "checksum"
+	sf, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	err = os.Remove(filepath.Join(dir, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	err = sf.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b, gone := testSiaPath("a").String(), testSiaPath("b").String(), testSiaPath("gone").String()
+	expected := []string{"upload " + a, "delete " + gone, "rename " + a + " " + b, "delete " + b}
+	ops := sink.ops()
+	sort.Strings(expected[:2])
+	if len(ops) >= 2 {
+		sort.Strings(ops[:2])
+	}
+	if !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("expected %v, got %v", expected, ops)
+	}
+	upload := sink.records[0]
+	if upload.Op != auditUpload {
+		upload = sink.records[1]
+	}
+	if upload.Path != filepath.Join(sf.path, "a") || upload.Size != 4 || upload.Checksum == "" || upload.Time.IsZero() {
+		t.Fatalf("upload record is missing details: %+v", upload)
+	}
+
+	config.DryRun = true
+	sink = &auditRecorder{}
+	config.AuditSink = sink
+	err = ioutil.WriteFile(filepath.Join(dir, "c"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err = newSyncedSiafolder(dir, newTestingClient(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sf.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ops := sink.ops(); len(ops) != 1 || ops[0] != "upload "+testSiaPath("c").String()+" dry run" {
+		t.Fatalf("expected the dry run upload of c, got %v", ops)
+	}
+}
+
+// TestSiafolderVerify verifies that verify reports local only, remote only and
+// mismatched files without changing Sia.
+func TestSiafolderVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range map[string]string{"synced": "data", "local": "data", "changed": "new data"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := newTestingClient()
+	for relpath, data := range map[string]string{"synced": "data", "changed": "data", "remote": "data"} {
+		client.siaFiles[testSiaPath(relpath).String()] = "checksum"
+		client.contents[testSiaPath(relpath).String()] = []byte(data)
+	}
+
+	config := testConfig()
+	config.SkipInitialSync = true
+	sf, err := newSyncedSiafolder(dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	report, err := sf.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ops := client.operations(); len(ops) != 0 {
+		t.Fatalf("verify should not change Sia, got %v", ops)
+	}
+	if report.OK() {
+		t.Fatal("the report should list differences")
+	}
+	if len(report.LocalOnly) != 1 || report.LocalOnly[0] != "local" {
+		t.Errorf("unexpected local only files %v", report.LocalOnly)
+	}
+	if len(report.RemoteOnly) != 1 || report.RemoteOnly[0] != "remote" {
+		t.Errorf("unexpected remote only files %v", report.RemoteOnly)
+	}
+	if len(report.Mismatched) != 1 || report.Mismatched[0] != (sizeMismatch{Path: "changed", LocalSize: 8, RemoteSize: 4}) {
+		t.Errorf("unexpected mismatched files %v", report.Mismatched)
+	}
+}
+
+// TestSiafolderStatus verifies that Status reports the tracked files with
+// their remote state.
+func TestSiafolderStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a", "b"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sf, err := newSyncedSiafolder(dir, newTestingClient(), testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	status, err := sf.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Watched != 2 || status.Uploaded != 2 || status.Pending != 0 || status.Failed != 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if len(status.Files) != 2 || status.Files[0].Path != "a" || status.Files[0].Size != 4 || !status.Files[0].Uploaded {
+		t.Fatalf("unexpected file status %+v", status.Files)
+	}
+}
+
+// TestSiafolderInvalidNames verifies that files with names Sia doesn't accept
+// are skipped and listed in the status while the other files are synced, or
+// uploaded under a sanitized name with SanitizeNames.
+func TestSiafolderInvalidNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"good", "bad\x01name", "trailing "} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := sf.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Close()
+	if _, exists := mockClient.file("good"); !exists || status.Watched != 1 {
+		t.Fatalf("only good should have been synced, got %+v", status)
+	}
+	if len(status.Skipped) != 2 || status.Skipped[0].Path != "bad\x01name" || status.Skipped[1].Path != "trailing " || status.Skipped[0].Error == "" {
+		t.Fatalf("unexpected skipped files %+v", status.Skipped)
+	}
+
+	// a file created while running is skipped too, and forgotten once it is
+	// removed
+	sf, err = newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	err = os.Remove(filepath.Join(dir, "trailing "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, " leading"), []byte("leading"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	status, err = sf.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Skipped) != 2 || status.Skipped[0].Path != " leading" || status.Skipped[1].Path != "bad\x01name" {
+		t.Fatalf("unexpected skipped files %+v", status.Skipped)
+	}
+
+	config := testConfig()
+	config.SanitizeNames = true
+	mockClient = newTestingClient()
+	sanitized, err := newSyncedSiafolder(dir, mockClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sanitized.Close()
+	for _, name := range []string{"good", "bad_name", "_leading"} {
+		if _, exists := mockClient.file(name); !exists {
+			t.Errorf("%v should have been uploaded", name)
+		}
+	}
+	if status, err := sanitized.Status(); err != nil || len(status.Skipped) != 0 {
+		t.Fatalf("no file should have been skipped, got %+v %v", status.Skipped, err)
+	}
+}
+
+// TestSiafolderUnicodeNames verifies that decomposed file names, as macOS
+// returns them, are synced to the composed siapath and tracked under the
+// composed path. The composed name is a hard link to the decomposed one, so
+// that both name the same file like they do on macOS.
+func TestSiafolderUnicodeNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	decomposed := filepath.Join(dir, "Ame\u0301lie.mkv")
+	composed := filepath.Join(dir, "Am\u00e9lie.mkv")
+	err = ioutil.WriteFile(decomposed, []byte("movie"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Link(decomposed, composed)
+	if err != nil {
+		t.Skip("hard links are not supported:", err)
+	}
+	// a decomposed name without a composed twin keeps its local path
+	err = ioutil.WriteFile(filepath.Join(dir, "Cafe\u0301.txt"), []byte("menu"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	if _, exists := mockClient.file("Am\u00e9lie.mkv"); !exists {
+		t.Fatal("Amélie.mkv should have been uploaded to its composed siapath")
+	}
+	if _, exists := mockClient.file("Caf\u00e9.txt"); !exists {
+		t.Fatal("Café.txt should have been uploaded to its composed siapath")
+	}
+	if n := mockClient.uploadRequests(); n != 2 {
+		t.Fatalf("expected 2 uploads, got %v", n)
+	}
+	if _, tracked := sf.trackedFile(composed); !tracked {
+		t.Fatal("Amélie.mkv should be tracked under its composed path")
+	}
+	if _, tracked := sf.trackedFile(decomposed); tracked {
+		t.Fatal("Amélie.mkv should not be tracked under its decomposed path")
+	}
+
+	// removing both names removes the file from Sia
+	os.Remove(composed)
+	err = os.Remove(decomposed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := mockClient.file("Am\u00e9lie.mkv"); exists {
+		t.Fatal("Amélie.mkv should have been removed from Sia")
+	}
+}
+
+// TestSiafolderUnreadable verifies that files that can't be read are skipped
+// and listed in the status instead of stopping the initial sync, unless
+// Strict is set.
+func TestSiafolderUnreadable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "good"), []byte("good"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a symlink to itself can't be opened, even by root
+	loop := filepath.Join(dir, "loop")
+	err = os.Symlink(loop, loop)
+	if err != nil {
+		t.Skip("symlinks are not supported:", err)
+	}
+
+	mockClient := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, mockClient, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := sf.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := mockClient.file("good"); !exists || status.Watched != 1 {
+		t.Fatalf("good should have been synced, got %+v", status)
+	}
+	if len(status.Skipped) != 1 || status.Skipped[0].Path != "loop" || status.Skipped[0].Error == "" {
+		t.Fatalf("unexpected skipped files %+v", status.Skipped)
+	}
+	if stats := sf.Stats(); stats.Skipped != 1 {
+		t.Fatalf("expected 1 skipped file, got %v", stats.Skipped)
+	}
+
+	// once the file can be read it is synced and not skipped anymore
+	err = os.Remove(loop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(loop, []byte("fixed"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	sf.Close()
+	if _, exists := mockClient.file("loop"); !exists {
+		t.Fatal("loop should have been uploaded once it could be read")
+	}
+	if stats := sf.Stats(); stats.Skipped != 0 {
+		t.Fatalf("expected no skipped files, got %v", stats.Skipped)
+	}
+
+	os.Remove(loop)
+	err = os.Symlink(loop, loop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig()
+	config.Strict = true
+	_, err = newSyncedSiafolder(dir, newTestingClient(), config)
+	if err == nil {
+		t.Fatal("NewSiafolder should fail on an unreadable file with Strict")
+	}
+}
+
+// TestSiafolderListing verifies that the listing of the Sia folder is reused
+// until siasync changes the folder.
+func TestSiafolderListing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "a"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestingClient()
+	sf, err := newSyncedSiafolder(dir, client, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	listings := func() int {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.listings
+	}
+	files, err := sf.getSiaFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := listings()
+	if _, err = sf.Status(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sf.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if listings() != before {
+		t.Fatalf("the cached listing should have been reused, got %v more listings", listings()-before)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file on Sia, got %v", len(files))
+	}
+
+	err = sf.handleRemove(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err = sf.getSiaFiles()
+	if err != nil && !strings.Contains(err.Error(), errNoFiles.Error()) {
+		t.Fatal(err)
+	}
+	if listings() == before || len(files) != 0 {
+		t.Fatalf("the listing should have been fetched again after a delete, got %v", files)
+	}
+}
+
+// TestSiafolderUploadingBytes verifies that the bytes siad is still uploading
+// are counted by looking up the files handed to siad, without listing the Sia
+// folder again.
+func TestSiafolderUploadingBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "a"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestingClient()
+	config := testConfig()
+	config.MaxConcurrentBytes = 1 << 20
+	sf, err := newSyncedSiafolder(dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	listings := func() int {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.listings
+	}
+	bytes, err := sf.uploadingBytes()
+	if err != nil || bytes != 4 {
+		t.Fatalf("expected 4 bytes uploading, got %v, %v", bytes, err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "b"), []byte("uploading"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	before := listings()
+	bytes, err = sf.uploadingBytes()
+	if err != nil || bytes != 13 {
+		t.Fatalf("expected 13 bytes uploading, got %v, %v", bytes, err)
+	}
+	if listings() != before {
+		t.Fatalf("the Sia folder shouldn't be listed to count the uploading bytes, got %v listings", listings()-before)
+	}
+
+	// a file that is gone from Sia no longer counts
+	err = client.RenterDeletePost(testSiaPath("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytes, err = sf.uploadingBytes()
+	if err != nil || bytes != 9 {
+		t.Fatalf("expected 9 bytes uploading, got %v, %v", bytes, err)
+	}
+}
+
+// TestSiafolderHooks verifies that the upload and delete hooks are run with
+// the event in their environment.
+func TestSiafolderHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hookDir, err := ioutil.TempDir("", "siasync-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hookDir)
+	script := filepath.Join(hookDir, "hook.sh")
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$SIASYNC_EVENT $SIASYNC_SIAPATH $SIASYNC_SIZE\" >> "+filepath.Join(hookDir, "events")+"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig()
+	config.OnUpload = script
+	config.OnDelete = script
+	sf, err := newSyncedSiafolder(dir, newTestingClient(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	err = sf.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(hookDir, "events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hooks run concurrently, so their output may be in any order
+	for _, expected := range []string{"upload " + testSiaPath("file").String() + " 4\n", "delete " + testSiaPath("file").String() + " 4\n"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected hook output %q, got %q", expected, data)
+		}
+	}
+}
+
+// TestSiafolderEvents verifies that the events of a SiaFolder are published
+// on its Events channel, which is closed by Close.
+func TestSiafolderEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sf, err := newSyncedSiafolder(dir, newTestingClient(), testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(sf.path, "file")
+	err = ioutil.WriteFile(file, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	err = os.Remove(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	err = sf.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []Event
+	for e := range sf.Events() {
+		events = append(events, e)
+	}
+	expected := []Event{
+		{Type: ReconcileComplete},
+		{Type: FileQueued, Path: file, SiaPath: testSiaPath("file").String()},
+		{Type: FileUploaded, Path: file, SiaPath: testSiaPath("file").String(), Size: 4},
+		{Type: FileDeleted, Path: file, SiaPath: testSiaPath("file").String(), Size: 4},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %v events, got %+v", len(expected), events)
+	}
+	for i, e := range events {
+		if e.Time.IsZero() || e.Err != nil {
+			t.Fatalf("event %v should have a time and no error, got %+v", i, e)
+		}
+		e.Time = time.Time{}
+		if e != expected[i] {
+			t.Fatalf("expected event %+v, got %+v", expected[i], e)
+		}
+	}
+}
+
+// BenchmarkSiafolderReconcile measures comparing 10k tracked files with a
+// listing of the same 10k files on Sia, which should scale linearly with the
+// number of files.
+func BenchmarkSiafolderReconcile(b *testing.B) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	client := newTestingClient()
+	config := testConfig()
+	config.SkipInitialSync = true
+	config.SyncOnly = true
+	config.ChangeDetection = "size"
+	sf, err := newSyncedSiafolder(dir, client, config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sf.Close()
+
+	for i := 0; i < 10000; i++ {
+		relpath := fmt.Sprintf("file%05d", i)
+		client.siaFiles[testSiaPath(relpath).String()] = "checksum"
+		client.contents[testSiaPath(relpath).String()] = []byte("data")
+		sf.trackFile(filepath.Join(dir, relpath), fileState{Size: 4, Uploaded: true})
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sf.listing.invalidate()
+		err = sf.uploadNonExisting()
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = sf.uploadChanged()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	if n := sf.uploads.len(); n != 0 {
+		b.Fatalf("no file should have been queued, got %v", n)
+	}
+}
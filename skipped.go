@@ -0,0 +1,52 @@
+package siasync
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// skipFile records a file or directory that isn't synced because of err, like
+// a name Sia doesn't accept or missing read permissions, so that it is listed
+// as skipped in the status. It is logged with msg the first time. A skipped
+// file that can be synced later, for example after its permissions were
+// fixed, is forgotten once it is tracked.
+func (sf *SiaFolder) skipFile(file string, err error, msg string) {
+	sf.mu.Lock()
+	_, known := sf.skipped[file]
+	sf.skipped[file] = err.Error()
+	sf.mu.Unlock()
+	if !known {
+		log.WithFields(logrus.Fields{
+			"file":  file,
+			"error": err.Error(),
+		}).Warn(msg)
+	}
+}
+
+// forgetSkipped removes a file that was removed locally from the skipped
+// files.
+func (sf *SiaFolder) forgetSkipped(file string) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	delete(sf.skipped, file)
+}
+
+// skippedFiles returns the number of skipped files.
+func (sf *SiaFolder) skippedFiles() int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return len(sf.skipped)
+}
+
+// skipUnreadable skips a file that could not be read because of err and
+// returns nil, so that one unreadable file doesn't stop the sync of the
+// others. It returns err if the file is gone, which its REMOVE event takes
+// care of, or if the SiaFolder is strict.
+func (sf *SiaFolder) skipUnreadable(file string, err error) error {
+	if os.IsNotExist(err) || sf.strict {
+		return err
+	}
+	sf.skipFile(file, err, "Skipping file that can't be read")
+	return nil
+}
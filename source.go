@@ -0,0 +1,149 @@
+package siasync
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// removeSourceInterval is how often uploaded files are checked for whether
+// their local copy can be removed.
+var removeSourceInterval = time.Minute
+
+// removeSourceRedundancy is the redundancy a file must reach on Sia before its
+// local copy is removed.
+const removeSourceRedundancy = 1.0
+
+// removeSources removes the local copy of every uploaded file that is
+// available on Sia with at least removeSourceRedundancy, or moves it into
+// doneDir if it is set, and then removes the directories that became empty.
+// Files that changed since they were uploaded are kept. The SiaFolder is in
+// archive mode when sources are removed, so the removal doesn't delete the
+// files from Sia. It runs on the eventWatcher goroutine.
+func (sf *SiaFolder) removeSources() {
+	sf.listing.invalidate()
+	renterFiles, err := sf.getSiaFiles()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error listing files to remove local copies")
+		return
+	}
+
+	for _, file := range sf.trackedFiles() {
+		fs, _ := sf.trackedFile(file)
+		if !fs.Uploaded {
+			continue
+		}
+		relpath, err := filepath.Rel(sf.path, file)
+		if err != nil {
+			continue
+		}
+		siaPath, err := sf.uploadedSiaPath(relpath, fs)
+		if err != nil {
+			continue
+		}
+		siafile, ok := renterFiles[siaPath]
+		if !ok || !siafile.Available || siafile.Redundancy < removeSourceRedundancy || int64(siafile.Filesize) != fs.Size {
+			continue
+		}
+		stat, err := os.Stat(file)
+		if err != nil || !fs.unchanged(stat) {
+			continue
+		}
+
+		if sf.doneDir != "" {
+			err = sf.moveToDoneDir(file, relpath)
+		} else {
+			log.WithFields(logrus.Fields{
+				"file":       file,
+				"redundancy": siafile.Redundancy,
+			}).Info("File is on Sia, removing the local copy")
+			err = os.Remove(file)
+		}
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Error removing local copy")
+			continue
+		}
+		sf.untrackFile(file)
+
+		// remove the directories left empty, os.Remove fails for the first
+		// one that isn't
+		for dir := filepath.Dir(file); isWithin(sf.path, dir); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	sf.saveStateLogged()
+}
+
+// moveToDoneDir moves a file to relpath in doneDir. If a file already exists
+// there, a number is added to the name of the moved file.
+func (sf *SiaFolder) moveToDoneDir(file, relpath string) error {
+	dest := filepath.Join(sf.doneDir, relpath)
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(dest); os.IsNotExist(err) {
+			break
+		}
+		dest = fmt.Sprintf("%v (%v)%v", base, i, ext)
+	}
+
+	log.WithFields(logrus.Fields{
+		"file": file,
+		"dest": dest,
+	}).Info("File is on Sia, moving it to the done directory")
+	err = os.Rename(file, dest)
+	if err == nil {
+		return nil
+	}
+
+	// the done directory may be on another filesystem
+	err = copyFile(file, dest)
+	if err != nil {
+		os.Remove(dest)
+		return err
+	}
+	return os.Remove(file)
+}
+
+// copyFile copies the content, permissions and modification time of src to
+// the new file dst.
+func copyFile(src, dst string) error {
+	stat, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, stat.Mode())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(dst, stat.ModTime(), stat.ModTime())
+}
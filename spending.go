@@ -0,0 +1,200 @@
+package siasync
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// spendingInterval is how often eventWatcher samples the renter's spending,
+// shared by all SiaFolders.
+const spendingInterval = time.Minute
+
+// Spending is what the renter spent while siasync was running and what is left
+// of its allowance.
+type Spending struct {
+	// Session is the estimated spending on contracts, storage and uploads
+	// since siasync started. The renter doesn't break its spending down by
+	// file, so this includes everything else the node uploaded meanwhile.
+	Session string `json:"session"`
+
+	// Remaining is the unspent allowance of the current period, and
+	// RemainingPercent the percentage of the allowance it is.
+	Remaining        string  `json:"remaining"`
+	RemainingPercent float64 `json:"remainingpercent"`
+
+	// Low is set while Remaining is below the -low-allowance percentage.
+	Low bool `json:"low"`
+}
+
+// SpendingTracker estimates the spending of a siasync session from the
+// financial metrics of the renter, sampled by every SiaFolder sharing it. The
+// metrics of the renter are reset every allowance period, so the session
+// spending is the sum of the increases between samples.
+type SpendingTracker struct {
+	mu         sync.Mutex
+	client     NodeClient
+	lowPercent float64
+	pauseOnLow bool
+
+	sampled   time.Time
+	spent     types.Currency // spent is the period spending at the last sample
+	session   types.Currency
+	funds     types.Currency
+	remaining types.Currency
+	low       bool
+}
+
+// NewSpendingTracker returns a tracker for the renter of c that warns once the
+// unspent allowance drops below lowPercent of the allowance, and pauses new
+// uploads with pauseOnLow.
+func NewSpendingTracker(c NodeClient, lowPercent float64, pauseOnLow bool) *SpendingTracker {
+	return &SpendingTracker{
+		client:     c,
+		lowPercent: lowPercent,
+		pauseOnLow: pauseOnLow,
+	}
+}
+
+// periodSpending returns what the renter spent on contracts, storage and
+// uploads in the current period. Downloads are left out, siasync only
+// downloads when restoring.
+func periodSpending(metrics modules.ContractorSpending) types.Currency {
+	return metrics.ContractFees.Add(metrics.StorageSpending).Add(metrics.UploadSpending)
+}
+
+// percentOf returns a as a percentage of b, 0 if b is zero.
+func percentOf(a, b types.Currency) float64 {
+	if b.IsZero() {
+		return 0
+	}
+	percent, _ := new(big.Rat).SetFrac(a.Big(), b.Big()).Float64()
+	return 100 * percent
+}
+
+// sample queries the financial metrics of the renter and adds the spending
+// since the last sample to the session. A drop of the period spending means a
+// new period started. It logs a warning once the allowance runs low and once
+// it was topped up.
+func (t *SpendingTracker) sample() error {
+	rg, err := t.client.RenterGet()
+	if err != nil {
+		return err
+	}
+	spent := periodSpending(rg.FinancialMetrics)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.sampled.IsZero():
+	case spent.Cmp(t.spent) >= 0:
+		t.session = t.session.Add(spent.Sub(t.spent))
+	default:
+		t.session = t.session.Add(spent)
+	}
+	t.sampled = time.Now()
+	t.spent = spent
+	t.funds = rg.Settings.Allowance.Funds
+	t.remaining = rg.FinancialMetrics.Unspent
+
+	percent := percentOf(t.remaining, t.funds)
+	low := !t.funds.IsZero() && percent < t.lowPercent
+	fields := logrus.Fields{
+		"remaining": t.remaining.HumanString(),
+		"allowance": t.funds.HumanString(),
+		"percent":   percent,
+		"session":   t.session.HumanString(),
+	}
+	if low && !t.low {
+		if t.pauseOnLow {
+			log.WithFields(fields).Warn("ALLOWANCE LOW: pausing new uploads until the allowance is increased with siac renter setallowance")
+		} else {
+			log.WithFields(fields).Warn("ALLOWANCE LOW: increase the allowance with siac renter setallowance")
+		}
+	}
+	if !low && t.low {
+		log.WithFields(fields).Info("Allowance is no longer low")
+	}
+	t.low = low
+	return nil
+}
+
+// due reports whether the last sample is older than spendingInterval.
+func (t *SpendingTracker) due() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Since(t.sampled) >= spendingInterval
+}
+
+// pauseUploads reports whether new uploads are paused for a low allowance.
+func (t *SpendingTracker) pauseUploads() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.low && t.pauseOnLow
+}
+
+// Spending returns the spending as of the last sample.
+func (t *SpendingTracker) Spending() Spending {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Spending{
+		Session:          t.session.HumanString(),
+		Remaining:        t.remaining.HumanString(),
+		RemainingPercent: percentOf(t.remaining, t.funds),
+		Low:              t.low,
+	}
+}
+
+// LogSummary logs the spending of the session.
+func (t *SpendingTracker) LogSummary() {
+	spending := t.Spending()
+	log.WithFields(logrus.Fields{
+		"session":   spending.Session,
+		"remaining": spending.Remaining,
+		"percent":   spending.RemainingPercent,
+	}).Info("Estimated spending this session")
+}
+
+// sampleSpending samples the spending of the renter, and pauses or resumes
+// new uploads with the allowance. Deletions and renames go on, they don't cost
+// anything. With onlyIfDue, it only samples if no SiaFolder sampled for
+// spendingInterval.
+func (sf *SiaFolder) sampleSpending(onlyIfDue bool) {
+	if sf.spending == nil {
+		return
+	}
+	if !onlyIfDue || sf.spending.due() {
+		err := sf.spending.sample()
+		if err != nil {
+			// an unreachable siad is handled by the upload workers
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Debug("Could not get the spending of the renter")
+		}
+	}
+	if sf.spending.pauseUploads() {
+		sf.uploads.pause("allowance")
+	} else {
+		sf.uploads.resume("allowance")
+	}
+}
+
+// sampleSpendingUntil samples the spending every spendingInterval until stop
+// is closed, so that initial uploads paused for a low allowance resume once it
+// is increased, also without eventWatcher in sync-only mode.
+func (sf *SiaFolder) sampleSpendingUntil(stop <-chan struct{}) {
+	ticker := time.NewTicker(spendingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sf.sampleSpending(true)
+		}
+	}
+}
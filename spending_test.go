@@ -0,0 +1,117 @@
+package siasync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/Sia/modules"
+	"gitlab.com/NebulousLabs/Sia/node/api"
+	"gitlab.com/NebulousLabs/Sia/types"
+)
+
+// spendingNode returns a testingNode with an allowance of 100 that spent
+// spent of it in the current period.
+func spendingNode(spent uint64) *testingNode {
+	return &testingNode{renter: api.RenterGET{
+		Settings: modules.RenterSettings{
+			Allowance: modules.Allowance{Funds: types.NewCurrency64(100)},
+		},
+		FinancialMetrics: modules.ContractorSpending{
+			UploadSpending: types.NewCurrency64(spent),
+			Unspent:        types.NewCurrency64(100 - spent),
+		},
+	}}
+}
+
+// TestSpendingTracker verifies that the spending of a session adds up across
+// allowance periods and that a low allowance is detected.
+func TestSpendingTracker(t *testing.T) {
+	node := spendingNode(20)
+	tracker := NewSpendingTracker(node, 10, true)
+	samples := []struct {
+		spent   uint64
+		session string
+		low     bool
+	}{
+		{20, "0 H", false},
+		{50, "30 H", false},
+		{95, "75 H", true},
+		// a new period started
+		{5, "80 H", false},
+	}
+	for _, sample := range samples {
+		*node = *spendingNode(sample.spent)
+		err := tracker.sample()
+		if err != nil {
+			t.Fatal(err)
+		}
+		spending := tracker.Spending()
+		if spending.Session != sample.session || spending.Low != sample.low || tracker.pauseUploads() != sample.low {
+			t.Fatalf("after spending %v, expected a session of %v and low %v, got %+v", sample.spent, sample.session, sample.low, spending)
+		}
+		if spending.RemainingPercent != float64(100-sample.spent) {
+			t.Fatalf("expected %v%% remaining, got %v", 100-sample.spent, spending.RemainingPercent)
+		}
+	}
+	if tracker.due() {
+		t.Fatal("the tracker was just sampled")
+	}
+}
+
+// TestSiafolderPauseOnLowAllowance verifies that new uploads are paused while
+// the allowance is low, and that deletions go on.
+func TestSiafolderPauseOnLowAllowance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siasync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "old"), []byte("old"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := spendingNode(50)
+	client := newTestingClient()
+	config := testConfig()
+	config.Spending = NewSpendingTracker(node, 10, true)
+	sf, err := newSyncedSiafolder(dir, client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	*node = *spendingNode(95)
+	sf.sampleSpending(false)
+	err = ioutil.WriteFile(filepath.Join(dir, "new"), []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(filepath.Join(dir, "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, exists := client.file("new"); exists {
+		t.Fatal("new should not be uploaded while the allowance is low")
+	}
+	if _, exists := client.file("old"); exists {
+		t.Fatal("old should be deleted while the allowance is low")
+	}
+	status, err := sf.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Spending == nil || !status.Spending.Low {
+		t.Fatalf("the status should report the low allowance, got %+v", status.Spending)
+	}
+
+	*node = *spendingNode(20)
+	sf.sampleSpending(false)
+	time.Sleep(time.Second)
+	if _, exists := client.file("new"); !exists {
+		t.Fatal("new should be uploaded once the allowance was increased")
+	}
+}
@@ -0,0 +1,92 @@
+package siasync
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StallActions are the supported actions for a stalled upload. alert only
+// logs the upload and runs the error hook, reupload also uploads the file
+// again.
+var StallActions = []string{"alert", "reupload"}
+
+// uploadProgress is the upload progress of a file that siad is still
+// uploading, since when it is at that progress, and whether it was reported
+// as stalled.
+type uploadProgress struct {
+	progress float64
+	since    time.Time
+	stalled  bool
+}
+
+// checkStalls looks for files that siad is uploading but whose upload
+// progress didn't increase for stallTimeout. Large files that upload slowly
+// are not stalled as long as their progress increases. A stalled upload is
+// logged and the error hook is run once, with the reupload action the file
+// is deleted from Sia and uploaded again unless syncing is paused. It runs on
+// the eventWatcher goroutine.
+func (sf *SiaFolder) checkStalls() {
+	sf.listing.invalidate()
+	renterFiles, err := sf.getSiaFiles()
+	if err != nil && !strings.Contains(err.Error(), errNoFiles.Error()) {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error listing files to check for stalled uploads")
+		return
+	}
+
+	now := time.Now()
+	progress := make(map[string]uploadProgress)
+	for _, file := range sf.trackedFiles() {
+		fs, _ := sf.trackedFile(file)
+		if !fs.Uploaded {
+			continue
+		}
+		relpath, err := filepath.Rel(sf.path, file)
+		if err != nil {
+			continue
+		}
+		siaPath, err := sf.uploadedSiaPath(relpath, fs)
+		if err != nil {
+			continue
+		}
+		siafile, ok := renterFiles[siaPath]
+		if !ok || siafile.UploadProgress >= 100 {
+			continue
+		}
+
+		p, seen := sf.progress[file]
+		if !seen || siafile.UploadProgress > p.progress {
+			progress[file] = uploadProgress{progress: siafile.UploadProgress, since: now}
+			continue
+		}
+		if p.stalled || now.Sub(p.since) < sf.stallTimeout {
+			progress[file] = p
+			continue
+		}
+
+		stallErr := fmt.Errorf("upload made no progress for %v at %.0f%%", now.Sub(p.since).Round(time.Second), p.progress)
+		log.WithFields(logrus.Fields{
+			"file":     file,
+			"progress": p.progress,
+			"error":    stallErr.Error(),
+		}).Warn("Upload stalled")
+		sf.emit(Event{Type: FileUploadFailed, Path: file, Size: fs.Size, Err: stallErr})
+		if sf.stallAction == "reupload" && !sf.Paused() {
+			err = sf.uploadAgain(file, fs, siafile.SiaPath)
+			if err == nil {
+				continue
+			}
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Error deleting stalled file to upload it again")
+		}
+		p.stalled = true
+		progress[file] = p
+	}
+	sf.progress = progress
+}
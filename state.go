@@ -0,0 +1,226 @@
+package siasync
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultStateFile is the name of the state file kept in the root of the
+// synced directory when no -state-file is given.
+const DefaultStateFile = ".siasync-state.json"
+
+// fileState is what siasync knows about a synced file. It is persisted in the
+// state file so that unchanged files don't need to be checksummed again on
+// restart.
+type fileState struct {
+	Checksum string    `json:"checksum"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modtime"`
+	Uploaded bool      `json:"uploaded"`
+
+	// UploadTime is when the file was uploaded, or created on Sia if it
+	// was already there.
+	UploadTime time.Time `json:"uploadtime"`
+
+	// DuplicateOf is the key of the tracked file with the same content
+	// whose upload this file shares, if it wasn't uploaded itself.
+	DuplicateOf string `json:"duplicateof,omitempty"`
+
+	// Version is the version of the file's content in archive mode, 0 if
+	// it was never changed. Versions are the earlier versions of the file
+	// still on Sia, oldest first.
+	Version  int           `json:"version,omitempty"`
+	Versions []fileVersion `json:"versions,omitempty"`
+
+	// Mode and Owner are the permissions and owner of the file, only
+	// recorded with -preserve-metadata.
+	Mode  os.FileMode `json:"mode,omitempty"`
+	Owner *fileOwner  `json:"owner,omitempty"`
+}
+
+// persistedState is the on-disk format of the state file. Files are keyed by
+// their slash separated path relative to the synced directory.
+type persistedState struct {
+	ChangeDetection string               `json:"changedetection"`
+	Files           map[string]fileState `json:"files"`
+}
+
+// statFile returns the checksum, size, modification time and, with
+// -preserve-metadata, the permissions and owner of a file on disk. The file
+// is stat'ed before it is checksummed so that a concurrent change is picked
+// up again on the next run.
+func (sf *SiaFolder) statFile(path string) (fileState, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	checksum, err := sf.checksumFile(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	fs := fileState{
+		Checksum: checksum,
+		Size:     stat.Size(),
+		ModTime:  stat.ModTime(),
+	}
+	sf.setMetadata(&fs, stat)
+	return fs, nil
+}
+
+// unchanged reports whether the file described by f still has the size and
+// modification time recorded in fs.
+func (fs fileState) unchanged(f os.FileInfo) bool {
+	return fs.Checksum != "" && fs.Size == f.Size() && fs.ModTime.Equal(f.ModTime())
+}
+
+// fileKey returns the key of a file in the files and state maps, which is its
+// slash separated path relative to the synced directory. A relative path to
+// the file, an absolute one and the path of an event all give the same key.
+func (sf *SiaFolder) fileKey(file string) string {
+	if !filepath.IsAbs(file) {
+		if abspath, err := filepath.Abs(file); err == nil {
+			file = abspath
+		}
+	}
+	key, err := relSlashPath(sf.path, file, isWindows)
+	if err != nil {
+		// files outside the synced directory are never tracked, their
+		// absolute path can't clash with a key
+		return filepath.ToSlash(file)
+	}
+	return key
+}
+
+// keyPath returns the absolute local path of a file key.
+func (sf *SiaFolder) keyPath(key string) string {
+	return filepath.Join(sf.path, filepath.FromSlash(key))
+}
+
+// trackFile records the state of a synced file. Duplicates of the file's
+// previous content are uploaded if it changed.
+func (sf *SiaFolder) trackFile(file string, fs fileState) {
+	key := sf.fileKey(file)
+	sf.mu.Lock()
+	old := sf.state[key]
+	sf.files[key] = fs.Checksum
+	sf.state[key] = fs
+	sf.stateDirty = true
+	delete(sf.skipped, sf.keyPath(key))
+	orphaned := sf.indexFile(key, old, fs)
+	sf.mu.Unlock()
+	sf.uploadOrphaned(orphaned)
+}
+
+// untrackFile forgets everything about a file. Its duplicates are uploaded.
+func (sf *SiaFolder) untrackFile(file string) {
+	key := sf.fileKey(file)
+	sf.mu.Lock()
+	old := sf.state[key]
+	delete(sf.files, key)
+	delete(sf.state, key)
+	sf.stateDirty = true
+	orphaned := sf.indexFile(key, old, fileState{})
+	sf.mu.Unlock()
+	sf.uploadOrphaned(orphaned)
+}
+
+// moveFile moves the state of a tracked file to a new path.
+func (sf *SiaFolder) moveFile(oldfile, file string) {
+	oldKey, key := sf.fileKey(oldfile), sf.fileKey(file)
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	fs := sf.state[oldKey]
+	delete(sf.files, oldKey)
+	delete(sf.state, oldKey)
+	sf.files[key] = fs.Checksum
+	sf.state[key] = fs
+	sf.stateDirty = true
+	sf.moveIndex(oldKey, key, fs)
+}
+
+// trackedFile returns the state of a file and whether it is tracked.
+func (sf *SiaFolder) trackedFile(file string) (fileState, bool) {
+	key := sf.fileKey(file)
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	fs, exists := sf.state[key]
+	return fs, exists
+}
+
+// trackedFiles returns the absolute paths of all tracked files.
+func (sf *SiaFolder) trackedFiles() []string {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	files := make([]string, 0, len(sf.files))
+	for key := range sf.files {
+		files = append(files, sf.keyPath(key))
+	}
+	return files
+}
+
+// loadState reads the state file of the SiaFolder and returns the file states
+// keyed by fileKey. A state file written with a different change detection
+// mode is ignored.
+func (sf *SiaFolder) loadState() (map[string]fileState, error) {
+	data, err := ioutil.ReadFile(sf.stateFile)
+	if err != nil {
+		return nil, err
+	}
+	var ps persistedState
+	err = json.Unmarshal(data, &ps)
+	if err != nil {
+		return nil, err
+	}
+
+	if ps.ChangeDetection != sf.changeDetection || ps.Files == nil {
+		return make(map[string]fileState), nil
+	}
+	return ps.Files, nil
+}
+
+// saveState atomically writes the state of every tracked file to the state
+// file if anything changed since it was last written. Nothing is written with
+// noCache.
+func (sf *SiaFolder) saveState() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if !sf.stateDirty || sf.dryRun || sf.noCache {
+		return nil
+	}
+
+	ps := persistedState{
+		ChangeDetection: sf.changeDetection,
+		Files:           sf.state,
+	}
+	data, err := json.Marshal(ps)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := sf.stateFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile, sf.stateFile)
+	if err != nil {
+		return err
+	}
+	sf.stateDirty = false
+	return nil
+}
+
+// saveStateLogged saves the state file, logging any error.
+func (sf *SiaFolder) saveStateLogged() {
+	err := sf.saveState()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Error saving state file")
+	}
+}
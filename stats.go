@@ -0,0 +1,117 @@
+package siasync
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Stats counts what a SiaFolder did since it was created.
+type Stats struct {
+	Scanned       int           // Scanned is the number of files found when scanning the directory at startup
+	Uploaded      int           // Uploaded is the number of files uploaded to Sia
+	UploadedBytes int64         // UploadedBytes is the size of the files uploaded to Sia
+	Reuploaded    int           // Reuploaded is the number of files queued again because they changed
+	Deduplicated  int           // Deduplicated is the number of files not uploaded because their content already was
+	Renamed       int           // Renamed is the number of files renamed on Sia
+	Deleted       int           // Deleted is the number of files deleted from Sia
+	Failed        int           // Failed is the number of files given up after every upload attempt
+	Skipped       int           // Skipped is the number of files skipped because they can't be read or Sia doesn't accept their name
+	Runtime       time.Duration // Runtime is the time since the SiaFolder was created
+}
+
+// Throughput returns the average number of bytes uploaded per second over the
+// runtime.
+func (s Stats) Throughput() float64 {
+	if s.Runtime <= 0 {
+		return 0
+	}
+	return float64(s.UploadedBytes) / s.Runtime.Seconds()
+}
+
+// add adds the counters of other to s. The runtime is the longer of both.
+func (s *Stats) add(other Stats) {
+	s.Scanned += other.Scanned
+	s.Uploaded += other.Uploaded
+	s.UploadedBytes += other.UploadedBytes
+	s.Reuploaded += other.Reuploaded
+	s.Deduplicated += other.Deduplicated
+	s.Renamed += other.Renamed
+	s.Deleted += other.Deleted
+	s.Failed += other.Failed
+	s.Skipped += other.Skipped
+	if other.Runtime > s.Runtime {
+		s.Runtime = other.Runtime
+	}
+}
+
+// log logs the counters with msg.
+func (s Stats) log(msg string) {
+	log.WithFields(logrus.Fields{
+		"scanned":    s.Scanned,
+		"uploaded":   s.Uploaded,
+		"bytes":      s.UploadedBytes,
+		"reuploaded": s.Reuploaded,
+		"duplicates": s.Deduplicated,
+		"renamed":    s.Renamed,
+		"deleted":    s.Deleted,
+		"failed":     s.Failed,
+		"skipped":    s.Skipped,
+		"runtime":    s.Runtime.Round(time.Second).String(),
+		"throughput": formatThroughput(s.Throughput()),
+	}).Info(msg)
+}
+
+// formatThroughput formats bytes per second with a binary unit.
+func formatThroughput(bytesPerSecond float64) string {
+	return formatBytes(bytesPerSecond) + "/s"
+}
+
+// formatBytes formats a number of bytes with a binary unit.
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for bytes >= 1024 && i < len(units)-1 {
+		bytes /= 1024
+		i++
+	}
+	return strconv.FormatFloat(bytes, 'f', 1, 64) + " " + units[i]
+}
+
+// syncStats accumulates the Stats of a SiaFolder, which are updated by the
+// startup scan, eventWatcher and the upload workers at the same time.
+type syncStats struct {
+	mu      sync.Mutex
+	stats   Stats
+	started time.Time
+}
+
+// update changes the counters with f.
+func (s *syncStats) update(f func(*Stats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(&s.stats)
+}
+
+// Stats returns the counters of the SiaFolder so far. Skipped is the number
+// of files skipped right now.
+func (sf *SiaFolder) Stats() Stats {
+	skipped := sf.skippedFiles()
+	sf.stats.mu.Lock()
+	defer sf.stats.mu.Unlock()
+	stats := sf.stats.stats
+	stats.Runtime = time.Since(sf.stats.started)
+	stats.Skipped = skipped
+	return stats
+}
+
+// LogStats logs the combined Stats of every folder with msg.
+func LogStats(folders []*SiaFolder, msg string) {
+	var stats Stats
+	for _, sf := range folders {
+		stats.add(sf.Stats())
+	}
+	stats.log(msg)
+}
@@ -0,0 +1,306 @@
+package siasync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Status is a snapshot of the sync state of a SiaFolder.
+type Status struct {
+	Watched  int  `json:"watched"`  // Watched is the number of tracked local files
+	Uploaded int  `json:"uploaded"` // Uploaded is the number of tracked files uploaded to Sia
+	Pending  int  `json:"pending"`  // Pending is the number of files queued or being uploaded
+	Failed   int  `json:"failed"`   // Failed is the number of files that could not be uploaded
+	Paused   bool `json:"paused"`   // Paused is set while syncing is paused
+	Offline  bool `json:"offline"`  // Offline is set while the synced directory is missing
+	Ready    bool `json:"ready"`    // Ready is set once the initial sync is done
+
+	// HeldDeletes is the number of files whose removal from Sia is held
+	// back because too many files were removed, until POST /resume-deletes.
+	HeldDeletes int `json:"helddeletes,omitempty"`
+
+	// Throttled is the upload limit that holds back the queued files, if
+	// any.
+	Throttled string `json:"throttled,omitempty"`
+
+	// Spending is the estimated spending of the session and the remaining
+	// allowance, shared by all folders.
+	Spending *Spending `json:"spending,omitempty"`
+
+	// API counts the siad API calls made and saved by coalescing identical
+	// GETs, shared by all folders.
+	API *APICalls `json:"api,omitempty"`
+
+	Files   []FileStatus  `json:"files"`
+	Skipped []SkippedFile `json:"skipped"`
+}
+
+// SkippedFile is a file that isn't synced because Sia doesn't accept its
+// name.
+type SkippedFile struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// FileStatus is the sync state of a single file. Redundancy, Health and
+// UploadProgress are reported by Sia and are zero for files not on Sia, like
+// duplicates, which share the upload of the file DuplicateOf. In archive mode
+// they are those of the current Version, Versions is the number of earlier
+// versions kept on Sia.
+type FileStatus struct {
+	Path           string  `json:"path"`
+	Size           int64   `json:"size"`
+	Uploaded       bool    `json:"uploaded"`
+	DuplicateOf    string  `json:"duplicateof,omitempty"`
+	Version        int     `json:"version,omitempty"`
+	Versions       int     `json:"versions,omitempty"`
+	Error          string  `json:"error,omitempty"`
+	Redundancy     float64 `json:"redundancy"`
+	Health         float64 `json:"health"`
+	UploadProgress float64 `json:"uploadprogress"`
+
+	// ETA is the estimate of when the file reaches its minimum redundancy
+	// and Stalled is set if its redundancy stopped growing, both going by
+	// the redundancy at the last progress reports. Only set with
+	// -progress-interval, while siad is uploading the file.
+	ETA     string `json:"eta,omitempty"`
+	Stalled bool   `json:"stalled,omitempty"`
+}
+
+// Status returns the sync state of the SiaFolder and of every tracked or
+// failed file. The remote state of all files comes from a single listing of
+// the Sia folder.
+func (sf *SiaFolder) Status() (Status, error) {
+	renterFiles, err := sf.getSiaFiles()
+	if err != nil && !strings.Contains(err.Error(), errNoFiles.Error()) {
+		return Status{}, err
+	}
+
+	sf.mu.Lock()
+	watched, failed, paused, offline, ready := len(sf.state), len(sf.failed), sf.paused, sf.offline, sf.ready
+	heldDeletes := len(sf.heldRemovals)
+	files := make(map[string]FileStatus, len(sf.state))
+	states := make(map[string]fileState, len(sf.state))
+	for key, fs := range sf.state {
+		files[key] = FileStatus{Size: fs.Size, Uploaded: fs.Uploaded, DuplicateOf: fs.DuplicateOf, Version: fs.Version, Versions: len(fs.Versions)}
+		states[key] = fs
+	}
+	for file, err := range sf.failed {
+		key := sf.fileKey(file)
+		f := files[key]
+		f.Error = err
+		files[key] = f
+	}
+	redundancy := make(map[string][]redundancySample, len(sf.redundancy))
+	for key, samples := range sf.redundancy {
+		redundancy[key] = samples
+	}
+	skipped := make([]SkippedFile, 0, len(sf.skipped))
+	for file, err := range sf.skipped {
+		skipped = append(skipped, SkippedFile{Path: sf.fileKey(file), Error: err})
+	}
+	sf.mu.Unlock()
+
+	status := Status{
+		Watched:     watched,
+		Pending:     sf.uploads.len(),
+		Failed:      failed,
+		Paused:      paused,
+		Offline:     offline,
+		HeldDeletes: heldDeletes,
+		Ready:       ready,
+		Throttled:   sf.uploads.throttled(),
+		Files:       make([]FileStatus, 0, len(files)),
+		Skipped:     skipped,
+	}
+	if sf.spending != nil {
+		spending := sf.spending.Spending()
+		status.Spending = &spending
+	}
+	if sf.api != nil {
+		calls := sf.api.Calls()
+		status.API = &calls
+	}
+	for key, f := range files {
+		f.Path = key
+		siaPath, err := sf.uploadedSiaPath(key, states[key])
+		if err != nil {
+			return Status{}, err
+		}
+		if fi, exists := renterFiles[siaPath]; exists {
+			f.Redundancy = fi.Redundancy
+			f.Health = fi.Health
+			f.UploadProgress = fi.UploadProgress
+		}
+		if samples, ok := redundancy[key]; ok {
+			target := sf.categorySettingsOf(key).minRedundancy
+			eta, ok, stalled := redundancyETA(samples, target)
+			if ok && samples[len(samples)-1].redundancy < target {
+				f.ETA = formatETA(eta)
+			}
+			f.Stalled = stalled
+		}
+		if f.Uploaded {
+			status.Uploaded++
+		}
+		status.Files = append(status.Files, f)
+	}
+	sort.Slice(status.Files, func(i, j int) bool {
+		return status.Files[i].Path < status.Files[j].Path
+	})
+	sort.Slice(status.Skipped, func(i, j int) bool {
+		return status.Skipped[i].Path < status.Skipped[j].Path
+	})
+	return status, nil
+}
+
+// FolderList is the list of SiaFolders served by ServeStatus. The server
+// starts before the folders are created, so that health probes are answered
+// during the initial sync, and every folder is added once it is created.
+type FolderList struct {
+	mu       sync.Mutex
+	folders  []*SiaFolder
+	expected int
+}
+
+// NewFolderList returns an empty list that is complete once expected folders
+// were added.
+func NewFolderList(expected int) *FolderList {
+	return &FolderList{expected: expected}
+}
+
+// Add adds a created folder to the list.
+func (l *FolderList) Add(sf *SiaFolder) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.folders = append(l.folders, sf)
+}
+
+// list returns the folders created so far and whether that are all of them.
+func (l *FolderList) list() ([]*SiaFolder, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*SiaFolder(nil), l.folders...), len(l.folders) >= l.expected
+}
+
+// ServeStatus serves the Status of a SiaFolder as JSON on /status at addr
+// until the returned server is closed. The folder is picked by its folder on
+// Sia with the subfolder query parameter, the first folder is served by
+// default. POST requests to /pause and /resume pause and resume syncing of
+// every folder, and to /resume-deletes remove the files whose removal from Sia
+// was held back because too many files were removed.
+//
+// /healthz answers 200 while every folder is Healthy within healthTimeout and
+// 503 otherwise, /readyz answers 200 once every folder finished its initial
+// sync. Both are meant for liveness and readiness probes.
+func ServeStatus(addr string, folderList *FolderList, healthTimeout time.Duration) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		folders, _ := folderList.list()
+		if len(folders) == 0 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		sf := folders[0]
+		if subfolder := strings.Trim(r.URL.Query().Get("subfolder"), "/"); subfolder != "" {
+			sf = nil
+			for _, folder := range folders {
+				if folder.prefix == subfolder {
+					sf = folder
+				}
+			}
+			if sf == nil {
+				http.Error(w, "unknown subfolder", http.StatusNotFound)
+				return
+			}
+		}
+		status, err := sf.Status()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		folders, _ := folderList.list()
+		for _, sf := range folders {
+			sf.Pause()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		folders, _ := folderList.list()
+		for _, sf := range folders {
+			sf.Resume()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/resume-deletes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		folders, _ := folderList.list()
+		for _, sf := range folders {
+			sf.ResumeDeletes()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		folders, _ := folderList.list()
+		var problems []string
+		for _, sf := range folders {
+			if err := sf.Healthy(healthTimeout); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+		if len(problems) > 0 {
+			http.Error(w, strings.Join(problems, "\n"), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		folders, complete := folderList.list()
+		ready := complete
+		for _, sf := range folders {
+			ready = ready && sf.Ready()
+		}
+		if !ready {
+			http.Error(w, "initial sync in progress", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	server := &http.Server{Handler: mux}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Error serving status")
+		}
+	}()
+	return server, nil
+}
@@ -0,0 +1,132 @@
+package siasync
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/modules"
+)
+
+// throttleCheckInterval is how often the files siad is still uploading are
+// counted against the upload limits.
+const throttleCheckInterval = 15 * time.Second
+
+// checkThrottle tells the upload queue how many bytes siad is still uploading
+// and logs when the upload limits start or stop holding back files. It runs
+// on the eventWatcher goroutine.
+func (sf *SiaFolder) checkThrottle() {
+	if sf.uploads.maxBytes > 0 {
+		bytes, err := sf.uploadingBytes()
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Error listing files to count the bytes Sia is uploading")
+		} else {
+			sf.uploads.setUploadingBytes(bytes)
+		}
+	}
+
+	// the upload window is logged by checkUploadWindow
+	throttle := sf.uploads.throttled()
+	if throttle == windowThrottle {
+		throttle = ""
+	}
+	if throttle == sf.throttle {
+		return
+	}
+	if throttle != "" {
+		log.WithFields(logrus.Fields{
+			"limit":  throttle,
+			"queued": sf.uploads.len(),
+		}).Info("Upload limit reached, holding back queued files")
+	} else {
+		log.Info("Upload limit no longer reached, uploading queued files")
+	}
+	sf.throttle = throttle
+}
+
+// uploadingBytes returns the size of the tracked files that siad hasn't
+// finished uploading. Only the files handed to siad are looked up, the
+// listing of Sia is used once to find the files siad was already uploading.
+func (sf *SiaFolder) uploadingBytes() (int64, error) {
+	if !sf.siadUploadingSeeded {
+		err := sf.seedSiadUploads()
+		if err != nil {
+			return 0, err
+		}
+		sf.siadUploadingSeeded = true
+	}
+
+	sf.mu.Lock()
+	siaPaths := make([]modules.SiaPath, 0, len(sf.siadUploading))
+	for siaPath := range sf.siadUploading {
+		siaPaths = append(siaPaths, siaPath)
+	}
+	sf.mu.Unlock()
+
+	var bytes int64
+	for _, siaPath := range siaPaths {
+		rf, err := sf.client.RenterFileGet(siaPath)
+		if err != nil && strings.Contains(err.Error(), "no file known") {
+			// removed or renamed in the meantime
+			sf.removeSiadUpload(siaPath)
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if rf.File.UploadProgress >= 100 {
+			sf.removeSiadUpload(siaPath)
+			continue
+		}
+		bytes += int64(rf.File.Filesize)
+	}
+	return bytes, nil
+}
+
+// seedSiadUploads adds the tracked files siad hasn't finished uploading
+// according to the listing of Sia to the files handed to siad.
+func (sf *SiaFolder) seedSiadUploads() error {
+	renterFiles, err := sf.getSiaFiles()
+	if err != nil && !strings.Contains(err.Error(), errNoFiles.Error()) {
+		return err
+	}
+	for _, file := range sf.trackedFiles() {
+		relpath, err := filepath.Rel(sf.path, file)
+		if err != nil {
+			continue
+		}
+		fs, _ := sf.trackedFile(file)
+		siaPath, err := sf.uploadedSiaPath(relpath, fs)
+		if err != nil {
+			continue
+		}
+		if siafile, ok := renterFiles[siaPath]; ok && siafile.UploadProgress < 100 {
+			sf.addSiadUpload(siaPath)
+		}
+	}
+	return nil
+}
+
+// addSiadUpload records that a file was handed to siad, if the bytes siad
+// uploads are limited.
+func (sf *SiaFolder) addSiadUpload(siaPath modules.SiaPath) {
+	if sf.uploads.maxBytes <= 0 {
+		return
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.siadUploading == nil {
+		sf.siadUploading = make(map[modules.SiaPath]struct{})
+	}
+	sf.siadUploading[siaPath] = struct{}{}
+}
+
+// removeSiadUpload forgets a file siad finished uploading.
+func (sf *SiaFolder) removeSiadUpload(siaPath modules.SiaPath) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	delete(sf.siadUploading, siaPath)
+}
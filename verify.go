@@ -0,0 +1,152 @@
+package siasync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// sizeMismatch is a file whose size on Sia differs from the local file.
+type sizeMismatch struct {
+	Path       string `json:"path"`
+	LocalSize  int64  `json:"localsize"`
+	RemoteSize uint64 `json:"remotesize"`
+}
+
+// VerifyReport lists the differences between the local directory and the
+// files below the prefix on Sia. Paths are slash separated and relative to
+// the synced directory. Changed lists the files with the same size whose
+// checksum differs from the one in the manifest.
+type VerifyReport struct {
+	LocalOnly  []string       `json:"localonly"`
+	RemoteOnly []string       `json:"remoteonly"`
+	Mismatched []sizeMismatch `json:"mismatched"`
+	Changed    []string       `json:"changed"`
+}
+
+// OK reports whether the local directory and Sia match.
+func (r VerifyReport) OK() bool {
+	return len(r.LocalOnly) == 0 && len(r.RemoteOnly) == 0 && len(r.Mismatched) == 0 && len(r.Changed) == 0
+}
+
+// Verify compares the tracked files with the files on Sia without changing
+// anything. Since Sia doesn't know the checksum of a file, files on both sides
+// are compared by size, and by their sha256 checksum if there is a manifest
+// and the SiaFolder is in sha256 mode.
+func (sf *SiaFolder) Verify() (VerifyReport, error) {
+	report := VerifyReport{
+		LocalOnly:  []string{},
+		RemoteOnly: []string{},
+		Mismatched: []sizeMismatch{},
+		Changed:    []string{},
+	}
+	renterFiles, err := sf.getSiaFiles()
+	if err != nil && !strings.Contains(err.Error(), errNoFiles.Error()) {
+		return report, err
+	}
+	m, err := sf.downloadManifest()
+	if err != nil {
+		return report, err
+	}
+
+	for _, file := range sf.trackedFiles() {
+		relpath, err := filepath.Rel(sf.path, file)
+		if err != nil {
+			return report, err
+		}
+		// a duplicate is on Sia as its original, in archive mode as the
+		// original's current version
+		fs, _ := sf.trackedFile(file)
+		uploaded, uploadedState := relpath, fs
+		if fs.DuplicateOf != "" {
+			uploaded = filepath.FromSlash(fs.DuplicateOf)
+			uploadedState, _ = sf.trackedFile(sf.keyPath(fs.DuplicateOf))
+		}
+		siaPath, err := sf.uploadedSiaPath(uploaded, uploadedState)
+		if err != nil {
+			return report, err
+		}
+		fi, exists := renterFiles[siaPath]
+		switch {
+		case !exists:
+			report.LocalOnly = append(report.LocalOnly, filepath.ToSlash(relpath))
+		case uint64(fs.Size) != fi.Filesize:
+			report.Mismatched = append(report.Mismatched, sizeMismatch{
+				Path:       filepath.ToSlash(relpath),
+				LocalSize:  fs.Size,
+				RemoteSize: fi.Filesize,
+			})
+		case m != nil && sf.changeDetection == "sha256":
+			entry, ok := m.Files[filepath.ToSlash(relpath)]
+			if ok && entry.SHA256 != "" && entry.SHA256 != fs.Checksum {
+				report.Changed = append(report.Changed, filepath.ToSlash(relpath))
+			}
+		}
+	}
+	sort.Strings(report.LocalOnly)
+	sort.Strings(report.Changed)
+	sort.Slice(report.Mismatched, func(i, j int) bool {
+		return report.Mismatched[i].Path < report.Mismatched[j].Path
+	})
+
+	deleted, err := sf.deletedFiles()
+	if err != nil {
+		return report, err
+	}
+	for _, file := range deleted {
+		relpath, err := filepath.Rel(sf.path, file)
+		if err != nil {
+			return report, err
+		}
+		report.RemoteOnly = append(report.RemoteOnly, filepath.ToSlash(relpath))
+	}
+	return report, nil
+}
+
+// Add adds the differences of another report, prefixing its paths with
+// folder.
+func (r *VerifyReport) Add(other VerifyReport, folder string) {
+	for _, file := range other.LocalOnly {
+		r.LocalOnly = append(r.LocalOnly, folder+"/"+file)
+	}
+	for _, file := range other.RemoteOnly {
+		r.RemoteOnly = append(r.RemoteOnly, folder+"/"+file)
+	}
+	for _, m := range other.Mismatched {
+		m.Path = folder + "/" + m.Path
+		r.Mismatched = append(r.Mismatched, m)
+	}
+	for _, file := range other.Changed {
+		r.Changed = append(r.Changed, folder+"/"+file)
+	}
+}
+
+// WriteTable writes the report as a human readable table.
+func (r VerifyReport) WriteTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tFILE\tLOCAL SIZE\tREMOTE SIZE")
+	for _, file := range r.LocalOnly {
+		fmt.Fprintf(w, "local only\t%v\t\t\n", file)
+	}
+	for _, file := range r.RemoteOnly {
+		fmt.Fprintf(w, "remote only\t%v\t\t\n", file)
+	}
+	for _, m := range r.Mismatched {
+		fmt.Fprintf(w, "size differs\t%v\t%v\t%v\n", m.Path, m.LocalSize, m.RemoteSize)
+	}
+	for _, file := range r.Changed {
+		fmt.Fprintf(w, "content differs\t%v\t\t\n", file)
+	}
+	return w.Flush()
+}
+
+// WriteJSON writes the report as JSON.
+func (r VerifyReport) WriteJSON(out io.Writer) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
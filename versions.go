@@ -0,0 +1,304 @@
+package siasync
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/Sia/modules"
+)
+
+// fileVersion is an earlier version of a file that is kept on Sia in archive
+// mode, under the siapath of the file with .v<Version> appended. The first
+// version has the siapath of the file itself.
+type fileVersion struct {
+	Version    int       `json:"version"`
+	Size       int64     `json:"size"`
+	Checksum   string    `json:"checksum,omitempty"`
+	UploadTime time.Time `json:"uploadtime"`
+}
+
+// manifestVersion is an earlier version of a file in the manifest.
+type manifestVersion struct {
+	Version  int       `json:"version"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256,omitempty"`
+	Uploaded time.Time `json:"uploaded"`
+}
+
+// versionSuffix matches the relative path of a versioned file on Sia.
+var versionSuffix = regexp.MustCompile(`^(.+)\.v([0-9]+)$`)
+
+// versionSiaPath returns the siapath of the given version of the file at
+// siaPath, which is siaPath with .v<version> appended. The first version is
+// at siaPath itself.
+func versionSiaPath(siaPath modules.SiaPath, version int) (modules.SiaPath, error) {
+	if version <= 1 {
+		return siaPath, nil
+	}
+	return newSiaPath(siaPath.String() + ".v" + strconv.Itoa(version))
+}
+
+// uploadedSiaPath returns the siapath of the version of the file at relpath
+// that fs describes.
+func (sf *SiaFolder) uploadedSiaPath(relpath string, fs fileState) (modules.SiaPath, error) {
+	siaPath, err := sf.getSiaPath(relpath)
+	if err != nil {
+		return siaPath, err
+	}
+	return versionSiaPath(siaPath, fs.Version)
+}
+
+// versionSiaPaths returns the siapaths of the earlier versions of the file at
+// relpath that are still on Sia, oldest first.
+func (sf *SiaFolder) versionSiaPaths(relpath string, fs fileState) ([]modules.SiaPath, error) {
+	siaPath, err := sf.getSiaPath(relpath)
+	if err != nil {
+		return nil, err
+	}
+	siaPaths := make([]modules.SiaPath, 0, len(fs.Versions))
+	for _, v := range fs.Versions {
+		versioned, err := versionSiaPath(siaPath, v.Version)
+		if err != nil {
+			return nil, err
+		}
+		siaPaths = append(siaPaths, versioned)
+	}
+	return siaPaths, nil
+}
+
+// currentVersion returns the version of the content described by fs.
+func (fs fileState) currentVersion() int {
+	if fs.Version < 1 {
+		return 1
+	}
+	return fs.Version
+}
+
+// nextVersion returns fs, the new content of a file whose state was old, as
+// the next version of the file. The uploaded content of old becomes an
+// earlier version. Content that was never uploaded is simply replaced.
+func (old fileState) nextVersion(fs fileState) fileState {
+	fs.Version, fs.Versions = old.Version, old.Versions
+	if !old.Uploaded || old.DuplicateOf != "" {
+		return fs
+	}
+	fs.Versions = append(append([]fileVersion(nil), old.Versions...), fileVersion{
+		Version:    old.currentVersion(),
+		Size:       old.Size,
+		Checksum:   old.Checksum,
+		UploadTime: old.UploadTime,
+	})
+	fs.Version = old.currentVersion() + 1
+	return fs
+}
+
+// freeVersion returns the first versioned siapath after the one of fs that no
+// file on Sia has, and fs as that version. taken is the file on Sia at the
+// siapath of fs. It and the files found at the versions in between are
+// recorded as earlier versions, their content is unknown.
+func (sf *SiaFolder) freeVersion(file string, fs fileState, taken modules.FileInfo) (modules.SiaPath, fileState, error) {
+	relpath, err := filepath.Rel(sf.path, file)
+	if err != nil {
+		return modules.SiaPath{}, fs, err
+	}
+	siaPath, err := sf.getSiaPath(relpath)
+	if err != nil {
+		return siaPath, fs, err
+	}
+	fs.Versions = append([]fileVersion(nil), fs.Versions...)
+	for version := fs.currentVersion(); ; version++ {
+		fs.Versions = append(fs.Versions, fileVersion{
+			Version:    version,
+			Size:       int64(taken.Filesize),
+			UploadTime: taken.CreateTime,
+		})
+		versioned, err := versionSiaPath(siaPath, version+1)
+		if err != nil {
+			return siaPath, fs, err
+		}
+		rf, err := sf.client.RenterFileGet(versioned)
+		if err != nil && strings.Contains(err.Error(), "no file known") {
+			fs.Version = version + 1
+			return versioned, fs, nil
+		}
+		if err != nil {
+			return siaPath, fs, fmt.Errorf("error getting file %v: %v", versioned, err)
+		}
+		taken = rf.File
+	}
+}
+
+// pruneVersions removes the oldest versions of file from Sia until no more
+// than maxVersions versions, including the current one, are left, and
+// returns fs without them. A version that can't be removed is kept.
+func (sf *SiaFolder) pruneVersions(file string, fs fileState) fileState {
+	if sf.maxVersions == 0 || len(fs.Versions) < sf.maxVersions {
+		return fs
+	}
+	relpath, err := filepath.Rel(sf.path, file)
+	if err != nil {
+		return fs
+	}
+	siaPaths, err := sf.versionSiaPaths(relpath, fs)
+	if err != nil {
+		return fs
+	}
+	prune := len(fs.Versions) + 1 - sf.maxVersions
+	for i := 0; i < prune; i++ {
+		v := fs.Versions[i]
+		siaPath := siaPaths[i]
+		if sf.dryRun {
+			sf.plan.delete(file, siaPath.String())
+			sf.audit(AuditRecord{Op: auditDelete, Path: file, SiaPath: siaPath.String(), Size: v.Size, Checksum: v.Checksum}, nil)
+			continue
+		}
+		err := sf.client.RenterDeletePost(siaPath)
+		sf.audit(AuditRecord{Op: auditDelete, Path: file, SiaPath: siaPath.String(), Size: v.Size, Checksum: v.Checksum}, err)
+		sf.listing.invalidate()
+		if err != nil && !strings.Contains(err.Error(), "no file known") {
+			log.WithFields(logrus.Fields{
+				"file":    file,
+				"siapath": siaPath.String(),
+				"error":   err.Error(),
+			}).Error("Error removing old version of file")
+			prune = i
+			break
+		}
+		log.WithFields(logrus.Fields{
+			"event":   "delete",
+			"file":    file,
+			"siapath": siaPath.String(),
+			"version": v.Version,
+		}).Info("Removed old version of file")
+	}
+	fs.Versions = append([]fileVersion(nil), fs.Versions[prune:]...)
+	return fs
+}
+
+// removeVersions removes the earlier versions of a file that is removed from
+// Sia, logging any error.
+func (sf *SiaFolder) removeVersions(file string, fs fileState) {
+	relpath, err := filepath.Rel(sf.path, file)
+	if err != nil {
+		return
+	}
+	siaPaths, err := sf.versionSiaPaths(relpath, fs)
+	if err != nil {
+		return
+	}
+	for i, siaPath := range siaPaths {
+		err := sf.client.RenterDeletePost(siaPath)
+		sf.audit(AuditRecord{Op: auditDelete, Path: file, SiaPath: siaPath.String(), Size: fs.Versions[i].Size, Checksum: fs.Versions[i].Checksum}, err)
+		sf.listing.invalidate()
+		if err != nil && !strings.Contains(err.Error(), "no file known") {
+			log.WithFields(logrus.Fields{
+				"file":    file,
+				"siapath": siaPath.String(),
+				"error":   err.Error(),
+			}).Error("Error removing old version of file")
+		}
+	}
+}
+
+// renameVersions renames the earlier versions of a file that was renamed from
+// oldRelpath to relpath on Sia, logging any error.
+func (sf *SiaFolder) renameVersions(file, oldRelpath, relpath string, fs fileState) {
+	oldSiaPaths, err := sf.versionSiaPaths(oldRelpath, fs)
+	if err != nil {
+		return
+	}
+	siaPaths, err := sf.versionSiaPaths(relpath, fs)
+	if err != nil {
+		return
+	}
+	for i := range siaPaths {
+		err := sf.client.RenterRenamePost(oldSiaPaths[i], siaPaths[i])
+		sf.audit(AuditRecord{Op: auditRename, Path: file, SiaPath: oldSiaPaths[i].String(), To: siaPaths[i].String(), Size: fs.Versions[i].Size, Checksum: fs.Versions[i].Checksum}, err)
+		sf.listing.invalidate()
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"file":    file,
+				"siapath": oldSiaPaths[i].String(),
+				"error":   err.Error(),
+			}).Error("Error renaming old version of file")
+		}
+	}
+}
+
+// manifestVersions returns the version index of a file in the previous
+// manifest, so that a file removed and created again in archive mode is
+// uploaded as the next version of the removed one.
+func (sf *SiaFolder) manifestVersions(key string) (int, []fileVersion) {
+	if sf.previousManifest == nil {
+		return 0, nil
+	}
+	entry, ok := sf.previousManifest.Files[key]
+	if !ok || entry.DuplicateOf != "" {
+		return 0, nil
+	}
+	versions := make([]fileVersion, 0, len(entry.Versions))
+	for _, v := range entry.Versions {
+		versions = append(versions, fileVersion{Version: v.Version, Size: v.Size, Checksum: v.SHA256, UploadTime: v.Uploaded})
+	}
+	return entry.Version, versions
+}
+
+// manifestVersionsOf returns the version index of fs as kept in the manifest.
+func (sf *SiaFolder) manifestVersionsOf(fs fileState) []manifestVersion {
+	var versions []manifestVersion
+	for _, v := range fs.Versions {
+		mv := manifestVersion{Version: v.Version, Size: v.Size, Uploaded: v.UploadTime}
+		if sf.changeDetection == "sha256" {
+			mv.SHA256 = v.Checksum
+		}
+		versions = append(versions, mv)
+	}
+	return versions
+}
+
+// version returns the given version of the file described by the entry.
+func (entry manifestEntry) version(version int) (manifestVersion, bool) {
+	current := entry.Version
+	if current < 1 {
+		current = 1
+	}
+	if version == current {
+		return manifestVersion{Version: current, Size: entry.Size, SHA256: entry.SHA256, Uploaded: entry.Uploaded}, true
+	}
+	for _, v := range entry.Versions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return manifestVersion{}, false
+}
+
+// versionOf returns the relative path of the file that the file on Sia at
+// relpath is a version of, and which version it is. A file the manifest lists
+// itself isn't a version of another file.
+func (m *manifest) versionOf(relpath string) (string, int, bool) {
+	if _, ok := m.Files[relpath]; ok {
+		return "", 0, false
+	}
+	match := versionSuffix.FindStringSubmatch(relpath)
+	if match == nil {
+		return "", 0, false
+	}
+	version, err := strconv.Atoi(match[2])
+	if err != nil {
+		return "", 0, false
+	}
+	entry, ok := m.Files[match[1]]
+	if !ok {
+		return "", 0, false
+	}
+	if _, ok := entry.version(version); !ok {
+		return "", 0, false
+	}
+	return match[1], version, true
+}
@@ -0,0 +1,142 @@
+package siasync
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// windowCheckInterval is how often the eventWatcher checks whether the upload
+// window opened or closed.
+const windowCheckInterval = 30 * time.Second
+
+// windowThrottle is the limit reported while the upload window is closed.
+const windowThrottle = "upload window"
+
+// UploadWindow is a time of day during which files are handed to siad, in
+// minutes since midnight. A window whose end is before its start spans
+// midnight.
+type UploadWindow struct {
+	start, end int
+}
+
+// ParseUploadWindows parses a comma separated list of windows written as
+// HH:MM-HH:MM, like 22:00-06:00. An empty list means uploads are always
+// allowed.
+func ParseUploadWindows(list string) ([]UploadWindow, error) {
+	var windows []UploadWindow
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		times := strings.Split(s, "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("invalid upload window %q, expected HH:MM-HH:MM", s)
+		}
+		start, err := parseTimeOfDay(times[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload window %q: %v", s, err)
+		}
+		end, err := parseTimeOfDay(times[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload window %q: %v", s, err)
+		}
+		if start == end || start == 24*60 {
+			return nil, fmt.Errorf("invalid upload window %q, it is empty", s)
+		}
+		windows = append(windows, UploadWindow{start: start, end: end})
+	}
+	return windows, nil
+}
+
+// parseTimeOfDay returns the minutes since midnight of a time written as
+// HH:MM, up to 24:00.
+func parseTimeOfDay(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// contains reports whether the window includes the time of day, in minutes
+// since midnight.
+func (w UploadWindow) contains(minute int) bool {
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// windowsOpen reports whether t falls in one of the windows, going by the
+// wall clock in loc. No windows means always open. Going by the wall clock
+// keeps windows at the same local times across DST transitions, so a window
+// is an hour shorter or longer on the night the clocks change.
+func windowsOpen(windows []UploadWindow, t time.Time, loc *time.Location) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	t = t.In(loc)
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range windows {
+		if w.contains(minute) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextWindowOpen returns when the next of the windows opens after t. A window
+// starting at a time skipped by a DST transition opens once the clocks have
+// moved past it.
+func nextWindowOpen(windows []UploadWindow, t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	var next time.Time
+	for day := 0; day <= 1; day++ {
+		for _, w := range windows {
+			start := time.Date(t.Year(), t.Month(), t.Day()+day, w.start/60, w.start%60, 0, 0, loc)
+			// time.Date picks a time around the gap for a start that was
+			// skipped, the window opens when the clocks jump past it
+			for i := 0; i < 24*60 && !windowsOpen(windows, start, loc); i++ {
+				start = start.Add(time.Minute)
+			}
+			if start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+	return next
+}
+
+// checkUploadWindow logs when the upload window opens or closes. Files are
+// held back by the upload queue, so this only reports it. It runs on the
+// eventWatcher goroutine.
+func (sf *SiaFolder) checkUploadWindow() {
+	open := sf.uploads.windowOpen(time.Now())
+	if open == sf.windowOpen {
+		return
+	}
+	sf.windowOpen = open
+	if open {
+		log.WithFields(logrus.Fields{
+			"queued": sf.uploads.len(),
+		}).Info("Upload window opened, uploading queued files")
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"queued": sf.uploads.len(),
+		"opens":  sf.uploads.nextWindowOpen(time.Now()).Format(time.RFC3339),
+	}).Info("Upload window closed, queuing files until it opens again")
+}
@@ -0,0 +1,125 @@
+package siasync
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseUploadWindows verifies that upload windows are parsed from a comma
+// separated list and that invalid windows are rejected.
+func TestParseUploadWindows(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []UploadWindow
+		fails    bool
+	}{
+		{"", nil, false},
+		{"22:00-06:00", []UploadWindow{{22 * 60, 6 * 60}}, false},
+		{"01:30-05:00, 12:00-13:15", []UploadWindow{{90, 300}, {720, 795}}, false},
+		{"00:00-24:00", []UploadWindow{{0, 24 * 60}}, false},
+		{"22:00", nil, true},
+		{"22:00-06:00-08:00", nil, true},
+		{"25:00-06:00", nil, true},
+		{"22:60-06:00", nil, true},
+		{"22:0-06:00", nil, true},
+		{"06:00-06:00", nil, true},
+		{"24:00-06:00", nil, true},
+	}
+	for _, test := range tests {
+		windows, err := ParseUploadWindows(test.list)
+		if test.fails {
+			if err == nil {
+				t.Errorf("ParseUploadWindows(%q): expected an error, got %v", test.list, windows)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseUploadWindows(%q): %v", test.list, err)
+			continue
+		}
+		if len(windows) != len(test.expected) {
+			t.Errorf("ParseUploadWindows(%q): expected %v, got %v", test.list, test.expected, windows)
+			continue
+		}
+		for i := range windows {
+			if windows[i] != test.expected[i] {
+				t.Errorf("ParseUploadWindows(%q): expected %v, got %v", test.list, test.expected, windows)
+			}
+		}
+	}
+}
+
+// TestUploadWindowsOpen verifies when upload windows are open, including
+// windows spanning midnight, and when they open next.
+func TestUploadWindowsOpen(t *testing.T) {
+	windows, err := ParseUploadWindows("22:00-06:00,12:00-13:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2020, time.March, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		t    time.Time
+		open bool
+		next time.Time
+	}{
+		{at(1, 23, 0), true, at(2, 12, 0)},
+		{at(2, 0, 0), true, at(2, 12, 0)},
+		{at(2, 5, 59), true, at(2, 12, 0)},
+		{at(2, 6, 0), false, at(2, 12, 0)},
+		{at(2, 12, 30), true, at(2, 22, 0)},
+		{at(2, 13, 0), false, at(2, 22, 0)},
+		{at(2, 21, 59), false, at(2, 22, 0)},
+	}
+	for _, test := range tests {
+		if open := windowsOpen(windows, test.t, time.UTC); open != test.open {
+			t.Errorf("%v: expected open %v, got %v", test.t, test.open, open)
+		}
+		if next := nextWindowOpen(windows, test.t, time.UTC); !next.Equal(test.next) {
+			t.Errorf("%v: expected the next window at %v, got %v", test.t, test.next, next)
+		}
+	}
+
+	// no windows means always open
+	if !windowsOpen(nil, at(2, 6, 0), time.UTC) {
+		t.Error("expected no windows to be open")
+	}
+
+	// the window is in the given time zone
+	berlin := time.FixedZone("CET", 60*60)
+	if windowsOpen(windows, at(2, 21, 30), berlin) != true {
+		t.Error("expected 21:30 UTC to be in the window in CET")
+	}
+}
+
+// TestUploadWindowsDST verifies that windows follow the wall clock across DST
+// transitions.
+func TestUploadWindowsDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database not available:", err)
+	}
+	windows, err := ParseUploadWindows("02:30-04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// clocks jump from 02:00 to 03:00 on 2020-03-08, the window opens at 03:00
+	beforeJump := time.Date(2020, time.March, 8, 1, 59, 0, 0, loc)
+	next := nextWindowOpen(windows, beforeJump, loc)
+	if expected := beforeJump.Add(time.Minute); !next.Equal(expected) {
+		t.Fatalf("expected the window to open at %v, got %v", expected, next)
+	}
+	if !windowsOpen(windows, next, loc) {
+		t.Fatalf("expected the window to be open at %v", next)
+	}
+	if windowsOpen(windows, time.Date(2020, time.March, 8, 4, 0, 0, 0, loc), loc) {
+		t.Fatal("expected the window to close at 04:00 local time")
+	}
+
+	// after clocks go back the window still ends at 04:00 local time
+	if windowsOpen(windows, time.Date(2020, time.November, 1, 4, 0, 0, 0, loc), loc) {
+		t.Fatal("expected the window to close at 04:00 local time")
+	}
+}